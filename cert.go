@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/server"
+	"github.com/aethertunnel/aethertunnel/pkg/store"
+)
+
+// runCertCommand 处理 cert 子命令
+func runCertCommand(args []string) {
+	if len(args) == 0 || args[0] != "issue" {
+		fmt.Printf("Usage: %s cert issue -config <config-file> -user <name> [-client <id>] [-ttl <duration>] [-out <prefix>]\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	flags := flag.NewFlagSet("cert issue", flag.ExitOnError)
+	configFile := flags.String("config", "", "server config file")
+	user := flags.String("user", "", "user the certificate authenticates")
+	clientID := flags.String("client", "", "client id bound to the certificate (optional)")
+	ttlValue := flags.String("ttl", "", "certificate lifetime, defaults to cert_manager.cert_ttl")
+	out := flags.String("out", "", "output prefix, writes <prefix>.crt and <prefix>.key (defaults to the user name)")
+	flags.Parse(args[1:])
+
+	if *configFile == "" || *user == "" {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	cfg, err := config.LoadServer(*configFile)
+	if err != nil {
+		fatal("Failed to load config", "file", *configFile, "err", err)
+	}
+	if !cfg.CertManager.Enabled {
+		fatal("cert_manager is not enabled", "file", *configFile)
+	}
+
+	// 只读取状态文件确认用户存在，运行中的服务端会覆盖这里的写入
+	stateFile := cfg.Server.StateFile
+	if stateFile == "" {
+		stateFile = "data/state.json"
+	}
+	stateStore, err := store.Open(stateFile)
+	if err != nil {
+		fatal("Failed to open state store", "file", stateFile, "err", err)
+	}
+	users, err := server.NewUserManager(cfg, stateStore)
+	if err != nil {
+		fatal("Failed to load users", "err", err)
+	}
+	if _, exists := users.Get(*user); !exists {
+		fatal("User does not exist or is disabled", "user", *user)
+	}
+
+	certs, created, err := server.LoadCertManager(&cfg.CertManager, nil)
+	if err != nil {
+		fatal("Failed to load certificate authority", "err", err)
+	}
+	if created {
+		slog.Info("Generated certificate authority", "subject", certs.CACertificate().Subject.CommonName)
+	}
+
+	ttl, err := server.ParseCertTTL(*ttlValue, certs.TTL())
+	if err != nil {
+		fatal("Invalid ttl", "err", err)
+	}
+	issued, err := certs.Issue(server.CertificateCommonName(*user, *clientID), ttl)
+	if err != nil {
+		fatal("Failed to issue certificate", "user", *user, "err", err)
+	}
+
+	prefix := *out
+	if prefix == "" {
+		prefix = *user
+	}
+	if err := os.WriteFile(prefix+".key", []byte(issued.PrivateKey), 0600); err != nil {
+		fatal("Failed to write key", "file", prefix+".key", "err", err)
+	}
+	if err := os.WriteFile(prefix+".crt", []byte(issued.Certificate), 0644); err != nil {
+		fatal("Failed to write certificate", "file", prefix+".crt", "err", err)
+	}
+
+	fmt.Printf("Issued certificate %s\n", issued.CommonName)
+	fmt.Printf("Serial:  %s\n", issued.Serial)
+	fmt.Printf("Expires: %s\n", issued.NotAfter.Format("2006-01-02 15:04:05 MST"))
+	fmt.Printf("Files:   %s.crt, %s.key\n", prefix, prefix)
+}
@@ -2,13 +2,13 @@ package main
 
 import (
 	"fmt"
-	"log"
-	"net"
+	"log/slog"
 	"os"
-	"time"
 
+	"github.com/aethertunnel/aethertunnel/pkg/client"
 	"github.com/aethertunnel/aethertunnel/pkg/config"
 	"github.com/aethertunnel/aethertunnel/pkg/crypto"
+	"github.com/aethertunnel/aethertunnel/pkg/logging"
 )
 
 var (
@@ -32,17 +32,24 @@ func main() {
 	configFile := os.Args[1]
 	cfg, err := config.LoadClient(configFile)
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		fatal("Failed to load config", "file", configFile, "err", err)
 	}
 
+	// 之后的日志按 [logging] 输出
+	logFile, err := logging.Setup(&cfg.Logging)
+	if err != nil {
+		fatal("Failed to set up logging", "err", err)
+	}
+	defer logFile.Close()
+
 	// 创建加密器
-	encryption := crypto.NewEncryption(cfg.Client.AuthToken)
+	encryption := crypto.NewEncryption(string(cfg.Client.AuthToken))
 
 	// 创建混淆器
 	// var obfuscator *obfuscation.Obfuscation
 	if cfg.Obfuscation.Enabled {
 		// obfuscator = obfuscation.NewObfuscation(encryption)
-		log.Printf("Obfuscation enabled")
+		slog.Info("Obfuscation enabled")
 	}
 
 	// VPN客户端功能暂未实现
@@ -52,114 +59,15 @@ func main() {
 	// 	vpnClient = vpn.NewVPNClient(cfg, vpnEncryption)
 	// 	go func() {
 	// 		if err := vpnClient.Connect(); err != nil {
-	// 			log.Printf("VPN connection failed: %v", err)
+	// 			slog.Error("VPN connection failed", "err", err)
 	// 		}
 	// 	}()
-	// 	log.Printf("VPN client enabled")
+	// 	slog.Info("VPN client enabled")
 	// }
-	log.Printf("VPN client feature not implemented yet")
-
-
-	// 连接到服务器
-	for {
-		conn, err := connectToServer(cfg, encryption)
-		if err != nil {
-			log.Printf("Failed to connect: %v", err)
-			time.Sleep(5 * time.Second)
-			continue
-		}
+	slog.Info("VPN client feature not implemented yet")
 
-		log.Printf("Connected to server: %s", cfg.Client.ServerAddr)
-
-		// 启动心跳
-		done := make(chan struct{})
-		go startHeartbeat(conn, encryption, done)
-
-		// 处理代理
-		handleProxies(conn, cfg, encryption, done)
-
-		// 等待断开
-		<-done
-		log.Println("Connection lost, reconnecting...")
-	}
-}
-
-func connectToServer(cfg *config.Config, encryption *crypto.Encryption) (net.Conn, error) {
-	conn, err := net.DialTimeout("tcp", cfg.Client.ServerAddr, 10*time.Second)
-	if err != nil {
-		return nil, err
-	}
-
-	// 简单的认证消息
-	authMsg := "AUTH:" + cfg.Client.AuthToken
-	if _, err := conn.Write([]byte(authMsg)); err != nil {
-		conn.Close()
-		return nil, err
-	}
-
-	// 读取认证响应
-	response, err := readLine(conn)
-	if err != nil {
-		conn.Close()
-		return nil, err
-	}
-
-	if response != "OK" {
-		conn.Close()
-		return nil, fmt.Errorf("authentication failed: %s", response)
-	}
-
-	return conn, nil
-}
-
-func startHeartbeat(conn net.Conn, encryption *crypto.Encryption, done chan struct{}) {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-done:
-			return
-		case <-ticker.C:
-			heartbeat := "HEARTBEAT"
-			if _, err := conn.Write([]byte(heartbeat)); err != nil {
-				log.Printf("Failed to send heartbeat: %v", err)
-				return
-			}
-		}
-	}
-}
-
-func handleProxies(conn net.Conn, cfg *config.Config, encryption *crypto.Encryption, done chan struct{}) {
-	for _, proxy := range cfg.Proxies {
-		log.Printf("Proxy feature not implemented: %s (%s:%d -> :%d)",
-			proxy.Name, proxy.LocalIP, proxy.LocalPort, proxy.RemotePort)
-		// TODO: Implement proxy functionality
-	}
-}
-
-func forwardData(clientConn, localConn net.Conn) {
-	buffer := make([]byte, 4096)
-
-	for {
-		n, err := clientConn.Read(buffer)
-		if err != nil {
-			break
-		}
-
-		if _, err := localConn.Write(buffer[:n]); err != nil {
-			break
-		}
-	}
-}
-
-func readLine(conn net.Conn) (string, error) {
-	buf := make([]byte, 1024)
-	n, err := conn.Read(buf)
-	if err != nil {
-		return "", err
-	}
-	return string(buf[:n]), nil
+	// 连接到服务器，断开后自动重连
+	client.NewClient(cfg, encryption).Run()
 }
 
 // client_simple_example 客户端简单配置示例
@@ -170,6 +78,9 @@ server_addr = "127.0.0.1:7001"
 # 认证令牌
 auth_token = "your-auth-token-here"
 
+# 服务器静态公钥（服务器启动时打印）
+server_public_key = "your-server-public-key"
+
 # 代理配置
 [[proxies]]
 name = "ssh"
@@ -199,3 +110,9 @@ local_ip = "127.0.0.1"
 local_port = 53
 remote_port = 53
 `
+
+// fatal 输出错误日志后退出
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
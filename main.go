@@ -2,16 +2,19 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/aethertunnel/aethertunnel/pkg/audit"
 	"github.com/aethertunnel/aethertunnel/pkg/config"
 	"github.com/aethertunnel/aethertunnel/pkg/crypto"
+	"github.com/aethertunnel/aethertunnel/pkg/logging"
 	"github.com/aethertunnel/aethertunnel/pkg/server"
+	"github.com/aethertunnel/aethertunnel/pkg/store"
 	"github.com/aethertunnel/aethertunnel/pkg/vpn"
 )
 
@@ -22,6 +25,20 @@ var (
 )
 
 func main() {
+	// 子命令
+	if len(os.Args) > 1 && os.Args[1] == "cert" {
+		runCertCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		runTokenCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		runAuditCommand(os.Args[2:])
+		return
+	}
+
 	// 打印版本信息
 	fmt.Printf("AetherTunnel Server v%s\n", version)
 	fmt.Printf("Build Time: %s\n", buildTime)
@@ -30,6 +47,9 @@ func main() {
 	// 加载配置
 	if len(os.Args) < 2 {
 		fmt.Printf("Usage: %s <config-file>\n", os.Args[0])
+		fmt.Printf("       %s cert issue -config <config-file> -user <name> [-client <id>] [-ttl <duration>] [-out <prefix>]\n", os.Args[0])
+		fmt.Printf("       %s token generate | token hash [-token <token>]\n", os.Args[0])
+		fmt.Printf("       %s audit verify -config <config-file> | -file <audit-log> -key <audit-key>\n", os.Args[0])
 		fmt.Println("\nConfig file example:")
 		exampleConfig, _ := os.ReadFile("config.example.toml")
 		fmt.Println(string(exampleConfig))
@@ -39,61 +59,173 @@ func main() {
 	configFile := os.Args[1]
 	cfg, err := config.LoadServer(configFile)
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		fatal("Failed to load config", "file", configFile, "err", err)
 	}
 
-	// 创建加密器
-	encryption := crypto.NewEncryption(cfg.Server.AuthToken)
+	// 之后的日志按 [logging] 输出
+	logFile, err := logging.Setup(&cfg.Logging)
+	if err != nil {
+		fatal("Failed to set up logging", "err", err)
+	}
+	defer logFile.Close()
 
-	// 创建混淆器
-	// var obfuscator *obfuscation.Obfuscation
 	if cfg.Obfuscation.Enabled {
-		// obfuscator = obfuscation.NewObfuscation(encryption)
-		log.Printf("Obfuscation enabled with default type: %s", cfg.Obfuscation.DefaultType)
+		slog.Info("Obfuscation enabled", "default_type", cfg.Obfuscation.Type(), "framing", cfg.Obfuscation.PacketFraming())
 	}
 
 	// 创建VPN管理器
 	var vpnManager *vpn.VPN
 	if cfg.VPN.Enabled {
-		vpnEncryption := crypto.NewEncryption(cfg.VPN.AuthToken)
+		vpnEncryption := crypto.NewEncryption(string(cfg.VPN.AuthToken), config.SecretStrings(cfg.VPN.PreviousAuthTokens)...)
+		slog.Info("VPN encryption key", "key_id", vpnEncryption.KeyID())
 		vpnManager = vpn.NewVPN(cfg, vpnEncryption)
 		go func() {
 			if err := vpnManager.Start(); err != nil {
-				log.Printf("Failed to start VPN: %v", err)
+				slog.Error("Failed to start VPN", "err", err)
 			}
 		}()
-		log.Printf("VPN enabled on port %d", cfg.VPN.Port)
+		slog.Info("VPN enabled", "port", cfg.VPN.Port)
 	}
 
-	// 创建监听器
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", cfg.Server.BindAddr, cfg.Server.BindPort))
+	// 打开状态存储
+	stateFile := cfg.Server.StateFile
+	if stateFile == "" {
+		stateFile = "data/state.json"
+	}
+	stateStore, err := store.Open(stateFile)
 	if err != nil {
-		log.Fatalf("Failed to listen: %v", err)
+		fatal("Failed to open state store", "err", err)
 	}
 
-	log.Printf("Server started on %s:%d", cfg.Server.BindAddr, cfg.Server.BindPort)
-	log.Printf("Auth Token: %s", maskToken(cfg.Server.AuthToken))
+	// 审计日志
+	var auditLog *audit.Log
+	if cfg.Security.EnableAuditLog {
+		keyFile := cfg.Security.AuditKeyPath()
+		key, created, err := audit.LoadOrCreateKey(keyFile)
+		if err != nil {
+			fatal("Failed to load audit key", "file", keyFile, "err", err)
+		}
+		if created {
+			slog.Info("Generated audit key", "file", keyFile)
+		}
+		auditLog, err = audit.Open(cfg.Security.AuditLogPath(), key)
+		if err != nil {
+			fatal("Failed to open audit log", "err", err)
+		}
+		defer auditLog.Close()
+		slog.Info("Audit log", "file", auditLog.Path())
+		recordConfigLoad(auditLog, configFile)
+	}
 
-	// 启动 Web 面板（如果启用）
-	if cfg.Dashboard.Enabled {
-		go func() {
-			if err := server.StartDashboard(cfg.Dashboard.Port, cfg); err != nil {
-				log.Printf("Failed to start dashboard: %v", err)
+	// 认证失败统计，封禁的 IP 在接受连接后立即断开（启用探测防护时按其处理）
+	guard, err := server.NewAuthGuard(&cfg.Security, stateStore, auditLog)
+	if err != nil {
+		fatal("Failed to load ban list", "err", err)
+	}
+
+	// 探测防护：认证前出错和被封禁的连接不立即关闭
+	probe, err := server.NewProbeGuard(&cfg.Server.ProbeResistance)
+	if err != nil {
+		fatal("Failed to set up probe resistance", "err", err)
+	}
+	if probe != nil {
+		slog.Info("Probe resistance", "mode", cfg.Server.ProbeResistance.Mode)
+	}
+
+	// 创建代理管理器，中继角色只转发连接
+	var (
+		proxyManager *server.ProxyManager
+		certs        *server.CertManager
+		handle       func(net.Conn)
+	)
+	if cfg.Server.Role == config.RoleRelay {
+		handle = server.NewRelay(cfg, guard, probe).HandleConnection
+	} else {
+		keyFile := cfg.Server.NoiseKeyFile
+		if keyFile == "" {
+			keyFile = "data/server_noise.key"
+		}
+		static, created, err := crypto.LoadOrCreateNoiseKeypair(keyFile)
+		if err != nil {
+			fatal("Failed to load server key", "file", keyFile, "err", err)
+		}
+		if created {
+			slog.Info("Generated server key", "file", keyFile)
+		}
+		slog.Info("Server public key", "public_key", static.PublicKeyString())
+
+		users, err := server.NewUserManager(cfg, stateStore)
+		if err != nil {
+			fatal("Failed to load users", "err", err)
+		}
+
+		// 内置 CA 签发的客户端证书用于 mTLS 认证
+		if cfg.CertManager.Enabled {
+			var created bool
+			certs, created, err = server.LoadCertManager(&cfg.CertManager, stateStore)
+			if err != nil {
+				fatal("Failed to load certificate authority", "err", err)
 			}
-		}()
+			if created {
+				slog.Info("Generated certificate authority", "subject", certs.CACertificate().Subject.CommonName)
+			}
+			slog.Info("Certificate manager enabled", "ttl", certs.TTL())
+		}
+
+		proxyManager = server.NewProxyManager(cfg, static, users, certs, guard, probe, auditLog, stateStore)
+		handle = proxyManager.HandleConnection
+	}
+
+	// 启用 TLS 时先完成 TLS 握手，allow_plain 时同一端口仍接受明文连接
+	if cfg.Server.EnableTLS {
+		tlsConfig, err := server.NewTLSConfig(&cfg.Server, certs)
+		if err != nil {
+			fatal("Failed to load TLS config", "err", err)
+		}
+		if pin, err := server.CertificatePin(tlsConfig); err == nil {
+			slog.Info("TLS certificate pin", "pin", pin)
+		}
+		if cfg.Server.ClientCAFile != "" {
+			slog.Info("Verifying client certificates", "ca_file", cfg.Server.ClientCAFile)
+		}
+		handle = server.TLSHandler(handle, tlsConfig, cfg.Server.AllowPlain, probe)
+	}
+
+	// TLS 伪装：未通过隐蔽认证的连接转发到伪装站点
+	if cfg.Server.Mimic.Enabled {
+		mimic, err := server.NewMimic(&cfg.Server)
+		if err != nil {
+			fatal("Failed to set up TLS mimicry", "err", err)
+		}
+		slog.Info("TLS mimicry enabled", "decoy", cfg.Server.Mimic.Decoy)
+		handle = mimic.Handler(handle, probe)
 	}
 
-	// 启动控制连接监听
+	// 启动控制连接监听，控制连接和工作连接共用同一端口
 	controlAddr := fmt.Sprintf("%s:%d", cfg.Server.BindAddr, cfg.Server.BindPort)
-	controlListener, err := net.Listen("tcp", controlAddr)
+	listener, err := net.Listen("tcp", controlAddr)
 	if err != nil {
-		log.Fatalf("Failed to listen on control port: %v", err)
+		fatal("Failed to listen on control port", "addr", controlAddr, "err", err)
 	}
 
-	log.Printf("Control listener started on %s", controlAddr)
+	slog.Info("Server started", "addr", controlAddr)
+	if cfg.Server.AuthTokenHash != "" {
+		slog.Info("Auth token is an Argon2id hash")
+	} else {
+		slog.Info("Auth token", "masked", cfg.Server.AuthToken)
+	}
+	if cfg.Server.Role == config.RoleRelay {
+		slog.Info("Running as relay")
+	}
 
-	// 创建代理管理器
-	proxyManager := server.NewProxyManager(cfg, encryption)
+	// 启动 Web 面板（如果启用）
+	if cfg.Dashboard.Enabled && proxyManager != nil {
+		go func() {
+			if err := server.StartDashboard(cfg.Dashboard.Port, cfg, proxyManager); err != nil {
+				slog.Error("Failed to start dashboard", "err", err)
+			}
+		}()
+	}
 
 	// 优雅关闭处理
 	done := make(chan os.Signal, 1)
@@ -101,9 +233,8 @@ func main() {
 
 	go func() {
 		<-done
-		log.Println("\nShutting down server...")
+		slog.Info("Shutting down server")
 		listener.Close()
-		controlListener.Close()
 		// Note: VPN shutdown not implemented yet
 	}()
 
@@ -112,30 +243,30 @@ func main() {
 	for {
 		select {
 		case <-done:
-			log.Printf("Server shutdown complete. Connections: %d", connections)
+			slog.Info("Server shutdown complete", "connections", connections)
 			return
 		default:
 			conn, err := listener.Accept()
 			if err != nil {
-				log.Printf("Accept error: %v", err)
+				slog.Warn("Accept error", "err", err)
 				time.Sleep(time.Second)
 				continue
 			}
+			if !guard.Allow(conn.RemoteAddr()) {
+				go probe.Reject(conn)
+				continue
+			}
 
 			connections++
-			log.Printf("New connection from %s (total: %d)", conn.RemoteAddr(), connections)
+			slog.Debug("New connection", "remote", conn.RemoteAddr().String(), "total", connections)
 
-			go func() {
-				proxyManager.HandleConnection(conn)
-			}()
+			go handle(conn)
 		}
 	}
 }
 
-// maskToken 隐藏认证令牌的一部分
-func maskToken(token string) string {
-	if len(token) <= 8 {
-		return token
-	}
-	return token[:4] + "****" + token[len(token)-4:]
+// fatal 输出错误日志后退出
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
@@ -0,0 +1,455 @@
+// Package audit 实现只追加、以 HMAC 链防篡改的审计日志
+//
+// 日志为 JSON Lines，每行一条 Entry。每条记录的 hash 是去掉 hash 字段后整条记录 JSON 的
+// HMAC-SHA256，其中包含上一条记录的 hash（prev），修改、删除或插入任意一条记录都会使之后的
+// 链校验失败。HMAC 密钥只保存在服务端，能改写日志文件但没有密钥的人无法重新计算整条链。
+// 截掉文件末尾的记录无法由链本身发现，需要另外保存最新的 hash（Verify 会返回）。
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 审计事件
+const (
+	ActionLogin        = "login"         // 客户端控制连接上线
+	ActionLogout       = "logout"        // 客户端控制连接断开
+	ActionAuthFailure  = "auth_failure"  // 控制端口或管理 API 认证失败
+	ActionBan          = "ip_ban"        // 来源 IP 因认证失败被封禁
+	ActionUnban        = "ip_unban"      // 解除封禁
+	ActionConfigLoad   = "config_load"   // 服务端启动时加载配置
+	ActionProxyCreate  = "proxy_create"  // 创建或注册代理
+	ActionProxyUpdate  = "proxy_update"  // 修改代理
+	ActionProxyDelete  = "proxy_delete"  // 删除或注销代理
+	ActionUserCreate   = "user_create"   // 创建用户
+	ActionUserUpdate   = "user_update"   // 修改用户（包括令牌和启用状态）
+	ActionUserDelete   = "user_delete"   // 删除用户
+	ActionCertIssue    = "cert_issue"    // 签发客户端证书
+	ActionCertRevoke   = "cert_revoke"   // 吊销客户端证书
+	ActionAdminRequest = "admin_request" // 管理 API 的修改请求
+)
+
+// 记录结果
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// maxLineSize 单条记录的长度上限
+const maxLineSize = 1 << 20
+
+// KeySize HMAC 密钥长度
+const KeySize = 32
+
+// ErrTampered 哈希链校验失败
+var ErrTampered = errors.New("audit log chain is broken")
+
+// Entry 一条审计记录
+type Entry struct {
+	Seq    uint64          `json:"seq"`
+	Time   time.Time       `json:"time"`
+	Action string          `json:"action"`
+	Actor  string          `json:"actor"`            // 如 "user:alice"、"admin:root"、"system"
+	Source string          `json:"source,omitempty"` // 来源 IP
+	Target string          `json:"target,omitempty"` // 操作对象，如代理名或用户名
+	Result string          `json:"result"`
+	Detail string          `json:"detail,omitempty"`
+	Before json.RawMessage `json:"before,omitempty"` // 修改前发生变化的字段
+	After  json.RawMessage `json:"after,omitempty"`  // 修改后发生变化的字段
+	Prev   string          `json:"prev"`
+	Hash   string          `json:"hash,omitempty"`
+}
+
+// Event 待记录的事件
+type Event struct {
+	Action string
+	Actor  string
+	Source string
+	Target string
+	Detail string
+	Failed bool
+
+	// 修改前后的对象，都不为 nil 时只记录发生变化的顶层字段；创建时 Before 为 nil，删除时 After 为 nil
+	Before interface{}
+	After  interface{}
+}
+
+// Log 审计日志，nil 表示未启用，所有方法都可以在 nil 上调用
+type Log struct {
+	path string
+	key  []byte
+	file *os.File
+	seq  uint64
+	last string // 最后一条记录的 hash
+	mu   sync.Mutex
+}
+
+// LoadOrCreateKey 读取 HMAC 密钥文件，不存在时生成，返回的 bool 表示是否新生成
+func LoadOrCreateKey(path string) ([]byte, bool, error) {
+	key, err := LoadKey(path)
+	if err == nil {
+		return key, false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, false, err
+	}
+
+	key = make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, false, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, false, fmt.Errorf("failed to write key file: %w", err)
+	}
+	return key, true, nil
+}
+
+// LoadKey 读取 HMAC 密钥文件
+func LoadKey(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key file %s", path)
+	}
+	return key, nil
+}
+
+// Open 用 HMAC 密钥 key 打开审计日志，不存在时创建
+//
+// 已有日志的最后一条记录必须能用 key 校验，密钥不对或日志由旧版本以无密钥的 SHA-256 写入时
+// 返回错误，需要把旧日志移走后重新开始。
+func Open(path string, key []byte) (*Log, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("audit key must be %d bytes", KeySize)
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	l := &Log{path: path, key: key}
+	last, err := readLast(path)
+	if err != nil {
+		return nil, err
+	}
+	if last != nil {
+		sum, err := mac(key, last)
+		if err != nil {
+			return nil, err
+		}
+		if !hmac.Equal([]byte(sum), []byte(last.Hash)) {
+			return nil, fmt.Errorf("audit log %s: last record does not match the audit key, check it with the verify command or move the log aside", path)
+		}
+		l.seq, l.last = last.Seq, last.Hash
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l.file = file
+	return l, nil
+}
+
+// readLast 读取文件最后一条记录，文件不存在或为空时返回 nil
+func readLast(path string) (*Entry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil, nil
+	}
+
+	// 从末尾读取最多 maxLineSize 字节，取最后一个完整的行
+	offset := size - maxLineSize
+	if offset < 0 {
+		offset = 0
+	}
+	data := make([]byte, size-offset)
+	if _, err := file.ReadAt(data, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	data = bytes.TrimRight(data, "\n")
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	} else if offset > 0 {
+		return nil, fmt.Errorf("audit log %s: last record is too long", path)
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Hash == "" {
+		return nil, fmt.Errorf("audit log %s: last record is corrupted, check it with the verify command", path)
+	}
+	return &entry, nil
+}
+
+// Path 返回日志文件路径
+func (l *Log) Path() string {
+	if l == nil {
+		return ""
+	}
+	return l.path
+}
+
+// Record 追加一条记录，写入失败只打印日志，不影响被审计的操作
+func (l *Log) Record(ev Event) {
+	if l == nil {
+		return
+	}
+
+	entry := Entry{
+		Time:   time.Now().UTC(),
+		Action: ev.Action,
+		Actor:  ev.Actor,
+		Source: ev.Source,
+		Target: ev.Target,
+		Result: ResultSuccess,
+		Detail: ev.Detail,
+	}
+	if ev.Failed {
+		entry.Result = ResultFailure
+	}
+	var err error
+	if entry.Before, entry.After, err = diff(ev.Before, ev.After); err != nil {
+		slog.Error("Failed to record audit event", "action", ev.Action, "err", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.Seq = l.seq + 1
+	entry.Prev = l.last
+	if entry.Hash, err = mac(l.key, &entry); err != nil {
+		slog.Error("Failed to record audit event", "action", ev.Action, "err", err)
+		return
+	}
+	line, err := json.Marshal(&entry)
+	if err != nil {
+		slog.Error("Failed to record audit event", "action", ev.Action, "err", err)
+		return
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		slog.Error("Failed to write audit log", "err", err)
+		return
+	}
+	l.seq, l.last = entry.Seq, entry.Hash
+}
+
+// Close 关闭日志文件
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// mac 计算记录的 hash（HMAC-SHA256），不包含 hash 字段本身
+func mac(key []byte, entry *Entry) (string, error) {
+	unsigned := *entry
+	unsigned.Hash = ""
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return "", err
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// diff 序列化修改前后的对象，两者都存在时只保留值不同的顶层字段
+func diff(before, after interface{}) (json.RawMessage, json.RawMessage, error) {
+	b, err := marshal(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := marshal(after)
+	if err != nil {
+		return nil, nil, err
+	}
+	if b == nil || a == nil {
+		return b, a, nil
+	}
+
+	var bFields, aFields map[string]json.RawMessage
+	if json.Unmarshal(b, &bFields) != nil || json.Unmarshal(a, &aFields) != nil {
+		// 不是 JSON 对象，整体记录
+		return b, a, nil
+	}
+	for key, value := range bFields {
+		if other, exists := aFields[key]; exists && bytes.Equal(value, other) {
+			delete(bFields, key)
+			delete(aFields, key)
+		}
+	}
+	if b, err = json.Marshal(bFields); err != nil {
+		return nil, nil, err
+	}
+	if a, err = json.Marshal(aFields); err != nil {
+		return nil, nil, err
+	}
+	return b, a, nil
+}
+
+// marshal 序列化对象，nil 返回 nil
+func marshal(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+// VerifyResult 校验结果
+type VerifyResult struct {
+	Records int    // 记录数
+	Head    string // 最后一条记录的 hash，保存在别处可以发现末尾被截断
+}
+
+// Verify 用写入时的 HMAC 密钥校验日志文件的链
+func Verify(path string, key []byte) (VerifyResult, error) {
+	var result VerifyResult
+
+	file, err := os.Open(path)
+	if err != nil {
+		return result, err
+	}
+	defer file.Close()
+
+	err = scan(file, func(line int, entry *Entry) error {
+		if entry.Seq != uint64(result.Records)+1 {
+			return fmt.Errorf("%w at line %d: expected seq %d, got %d", ErrTampered, line, result.Records+1, entry.Seq)
+		}
+		if entry.Prev != result.Head {
+			return fmt.Errorf("%w at line %d: prev does not match the previous record", ErrTampered, line)
+		}
+		sum, err := mac(key, entry)
+		if err != nil {
+			return err
+		}
+		if !hmac.Equal([]byte(sum), []byte(entry.Hash)) {
+			return fmt.Errorf("%w at line %d: record was modified or the audit key is wrong", ErrTampered, line)
+		}
+		result.Records++
+		result.Head = entry.Hash
+		return nil
+	})
+	return result, err
+}
+
+// Filter 查询条件，零值字段不限制
+type Filter struct {
+	From   time.Time
+	To     time.Time
+	Actor  string // 完整的 actor（如 "user:alice"）或去掉类型前缀的名称
+	Action string
+	Limit  int // 返回最近的多少条，0 不限制
+}
+
+// match 判断记录是否满足条件
+func (f *Filter) match(entry *Entry) bool {
+	if !f.From.IsZero() && entry.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && entry.Time.After(f.To) {
+		return false
+	}
+	if f.Action != "" && entry.Action != f.Action {
+		return false
+	}
+	if f.Actor != "" && entry.Actor != f.Actor {
+		if _, name, found := strings.Cut(entry.Actor, ":"); !found || name != f.Actor {
+			return false
+		}
+	}
+	return true
+}
+
+// Query 按时间顺序返回满足条件的记录，设置了 Limit 时只返回最近的 Limit 条
+func (l *Log) Query(filter Filter) ([]Entry, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	// 写入都在锁内完成，锁内取得的长度总在行尾，之后追加的记录不读取
+	l.mu.Lock()
+	info, err := l.file.Stat()
+	l.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make([]Entry, 0)
+	err = scan(io.LimitReader(file, info.Size()), func(line int, entry *Entry) error {
+		if filter.match(entry) {
+			entries = append(entries, *entry)
+			if filter.Limit > 0 && len(entries) > filter.Limit {
+				entries = entries[1:]
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// scan 逐行解析记录
+func scan(r io.Reader, fn func(line int, entry *Entry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("%w at line %d: %v", ErrTampered, line, err)
+		}
+		if err := fn(line, &entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
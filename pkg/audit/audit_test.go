@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeLog 用 key 写入 n 条记录，返回日志路径
+func writeLog(t *testing.T, key []byte, n int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		l.Record(Event{Action: ActionLogin, Actor: "user:alice", Source: "192.0.2.1"})
+	}
+	l.Close()
+	return path
+}
+
+// readEntries 读取日志的全部记录
+func readEntries(t *testing.T, path string) []Entry {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []Entry
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// writeEntries 覆盖日志文件
+func writeEntries(t *testing.T, path string, entries []Entry) {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		line, _ := json.Marshal(&entry)
+		buf.Write(append(line, '\n'))
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, KeySize)
+	path := writeLog(t, key, 3)
+
+	result, err := Verify(path, key)
+	if err != nil || result.Records != 3 {
+		t.Fatalf("Expected 3 verified records, got %d, %v", result.Records, err)
+	}
+	if entries := readEntries(t, path); result.Head != entries[2].Hash {
+		t.Errorf("Expected the head to be the last hash, got %s", result.Head)
+	}
+
+	// 重新打开后继续同一条链
+	l, err := Open(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Record(Event{Action: ActionLogout, Actor: "user:alice"})
+	l.Close()
+	if result, err := Verify(path, key); err != nil || result.Records != 4 {
+		t.Errorf("Expected 4 verified records after reopening, got %d, %v", result.Records, err)
+	}
+
+	if _, err := Verify(path, bytes.Repeat([]byte{2}, KeySize)); !errors.Is(err, ErrTampered) {
+		t.Errorf("Expected a different key to fail verification, got %v", err)
+	}
+	if _, err := Open(path, bytes.Repeat([]byte{2}, KeySize)); err == nil {
+		t.Error("Expected opening with a different key to fail")
+	}
+}
+
+func TestVerifyTampering(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, KeySize)
+
+	tests := []struct {
+		name   string
+		tamper func(entries []Entry) []Entry
+	}{
+		{"modified", func(entries []Entry) []Entry {
+			entries[1].Source = "198.51.100.1"
+			return entries
+		}},
+		{"removed", func(entries []Entry) []Entry {
+			return append(entries[:1], entries[2:]...)
+		}},
+		{"reordered", func(entries []Entry) []Entry {
+			entries[1], entries[2] = entries[2], entries[1]
+			return entries
+		}},
+		// 没有密钥时按旧格式重新计算整条链也无法通过校验
+		{"rehashed without the key", func(entries []Entry) []Entry {
+			entries[1].Source = "198.51.100.1"
+			prev := ""
+			for i := range entries {
+				entries[i].Prev, entries[i].Hash = prev, ""
+				data, _ := json.Marshal(&entries[i])
+				sum := sha256.Sum256(data)
+				entries[i].Hash = hex.EncodeToString(sum[:])
+				prev = entries[i].Hash
+			}
+			return entries
+		}},
+	}
+	for _, tt := range tests {
+		path := writeLog(t, key, 3)
+		writeEntries(t, path, tt.tamper(readEntries(t, path)))
+		if _, err := Verify(path, key); !errors.Is(err, ErrTampered) {
+			t.Errorf("%s: expected ErrTampered, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestLoadOrCreateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "audit.key")
+	key, created, err := LoadOrCreateKey(path)
+	if err != nil || !created || len(key) != KeySize {
+		t.Fatalf("Expected a new %d-byte key, got %d bytes, %v, %v", KeySize, len(key), created, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the key file to be private, got %v, %v", info.Mode(), err)
+	}
+
+	loaded, created, err := LoadOrCreateKey(path)
+	if err != nil || created || !bytes.Equal(loaded, key) {
+		t.Errorf("Expected the existing key to be loaded, got %v, %v", created, err)
+	}
+
+	os.WriteFile(path, []byte("short\n"), 0600)
+	if _, _, err := LoadOrCreateKey(path); err == nil {
+		t.Error("Expected an invalid key file to be rejected")
+	}
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/protocol"
+)
+
+// certRenewRetry 续期没有成功时重试的间隔
+const certRenewRetry = 10 * time.Minute
+
+// renewCert 在客户端证书剩余有效期不足三分之一时通过控制连接续期，直到连接断开
+func (c *Client) renewCert(done chan struct{}) {
+	for {
+		leaf := c.cert.leaf()
+		lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+		if wait := time.Until(leaf.NotAfter.Add(-lifetime / 3)); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-done:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+
+		if err := c.requestRenewal(leaf); err != nil {
+			slog.Warn("Failed to request certificate renewal", "err", err)
+			return
+		}
+
+		// 成功后按新证书计算下次续期时间，失败或没有响应时稍后重试
+		timer := time.NewTimer(certRenewRetry)
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-c.renewed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// requestRenewal 生成新私钥，发送与当前证书同名的证书签名请求
+func (c *Client) requestRenewal(leaf *x509.Certificate) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: leaf.Subject.CommonName},
+	}, key)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.pendingKey = key
+	c.mu.Unlock()
+
+	msg, err := protocol.NewJSONMessage(protocol.MessageTypeCertRenew, &protocol.CertRenewPayload{
+		CSR: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})),
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("Renewing client certificate", "subject", leaf.Subject.CommonName, "expires", leaf.NotAfter.Format(time.RFC3339))
+	return c.writeMessage(msg)
+}
+
+// handleCertRenew 保存服务端签发的新证书，之后的连接使用新证书
+func (c *Client) handleCertRenew(msg *protocol.Message) {
+	var resp protocol.CertRenewPayload
+	if err := msg.DecodeJSON(&resp); err != nil {
+		slog.Warn("Invalid certificate renewal response", "err", err)
+		return
+	}
+	if resp.Error != "" {
+		slog.Warn("Certificate renewal failed", "err", resp.Error)
+		return
+	}
+
+	c.mu.Lock()
+	key := c.pendingKey
+	c.pendingKey = nil
+	c.mu.Unlock()
+	if key == nil {
+		slog.Warn("Unexpected certificate renewal response")
+		return
+	}
+
+	if err := c.saveRenewedCert([]byte(resp.Certificate), key); err != nil {
+		slog.Error("Failed to save renewed certificate", "err", err)
+		return
+	}
+	slog.Info("Client certificate renewed", "expires", c.cert.leaf().NotAfter.Format(time.RFC3339))
+
+	select {
+	case c.renewed <- struct{}{}:
+	default:
+	}
+}
+
+// saveRenewedCert 写入新证书和私钥
+func (c *Client) saveRenewedCert(certPEM []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := c.cert.replace(certPEM, keyPEM); err != nil {
+		return fmt.Errorf("invalid certificate: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/crypto"
+	"github.com/aethertunnel/aethertunnel/pkg/protocol"
+)
+
+// dialServerVia 经过给定的中继依次连接到服务器，没有中继时直接连接
+//
+// 每一跳用各自的令牌完成 Noise 握手；到达服务器后再完成端到端的 Noise 握手，
+// 中继只能看到密文。启用 TLS 的中继和服务器在各自的 Noise 握手之前完成 TLS 握手，
+// 与服务器的 TLS（或 TLS 伪装）同样经过中继端到端建立。
+func (c *Client) dialServerVia(hops []config.HopConfig) (net.Conn, error) {
+	if c.tlsErr != nil {
+		return nil, c.tlsErr
+	}
+
+	addr := c.cfg.Client.ServerAddr
+	if len(hops) > 0 {
+		addr = hops[0].Addr
+	}
+
+	raw, err := c.dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	raw.SetDeadline(time.Now().Add(dialTimeout))
+
+	conn, err := c.connectVia(raw, hops)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+
+	raw.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// connectVia 在已连接第一跳的连接上完成逐跳握手和与服务器的握手
+func (c *Client) connectVia(conn net.Conn, hops []config.HopConfig) (net.Conn, error) {
+	for i, hop := range hops {
+		if hop.TLS {
+			tlsConfig, err := hopTLSConfig(&c.cfg.Client.TLS, hop.Addr)
+			if err != nil {
+				return nil, fmt.Errorf("relay %s: %w", hop.Addr, err)
+			}
+			if conn, err = wrapTLS(conn, tlsConfig); err != nil {
+				return nil, fmt.Errorf("relay %s: %w", hop.Addr, err)
+			}
+		}
+
+		next := c.cfg.Client.ServerAddr
+		if i+1 < len(hops) {
+			next = hops[i+1].Addr
+		}
+		if err := relayHandshake(conn, string(hop.Token), next); err != nil {
+			return nil, fmt.Errorf("relay %s: %w", hop.Addr, err)
+		}
+	}
+
+	if c.mimic != nil {
+		var err error
+		if conn, err = c.mimic.wrap(conn); err != nil {
+			return nil, err
+		}
+	} else if c.tls != nil {
+		var err error
+		if conn, err = wrapTLS(conn, c.tls); err != nil {
+			return nil, err
+		}
+	}
+
+	secure, err := c.handshake(conn)
+	if err != nil {
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+	return secure, nil
+}
+
+// relayHandshake 请求当前中继连接下一跳
+func relayHandshake(conn net.Conn, token, next string) error {
+	handshake, err := crypto.NewNoiseHandshake(crypto.NoiseNNpsk0, true, nil, nil, crypto.DerivePSK(token, crypto.PSKPurposeRelay))
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(&protocol.RelayPayload{Target: next})
+	if err != nil {
+		return err
+	}
+	hello, err := handshake.WriteMessage(payload)
+	if err != nil {
+		return err
+	}
+	if err := protocol.WriteMessage(conn, &protocol.Message{Type: protocol.MessageTypeRelay, Payload: hello}); err != nil {
+		return err
+	}
+
+	reply, err := protocol.ReadMessage(conn)
+	if err != nil {
+		return err
+	}
+	if reply.Type != protocol.MessageTypeRelay {
+		return fmt.Errorf("unexpected message type %d", reply.Type)
+	}
+	result, err := handshake.ReadMessage(reply.Payload)
+	if err != nil {
+		return fmt.Errorf("invalid relay token: %w", err)
+	}
+	if string(result) != "OK" {
+		return fmt.Errorf("rejected: %s", result)
+	}
+	return nil
+}
+
+// handshake 与服务器完成 Noise 握手，配置了静态密钥时用 IK，否则用令牌派生的 PSK；
+// 两者都没有配置时由 TLS 客户端证书认证（NK）
+//
+// 第一条消息携带用户名和支持的传输加密算法（用服务器静态公钥加密），PSK 模式下还携带
+// 令牌：服务器先验证令牌再回复，令牌错误时不会收到任何回复。令牌只有服务器静态私钥能解密，
+// 由它派生的 PSK 同时混入握手。服务器按自己的偏好顺序在第二条消息中返回选定的算法。
+// 启用 post_quantum 时提出混合密钥交换，服务器回复的第一个字节表示是否同意。
+func (c *Client) handshake(conn net.Conn) (net.Conn, error) {
+	pattern := crypto.NoiseNKpsk2
+	var psk []byte
+	hello := protocol.SecureHelloPayload{CipherSuites: crypto.CipherSuiteNames(c.suites), Timestamp: time.Now().Unix()}
+	switch {
+	case c.static != nil:
+		pattern = crypto.NoiseIK
+	case c.cfg.Client.AuthToken == "" && c.tls != nil:
+		pattern = crypto.NoiseNK
+	default:
+		psk = crypto.DerivePSK(string(c.cfg.Client.AuthToken), crypto.PSKPurposeControl)
+		hello.User = c.cfg.Client.User
+		hello.Token = string(c.cfg.Client.AuthToken)
+	}
+	if c.cfg.Client.PostQuantum {
+		pattern |= crypto.NoiseHybrid
+	}
+
+	handshake, err := crypto.NewNoiseHandshake(pattern, true, c.static, c.serverKey, psk)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(&hello)
+	if err != nil {
+		return nil, err
+	}
+	message, err := handshake.WriteMessage(payload)
+	if err != nil {
+		return nil, err
+	}
+	msg := &protocol.Message{Type: protocol.MessageTypeSecure, Payload: append([]byte{byte(pattern)}, message...)}
+	if err := protocol.WriteMessage(conn, msg); err != nil {
+		return nil, err
+	}
+
+	reply, err := protocol.ReadMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Type != protocol.MessageTypeSecure || len(reply.Payload) == 0 {
+		return nil, fmt.Errorf("unexpected message type %d", reply.Type)
+	}
+	mode := crypto.NoisePattern(reply.Payload[0])
+	if mode&^crypto.NoiseHybrid != pattern&^crypto.NoiseHybrid || mode&^pattern != 0 {
+		return nil, fmt.Errorf("unexpected handshake mode %d", mode)
+	}
+	if mode&crypto.NoiseHybrid == 0 {
+		handshake.DeclineHybrid()
+	}
+	acceptPayload, err := handshake.ReadMessage(reply.Payload[1:])
+	if err != nil {
+		return nil, fmt.Errorf("server key or auth token mismatch: %w", err)
+	}
+
+	var accept protocol.SecureAcceptPayload
+	if err := json.Unmarshal(acceptPayload, &accept); err != nil {
+		return nil, fmt.Errorf("invalid handshake reply: %w", err)
+	}
+	suite, err := crypto.ParseCipherSuite(accept.CipherSuite)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := crypto.NegotiateCipherSuite([]crypto.CipherSuite{suite}, c.suites); !ok {
+		return nil, fmt.Errorf("server selected cipher suite %s which was not offered", suite)
+	}
+
+	return handshake.Conn(conn, suite)
+}
@@ -0,0 +1,410 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/crypto"
+	tunnelnet "github.com/aethertunnel/aethertunnel/pkg/net"
+	"github.com/aethertunnel/aethertunnel/pkg/protocol"
+)
+
+const (
+	dialTimeout       = 10 * time.Second
+	streamOpenTimeout = 15 * time.Second
+	heartbeatInterval = 30 * time.Second
+	reconnectDelay    = 5 * time.Second
+)
+
+// Client 隧道客户端
+type Client struct {
+	cfg        *config.Config
+	encryption *crypto.Encryption
+	clientID   string
+	proxies    map[string]config.ProxyConfig
+	ctl        net.Conn
+	session    *tunnelnet.Session // 控制连接上复用的流，未连接时为 nil
+	dialer     *tunnelnet.ProxyDialer
+	serverKey  []byte               // 服务器静态公钥
+	static     *crypto.NoiseKeypair // 客户端静态密钥，为 nil 时用令牌认证
+	suites     []crypto.CipherSuite // 提供给服务器的传输加密算法
+	tls        *tls.Config          // 连接服务器使用的 TLS，未启用时为 nil
+	mimic      *mimic               // 以浏览器的 TLS 握手连接服务器，未启用时为 nil
+	cert       *clientCert          // TLS 客户端证书，未配置时为 nil
+	pendingKey *ecdsa.PrivateKey    // 等待续期响应的新私钥
+	renewed    chan struct{}        // 证书续期成功的通知
+	tlsErr     error                // TLS 配置加载失败的原因，连接时返回，不降级为明文
+	writeMu    sync.Mutex
+	mu         sync.RWMutex
+}
+
+// NewClient 创建客户端
+func NewClient(cfg *config.Config, encryption *crypto.Encryption) *Client {
+	clientID := cfg.Client.ClientID
+	if clientID == "" {
+		if hostname, err := os.Hostname(); err == nil {
+			clientID = hostname
+		}
+	}
+
+	dialer, err := newServerDialer(&cfg.Client)
+	if err != nil {
+		slog.Error("Invalid upstream proxy, connecting directly", "err", err)
+		dialer, _ = tunnelnet.NewProxyDialer("", "", dialTimeout)
+	}
+	if proxy := dialer.Proxy(); proxy != nil {
+		slog.Info("Connecting to server through proxy", "proxy", proxy.Redacted())
+	}
+
+	serverKey, err := crypto.ParseNoisePublicKey(cfg.Client.ServerPublicKey)
+	if err != nil {
+		slog.Error("Invalid server public key", "err", err)
+	}
+
+	suites, err := crypto.ParseCipherSuites(cfg.Client.CipherSuites)
+	if err != nil {
+		slog.Error("Invalid cipher suites, using defaults", "err", err)
+		suites = crypto.DefaultCipherSuites()
+	}
+
+	var static *crypto.NoiseKeypair
+	if keyFile := cfg.Client.NoiseKeyFile; keyFile != "" {
+		kp, created, err := crypto.LoadOrCreateNoiseKeypair(keyFile)
+		if err != nil {
+			slog.Error("Failed to load client key, using auth token", "file", keyFile, "err", err)
+		} else {
+			if created {
+				slog.Info("Generated client key", "file", keyFile)
+			}
+			slog.Info("Client public key", "public_key", kp.PublicKeyString())
+			static = kp
+		}
+	}
+
+	var (
+		tlsConfig *tls.Config
+		cert      *clientCert
+		tlsErr    error
+	)
+	if tlsCfg := &cfg.Client.TLS; tlsCfg.Enabled {
+		if tlsCfg.CertFile != "" {
+			cert, tlsErr = loadClientCert(tlsCfg.CertFile, tlsCfg.KeyFile)
+		}
+		if tlsErr == nil {
+			tlsConfig, tlsErr = newTLSConfig(tlsCfg, cfg.Client.ServerAddr, cert)
+		}
+		if tlsErr != nil {
+			slog.Error("Invalid TLS config", "err", tlsErr)
+		}
+	}
+
+	var mimic *mimic
+	if cfg.Client.Mimic.Enabled {
+		mimic = newMimic(&cfg.Client.Mimic)
+		slog.Info("Mimicking browser TLS handshake", "browser", cfg.Client.Mimic.Browser(), "server_name", cfg.Client.Mimic.ServerName)
+	}
+
+	return &Client{
+		cfg:        cfg,
+		encryption: encryption,
+		clientID:   clientID,
+		proxies:    make(map[string]config.ProxyConfig),
+		dialer:     dialer,
+		serverKey:  serverKey,
+		static:     static,
+		suites:     suites,
+		tls:        tlsConfig,
+		mimic:      mimic,
+		cert:       cert,
+		renewed:    make(chan struct{}, 1),
+		tlsErr:     tlsErr,
+	}
+}
+
+// Run 连接服务器并在断开后自动重连，不会返回
+func (c *Client) Run() {
+	c.startListeners()
+
+	for {
+		conn, err := c.connect()
+		if err != nil {
+			slog.Warn("Failed to connect", "server", c.cfg.Client.ServerAddr, "err", err)
+			time.Sleep(reconnectDelay)
+			continue
+		}
+
+		if secure, ok := conn.(*crypto.SecureConn); ok {
+			slog.Info("Connected to server", "server", c.cfg.Client.ServerAddr, "key_exchange", secure.KeyExchange(), "cipher_suite", secure.CipherSuite())
+		} else {
+			slog.Info("Connected to server", "server", c.cfg.Client.ServerAddr)
+		}
+
+		if err := c.serve(conn); err != nil {
+			slog.Warn("Control connection error", "err", err)
+		}
+		conn.Close()
+
+		slog.Info("Connection lost, reconnecting")
+		time.Sleep(reconnectDelay)
+	}
+}
+
+// dialServer 建立到服务器的连接，配置了上游代理时经代理连接，配置了中继时经中继连接
+func (c *Client) dialServer() (net.Conn, error) {
+	return c.dialServerVia(c.cfg.Client.Chain)
+}
+
+// connect 连接服务器并完成认证
+func (c *Client) connect() (net.Conn, error) {
+	conn, err := c.dialServer()
+	if err != nil {
+		return nil, err
+	}
+
+	authMsg, err := protocol.NewJSONMessage(protocol.MessageTypeAuth, &protocol.AuthPayload{
+		ClientID: c.clientID,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := protocol.WriteMessage(conn, authMsg); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// 读取认证响应
+	conn.SetReadDeadline(time.Now().Add(dialTimeout))
+	response, err := protocol.ReadMessage(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	if response.Type != protocol.MessageTypeAuth || string(response.Payload) != "OK" {
+		conn.Close()
+		return nil, fmt.Errorf("authentication failed: %s", response.Payload)
+	}
+
+	return conn, nil
+}
+
+// serve 注册代理并处理控制消息，直到连接断开
+func (c *Client) serve(conn net.Conn) error {
+	session := tunnelnet.NewSession(true, func(frame []byte) error {
+		c.writeMu.Lock()
+		defer c.writeMu.Unlock()
+
+		return protocol.WriteMessage(conn, &protocol.Message{Type: protocol.MessageTypeStream, Payload: frame})
+	}, nil)
+
+	c.mu.Lock()
+	c.ctl = conn
+	c.session = session
+	c.proxies = make(map[string]config.ProxyConfig)
+	for _, proxy := range c.cfg.Proxies {
+		c.proxies[proxy.Name] = proxy
+	}
+	c.mu.Unlock()
+
+	// 注册配置文件中的代理，动态代理由服务端在上线后下发
+	for _, proxy := range c.cfg.Proxies {
+		msg, err := protocol.NewJSONMessage(protocol.MessageTypeNewProxy, proxy)
+		if err != nil {
+			return err
+		}
+		if err := c.writeMessage(msg); err != nil {
+			return err
+		}
+	}
+
+	defer func() {
+		c.mu.Lock()
+		c.session = nil
+		c.mu.Unlock()
+		session.Close()
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go c.startHeartbeat(done)
+	if c.cert != nil {
+		go c.renewCert(done)
+	}
+
+	for {
+		msg, err := protocol.ReadMessage(conn)
+		if err != nil {
+			return err
+		}
+
+		switch msg.Type {
+		case protocol.MessageTypeHeartbeat:
+
+		case protocol.MessageTypeReqWorkConn:
+			var req protocol.WorkConnPayload
+			if err := msg.DecodeJSON(&req); err != nil {
+				slog.Warn("Invalid work connection request", "err", err)
+				continue
+			}
+			go c.handleWorkConn(&req)
+
+		case protocol.MessageTypeStream:
+			if err := session.HandleFrame(msg.Payload); err != nil {
+				return fmt.Errorf("invalid stream frame: %w", err)
+			}
+
+		case protocol.MessageTypeNewProxy:
+			var proxy config.ProxyConfig
+			if err := msg.DecodeJSON(&proxy); err != nil {
+				slog.Warn("Invalid proxy from server", "err", err)
+				continue
+			}
+			c.mu.Lock()
+			// 中继配置只在客户端本地保存
+			if existing, exists := c.proxies[proxy.Name]; exists {
+				proxy.Chain = existing.Chain
+			}
+			c.proxies[proxy.Name] = proxy
+			c.mu.Unlock()
+			slog.Info("Proxy ready", "proxy", proxy.Name, "local_ip", proxy.LocalIP, "local_port", proxy.LocalPort, "remote_port", proxy.RemotePort)
+
+		case protocol.MessageTypeCloseProxy:
+			var req protocol.CloseProxyPayload
+			if err := msg.DecodeJSON(&req); err != nil {
+				slog.Warn("Invalid close proxy request", "err", err)
+				continue
+			}
+			c.mu.Lock()
+			delete(c.proxies, req.Name)
+			c.mu.Unlock()
+			slog.Info("Proxy removed by server", "proxy", req.Name)
+
+		case protocol.MessageTypeCertRenew:
+			c.handleCertRenew(msg)
+
+		case protocol.MessageTypeError:
+			slog.Warn("Server error", "err", string(msg.Payload))
+
+		default:
+			slog.Warn("Unexpected message type from server", "type", msg.Type)
+		}
+	}
+}
+
+// writeMessage 在控制连接上发送消息
+func (c *Client) writeMessage(msg *protocol.Message) error {
+	c.mu.RLock()
+	conn := c.ctl
+	c.mu.RUnlock()
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return protocol.WriteMessage(conn, msg)
+}
+
+// startHeartbeat 定时发送心跳
+func (c *Client) startHeartbeat(done chan struct{}) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.writeMessage(protocol.NewHeartbeatMessage()); err != nil {
+				slog.Warn("Failed to send heartbeat", "err", err)
+				return
+			}
+		}
+	}
+}
+
+// openStream 通过控制连接打开流，由服务端连接目标地址
+func (c *Client) openStream(req *protocol.StreamOpenPayload) (net.Conn, error) {
+	c.mu.RLock()
+	session := c.session
+	c.mu.RUnlock()
+	if session == nil {
+		return nil, fmt.Errorf("not connected to server")
+	}
+
+	meta, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return session.Open(meta, streamOpenTimeout)
+}
+
+// handleWorkConn 建立工作连接并转发到本地服务
+func (c *Client) handleWorkConn(req *protocol.WorkConnPayload) {
+	c.mu.RLock()
+	proxy, exists := c.proxies[req.Name]
+	c.mu.RUnlock()
+	if !exists {
+		slog.Warn("Work connection requested for unknown proxy", "proxy", req.Name)
+		return
+	}
+
+	localAddr := net.JoinHostPort(proxy.LocalIP, fmt.Sprint(proxy.LocalPort))
+	localConn, err := net.DialTimeout("tcp", localAddr, dialTimeout)
+	if err != nil {
+		slog.Warn("Failed to connect to local service", "proxy", proxy.Name, "address", localAddr, "err", err)
+		return
+	}
+
+	hops := proxy.Chain
+	if len(hops) == 0 {
+		hops = c.cfg.Client.Chain
+	}
+	workConn, err := c.dialServerVia(hops)
+	if err != nil {
+		slog.Warn("Failed to open work connection", "proxy", proxy.Name, "err", err)
+		localConn.Close()
+		return
+	}
+
+	req.ClientID = c.clientID
+	msg, err := protocol.NewJSONMessage(protocol.MessageTypeProxy, req)
+	if err == nil {
+		err = protocol.WriteMessage(workConn, msg)
+	}
+	if err != nil {
+		slog.Warn("Failed to start work connection", "proxy", proxy.Name, "err", err)
+		localConn.Close()
+		workConn.Close()
+		return
+	}
+
+	forwardData(workConn, localConn)
+}
+
+// forwardData 双向转发数据，任一方向结束后关闭两端
+func forwardData(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"os"
+	"strings"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	tunnelnet "github.com/aethertunnel/aethertunnel/pkg/net"
+)
+
+// newServerDialer 创建连接服务器使用的拨号器
+//
+// 配置文件未指定上游代理时依次使用 HTTP_PROXY、ALL_PROXY 环境变量，
+// 未指定 no_proxy 时使用 NO_PROXY 环境变量。
+func newServerDialer(cfg *config.ClientConfig) (*tunnelnet.ProxyDialer, error) {
+	var proxyURL string
+	switch {
+	case cfg.HTTPProxy != "":
+		u, err := config.ParseUpstreamProxy(cfg.HTTPProxy, "http")
+		if err != nil {
+			return nil, err
+		}
+		proxyURL = u.String()
+	case cfg.Socks5Proxy != "":
+		u, err := config.ParseUpstreamProxy(cfg.Socks5Proxy, "socks5")
+		if err != nil {
+			return nil, err
+		}
+		proxyURL = u.String()
+	default:
+		proxyURL = getenv("HTTP_PROXY", "http_proxy", "ALL_PROXY", "all_proxy")
+		if proxyURL != "" && !strings.Contains(proxyURL, "://") {
+			proxyURL = "http://" + proxyURL
+		}
+	}
+
+	noProxy := cfg.NoProxy
+	if noProxy == "" {
+		noProxy = getenv("NO_PROXY", "no_proxy")
+	}
+
+	return tunnelnet.NewProxyDialer(proxyURL, noProxy, dialTimeout)
+}
+
+// getenv 返回第一个非空的环境变量
+func getenv(keys ...string) string {
+	for _, key := range keys {
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
+	}
+	return ""
+}
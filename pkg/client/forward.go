@@ -0,0 +1,27 @@
+package client
+
+import (
+	"log/slog"
+	"net"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/protocol"
+)
+
+// forwardHandler 返回静态转发的连接处理函数，每个连接经控制连接转发到固定目标
+func (c *Client) forwardHandler(forward config.ForwardConfig) func(net.Conn) {
+	return func(conn net.Conn) {
+		target, err := c.openStream(&protocol.StreamOpenPayload{
+			Network: "tcp",
+			Address: forward.Target,
+			Forward: forward.Name,
+		})
+		if err != nil {
+			slog.Warn("Forward failed", "forward", forward.Name, "target", forward.Target, "err", err)
+			conn.Close()
+			return
+		}
+
+		forwardData(conn, target)
+	}
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	tunnelnet "github.com/aethertunnel/aethertunnel/pkg/net"
+	"github.com/aethertunnel/aethertunnel/pkg/protocol"
+)
+
+// handleHTTPConnect 处理一个 HTTP CONNECT 代理连接
+func (c *Client) handleHTTPConnect(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	reader := bufio.NewReader(conn)
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	if req.Method != http.MethodConnect {
+		writeHTTPStatus(conn, http.StatusMethodNotAllowed)
+		conn.Close()
+		return
+	}
+
+	address := req.Host
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "443")
+	}
+
+	target, err := c.openStream(&protocol.StreamOpenPayload{Network: "tcp", Address: address})
+	if err != nil {
+		slog.Warn("HTTP CONNECT failed", "address", address, "err", err)
+		writeHTTPStatus(conn, httpConnectStatus(err))
+		conn.Close()
+		return
+	}
+
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		conn.Close()
+		target.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	// 客户端可能在收到响应前就发送了数据
+	if n := reader.Buffered(); n > 0 {
+		data, _ := reader.Peek(n)
+		if _, err := target.Write(data); err != nil {
+			conn.Close()
+			target.Close()
+			return
+		}
+	}
+
+	forwardData(conn, target)
+}
+
+// httpConnectStatus 将打开流的错误转换为 HTTP 状态码
+func httpConnectStatus(err error) int {
+	var streamErr *tunnelnet.StreamError
+	if errors.As(err, &streamErr) && protocol.IsStreamDenied(streamErr.Reason) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadGateway
+}
+
+// writeHTTPStatus 返回不带内容的响应
+func writeHTTPStatus(conn net.Conn, status int) {
+	fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", status, http.StatusText(status))
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	utls "github.com/refraction-networking/utls"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/crypto"
+)
+
+// mimicFingerprints 浏览器名称到 uTLS ClientHello 指纹
+var mimicFingerprints = map[string]utls.ClientHelloID{
+	"chrome":  utls.HelloChrome_Auto,
+	"firefox": utls.HelloFirefox_Auto,
+	"safari":  utls.HelloSafari_Auto,
+	"edge":    utls.HelloEdge_Auto,
+	"ios":     utls.HelloIOS_Auto,
+}
+
+// sessionIDOffset 握手消息中 session_id 的位置：消息头（4 字节）、版本（2 字节）、
+// client_random（32 字节）和 session_id 长度（1 字节）之后
+const sessionIDOffset = 4 + 2 + 32 + 1
+
+// mimic 以浏览器的 TLS 1.3 握手连接服务器
+//
+// 不校验服务器证书：未通过认证的连接会被转发到伪装站点，之后的 Noise 握手负责认证服务器。
+type mimic struct {
+	auth       *crypto.MimicAuth
+	serverName string
+	hello      utls.ClientHelloID
+}
+
+// newMimic 根据 [client.mimic] 创建 TLS 伪装
+func newMimic(cfg *config.ClientMimicConfig) *mimic {
+	return &mimic{
+		auth:       crypto.NewMimicAuth(string(cfg.Key)),
+		serverName: cfg.ServerName,
+		hello:      mimicFingerprints[cfg.Browser()],
+	}
+}
+
+// wrap 在连接上完成 TLS 握手，ClientHello 的 session_id 携带隐蔽认证
+func (m *mimic) wrap(conn net.Conn) (net.Conn, error) {
+	uconn := utls.UClient(conn, &utls.Config{
+		ServerName:             m.serverName,
+		InsecureSkipVerify:     true,
+		SessionTicketsDisabled: true,
+	}, m.hello)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		return nil, fmt.Errorf("mimic: %w", err)
+	}
+
+	hello := uconn.HandshakeState.Hello
+	if len(hello.SessionId) != crypto.MimicSessionIDSize || len(hello.Raw) < sessionIDOffset+crypto.MimicSessionIDSize {
+		return nil, fmt.Errorf("mimic: fingerprint has no TLS 1.3 session id")
+	}
+	sessionID := m.auth.SessionID(hello.Random, time.Now())
+	copy(hello.SessionId, sessionID)
+	copy(hello.Raw[sessionIDOffset:], sessionID)
+
+	if err := uconn.Handshake(); err != nil {
+		return nil, fmt.Errorf("mimic: %w", err)
+	}
+	return uconn, nil
+}
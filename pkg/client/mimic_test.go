@@ -0,0 +1,208 @@
+package client
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	utls "github.com/refraction-networking/utls"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/server"
+)
+
+const testMimicKey = "mimic-test-key-0123456789"
+
+// recordingConn 记录写入连接的数据
+type recordingConn struct {
+	net.Conn
+	written bytes.Buffer
+	mu      sync.Mutex
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.written.Write(p)
+	c.mu.Unlock()
+	return c.Conn.Write(p)
+}
+
+// startMimicServer 启动以 decoy 为伪装站点的 TLS 伪装服务器，认证通过的连接回复 "tunnel"
+func startMimicServer(t *testing.T, decoy *httptest.Server) string {
+	t.Helper()
+	cfg := &config.ServerConfig{}
+	cfg.Mimic.Enabled = true
+	cfg.Mimic.Key = testMimicKey
+	cfg.Mimic.Decoy = decoy.Listener.Addr().String()
+	mimic, err := server.NewMimic(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle := mimic.Handler(func(conn net.Conn) {
+		conn.Write([]byte("tunnel"))
+		conn.Close()
+	}, nil)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// startDecoy 启动伪装站点，返回接受的连接数
+func startDecoy(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var accepted atomic.Int32
+	decoy := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("decoy"))
+	}))
+	decoy.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			accepted.Add(1)
+		}
+	}
+	decoy.StartTLS()
+	t.Cleanup(decoy.Close)
+	return decoy, &accepted
+}
+
+func TestMimicAuthenticatedClient(t *testing.T) {
+	decoy, accepted := startDecoy(t)
+	addr := startMimicServer(t, decoy)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	m := newMimic(&config.ClientMimicConfig{Key: testMimicKey, ServerName: "www.example.com"})
+	tlsConn, err := m.wrap(conn)
+	if err != nil {
+		t.Fatalf("Failed to complete the mimic handshake: %v", err)
+	}
+	data, err := io.ReadAll(tlsConn)
+	if err != nil || string(data) != "tunnel" {
+		t.Errorf("Expected the tunnel to answer, got %q, %v", data, err)
+	}
+	if n := accepted.Load(); n != 0 {
+		t.Errorf("Expected the decoy not to be used, it accepted %d connections", n)
+	}
+}
+
+func TestMimicUnauthenticatedClient(t *testing.T) {
+	decoy, _ := startDecoy(t)
+	addr := startMimicServer(t, decoy)
+
+	clients := map[string]func(net.Conn) (*x509.Certificate, error){
+		"plain TLS": func(conn net.Conn) (*x509.Certificate, error) {
+			tlsConn := tls.Client(conn, &tls.Config{ServerName: "www.example.com", InsecureSkipVerify: true})
+			if err := tlsConn.Handshake(); err != nil {
+				return nil, err
+			}
+			return tlsConn.ConnectionState().PeerCertificates[0], nil
+		},
+		"wrong key": func(conn net.Conn) (*x509.Certificate, error) {
+			m := newMimic(&config.ClientMimicConfig{Key: "wrong-mimic-key-0123456789", ServerName: "www.example.com"})
+			wrapped, err := m.wrap(conn)
+			if err != nil {
+				return nil, err
+			}
+			return wrapped.(*utls.UConn).ConnectionState().PeerCertificates[0], nil
+		},
+	}
+	for name, handshake := range clients {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		cert, err := handshake(conn)
+		conn.Close()
+		if err != nil {
+			t.Errorf("%s: expected the decoy to complete the handshake, got %v", name, err)
+			continue
+		}
+		if !cert.Equal(decoy.Certificate()) {
+			t.Errorf("%s: expected the decoy's certificate, got %s", name, cert.Subject)
+		}
+	}
+}
+
+func TestMimicReplayedClientHello(t *testing.T) {
+	decoy, accepted := startDecoy(t)
+	addr := startMimicServer(t, decoy)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	recorded := &recordingConn{Conn: conn}
+
+	m := newMimic(&config.ClientMimicConfig{Key: testMimicKey, ServerName: "www.example.com"})
+	if _, err := m.wrap(recorded); err != nil {
+		t.Fatalf("Failed to complete the mimic handshake: %v", err)
+	}
+	recorded.mu.Lock()
+	written := recorded.written.Bytes()
+	recorded.mu.Unlock()
+	// 第一个 TLS 记录是 ClientHello
+	hello := written[:5+int(written[3])<<8|int(written[4])]
+
+	replay, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replay.Close()
+	replay.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := replay.Write(hello); err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(replay, header); err != nil || header[0] != 0x16 {
+		t.Fatalf("Expected a ServerHello, got %x, %v", header, err)
+	}
+	if n := accepted.Load(); n != 1 {
+		t.Errorf("Expected the replayed ClientHello to reach the decoy, it accepted %d connections", n)
+	}
+}
+
+func TestMimicSilentClient(t *testing.T) {
+	decoy, accepted := startDecoy(t)
+	addr := startMimicServer(t, decoy)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// 不发送任何数据就结束写入，连接同样交给伪装站点
+	conn.(*net.TCPConn).CloseWrite()
+	for deadline := time.Now().Add(5 * time.Second); accepted.Load() == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the connection to reach the decoy")
+		}
+	}
+}
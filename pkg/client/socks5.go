@@ -0,0 +1,326 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	tunnelnet "github.com/aethertunnel/aethertunnel/pkg/net"
+	"github.com/aethertunnel/aethertunnel/pkg/protocol"
+)
+
+// handshakeTimeout 本地代理握手超时
+const handshakeTimeout = 30 * time.Second
+
+// SOCKS5 协议常量（RFC 1928）
+const (
+	socks5Version = 0x05
+
+	socksMethodNoAuth       = 0x00
+	socksMethodNoAcceptable = 0xFF
+
+	socksCmdConnect      = 0x01
+	socksCmdUDPAssociate = 0x03
+
+	socksAtypIPv4   = 0x01
+	socksAtypDomain = 0x03
+	socksAtypIPv6   = 0x04
+
+	socksReplySucceeded           = 0x00
+	socksReplyGeneralFailure      = 0x01
+	socksReplyNotAllowed          = 0x02
+	socksReplyHostUnreachable     = 0x04
+	socksReplyCommandNotSupported = 0x07
+)
+
+// startListeners 启动本地 SOCKS5、HTTP CONNECT 和静态转发监听
+func (c *Client) startListeners() {
+	if addr := c.cfg.Client.Socks5Listen; addr != "" {
+		go c.listen("SOCKS5", addr, c.handleSocks5)
+	}
+	if addr := c.cfg.Client.HTTPConnectListen; addr != "" {
+		go c.listen("HTTP CONNECT", addr, c.handleHTTPConnect)
+	}
+	for _, forward := range c.cfg.Forwards {
+		go c.listen("Forward "+forward.Name, forward.Listen, c.forwardHandler(forward))
+	}
+}
+
+// listen 监听本地地址，每个连接交给 handler 处理
+func (c *Client) listen(name, addr string, handler func(net.Conn)) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error("Failed to start listener", "listener", name, "addr", addr, "err", err)
+		return
+	}
+	slog.Info("Listening", "listener", name, "addr", addr)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			slog.Info("Listener stopped", "listener", name, "err", err)
+			return
+		}
+		go handler(conn)
+	}
+}
+
+// handleSocks5 处理一个 SOCKS5 连接，支持 CONNECT 和 UDP ASSOCIATE
+func (c *Client) handleSocks5(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	// 协商认证方式，本地监听只支持无认证
+	var greeting [2]byte
+	if _, err := io.ReadFull(conn, greeting[:]); err != nil || greeting[0] != socks5Version {
+		conn.Close()
+		return
+	}
+	methods := make([]byte, greeting[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		conn.Close()
+		return
+	}
+	if bytes.IndexByte(methods, socksMethodNoAuth) < 0 {
+		conn.Write([]byte{socks5Version, socksMethodNoAcceptable})
+		conn.Close()
+		return
+	}
+	if _, err := conn.Write([]byte{socks5Version, socksMethodNoAuth}); err != nil {
+		conn.Close()
+		return
+	}
+
+	// 读取请求
+	var header [3]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil || header[0] != socks5Version {
+		conn.Close()
+		return
+	}
+	address, err := readSocksAddr(conn)
+	if err != nil {
+		writeSocksReply(conn, socksReplyGeneralFailure, nil)
+		conn.Close()
+		return
+	}
+
+	switch header[1] {
+	case socksCmdConnect:
+		target, err := c.openStream(&protocol.StreamOpenPayload{Network: "tcp", Address: address})
+		if err != nil {
+			slog.Warn("SOCKS5 connect failed", "address", address, "err", err)
+			writeSocksReply(conn, socksReplyCode(err), nil)
+			conn.Close()
+			return
+		}
+		if err := writeSocksReply(conn, socksReplySucceeded, nil); err != nil {
+			conn.Close()
+			target.Close()
+			return
+		}
+		conn.SetDeadline(time.Time{})
+		forwardData(conn, target)
+
+	case socksCmdUDPAssociate:
+		c.handleUDPAssociate(conn)
+
+	default:
+		writeSocksReply(conn, socksReplyCommandNotSupported, nil)
+		conn.Close()
+	}
+}
+
+// handleUDPAssociate 在本地 UDP 端口和服务端之间转发数据报，直到 TCP 连接关闭
+func (c *Client) handleUDPAssociate(conn net.Conn) {
+	defer conn.Close()
+
+	localIP := conn.LocalAddr().(*net.TCPAddr).IP
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		writeSocksReply(conn, socksReplyGeneralFailure, nil)
+		return
+	}
+	defer udpConn.Close()
+
+	stream, err := c.openStream(&protocol.StreamOpenPayload{Network: "udp"})
+	if err != nil {
+		slog.Warn("SOCKS5 UDP associate failed", "err", err)
+		writeSocksReply(conn, socksReplyCode(err), nil)
+		return
+	}
+	defer stream.Close()
+
+	if err := writeSocksReply(conn, socksReplySucceeded, udpConn.LocalAddr()); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	// 只接受发起关联的主机发来的数据报
+	peerIP := conn.RemoteAddr().(*net.TCPAddr).IP
+	var (
+		clientAddr *net.UDPAddr
+		mu         sync.Mutex
+	)
+
+	go func() {
+		defer conn.Close()
+
+		for {
+			address, data, err := protocol.ReadDatagram(stream)
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			dst := clientAddr
+			mu.Unlock()
+			if dst == nil {
+				continue
+			}
+
+			packet, err := appendSocksAddr([]byte{0, 0, 0}, address)
+			if err != nil {
+				continue
+			}
+			udpConn.WriteToUDP(append(packet, data...), dst)
+		}
+	}()
+
+	go func() {
+		defer conn.Close()
+
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := udpConn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if !from.IP.Equal(peerIP) {
+				continue
+			}
+
+			// RSV(2) FRAG(1) 地址 数据，不支持分片
+			if n < 4 || buf[2] != 0 {
+				continue
+			}
+			reader := bytes.NewReader(buf[3:n])
+			address, err := readSocksAddr(reader)
+			if err != nil {
+				continue
+			}
+
+			mu.Lock()
+			clientAddr = from
+			mu.Unlock()
+
+			if err := protocol.WriteDatagram(stream, address, buf[n-reader.Len():n]); err != nil {
+				return
+			}
+		}
+	}()
+
+	// TCP 连接关闭时结束关联
+	io.Copy(io.Discard, conn)
+}
+
+// socksReplyCode 将打开流的错误转换为 SOCKS5 响应码
+func socksReplyCode(err error) byte {
+	var streamErr *tunnelnet.StreamError
+	if errors.As(err, &streamErr) {
+		if protocol.IsStreamDenied(streamErr.Reason) {
+			return socksReplyNotAllowed
+		}
+		return socksReplyHostUnreachable
+	}
+	return socksReplyGeneralFailure
+}
+
+// writeSocksReply 发送 SOCKS5 响应，bindAddr 为空时返回 0.0.0.0:0
+func writeSocksReply(w io.Writer, code byte, bindAddr net.Addr) error {
+	address := "0.0.0.0:0"
+	if bindAddr != nil {
+		address = bindAddr.String()
+	}
+
+	reply, err := appendSocksAddr([]byte{socks5Version, code, 0}, address)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(reply)
+	return err
+}
+
+// readSocksAddr 读取 SOCKS5 地址（ATYP、地址、端口），返回 host:port
+func readSocksAddr(r io.Reader) (string, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", err
+	}
+
+	var host string
+	switch atyp[0] {
+	case socksAtypIPv4, socksAtypIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if atyp[0] == socksAtypIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksAtypDomain:
+		var length [1]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", fmt.Errorf("unsupported address type %d", atyp[0])
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// appendSocksAddr 按 SOCKS5 格式追加 host:port
+func appendSocksAddr(b []byte, address string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port in %s", address)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, socksAtypIPv4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, socksAtypIPv6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("domain too long: %s", host)
+		}
+		b = append(b, socksAtypDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
@@ -0,0 +1,257 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+)
+
+// newTLSConfig 根据 [client.tls] 创建连接服务器使用的 TLS 配置
+//
+// 配置 pin_spki 时由 VerifyConnection 校验：有 ca_file 时先校验证书链，
+// 链中任一证书匹配指纹即可；没有 ca_file 时只接受指纹匹配的服务器证书。
+func newTLSConfig(cfg *config.ClientTLSConfig, serverAddr string, cert *clientCert) (*tls.Config, error) {
+	serverName := cfg.ServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(serverAddr)
+		if err != nil {
+			return nil, err
+		}
+		serverName = host
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if cfg.CAFile != "" {
+		pool, err := loadCertPool(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cert != nil {
+		tlsConfig.GetClientCertificate = cert.get
+	}
+
+	if len(cfg.PinSPKI) > 0 {
+		pins := make([][]byte, 0, len(cfg.PinSPKI))
+		for _, pin := range cfg.PinSPKI {
+			decoded, err := config.ParseSPKIPin(pin)
+			if err != nil {
+				return nil, err
+			}
+			pins = append(pins, decoded)
+		}
+
+		roots := tlsConfig.RootCAs
+		verifyChain := cfg.CAFile != ""
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return fmt.Errorf("server sent no certificate")
+			}
+
+			candidates := state.PeerCertificates[:1]
+			if verifyChain {
+				intermediates := x509.NewCertPool()
+				for _, cert := range state.PeerCertificates[1:] {
+					intermediates.AddCert(cert)
+				}
+				chains, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+					Roots:         roots,
+					Intermediates: intermediates,
+					DNSName:       serverName,
+				})
+				if err != nil {
+					return err
+				}
+				candidates = nil
+				for _, chain := range chains {
+					candidates = append(candidates, chain...)
+				}
+			}
+
+			for _, cert := range candidates {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				for _, pin := range pins {
+					if bytes.Equal(sum[:], pin) {
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("server certificate does not match pin_spki")
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// hopTLSConfig 创建连接中继使用的 TLS 配置，用 ca_file 验证中继证书
+func hopTLSConfig(cfg *config.ClientTLSConfig, addr string) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.CAFile != "" {
+		pool, err := loadCertPool(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// loadCertPool 加载 PEM 格式的证书包
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", filename)
+	}
+	return pool, nil
+}
+
+// wrapTLS 在连接上完成 TLS 握手
+func wrapTLS(conn net.Conn, tlsConfig *tls.Config) (net.Conn, error) {
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	return tlsConn, nil
+}
+
+// clientCert 客户端证书，续期后原子地替换文件和内存中的证书
+//
+// 续期时证书和私钥一起写入 cert_file（组合 PEM），一次重命名同时提交两者，中途崩溃也不会
+// 留下不配对的证书和私钥。cert_file 中有私钥时加载以它为准，key_file 只是随后同步更新。
+type clientCert struct {
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	mu       sync.RWMutex
+}
+
+// loadClientCert 加载客户端证书和私钥，cert_file 中有私钥时不读取 key_file
+func loadClientCert(certFile, keyFile string) (*clientCert, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	keyPEM := certPEM
+	if !hasPrivateKey(certPEM) {
+		if keyPEM, err = os.ReadFile(keyFile); err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	return &clientCert{certFile: certFile, keyFile: keyFile, cert: &cert}, nil
+}
+
+// get 用于 tls.Config.GetClientCertificate
+func (cc *clientCert) get(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	return cc.cert, nil
+}
+
+// leaf 返回当前证书
+func (cc *clientCert) leaf() *x509.Certificate {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	return cc.cert.Leaf
+}
+
+// hasPrivateKey 判断 PEM 数据中是否有私钥
+func hasPrivateKey(data []byte) bool {
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			return false
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			return true
+		}
+	}
+}
+
+// replace 保存续期得到的证书和对应私钥
+//
+// 组合 PEM 写入 cert_file 是唯一的提交点，之后才更新内存中的证书；key_file 的更新只为
+// 其他读取它的程序，失败时只记录日志。
+func (cc *clientCert) replace(certPEM, keyPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return err
+	}
+
+	combined := append(append([]byte{}, certPEM...), keyPEM...)
+	if err := writeFileAtomic(cc.certFile, combined, 0600); err != nil {
+		return err
+	}
+
+	cc.mu.Lock()
+	cc.cert = &cert
+	cc.mu.Unlock()
+
+	if cc.keyFile != cc.certFile {
+		if err := writeFileAtomic(cc.keyFile, keyPEM, 0600); err != nil {
+			slog.Warn("Failed to update key_file, the key in cert_file is used", "file", cc.keyFile, "err", err)
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic 先写同目录下的临时文件再重命名
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
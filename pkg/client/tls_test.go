@@ -0,0 +1,68 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/server"
+)
+
+func TestClientCertReplace(t *testing.T) {
+	dir := t.TempDir()
+	cm, _, err := server.LoadCertManager(&config.CertManagerConfig{
+		CACertFile: filepath.Join(dir, "ca.crt"),
+		CAKeyFile:  filepath.Join(dir, "ca.key"),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue := func() *server.IssuedCert {
+		issued, err := cm.Issue("alice/laptop", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return issued
+	}
+
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	first := issue()
+	os.WriteFile(certFile, []byte(first.Certificate), 0644)
+	os.WriteFile(keyFile, []byte(first.PrivateKey), 0600)
+	cc, err := loadClientCert(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renewed := issue()
+	if err := cc.replace([]byte(renewed.Certificate), []byte(renewed.PrivateKey)); err != nil {
+		t.Fatal(err)
+	}
+	if serial := cc.cert.Leaf.SerialNumber.Text(16); serial != renewed.Serial {
+		t.Errorf("Expected the renewed certificate %s in memory, got %s", renewed.Serial, serial)
+	}
+	if info, err := os.Stat(certFile); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected cert_file to be written with mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// cert_file 中有私钥时以它为准，key_file 未同步（例如中途崩溃）也能加载配对的证书
+	os.WriteFile(keyFile, []byte(first.PrivateKey), 0600)
+	loaded, err := loadClientCert(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Expected the combined cert_file to load, got %v", err)
+	}
+	if serial := loaded.cert.Leaf.SerialNumber.Text(16); serial != renewed.Serial {
+		t.Errorf("Expected the renewed certificate %s to load, got %s", renewed.Serial, serial)
+	}
+
+	// 旧的分开存放的证书和私钥仍然可以加载
+	os.WriteFile(certFile, []byte(first.Certificate), 0644)
+	if _, err := loadClientCert(certFile, keyFile); err != nil {
+		t.Errorf("Expected separate cert and key files to load, got %v", err)
+	}
+}
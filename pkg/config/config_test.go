@@ -1,8 +1,11 @@
 package config
 
 import (
+	"log/slog"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadServer(t *testing.T) {
@@ -51,7 +54,7 @@ local_ip = "127.0.0.1"
 local_port = 8080
 remote_port = 8080
 `
-	
+
 	// Write to temp file
 	err := os.WriteFile("test-config.toml", []byte(configContent), 0644)
 	if err != nil {
@@ -89,6 +92,7 @@ func TestLoadClient(t *testing.T) {
 [client]
 server_addr = "127.0.0.1:7001"
 auth_token = "test-client-token"
+server_public_key = "HSz6ALFcGcYF1LOPp/5tkLkK6BxQzu+7dNA8WuqGE3A="
 
 [[proxies]]
 name = "ssh"
@@ -97,7 +101,7 @@ local_ip = "127.0.0.1"
 local_port = 22
 remote_port = 2222
 `
-	
+
 	// Write to temp file
 	err := os.WriteFile("test-client-config.toml", []byte(configContent), 0644)
 	if err != nil {
@@ -141,4 +145,857 @@ func TestDefaultConfig(t *testing.T) {
 	if vpnConfig.MTU != 1500 {
 		t.Errorf("Expected MTU 1500, got %d", vpnConfig.MTU)
 	}
-}
\ No newline at end of file
+}
+func TestLoadClientTransformRules(t *testing.T) {
+	valid := `
+[client]
+server_addr = "127.0.0.1:7001"
+auth_token = "test-client-token"
+server_public_key = "HSz6ALFcGcYF1LOPp/5tkLkK6BxQzu+7dNA8WuqGE3A="
+
+[[proxies]]
+name = "web"
+type = "http"
+local_ip = "127.0.0.1"
+local_port = 8080
+remote_port = 8081
+
+[[proxies.request_transform]]
+type = "rewrite_path"
+from = "^/api/v1/(.*)$"
+to = "/v2/$1"
+
+[[proxies.request_transform]]
+type = "redirect"
+from = "^/old$"
+to = "/new"
+status = 301
+[proxies.request_transform.match]
+host = "*.example.com"
+methods = ["GET"]
+
+[[proxies.response_transform]]
+type = "set_header"
+name = "Strict-Transport-Security"
+value = "max-age=31536000"
+`
+	if err := os.WriteFile("test-transform-config.toml", []byte(valid), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+	defer os.Remove("test-transform-config.toml")
+
+	cfg, err := LoadClient("test-transform-config.toml")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(cfg.Proxies[0].RequestTransform) != 2 {
+		t.Errorf("Expected 2 request rules, got %d", len(cfg.Proxies[0].RequestTransform))
+	}
+	if cfg.Proxies[0].RequestTransform[1].Match.Host != "*.example.com" {
+		t.Errorf("Expected match host '*.example.com', got '%s'", cfg.Proxies[0].RequestTransform[1].Match.Host)
+	}
+
+	invalid := []TransformRule{
+		{Type: "unknown"},
+		{Type: TransformSetHeader, Name: "X-Test"},
+		{Type: TransformRewritePath, From: "([", To: "/"},
+		{Type: TransformRedirect, From: "^/$", To: "/x", Status: 200},
+		{Type: TransformDelHeader, Name: "X-Test", Match: TransformMatch{Methods: []string{"get"}}},
+	}
+	for _, rule := range invalid {
+		proxies := []ProxyConfig{{Name: "web", Type: "http", LocalPort: 8080, RemotePort: 8081, RequestTransform: []TransformRule{rule}}}
+		if err := validateProxies(proxies); err == nil {
+			t.Errorf("Expected rule %+v to be rejected", rule)
+		}
+	}
+
+	response := []ProxyConfig{{Name: "web", Type: "http", LocalPort: 8080, RemotePort: 8081, ResponseTransform: []TransformRule{
+		{Type: TransformRedirect, From: "^/$", To: "/x"},
+	}}}
+	if err := validateProxies(response); err == nil {
+		t.Error("Expected redirect in response_transform to be rejected")
+	}
+
+	tcp := []ProxyConfig{{Name: "ssh", Type: "tcp", LocalPort: 22, RemotePort: 2222, RequestTransform: []TransformRule{
+		{Type: TransformDelHeader, Name: "X-Test"},
+	}}}
+	if err := validateProxies(tcp); err == nil {
+		t.Error("Expected transform rules on tcp proxy to be rejected")
+	}
+}
+
+func TestAccessLogConfig(t *testing.T) {
+	valid := []AccessLogConfig{
+		{Enabled: false, LogFormat: "unknown"},
+		{Enabled: true},
+		{Enabled: true, LogFormat: "combined", LogFile: "./logs/web.log", MaxSize: "100MB", MaxBackups: 5},
+		{Enabled: true, LogFormat: "template", Template: "{{.Proxy}} {{.Status}}"},
+	}
+	for _, cfg := range valid {
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", cfg, err)
+		}
+	}
+
+	invalid := []AccessLogConfig{
+		{Enabled: true, LogFormat: "xml"},
+		{Enabled: true, LogFormat: "template"},
+		{Enabled: true, LogFormat: "template", Template: "{{.Proxy"},
+		{Enabled: true, MaxSize: "lots"},
+		{Enabled: true, MaxBackups: -1},
+	}
+	for _, cfg := range invalid {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", cfg)
+		}
+	}
+
+	shared := func(name, maxSize string, maxBackups int) ProxyConfig {
+		return ProxyConfig{Name: name, Type: "tcp", LocalPort: 22, AccessLog: AccessLogConfig{
+			Enabled: true, LogFile: "./logs/shared.log", MaxSize: maxSize, MaxBackups: maxBackups,
+		}}
+	}
+	if err := validateProxies([]ProxyConfig{shared("a", "1MB", 3), shared("b", "1024KB", 3)}); err != nil {
+		t.Errorf("Expected same rotation settings to be accepted, got %v", err)
+	}
+	if err := validateProxies([]ProxyConfig{shared("a", "1MB", 3), shared("b", "2MB", 3)}); err == nil {
+		t.Error("Expected different max_size on a shared access log to be rejected")
+	}
+	if err := validateProxies([]ProxyConfig{shared("a", "1MB", 3), shared("b", "1MB", 5)}); err == nil {
+		t.Error("Expected different max_backups on a shared access log to be rejected")
+	}
+
+	sizes := map[string]int64{"100": 100, "4KB": 4096, "100MB": 100 << 20, "1gb": 1 << 30}
+	for input, expected := range sizes {
+		size, err := ParseSize(input)
+		if err != nil || size != expected {
+			t.Errorf("ParseSize(%q) = %d, %v; expected %d", input, size, err, expected)
+		}
+	}
+}
+
+func TestParsePortRanges(t *testing.T) {
+	ranges, err := ParsePortRanges("8000-9000, 9500")
+	if err != nil {
+		t.Fatalf("Failed to parse port ranges: %v", err)
+	}
+	if len(ranges) != 2 || ranges[0] != (PortRange{8000, 9000}) || ranges[1] != (PortRange{9500, 9500}) {
+		t.Errorf("Unexpected port ranges: %+v", ranges)
+	}
+	if !ranges[0].Contains(8500) || ranges[1].Contains(9501) {
+		t.Error("PortRange.Contains returned wrong result")
+	}
+
+	for _, input := range []string{"abc", "0-10", "9000-8000", "60000-70000", "1-"} {
+		if _, err := ParsePortRanges(input); err == nil {
+			t.Errorf("Expected %q to be rejected", input)
+		}
+	}
+
+	policy := ProxyPolicyConfig{UserPorts: map[string]string{"office": ""}}
+	if err := policy.Validate(); err == nil {
+		t.Error("Expected empty user_ports range to be rejected")
+	}
+}
+
+func TestEgressConfig(t *testing.T) {
+	valid := EgressConfig{Enabled: true, Users: map[string]EgressRule{
+		"*":      {AllowDomains: []string{"*.corp.local"}},
+		"office": {AllowCIDRs: []string{"10.0.0.0/8", "fd00::/8"}, AllowPorts: "22,443"},
+	}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected egress config to be valid, got %v", err)
+	}
+
+	invalid := []EgressRule{
+		{},
+		{AllowCIDRs: []string{"10.0.0.0"}},
+		{AllowCIDRs: []string{"10.0.0.0/8"}, AllowPorts: "0"},
+		{AllowDomains: []string{"*."}},
+	}
+	for _, rule := range invalid {
+		cfg := EgressConfig{Users: map[string]EgressRule{"office": rule}}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", rule)
+		}
+	}
+}
+
+func TestValidateForwards(t *testing.T) {
+	forwards := []ForwardConfig{
+		{Name: "db", Listen: "127.0.0.1:5432", Target: "db.corp.local:5432"},
+	}
+	if err := validateForwards(forwards); err != nil {
+		t.Errorf("Expected forwards to be valid, got %v", err)
+	}
+
+	invalid := [][]ForwardConfig{
+		{{Listen: "127.0.0.1:5432", Target: "db:5432"}},
+		{{Name: "db", Listen: "5432", Target: "db:5432"}},
+		{{Name: "db", Listen: "127.0.0.1:5432", Target: "db"}},
+		{forwards[0], forwards[0]},
+	}
+	for _, cfg := range invalid {
+		if err := validateForwards(cfg); err == nil {
+			t.Errorf("Expected %+v to be rejected", cfg)
+		}
+	}
+}
+
+func TestUpstreamProxy(t *testing.T) {
+	u, err := ParseUpstreamProxy("user:pass@proxy.corp:3128", "http")
+	if err != nil {
+		t.Fatalf("Failed to parse proxy: %v", err)
+	}
+	if u.Scheme != "http" || u.Host != "proxy.corp:3128" || u.User.Username() != "user" {
+		t.Errorf("Unexpected proxy url: %s", u)
+	}
+
+	if _, err := ParseUpstreamProxy("socks5h://proxy.corp:1080", "socks5"); err != nil {
+		t.Errorf("Expected socks5h proxy to be valid, got %v", err)
+	}
+	if _, err := ParseUpstreamProxy("socks5://proxy.corp:1080", "http"); err == nil {
+		t.Error("Expected socks5 scheme in http_proxy to be rejected")
+	}
+
+	both := ClientConfig{HTTPProxy: "proxy:3128", Socks5Proxy: "proxy:1080"}
+	if err := both.validateUpstreamProxy(); err == nil {
+		t.Error("Expected http_proxy and socks5_proxy together to be rejected")
+	}
+}
+
+func TestRelayChain(t *testing.T) {
+	hops := []HopConfig{{Addr: "relay1.example.com:8080", Token: "t1"}, {Addr: "10.0.0.2:8080", Token: "t2"}}
+	if err := validateChain("client.chain", hops); err != nil {
+		t.Errorf("Expected chain to be valid, got %v", err)
+	}
+	if err := validateChain("client.chain", []HopConfig{{Addr: "relay1.example.com", Token: "t1"}}); err == nil {
+		t.Error("Expected hop without port to be rejected")
+	}
+	if err := validateChain("client.chain", []HopConfig{{Addr: "relay1.example.com:8080"}}); err == nil {
+		t.Error("Expected hop without token to be rejected")
+	}
+
+	content := `
+[server]
+bind_addr = "0.0.0.0"
+bind_port = 7000
+auth_token = "relay-token"
+role = "relay"
+
+[relay]
+allowed_targets = ["tunnel.example.com:7000"]
+`
+	tmpFile := t.TempDir() + "/relay.toml"
+	if err := os.WriteFile(tmpFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := LoadServer(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to load relay config: %v", err)
+	}
+	if cfg.Server.Role != RoleRelay || len(cfg.Relay.AllowedTargets) != 1 {
+		t.Errorf("Unexpected relay config: %+v %+v", cfg.Server, cfg.Relay)
+	}
+}
+
+func TestNoiseKeys(t *testing.T) {
+	serverKey := "HSz6ALFcGcYF1LOPp/5tkLkK6BxQzu+7dNA8WuqGE3A="
+	if err := validatePublicKey(serverKey); err != nil {
+		t.Errorf("Expected key to be valid, got %v", err)
+	}
+	if err := validatePublicKey("c2hvcnQ="); err == nil {
+		t.Error("Expected short key to be rejected")
+	}
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := dir + "/" + name
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return path
+	}
+
+	missing := write("missing.toml", `
+[client]
+server_addr = "127.0.0.1:7001"
+auth_token = "token"
+`)
+	if _, err := LoadClient(missing); err == nil {
+		t.Error("Expected client without server_public_key to be rejected")
+	}
+
+	keyOnly := write("key.toml", `
+[client]
+server_addr = "127.0.0.1:7001"
+server_public_key = "`+serverKey+`"
+noise_key_file = "client.key"
+`)
+	if _, err := LoadClient(keyOnly); err != nil {
+		t.Errorf("Expected client with static key and no token to be valid, got %v", err)
+	}
+
+	server := write("server.toml", `
+[server]
+bind_addr = "0.0.0.0"
+bind_port = 7000
+auth_token = "token"
+
+[server.authorized_keys]
+laptop = "not-a-key"
+`)
+	if _, err := LoadServer(server); err == nil {
+		t.Error("Expected invalid authorized key to be rejected")
+	}
+}
+
+func TestLoadUsers(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := dir + "/" + name
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write users file: %v", err)
+		}
+		return path
+	}
+
+	valid := write("users.toml", `
+[[users]]
+name = "alice"
+token = "alice-token"
+proxy_types = ["tcp", "http"]
+allow_ports = "8000-8100"
+allow_domains = ["*.example.com"]
+max_proxies = 5
+
+[[users]]
+name = "bob"
+public_key = "HSz6ALFcGcYF1LOPp/5tkLkK6BxQzu+7dNA8WuqGE3A="
+enabled = false
+`)
+	users, err := LoadUsers(valid)
+	if err != nil {
+		t.Fatalf("Failed to load users: %v", err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("Expected 2 users, got %d", len(users))
+	}
+	if !users[0].IsEnabled() || users[1].IsEnabled() {
+		t.Error("Expected alice enabled and bob disabled")
+	}
+	if users[0].MaxProxies != 5 || users[0].AllowPorts != "8000-8100" {
+		t.Errorf("Unexpected alice config: %+v", users[0])
+	}
+
+	invalid := map[string]string{
+		"reserved": `
+[[users]]
+name = "default"
+token = "token"
+`,
+		"duplicate": `
+[[users]]
+name = "alice"
+token = "one"
+
+[[users]]
+name = "alice"
+token = "two"
+`,
+		"no credential": `
+[[users]]
+name = "alice"
+`,
+		"proxy type": `
+[[users]]
+name = "alice"
+token = "token"
+proxy_types = ["ftp"]
+`,
+		"ports": `
+[[users]]
+name = "alice"
+token = "token"
+allow_ports = "9000-8000"
+`,
+	}
+	for name, content := range invalid {
+		if _, err := LoadUsers(write("invalid.toml", content)); err == nil {
+			t.Errorf("Expected %s users file to be rejected", name)
+		}
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := dir + "/" + name
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return path
+	}
+
+	server := `
+[server]
+bind_addr = "0.0.0.0"
+bind_port = 7000
+auth_token = "token"
+`
+	if _, err := LoadServer(write("tls-missing.toml", server+"enable_tls = true\n")); err == nil {
+		t.Error("Expected enable_tls without certificate to be rejected")
+	}
+	if _, err := LoadServer(write("plain-only.toml", server+"allow_plain = true\n")); err == nil {
+		t.Error("Expected allow_plain without enable_tls to be rejected")
+	}
+	if _, err := LoadServer(write("require.toml", server+"enable_tls = true\ncert_file = \"a\"\nkey_file = \"b\"\nrequire_client_cert = true\n")); err == nil {
+		t.Error("Expected require_client_cert without client_ca_file to be rejected")
+	}
+
+	client := `
+[client]
+server_addr = "127.0.0.1:7001"
+server_public_key = "HSz6ALFcGcYF1LOPp/5tkLkK6BxQzu+7dNA8WuqGE3A="
+`
+	certOnly := write("cert.toml", client+`
+[client.tls]
+enabled = true
+cert_file = "client.crt"
+key_file = "client.key"
+pin_spki = ["sha256/RdNs6Zq8blmP6XIuvoc9i7tTQ/H2bmhiaQuCk6nkc2A="]
+`)
+	cfg, err := LoadClient(certOnly)
+	if err != nil {
+		t.Fatalf("Expected client with certificate and no token to be valid, got %v", err)
+	}
+	if !cfg.Client.TLS.Enabled || len(cfg.Client.TLS.PinSPKI) != 1 {
+		t.Errorf("Unexpected TLS config: %+v", cfg.Client.TLS)
+	}
+
+	badPin := write("pin.toml", client+`auth_token = "token"
+[client.tls]
+enabled = true
+pin_spki = ["c2hvcnQ="]
+`)
+	if _, err := LoadClient(badPin); err == nil {
+		t.Error("Expected short pin to be rejected")
+	}
+
+	disabled := write("disabled.toml", client+`
+[client.tls]
+cert_file = "client.crt"
+key_file = "client.key"
+`)
+	if _, err := LoadClient(disabled); err == nil {
+		t.Error("Expected client certificate without tls.enabled to be rejected")
+	}
+}
+
+func TestCertManagerConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := dir + "/" + name
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return path
+	}
+
+	server := `
+[server]
+bind_addr = "0.0.0.0"
+bind_port = 7000
+auth_token = "token"
+`
+	tls := "enable_tls = true\ncert_file = \"a\"\nkey_file = \"b\"\n"
+
+	if _, err := LoadServer(write("no-tls.toml", server+"[cert_manager]\nenabled = true\n")); err == nil {
+		t.Error("Expected cert_manager without enable_tls to be rejected")
+	}
+	if _, err := LoadServer(write("ttl.toml", server+tls+"[cert_manager]\nenabled = true\ncert_ttl = \"10s\"\n")); err == nil {
+		t.Error("Expected cert_ttl below one minute to be rejected")
+	}
+
+	cfg, err := LoadServer(write("ok.toml", server+tls+"require_client_cert = true\n[cert_manager]\nenabled = true\ncert_ttl = \"12h\"\n"))
+	if err != nil {
+		t.Fatalf("Expected built-in CA to satisfy require_client_cert, got %v", err)
+	}
+	if ttl, _ := cfg.CertManager.TTL(); ttl != 12*time.Hour {
+		t.Errorf("Expected cert_ttl 12h, got %v", ttl)
+	}
+
+	var empty CertManagerConfig
+	if ttl, _ := empty.TTL(); ttl != DefaultCertTTL {
+		t.Errorf("Expected default cert_ttl, got %v", ttl)
+	}
+}
+
+func TestObfuscationTimestampSkew(t *testing.T) {
+	cases := map[string]struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		"default":  {"", DefaultTimestampSkew, false},
+		"custom":   {"5s", 5 * time.Second, false},
+		"disabled": {"0s", 0, false},
+		"negative": {"-1s", 0, true},
+		"invalid":  {"soon", 0, true},
+	}
+
+	for name, tc := range cases {
+		cfg := ObfuscationConfig{TimestampSkew: tc.value}
+		skew, err := cfg.MaxSkew()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: unexpected error %v", name, err)
+			continue
+		}
+		if skew != tc.want {
+			t.Errorf("%s: expected %v, got %v", name, tc.want, skew)
+		}
+	}
+}
+
+func TestObfuscationPipelines(t *testing.T) {
+	var empty ObfuscationConfig
+	if typ := empty.Type(); typ != DefaultObfuscationType {
+		t.Errorf("Expected default obfuscation type, got %s", typ)
+	}
+
+	valid := ObfuscationConfig{
+		DefaultType: "stealth",
+		Pipelines: map[string][]string{
+			"stealth": {"morph", "aes"},
+			"light":   {"xor"},
+		},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Expected valid pipelines, got %v", err)
+	}
+	if names := valid.PipelineNames(); len(names) != 2 || names[0] != "light" || names[1] != "stealth" {
+		t.Errorf("Expected sorted pipeline names, got %v", names)
+	}
+
+	invalid := map[string]map[string][]string{
+		"no stages": {"empty": {}},
+		"self":      {"loop": {"xor", "loop"}},
+		"nested":    {"inner": {"xor"}, "outer": {"inner", "aes"}},
+		"stego":     {"stealth": {"aes", "stego"}},
+		"chacha":    {"stealth": {"morph", "chacha"}},
+	}
+	for name, pipelines := range invalid {
+		cfg := ObfuscationConfig{Pipelines: pipelines}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected invalid pipelines to be rejected", name)
+		}
+	}
+
+	// The old chacha type did not encrypt and is not silently renamed to xchacha
+	for _, cfg := range []ObfuscationConfig{
+		{DefaultType: "chacha"},
+		{DefaultType: "xor", AllowedTypes: []string{"xor", "chacha"}},
+	} {
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "xchacha") {
+			t.Errorf("Expected %+v to be rejected in favour of xchacha, got %v", cfg, err)
+		}
+	}
+}
+
+func TestObfuscationFraming(t *testing.T) {
+	var empty ObfuscationConfig
+	if framing := empty.PacketFraming(); framing != ObfuscationFramingLength {
+		t.Errorf("Expected length framing by default, got %s", framing)
+	}
+
+	viaHTTP := ObfuscationConfig{DefaultType: "aes", Framing: "http"}
+	if err := viaHTTP.Validate(); err != nil || viaHTTP.PacketFraming() != ObfuscationFramingHTTP || viaHTTP.Type() != "aes" {
+		t.Errorf("Expected aes over http framing, got %s over %s (%v)", viaHTTP.Type(), viaHTTP.PacketFraming(), err)
+	}
+
+	// The old stego obfuscation type selects the HTTP framing
+	legacy := ObfuscationConfig{DefaultType: "stego"}
+	if err := legacy.Validate(); err != nil || legacy.PacketFraming() != ObfuscationFramingHTTP || legacy.Type() != "none" {
+		t.Errorf("Expected stego to mean http framing, got %s over %s (%v)", legacy.Type(), legacy.PacketFraming(), err)
+	}
+
+	for _, cfg := range []ObfuscationConfig{
+		{Framing: "websocket"},
+		{DefaultType: "stego", Framing: "length"},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", cfg)
+		}
+	}
+}
+
+func TestSecretSources(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := dir + "/" + name
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return path
+	}
+	server := "[server]\nbind_addr = \"0.0.0.0\"\nbind_port = 7000\n"
+
+	tokenFile := write("token", "file-token\n")
+	t.Setenv("AETHERTUNNEL_TEST_TOKEN", "env-token")
+
+	cases := map[string]struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		"string":       {`"plain-token"`, "plain-token", false},
+		"file":         {`{ file = "` + tokenFile + `" }`, "file-token", false},
+		"env":          {`{ env = "AETHERTUNNEL_TEST_TOKEN" }`, "env-token", false},
+		"command":      {`{ command = "echo command-token" }`, "command-token", false},
+		"missing file": {`{ file = "` + dir + `/missing" }`, "", true},
+		"unset env":    {`{ env = "AETHERTUNNEL_TEST_UNSET" }`, "", true},
+		"failed cmd":   {`{ command = "exit 1" }`, "", true},
+		"two sources":  {`{ file = "` + tokenFile + `", env = "AETHERTUNNEL_TEST_TOKEN" }`, "", true},
+		"unknown":      {`{ vault = "token" }`, "", true},
+	}
+
+	for name, tc := range cases {
+		cfg, err := LoadServer(write("server.toml", server+"auth_token = "+tc.value+"\n"))
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: unexpected error %v", name, err)
+			continue
+		}
+		if err == nil && string(cfg.Server.AuthToken) != tc.want {
+			t.Errorf("%s: expected %q, got %q", name, tc.want, cfg.Server.AuthToken)
+		}
+	}
+}
+
+func TestPreviousAuthTokens(t *testing.T) {
+	path := t.TempDir() + "/server.toml"
+	t.Setenv("AETHERTUNNEL_TEST_OLD_TOKEN", "env-old-token")
+	content := "[server]\nbind_addr = \"0.0.0.0\"\nbind_port = 7000\nauth_token = \"token\"\n" +
+		"[vpn]\nauth_token = \"new-token\"\n" +
+		"previous_auth_tokens = [\"old-token\", { env = \"AETHERTUNNEL_TEST_OLD_TOKEN\" }]\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadServer(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	got := SecretStrings(cfg.VPN.PreviousAuthTokens)
+	if len(got) != 2 || got[0] != "old-token" || got[1] != "env-old-token" {
+		t.Errorf("Expected previous tokens [old-token env-old-token], got %v", got)
+	}
+}
+
+func TestTokenHash(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := dir + "/" + name
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return path
+	}
+	server := "[server]\nbind_addr = \"0.0.0.0\"\nbind_port = 7000\n"
+	hash := `"$argon2id$v=19$m=19456,t=2,p=1$c2FsdHNhbHRzYWx0$aGFzaGhhc2hoYXNoaGFzaGhhc2hoYXNoaGFzaGhhc2g"`
+
+	if _, err := LoadServer(write("hash.toml", server+"auth_token_hash = "+hash+"\n")); err != nil {
+		t.Errorf("Expected auth_token_hash without auth_token to be valid, got %v", err)
+	}
+	if _, err := LoadServer(write("both.toml", server+"auth_token = \"token\"\nauth_token_hash = "+hash+"\n")); err == nil {
+		t.Error("Expected auth_token together with auth_token_hash to be rejected")
+	}
+	if _, err := LoadServer(write("bcrypt.toml", server+"auth_token_hash = \"$2a$10$abc\"\n")); err == nil {
+		t.Error("Expected non-Argon2id hash to be rejected")
+	}
+	if _, err := LoadServer(write("relay.toml", server+"role = \"relay\"\nauth_token_hash = "+hash+"\n")); err == nil {
+		t.Error("Expected relay without auth_token to be rejected")
+	}
+
+	users := write("users.toml", "[[users]]\nname = \"alice\"\ntoken_hash = "+hash+"\n")
+	if _, err := LoadUsers(users); err != nil {
+		t.Errorf("Expected user with token_hash to be valid, got %v", err)
+	}
+	both := write("both-users.toml", "[[users]]\nname = \"alice\"\ntoken = \"t\"\ntoken_hash = "+hash+"\n")
+	if _, err := LoadUsers(both); err == nil {
+		t.Error("Expected user with token and token_hash to be rejected")
+	}
+}
+
+func TestSecurityConfig(t *testing.T) {
+	var empty SecurityConfig
+	if ip, user := empty.Attempts(); ip != DefaultMaxFailedAttempts || user != DefaultUserMaxFailedAttempts {
+		t.Errorf("Expected default attempts, got %d and %d", ip, user)
+	}
+	disabled := SecurityConfig{MaxFailedAttempts: -1, UserMaxFailedAttempts: -1}
+	if ip, user := disabled.Attempts(); ip != 0 || user != 0 {
+		t.Errorf("Expected negative attempts to disable bans, got %d and %d", ip, user)
+	}
+	if block, max, err := empty.BlockDurations(); err != nil || block != DefaultBlockDuration || max != DefaultMaxBlockDuration {
+		t.Errorf("Expected default block durations, got %v, %v, %v", block, max, err)
+	}
+
+	invalid := map[string]SecurityConfig{
+		"window":        {FailureWindow: "soon"},
+		"zero window":   {FailureWindow: "0s"},
+		"negative":      {BlockDuration: "-5m"},
+		"max too short": {BlockDuration: "1h", MaxBlockDuration: "10m"},
+	}
+	for name, cfg := range invalid {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected invalid security config to be rejected", name)
+		}
+	}
+
+	if path := empty.AuditLogPath(); path != DefaultAuditLogFile {
+		t.Errorf("Expected default audit log path, got %s", path)
+	}
+	custom := SecurityConfig{AuditLogFile: "/var/log/aethertunnel/audit.log"}
+	if path := custom.AuditLogPath(); path != custom.AuditLogFile {
+		t.Errorf("Expected configured audit log path, got %s", path)
+	}
+	if path := empty.AuditKeyPath(); path != DefaultAuditKeyFile {
+		t.Errorf("Expected default audit key path, got %s", path)
+	}
+}
+
+func TestLoggingConfig(t *testing.T) {
+	var empty LoggingConfig
+	if err := empty.Validate(); err != nil {
+		t.Fatalf("Expected empty logging config to be valid, got %v", err)
+	}
+	if size, backups, err := empty.Rotation(); err != nil || size != DefaultLogMaxSize || backups != DefaultLogMaxBackups {
+		t.Errorf("Expected default rotation, got %d, %d, %v", size, backups, err)
+	}
+	if !empty.Console() {
+		t.Error("Expected console output without log_file")
+	}
+
+	off := false
+	cfg := LoggingConfig{
+		Level:         "warn",
+		Format:        LogFormatJSON,
+		LogFile:       "server.log",
+		ConsoleOutput: &off,
+		MaxSize:       "10MB",
+		Components:    map[string]string{"server": "debug"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid logging config, got %v", err)
+	}
+	level, components, _ := cfg.Levels()
+	if level != slog.LevelWarn || components["server"] != slog.LevelDebug {
+		t.Errorf("Unexpected levels %v, %v", level, components)
+	}
+	if cfg.Console() {
+		t.Error("Expected console output to be disabled")
+	}
+
+	invalid := map[string]LoggingConfig{
+		"level":     {Level: "verbose"},
+		"component": {Components: map[string]string{"vpn": "loud"}},
+		"format":    {Format: "xml"},
+		"size":      {MaxSize: "big"},
+		"backups":   {MaxBackups: -1},
+		"sampling":  {Sampling: SamplingConfig{Initial: 10, Interval: "0s"}},
+	}
+	for name, cfg := range invalid {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected invalid logging config to be rejected", name)
+		}
+	}
+
+	secrets := map[Secret]string{
+		"":                                 "",
+		"short":                            "****",
+		"0123456789abcdef0123456789abcdef": "0123****cdef",
+	}
+	for secret, expected := range secrets {
+		if masked := secret.Masked(); masked != expected {
+			t.Errorf("Masked(%q) = %q, expected %q", secret, masked, expected)
+		}
+		if value := secret.LogValue().String(); value != expected {
+			t.Errorf("LogValue(%q) = %q, expected %q", secret, value, expected)
+		}
+	}
+}
+
+func TestMimicConfig(t *testing.T) {
+	key := Secret("mimic-shared-key-0123")
+	server := ServerConfig{Mimic: MimicConfig{Enabled: true, Key: key, Decoy: "www.example.com:443"}}
+	if err := server.validateMimic(); err != nil {
+		t.Fatalf("Expected valid mimic config, got %v", err)
+	}
+	if diff, _ := server.Mimic.TimeDiff(); diff != DefaultMimicMaxTimeDiff {
+		t.Errorf("Expected default max_time_diff, got %v", diff)
+	}
+
+	invalidServer := map[string]ServerConfig{
+		"with tls":  {EnableTLS: true, Mimic: server.Mimic},
+		"short key": {Mimic: MimicConfig{Enabled: true, Key: "short", Decoy: "www.example.com:443"}},
+		"no port":   {Mimic: MimicConfig{Enabled: true, Key: key, Decoy: "www.example.com"}},
+		"time diff": {Mimic: MimicConfig{Enabled: true, Key: key, Decoy: "www.example.com:443", MaxTimeDiff: "0s"}},
+		"key only":  {KeyFile: "server.key", Mimic: server.Mimic},
+	}
+	for name, cfg := range invalidServer {
+		if err := cfg.validateMimic(); err == nil {
+			t.Errorf("%s: expected invalid server mimic config to be rejected", name)
+		}
+	}
+
+	client := ClientConfig{Mimic: ClientMimicConfig{Enabled: true, Key: key, ServerName: "www.example.com"}}
+	if err := client.validateMimic(); err != nil {
+		t.Fatalf("Expected valid client mimic config, got %v", err)
+	}
+	if browser := client.Mimic.Browser(); browser != "chrome" {
+		t.Errorf("Expected chrome fingerprint by default, got %s", browser)
+	}
+
+	invalidClient := map[string]ClientConfig{
+		"with tls":    {TLS: ClientTLSConfig{Enabled: true}, Mimic: client.Mimic},
+		"no name":     {Mimic: ClientMimicConfig{Enabled: true, Key: key}},
+		"fingerprint": {Mimic: ClientMimicConfig{Enabled: true, Key: key, ServerName: "www.example.com", Fingerprint: "netscape"}},
+	}
+	for name, cfg := range invalidClient {
+		if err := cfg.validateMimic(); err == nil {
+			t.Errorf("%s: expected invalid client mimic config to be rejected", name)
+		}
+	}
+}
+
+func TestProbeResistanceConfig(t *testing.T) {
+	var empty ProbeResistanceConfig
+	if empty.Enabled() {
+		t.Error("Expected probe resistance to be disabled by default")
+	}
+	if min, max, err := empty.Timeouts(); err != nil || min != DefaultProbeMinTimeout || max != DefaultProbeMaxTimeout {
+		t.Errorf("Expected default timeouts, got %v, %v, %v", min, max, err)
+	}
+
+	valid := []ProbeResistanceConfig{
+		{Mode: ProbeModeOff},
+		{Mode: ProbeModeDrain, MinTimeout: "5s", MaxTimeout: "5s"},
+		{Mode: ProbeModeDecoy, Decoy: "127.0.0.1:80"},
+	}
+	for _, cfg := range valid {
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("%s: unexpected error %v", cfg.Mode, err)
+		}
+	}
+
+	invalid := map[string]ProbeResistanceConfig{
+		"mode":            {Mode: "silent"},
+		"decoy without":   {Mode: ProbeModeDecoy},
+		"decoy for drain": {Mode: ProbeModeDrain, Decoy: "127.0.0.1:80"},
+		"max too short":   {Mode: ProbeModeDrain, MinTimeout: "1m", MaxTimeout: "10s"},
+	}
+	for name, cfg := range invalid {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected invalid probe resistance config to be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// EgressDefaultRule 未单独配置的用户使用的规则名
+const EgressDefaultRule = "*"
+
+// EgressConfig 客户端经服务端访问内网（SOCKS5 / HTTP CONNECT）的配置
+type EgressConfig struct {
+	Enabled bool                  `toml:"enabled"`
+	Users   map[string]EgressRule `toml:"users"` // 按用户名配置，"*" 适用于其余用户；没有匹配规则的用户一律拒绝
+}
+
+// EgressRule 目标地址白名单
+//
+// 目标为 IP 时必须落在 allow_cidrs 内；目标为域名时匹配 allow_domains 即放行，
+// 否则解析后按 allow_cidrs 检查。allow_ports 为空时不限制端口。
+type EgressRule struct {
+	AllowCIDRs   []string `toml:"allow_cidrs"`
+	AllowPorts   string   `toml:"allow_ports"`
+	AllowDomains []string `toml:"allow_domains"` // 支持 "*.corp.local" 匹配子域名
+}
+
+// Validate 验证出站配置
+func (e *EgressConfig) Validate() error {
+	for user, rule := range e.Users {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("users.%s: %w", user, err)
+		}
+	}
+	return nil
+}
+
+// Validate 验证单条规则
+func (r *EgressRule) Validate() error {
+	if len(r.AllowCIDRs) == 0 && len(r.AllowDomains) == 0 {
+		return fmt.Errorf("allow_cidrs or allow_domains is required")
+	}
+	for _, cidr := range r.AllowCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid cidr %q", cidr)
+		}
+	}
+	if _, err := ParsePortRanges(r.AllowPorts); err != nil {
+		return fmt.Errorf("allow_ports: %w", err)
+	}
+	for _, domain := range r.AllowDomains {
+		name := strings.TrimPrefix(domain, "*.")
+		if name == "" || strings.ContainsAny(name, "*/: ") {
+			return fmt.Errorf("invalid domain %q", domain)
+		}
+	}
+	return nil
+}
+
+// ForwardConfig 客户端静态转发配置，类似 ssh -L
+type ForwardConfig struct {
+	Name   string `toml:"name"`
+	Listen string `toml:"listen"` // 本地监听地址，如 "127.0.0.1:5432"
+	Target string `toml:"target"` // 服务端可以访问的目标地址，如 "db.corp.local:5432"
+}
+
+// validateForwards 验证静态转发配置
+func validateForwards(forwards []ForwardConfig) error {
+	names := make(map[string]bool)
+	for _, forward := range forwards {
+		if forward.Name == "" {
+			return fmt.Errorf("forward name is required")
+		}
+		if names[forward.Name] {
+			return fmt.Errorf("duplicate forward name %s", forward.Name)
+		}
+		names[forward.Name] = true
+
+		if _, _, err := net.SplitHostPort(forward.Listen); err != nil {
+			return fmt.Errorf("forward %s: listen: %w", forward.Name, err)
+		}
+		if _, _, err := net.SplitHostPort(forward.Target); err != nil {
+			return fmt.Errorf("forward %s: target: %w", forward.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// 日志的默认值
+const (
+	DefaultLogMaxSize        = 100 << 20 // 100MB
+	DefaultLogMaxBackups     = 5
+	DefaultLogSampleInterval = time.Second
+)
+
+// 日志格式
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LoggingConfig 日志配置
+//
+// 组件是输出日志的 Go 包名（server、client、vpn、obfuscation、net、crypto、main 等），
+// components 中的级别覆盖 level，例如 { vpn = "warn", server = "debug" }。
+type LoggingConfig struct {
+	Level         string            `toml:"level"`          // debug、info（默认）、warn、error
+	Format        string            `toml:"format"`         // text（默认）或 json
+	LogFile       string            `toml:"log_file"`       // 写入文件，为空时只输出到控制台
+	ConsoleOutput *bool             `toml:"console_output"` // 配置了 log_file 时是否仍输出到控制台，默认 true
+	MaxSize       string            `toml:"max_size"`       // 日志文件达到该大小后轮转，如 "100MB"（默认），"0" 不轮转
+	MaxBackups    int               `toml:"max_backups"`    // 保留的轮转文件数，默认 5
+	Components    map[string]string `toml:"components"`     // 按组件覆盖日志级别
+	RedactKeys    []string          `toml:"redact_keys"`    // 值需要隐藏的额外字段名，token、password 等始终隐藏
+
+	// 高频消息采样：每个组件的同一条消息在 interval 内只输出前 initial 条，之后每 thereafter 条输出一条。
+	// 只对 warn 以下的级别生效，initial 为 0 时不采样
+	Sampling SamplingConfig `toml:"sampling"`
+}
+
+// SamplingConfig 日志采样配置
+type SamplingConfig struct {
+	Initial    int    `toml:"initial"`
+	Thereafter int    `toml:"thereafter"` // 为 0 时丢弃 initial 之后的消息
+	Interval   string `toml:"interval"`   // 默认 "1s"
+}
+
+// ParseLogLevel 解析日志级别
+func ParseLogLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q", value)
+	}
+}
+
+// Levels 返回默认级别和各组件的级别
+func (l *LoggingConfig) Levels() (slog.Level, map[string]slog.Level, error) {
+	level, err := ParseLogLevel(l.Level)
+	if err != nil {
+		return 0, nil, fmt.Errorf("logging.level: %w", err)
+	}
+	components := make(map[string]slog.Level, len(l.Components))
+	for name, value := range l.Components {
+		componentLevel, err := ParseLogLevel(value)
+		if err != nil {
+			return 0, nil, fmt.Errorf("logging.components.%s: %w", name, err)
+		}
+		components[name] = componentLevel
+	}
+	return level, components, nil
+}
+
+// Console 返回是否输出到控制台
+func (l *LoggingConfig) Console() bool {
+	return l.LogFile == "" || l.ConsoleOutput == nil || *l.ConsoleOutput
+}
+
+// Rotation 返回日志文件轮转的大小（0 表示不轮转）和保留的文件数
+func (l *LoggingConfig) Rotation() (int64, int, error) {
+	size := int64(DefaultLogMaxSize)
+	if l.MaxSize != "" {
+		var err error
+		if size, err = ParseSize(l.MaxSize); err != nil {
+			return 0, 0, fmt.Errorf("logging.max_size: %w", err)
+		}
+	}
+	backups := l.MaxBackups
+	switch {
+	case backups == 0:
+		backups = DefaultLogMaxBackups
+	case backups < 0:
+		return 0, 0, fmt.Errorf("logging.max_backups must not be negative")
+	}
+	return size, backups, nil
+}
+
+// SampleInterval 返回采样的时间窗口
+func (s *SamplingConfig) SampleInterval() (time.Duration, error) {
+	return parsePositiveDuration("logging.sampling.interval", s.Interval, DefaultLogSampleInterval)
+}
+
+// Validate 验证日志配置
+func (l *LoggingConfig) Validate() error {
+	switch l.Format {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("logging.format must be %s or %s", LogFormatText, LogFormatJSON)
+	}
+	if _, _, err := l.Levels(); err != nil {
+		return err
+	}
+	if _, _, err := l.Rotation(); err != nil {
+		return err
+	}
+	if l.Sampling.Initial < 0 || l.Sampling.Thereafter < 0 {
+		return fmt.Errorf("logging.sampling.initial and thereafter must not be negative")
+	}
+	_, err := l.Sampling.SampleInterval()
+	return err
+}
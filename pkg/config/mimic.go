@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultMimicMaxTimeDiff 隐蔽认证允许的默认时钟偏差
+const DefaultMimicMaxTimeDiff = 2 * time.Minute
+
+// mimicKeyMinSize 伪装密钥的最小长度
+const mimicKeyMinSize = 16
+
+// MimicFingerprints 客户端可模仿的浏览器 ClientHello 指纹，第一个为默认值
+var MimicFingerprints = []string{"chrome", "firefox", "safari", "edge", "ios"}
+
+// MimicConfig 控制端口的 TLS 1.3 伪装
+//
+// 客户端发送浏览器的 ClientHello，并在其中的 session_id 隐蔽地携带由 key 计算的认证信息。
+// 认证通过的连接由服务器完成 TLS 握手后按原有协议处理；其余连接（包括非 TLS 的探测和重放的
+// ClientHello）原样转发到 decoy，看到的是真实站点。与 enable_tls 不能同时使用。
+type MimicConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Key     Secret `toml:"key"`   // 与客户端 [client.mimic] 相同的共享密钥，可用 "aethertunnel token generate" 生成
+	Decoy   string `toml:"decoy"` // 伪装的真实 HTTPS 站点，如 "www.example.com:443"
+
+	// 接受的 SNI，为空时不检查；客户端的 server_name 应为伪装站点的域名
+	ServerNames []string `toml:"server_names"`
+	// 客户端时钟允许的最大偏差，默认 "2m"
+	MaxTimeDiff string `toml:"max_time_diff"`
+}
+
+// TimeDiff 返回客户端时钟允许的最大偏差
+func (m *MimicConfig) TimeDiff() (time.Duration, error) {
+	return parsePositiveDuration("server.mimic.max_time_diff", m.MaxTimeDiff, DefaultMimicMaxTimeDiff)
+}
+
+// validateMimic 验证服务端 TLS 伪装配置
+func (s *ServerConfig) validateMimic() error {
+	m := &s.Mimic
+	if !m.Enabled {
+		return nil
+	}
+	if s.EnableTLS {
+		return fmt.Errorf("server.mimic and server.enable_tls cannot be used together")
+	}
+	if len(m.Key) < mimicKeyMinSize {
+		return fmt.Errorf("server.mimic.key must be at least %d characters", mimicKeyMinSize)
+	}
+	if _, _, err := net.SplitHostPort(m.Decoy); err != nil {
+		return fmt.Errorf("server.mimic.decoy: %w", err)
+	}
+	if (s.CertFile == "") != (s.KeyFile == "") {
+		return fmt.Errorf("server.cert_file and server.key_file must be set together")
+	}
+	_, err := m.TimeDiff()
+	return err
+}
+
+// ClientMimicConfig 客户端的 TLS 1.3 伪装，与服务端 [server.mimic] 对应
+type ClientMimicConfig struct {
+	Enabled     bool   `toml:"enabled"`
+	Key         Secret `toml:"key"`         // 与服务端相同的共享密钥
+	ServerName  string `toml:"server_name"` // 发送的 SNI，为伪装站点的域名
+	Fingerprint string `toml:"fingerprint"` // chrome（默认）、firefox、safari、edge、ios
+}
+
+// Browser 返回模仿的浏览器指纹
+func (m *ClientMimicConfig) Browser() string {
+	if m.Fingerprint == "" {
+		return MimicFingerprints[0]
+	}
+	return m.Fingerprint
+}
+
+// validateMimic 验证客户端 TLS 伪装配置
+func (c *ClientConfig) validateMimic() error {
+	m := &c.Mimic
+	if !m.Enabled {
+		return nil
+	}
+	if c.TLS.Enabled {
+		return fmt.Errorf("client.mimic and client.tls cannot be used together")
+	}
+	if len(m.Key) < mimicKeyMinSize {
+		return fmt.Errorf("client.mimic.key must be at least %d characters", mimicKeyMinSize)
+	}
+	if m.ServerName == "" {
+		return fmt.Errorf("client.mimic.server_name is required")
+	}
+	for _, name := range MimicFingerprints {
+		if m.Browser() == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("client.mimic.fingerprint must be one of %v", MimicFingerprints)
+}
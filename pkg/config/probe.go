@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// 探测防护模式
+const (
+	ProbeModeOff   = "off"
+	ProbeModeDrain = "drain" // 继续静默读取，随机时间后关闭
+	ProbeModeDecoy = "decoy" // 连同已读取的数据转发到 decoy
+)
+
+// 探测防护的默认随机超时范围
+const (
+	DefaultProbeMinTimeout = 15 * time.Second
+	DefaultProbeMaxTimeout = 90 * time.Second
+)
+
+// ProbeResistanceConfig 控制端口的主动探测防护
+//
+// 连接完成认证之前，服务端不回复任何内容；认证失败、格式错误或超时的连接不会立即关闭，
+// 而是按 mode 处理：drain 继续读取并丢弃数据，随机时间后关闭；decoy 把已读取的数据和之后的
+// 连接转发到 decoy（如本机的 nginx），探测者看到的是一个普通的网站。
+// 认证阶段的超时同样在 min_timeout 和 max_timeout 之间随机选取。
+type ProbeResistanceConfig struct {
+	Mode       string `toml:"mode"`        // off（默认）、drain、decoy
+	Decoy      string `toml:"decoy"`       // decoy 模式转发到的地址，如 "127.0.0.1:80"
+	MinTimeout string `toml:"min_timeout"` // 默认 "15s"
+	MaxTimeout string `toml:"max_timeout"` // 默认 "90s"
+}
+
+// Enabled 返回是否启用探测防护
+func (p *ProbeResistanceConfig) Enabled() bool {
+	return p.Mode != "" && p.Mode != ProbeModeOff
+}
+
+// Timeouts 返回随机超时的范围
+func (p *ProbeResistanceConfig) Timeouts() (time.Duration, time.Duration, error) {
+	min, err := parsePositiveDuration("server.probe_resistance.min_timeout", p.MinTimeout, DefaultProbeMinTimeout)
+	if err != nil {
+		return 0, 0, err
+	}
+	max, err := parsePositiveDuration("server.probe_resistance.max_timeout", p.MaxTimeout, DefaultProbeMaxTimeout)
+	if err != nil {
+		return 0, 0, err
+	}
+	if max < min {
+		return 0, 0, fmt.Errorf("server.probe_resistance.max_timeout must not be shorter than min_timeout")
+	}
+	return min, max, nil
+}
+
+// Validate 验证探测防护配置
+func (p *ProbeResistanceConfig) Validate() error {
+	switch p.Mode {
+	case "", ProbeModeOff, ProbeModeDrain:
+		if p.Decoy != "" {
+			return fmt.Errorf("server.probe_resistance.decoy requires mode = %q", ProbeModeDecoy)
+		}
+	case ProbeModeDecoy:
+		if _, _, err := net.SplitHostPort(p.Decoy); err != nil {
+			return fmt.Errorf("server.probe_resistance.decoy: %w", err)
+		}
+	default:
+		return fmt.Errorf("server.probe_resistance.mode must be %s, %s or %s", ProbeModeOff, ProbeModeDrain, ProbeModeDecoy)
+	}
+	_, _, err := p.Timeouts()
+	return err
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// secretCommandTimeout 读取密钥的命令的执行时限
+const secretCommandTimeout = 30 * time.Second
+
+// Secret 令牌、密码等敏感配置项
+//
+// 除直接写字符串外，还可以从文件、环境变量或命令输出读取，避免把明文写进配置文件：
+//
+//	auth_token = { file = "/run/secrets/token" }
+//	auth_token = { env = "AETHERTUNNEL_TOKEN" }
+//	auth_token = { command = "pass show aethertunnel/token" }
+//
+// 文件内容和命令输出去掉末尾的换行；命令由 sh -c（Windows 为 cmd /C）执行。
+// 在加载配置时读取，之后修改文件或环境变量需要重启。
+type Secret string
+
+// UnmarshalTOML 实现 toml.Unmarshaler
+func (s *Secret) UnmarshalTOML(data interface{}) error {
+	switch v := data.(type) {
+	case string:
+		*s = Secret(v)
+		return nil
+	case map[string]interface{}:
+		value, err := resolveSecret(v)
+		if err != nil {
+			return err
+		}
+		*s = Secret(value)
+		return nil
+	default:
+		return fmt.Errorf("secret must be a string or a table with file, env or command")
+	}
+}
+
+// Masked 返回用于显示的形式，只保留长密钥的首尾各 4 个字符，短密钥全部隐藏
+func (s Secret) Masked() string {
+	if s == "" {
+		return ""
+	}
+	if len(s) < 16 {
+		return "****"
+	}
+	return string(s[:4]) + "****" + string(s[len(s)-4:])
+}
+
+// LogValue 实现 slog.LogValuer，写入日志时只输出隐藏后的形式
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.Masked())
+}
+
+// resolveSecret 按来源读取密钥
+func resolveSecret(source map[string]interface{}) (string, error) {
+	if len(source) != 1 {
+		return "", fmt.Errorf("secret must set exactly one of file, env or command")
+	}
+	for kind, raw := range source {
+		value, ok := raw.(string)
+		if !ok || value == "" {
+			return "", fmt.Errorf("secret %s must be a non-empty string", kind)
+		}
+
+		switch kind {
+		case "file":
+			data, err := os.ReadFile(value)
+			if err != nil {
+				return "", fmt.Errorf("failed to read secret: %w", err)
+			}
+			return trimSecret(data, "file "+value)
+		case "env":
+			secret, exists := os.LookupEnv(value)
+			if !exists || secret == "" {
+				return "", fmt.Errorf("secret environment variable %s is not set", value)
+			}
+			return secret, nil
+		case "command":
+			return runSecretCommand(value)
+		default:
+			return "", fmt.Errorf("unknown secret source %q, expected file, env or command", kind)
+		}
+	}
+	return "", nil
+}
+
+// runSecretCommand 执行命令并返回其标准输出
+func runSecretCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("secret command %q failed: %w: %s", command, err, msg)
+		}
+		return "", fmt.Errorf("secret command %q failed: %w", command, err)
+	}
+	return trimSecret(output, "command "+command)
+}
+
+// trimSecret 去掉末尾的换行，内容为空时报错
+func trimSecret(data []byte, source string) (string, error) {
+	secret := strings.TrimRight(string(data), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("secret from %s is empty", source)
+	}
+	return secret, nil
+}
+
+// SecretStrings 把 Secret 列表转换为字符串列表
+func SecretStrings(secrets []Secret) []string {
+	values := make([]string, len(secrets))
+	for i, secret := range secrets {
+		values[i] = string(secret)
+	}
+	return values
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// 认证失败封禁的默认值
+const (
+	DefaultMaxFailedAttempts     = 5
+	DefaultUserMaxFailedAttempts = 20
+	DefaultFailureWindow         = 10 * time.Minute
+	DefaultBlockDuration         = 5 * time.Minute
+	DefaultMaxBlockDuration      = 24 * time.Hour
+)
+
+// SecurityConfig 控制端口认证失败的封禁策略和审计日志
+//
+// 同一来源 IP 在 failure_window 内认证失败 max_failed_attempts 次后被封禁，
+// 封禁期间的连接在接受后立即关闭（启用探测防护时按其处理）。同一 IP 每次再被封禁，时长加倍，最长 max_block_duration。
+// 同一用户在 failure_window 内累计（来自任意 IP）失败 user_max_failed_attempts 次后，
+// 之后针对该用户的每次失败都直接封禁来源 IP，应对分散来源的猜测。
+type SecurityConfig struct {
+	MaxFailedAttempts     int    `toml:"max_failed_attempts"`      // 默认 5，小于 0 不封禁
+	UserMaxFailedAttempts int    `toml:"user_max_failed_attempts"` // 默认 20，小于 0 不按用户统计
+	FailureWindow         string `toml:"failure_window"`           // 统计失败次数的时间窗口，默认 "10m"
+	BlockDuration         string `toml:"block_duration"`           // 首次封禁时长，默认 "5m"
+	MaxBlockDuration      string `toml:"max_block_duration"`       // 封禁时长上限，默认 "24h"
+
+	// 审计日志：登录、认证失败、代理和用户的修改、管理 API 请求等，记录以 HMAC 链相连，
+	// 可用 "aethertunnel audit verify" 检查是否被修改
+	EnableAuditLog bool   `toml:"enable_audit_log"`
+	AuditLogFile   string `toml:"audit_log_file"` // 默认 data/audit.log
+	// HMAC 密钥，只保存在服务端，不存在时自动生成，默认 data/audit.key。
+	// 能修改日志但读不到密钥的人无法伪造记录；密钥丢失后旧日志无法校验
+	AuditKeyFile string `toml:"audit_key_file"`
+}
+
+// 审计日志和 HMAC 密钥的默认路径
+const (
+	DefaultAuditLogFile = "data/audit.log"
+	DefaultAuditKeyFile = "data/audit.key"
+)
+
+// AuditLogPath 返回审计日志路径
+func (s *SecurityConfig) AuditLogPath() string {
+	if s.AuditLogFile == "" {
+		return DefaultAuditLogFile
+	}
+	return s.AuditLogFile
+}
+
+// AuditKeyPath 返回审计日志 HMAC 密钥的路径
+func (s *SecurityConfig) AuditKeyPath() string {
+	if s.AuditKeyFile == "" {
+		return DefaultAuditKeyFile
+	}
+	return s.AuditKeyFile
+}
+
+// Attempts 返回 IP 和用户的失败次数阈值，0 表示不启用
+func (s *SecurityConfig) Attempts() (ip, user int) {
+	ip, user = s.MaxFailedAttempts, s.UserMaxFailedAttempts
+	switch {
+	case ip == 0:
+		ip = DefaultMaxFailedAttempts
+	case ip < 0:
+		ip = 0
+	}
+	switch {
+	case user == 0:
+		user = DefaultUserMaxFailedAttempts
+	case user < 0:
+		user = 0
+	}
+	return ip, user
+}
+
+// Window 返回统计失败次数的时间窗口
+func (s *SecurityConfig) Window() (time.Duration, error) {
+	return parsePositiveDuration("security.failure_window", s.FailureWindow, DefaultFailureWindow)
+}
+
+// BlockDurations 返回首次封禁时长和封禁时长上限
+func (s *SecurityConfig) BlockDurations() (time.Duration, time.Duration, error) {
+	block, err := parsePositiveDuration("security.block_duration", s.BlockDuration, DefaultBlockDuration)
+	if err != nil {
+		return 0, 0, err
+	}
+	max, err := parsePositiveDuration("security.max_block_duration", s.MaxBlockDuration, DefaultMaxBlockDuration)
+	if err != nil {
+		return 0, 0, err
+	}
+	if max < block {
+		return 0, 0, fmt.Errorf("security.max_block_duration must not be shorter than security.block_duration")
+	}
+	return block, max, nil
+}
+
+// Validate 验证封禁配置
+func (s *SecurityConfig) Validate() error {
+	if _, err := s.Window(); err != nil {
+		return err
+	}
+	_, _, err := s.BlockDurations()
+	return err
+}
+
+// parsePositiveDuration 解析时长，为空时返回默认值
+func parsePositiveDuration(name, value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be positive", name)
+	}
+	return d, nil
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ClientTLSConfig 客户端连接服务器使用的 TLS
+type ClientTLSConfig struct {
+	Enabled    bool   `toml:"enabled"`
+	CAFile     string `toml:"ca_file"`     // 验证服务器证书的 CA 证书包，为空使用系统根证书
+	ServerName string `toml:"server_name"` // 覆盖 SNI 和证书校验使用的名称，默认取 server_addr 的主机名
+
+	// 证书公钥指纹（SubjectPublicKeyInfo 的 SHA-256，Base64，可带 "sha256/" 前缀），
+	// 服务器启动时打印。配置后证书须匹配其中之一；未配置 ca_file 时只校验服务器证书的指纹，
+	// 可用于自签名证书
+	PinSPKI []string `toml:"pin_spki"`
+
+	// 客户端证书（mTLS），配置后可以不使用 auth_token 和 noise_key_file。
+	// cert_file 中有私钥（组合 PEM，续期后总是如此）时以它为准，两者可以是同一个文件
+	CertFile string `toml:"cert_file"`
+	KeyFile  string `toml:"key_file"`
+}
+
+// Validate 验证客户端 TLS 配置
+func (t *ClientTLSConfig) Validate() error {
+	if !t.Enabled {
+		if t.CertFile != "" || len(t.PinSPKI) > 0 {
+			return fmt.Errorf("client.tls.enabled must be set to use cert_file or pin_spki")
+		}
+		return nil
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("client.tls.cert_file and client.tls.key_file must be set together")
+	}
+	for _, pin := range t.PinSPKI {
+		if _, err := ParseSPKIPin(pin); err != nil {
+			return fmt.Errorf("client.tls.pin_spki: %w", err)
+		}
+	}
+	return nil
+}
+
+// ParseSPKIPin 解析证书公钥指纹
+func ParseSPKIPin(pin string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pin %q: %w", pin, err)
+	}
+	if len(decoded) != 32 {
+		return nil, fmt.Errorf("pin %q must be a SHA-256 digest", pin)
+	}
+	return decoded, nil
+}
+
+// validateTLS 验证服务端控制端口的 TLS 配置
+func (s *ServerConfig) validateTLS() error {
+	if !s.EnableTLS {
+		if s.ClientCAFile != "" || s.RequireClientCert || s.AllowPlain {
+			return fmt.Errorf("server.enable_tls must be set to use client_ca_file, require_client_cert or allow_plain")
+		}
+		return nil
+	}
+	if s.CertFile == "" || s.KeyFile == "" {
+		return fmt.Errorf("server.cert_file and server.key_file are required when enable_tls is set")
+	}
+	return nil
+}
+
+// CertManagerConfig 内置 CA，为客户端签发短期 mTLS 证书
+type CertManagerConfig struct {
+	Enabled    bool   `toml:"enabled"`
+	CACertFile string `toml:"ca_cert_file"` // CA 证书，默认 data/ca.crt，不存在时与私钥一起生成
+	CAKeyFile  string `toml:"ca_key_file"`  // CA 私钥，默认 data/ca.key
+	CertTTL    string `toml:"cert_ttl"`     // 签发证书的有效期，也是单次签发允许的最大值，默认 "24h"
+}
+
+// DefaultCertTTL 签发证书的默认有效期
+const DefaultCertTTL = 24 * time.Hour
+
+// TTL 返回签发证书的有效期
+func (c *CertManagerConfig) TTL() (time.Duration, error) {
+	if c.CertTTL == "" {
+		return DefaultCertTTL, nil
+	}
+	ttl, err := time.ParseDuration(c.CertTTL)
+	if err != nil {
+		return 0, fmt.Errorf("cert_manager.cert_ttl: %w", err)
+	}
+	if ttl < time.Minute {
+		return 0, fmt.Errorf("cert_manager.cert_ttl must be at least 1m")
+	}
+	return ttl, nil
+}
+
+// Validate 验证内置 CA 配置
+func (c *CertManagerConfig) Validate(server *ServerConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+	if !server.EnableTLS {
+		return fmt.Errorf("cert_manager requires server.enable_tls")
+	}
+	if _, err := c.TTL(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateClientAuth 验证客户端证书配置，证书可以来自 client_ca_file 或内置 CA
+func (cfg *Config) validateClientAuth() error {
+	if cfg.Server.RequireClientCert && cfg.Server.ClientCAFile == "" && !cfg.CertManager.Enabled {
+		return fmt.Errorf("server.require_client_cert requires server.client_ca_file or cert_manager")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// 改写规则类型
+const (
+	TransformSetHeader   = "set_header"   // 设置（覆盖）头部
+	TransformAddHeader   = "add_header"   // 追加头部
+	TransformDelHeader   = "del_header"   // 删除头部
+	TransformRewritePath = "rewrite_path" // 正则改写路径，支持 $1 捕获组
+	TransformRedirect    = "redirect"     // 直接返回重定向
+)
+
+// TransformRule HTTP 改写规则
+type TransformRule struct {
+	Type   string         `toml:"type" json:"type,omitempty"`
+	Name   string         `toml:"name" json:"name,omitempty"`     // 头部名称
+	Value  string         `toml:"value" json:"value,omitempty"`   // 头部值，支持 {{client_ip}} 等占位符
+	From   string         `toml:"from" json:"from,omitempty"`     // 路径匹配正则
+	To     string         `toml:"to" json:"to,omitempty"`         // 改写目标或重定向地址
+	Status int            `toml:"status" json:"status,omitempty"` // 重定向状态码，默认 302
+	Match  TransformMatch `toml:"match" json:"match,omitempty"`
+}
+
+// TransformMatch 规则匹配条件，所有条件均满足时规则才生效
+type TransformMatch struct {
+	Host    string   `toml:"host" json:"host,omitempty"`       // 主机名，支持 *.example.com 通配
+	Path    string   `toml:"path" json:"path,omitempty"`       // 路径正则
+	Methods []string `toml:"methods" json:"methods,omitempty"` // 请求方法
+}
+
+// Validate 验证改写规则，response 为 true 时只允许头部操作
+func (r *TransformRule) Validate(response bool) error {
+	switch r.Type {
+	case TransformSetHeader, TransformAddHeader:
+		if r.Name == "" {
+			return fmt.Errorf("%s requires name", r.Type)
+		}
+		if r.Value == "" {
+			return fmt.Errorf("%s requires value", r.Type)
+		}
+	case TransformDelHeader:
+		if r.Name == "" {
+			return fmt.Errorf("%s requires name", r.Type)
+		}
+	case TransformRewritePath, TransformRedirect:
+		if response {
+			return fmt.Errorf("%s is not allowed in response_transform", r.Type)
+		}
+		if r.From == "" || r.To == "" {
+			return fmt.Errorf("%s requires from and to", r.Type)
+		}
+		if _, err := regexp.Compile(r.From); err != nil {
+			return fmt.Errorf("invalid from pattern: %w", err)
+		}
+		if r.Type == TransformRedirect && r.Status != 0 {
+			switch r.Status {
+			case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+				http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			default:
+				return fmt.Errorf("invalid redirect status %d", r.Status)
+			}
+		}
+	case "":
+		return fmt.Errorf("type is required")
+	default:
+		return fmt.Errorf("unknown type %q", r.Type)
+	}
+
+	if r.Name != "" && strings.ContainsAny(r.Name, " :\r\n") {
+		return fmt.Errorf("invalid header name %q", r.Name)
+	}
+	if strings.ContainsAny(r.Value, "\r\n") {
+		return fmt.Errorf("header value must not contain line breaks")
+	}
+	if r.Match.Path != "" {
+		if _, err := regexp.Compile(r.Match.Path); err != nil {
+			return fmt.Errorf("invalid match.path pattern: %w", err)
+		}
+	}
+	for _, method := range r.Match.Methods {
+		if method == "" || strings.ToUpper(method) != method {
+			return fmt.Errorf("invalid match method %q", method)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// DefaultUser 使用 server.auth_token 和 server.authorized_keys 认证的内置用户，不受限制
+const DefaultUser = "default"
+
+// UserConfig 用户账号
+type UserConfig struct {
+	Name      string `toml:"name" json:"name"`
+	Token     Secret `toml:"token" json:"token,omitempty"`           // 派生握手 PSK 的令牌
+	TokenHash string `toml:"token_hash" json:"token_hash,omitempty"` // 令牌的 Argon2id 哈希，代替 token 使用，服务端不保存令牌明文
+	PublicKey string `toml:"public_key" json:"public_key,omitempty"` // 客户端 Base64 静态公钥，与 token 至少配置一个
+	Enabled   *bool  `toml:"enabled" json:"enabled,omitempty"`       // 默认启用
+
+	// 权限，为空或 0 不限制
+	ProxyTypes   []string `toml:"proxy_types" json:"proxy_types,omitempty"`     // 允许的代理类型
+	AllowPorts   string   `toml:"allow_ports" json:"allow_ports,omitempty"`     // 允许的远程端口，如 "8000-8100"
+	AllowDomains []string `toml:"allow_domains" json:"allow_domains,omitempty"` // 出口和转发允许的目标域名，支持 "*.example.com"
+	MaxProxies   int      `toml:"max_proxies" json:"max_proxies,omitempty"`     // 同时运行的代理数上限
+	MaxClients   int      `toml:"max_clients" json:"max_clients,omitempty"`     // 同时在线的客户端数上限
+}
+
+// usersFile 用户文件格式
+type usersFile struct {
+	Users []UserConfig `toml:"users"`
+}
+
+// IsEnabled 判断用户是否启用
+func (u *UserConfig) IsEnabled() bool {
+	return u.Enabled == nil || *u.Enabled
+}
+
+// Validate 验证用户配置
+func (u *UserConfig) Validate() error {
+	if u.Name == "" {
+		return fmt.Errorf("user name is required")
+	}
+	if strings.ContainsAny(u.Name, "/ \t") {
+		return fmt.Errorf("user %s: name must not contain '/' or spaces", u.Name)
+	}
+	if u.Token == "" && u.TokenHash == "" && u.PublicKey == "" {
+		return fmt.Errorf("user %s: token, token_hash or public_key is required", u.Name)
+	}
+	if u.Token != "" && u.TokenHash != "" {
+		return fmt.Errorf("user %s: token and token_hash cannot both be set", u.Name)
+	}
+	if err := validateTokenHash(u.TokenHash); err != nil {
+		return fmt.Errorf("user %s: token_hash: %w", u.Name, err)
+	}
+	if u.PublicKey != "" {
+		if err := validatePublicKey(u.PublicKey); err != nil {
+			return fmt.Errorf("user %s: public_key: %w", u.Name, err)
+		}
+	}
+	for _, proxyType := range u.ProxyTypes {
+		switch proxyType {
+		case "tcp", "udp", "http":
+		default:
+			return fmt.Errorf("user %s: unknown proxy type %q", u.Name, proxyType)
+		}
+	}
+	if _, err := ParsePortRanges(u.AllowPorts); err != nil {
+		return fmt.Errorf("user %s: allow_ports: %w", u.Name, err)
+	}
+	for _, domain := range u.AllowDomains {
+		if strings.TrimPrefix(domain, "*.") == "" {
+			return fmt.Errorf("user %s: invalid domain %q", u.Name, domain)
+		}
+	}
+	if u.MaxProxies < 0 || u.MaxClients < 0 {
+		return fmt.Errorf("user %s: max_proxies and max_clients must not be negative", u.Name)
+	}
+	return nil
+}
+
+// LoadUsers 加载用户文件
+//
+// 文件为 TOML 格式，每个 [[users]] 对应一个 UserConfig。
+func LoadUsers(filename string) ([]UserConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users file: %w", err)
+	}
+
+	var file usersFile
+	if err := toml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse users file: %w", err)
+	}
+
+	names := make(map[string]bool)
+	keys := make(map[string]string)
+	for i := range file.Users {
+		user := &file.Users[i]
+		if err := user.Validate(); err != nil {
+			return nil, err
+		}
+		if user.Name == DefaultUser {
+			return nil, fmt.Errorf("user name %s is reserved", DefaultUser)
+		}
+		if names[user.Name] {
+			return nil, fmt.Errorf("duplicate user %s", user.Name)
+		}
+		names[user.Name] = true
+
+		if user.PublicKey != "" {
+			if owner, exists := keys[user.PublicKey]; exists {
+				return nil, fmt.Errorf("user %s: public_key is already used by %s", user.Name, owner)
+			}
+			keys[user.PublicKey] = user.Name
+		}
+	}
+
+	return file.Users, nil
+}
@@ -1,83 +1,157 @@
 package crypto
 
 import (
+	"bytes"
+	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 
 	"golang.org/x/crypto/chacha20poly1305"
 )
 
+// KeyIDSize 密文头中密钥标识的长度
+const KeyIDSize = 4
+
+// ciphertextVersion 密文格式版本
+//
+// 密文格式：版本（1 字节）| 密钥标识（4 字节）| nonce（24 字节）| 密文，
+// 版本和密钥标识作为附加数据参与认证。
+const ciphertextVersion = 1
+
+// ciphertextHeaderSize 密文头长度
+const ciphertextHeaderSize = 1 + KeyIDSize
+
+// ErrUnknownKeyID 密文使用的密钥不在当前密钥列表中
+var ErrUnknownKeyID = errors.New("unknown key id")
+
 // Encryption 加密结构
+//
+// 持有一组密钥：第一个用于加密，其余只用于解密，轮换令牌期间旧令牌加密的数据仍然可以解密。
 type Encryption struct {
-	key []byte
+	keys []*encryptionKey
+}
+
+// encryptionKey 由一个令牌派生的密钥
+type encryptionKey struct {
+	id     [KeyIDSize]byte
+	master []byte
+	aead   cipher.AEAD // XChaCha20-Poly1305，可并发使用
 }
 
-// NewEncryption 创建加密对象
-func NewEncryption(key string) *Encryption {
-	return &Encryption{
-		key: []byte(key),
+// NewEncryption 创建加密对象，secret 用于加密，previous 为轮换期间仍可解密的旧令牌
+func NewEncryption(secret string, previous ...string) *Encryption {
+	e := &Encryption{}
+	for _, s := range append([]string{secret}, previous...) {
+		key := newEncryptionKey(s)
+		if e.key(key.id[:]) == nil {
+			e.keys = append(e.keys, key)
+		}
 	}
+	return e
 }
 
-// Key 返回加密密钥
-func (e *Encryption) Key() []byte {
-	return e.key
+// newEncryptionKey 由令牌派生主密钥、加密密钥和密钥标识
+func newEncryptionKey(secret string) *encryptionKey {
+	master := DeriveMasterKey(secret)
+	// 密钥长度与算法一致，不会失败
+	aead, _ := CipherSuiteXChaCha20Poly1305.New(DeriveKey(master, PurposeEncryption, chacha20poly1305.KeySize))
+	key := &encryptionKey{master: master, aead: aead}
+	copy(key.id[:], DeriveKey(master, PurposeKeyID, KeyIDSize))
+	return key
 }
 
-// Encrypt 加密数据
-func (e *Encryption) Encrypt(plaintext []byte) (string, error) {
-	// 生成随机 nonce
-	nonce := make([]byte, chacha20poly1305.NonceSize)
-	if _, err := rand.Read(nonce); err != nil {
-		return "", err
+// key 按标识查找密钥
+func (e *Encryption) key(id []byte) *encryptionKey {
+	for _, key := range e.keys {
+		if bytes.Equal(key.id[:], id) {
+			return key
+		}
 	}
+	return nil
+}
 
-	// 使用 ChaCha20-Poly1305 加密
-	aead, err := chacha20poly1305.NewX(e.key)
-	if err != nil {
-		return "", err
-	}
+// KeyID 返回当前加密密钥的标识
+func (e *Encryption) KeyID() string {
+	return fmt.Sprintf("%x", e.keys[0].id)
+}
 
-	ciphertext := aead.Seal(nil, nonce, plaintext, nil)
+// DeriveKey 由当前令牌的主密钥派生 purpose 用途、length 字节的子密钥
+func (e *Encryption) DeriveKey(purpose string, length int) []byte {
+	return DeriveKey(e.keys[0].master, purpose, length)
+}
 
-	// 将 nonce 和 ciphertext 组合
-	result := make([]byte, len(nonce)+len(ciphertext))
-	copy(result, nonce)
-	copy(result[len(nonce):], ciphertext)
+// Seal 加密 plaintext 并把密文追加到 dst，ad 为参与认证但不加密的附加数据，解密时须相同
+func (e *Encryption) Seal(dst, plaintext, ad []byte) ([]byte, error) {
+	key := e.keys[0]
 
-	return base64.StdEncoding.EncodeToString(result), nil
-}
+	// 密文头
+	start := len(dst)
+	dst = append(dst, ciphertextVersion)
+	dst = append(dst, key.id[:]...)
 
-// Decrypt 解密数据
-func (e *Encryption) Decrypt(encrypted string) ([]byte, error) {
-	// Base64 解码
-	data, err := base64.StdEncoding.DecodeString(encrypted)
-	if err != nil {
+	// 生成随机 nonce
+	nonceStart := len(dst)
+	dst = append(dst, make([]byte, chacha20poly1305.NonceSizeX)...)
+	nonce := dst[nonceStart:]
+	if _, err := rand.Read(nonce); err != nil {
 		return nil, err
 	}
 
-	// 验证长度
-	if len(data) < chacha20poly1305.NonceSize {
+	return key.aead.Seal(dst, nonce, plaintext, associatedData(dst[start:nonceStart], ad)), nil
+}
+
+// Open 解密 Seal 生成的密文并把明文追加到 dst
+func (e *Encryption) Open(dst, ciphertext, ad []byte) ([]byte, error) {
+	// 验证长度和版本
+	if len(ciphertext) < ciphertextHeaderSize+chacha20poly1305.NonceSizeX {
 		return nil, fmt.Errorf("invalid encrypted data length")
 	}
+	if ciphertext[0] != ciphertextVersion {
+		return nil, fmt.Errorf("unsupported ciphertext version %d", ciphertext[0])
+	}
 
-	// 分离 nonce 和 ciphertext
-	nonce := data[:chacha20poly1305.NonceSize]
-	ciphertext := data[chacha20poly1305.NonceSize:]
+	// 分离密文头、nonce 和 ciphertext
+	header := ciphertext[:ciphertextHeaderSize]
+	nonce := ciphertext[ciphertextHeaderSize : ciphertextHeaderSize+chacha20poly1305.NonceSizeX]
+	ciphertext = ciphertext[ciphertextHeaderSize+chacha20poly1305.NonceSizeX:]
 
-	// 使用 ChaCha20-Poly1305 解密
-	aead, err := chacha20poly1305.NewX(e.key)
+	key := e.key(header[1:])
+	if key == nil {
+		return nil, fmt.Errorf("%w %x", ErrUnknownKeyID, header[1:])
+	}
+
+	return key.aead.Open(dst, nonce, ciphertext, associatedData(header, ad))
+}
+
+// associatedData 返回密文头和调用方附加数据拼接后的认证数据
+func associatedData(header, ad []byte) []byte {
+	if len(ad) == 0 {
+		return header
+	}
+	data := make([]byte, 0, len(header)+len(ad))
+	data = append(data, header...)
+	return append(data, ad...)
+}
+
+// Encrypt 加密数据，返回 Base64 编码的密文
+func (e *Encryption) Encrypt(plaintext []byte) (string, error) {
+	result, err := e.Seal(nil, plaintext, nil)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
+	return base64.StdEncoding.EncodeToString(result), nil
+}
 
-	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+// Decrypt 解密 Base64 编码的密文
+func (e *Encryption) Decrypt(encrypted string) ([]byte, error) {
+	// Base64 解码
+	data, err := base64.StdEncoding.DecodeString(encrypted)
 	if err != nil {
 		return nil, err
 	}
-
-	return plaintext, nil
+	return e.Open(nil, data, nil)
 }
 
 // EncryptBase64 加密并 Base64 编码
@@ -0,0 +1,91 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+)
+
+// 派生密钥的用途，同一主密钥用于不同用途时得到互不相关的子密钥
+const (
+	PurposeEncryption        = "encryption"         // Encryption 的 XChaCha20-Poly1305 密钥
+	PurposeKeyID             = "key-id"             // 密文头中的密钥标识
+	PurposeObfuscationMAC    = "obfuscation-mac"    // 混淆包的 HMAC 密钥
+	PurposeObfuscationCipher = "obfuscation-cipher" // 混淆算法使用的密钥
+	PurposeStream            = "stream"             // 流式加密，每个流另有随机盐
+	PurposeMimicAuth         = "mimic-auth"         // TLS 伪装隐蔽认证的 HMAC 密钥
+)
+
+// MasterKeySize 主密钥长度
+const MasterKeySize = 32
+
+// highEntropyMinSize 按 hex 或 Base64 解码后至少这么长的令牌视为随机生成的高熵密钥
+const highEntropyMinSize = 32
+
+// Argon2id 参数（RFC 9106 推荐的低内存配置），只在启动时为每个令牌计算一次
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024 // KiB
+	argon2Threads = 4
+)
+
+// kdfSalt 派生主密钥使用的盐
+//
+// 通信双方各自由令牌派生出相同的密钥，无法交换随机盐，因此使用带版本号的固定盐，
+// 防止针对通用口令的预计算；更换派生方式时同时修改版本号。
+var kdfSalt = []byte("aethertunnel kdf v1")
+
+// DeriveMasterKey 由令牌派生主密钥
+//
+// hex 或 Base64 编码的随机密钥（解码后至少 32 字节）直接用 HKDF 提取，
+// 其他令牌视为口令，用 Argon2id 增加暴力破解的成本。
+func DeriveMasterKey(secret string) []byte {
+	if key, ok := decodeHighEntropy(secret); ok {
+		prk := hkdf.Extract(sha256.New, key, kdfSalt)
+		return prk[:MasterKeySize]
+	}
+	return argon2.IDKey([]byte(secret), kdfSalt, argon2Time, argon2Memory, argon2Threads, MasterKeySize)
+}
+
+// DeriveKey 用 HKDF-SHA256 由主密钥派生 purpose 用途、length 字节的子密钥
+func DeriveKey(master []byte, purpose string, length int) []byte {
+	key := make([]byte, length)
+	reader := hkdf.Expand(sha256.New, master, []byte("aethertunnel "+purpose))
+	if _, err := io.ReadFull(reader, key); err != nil {
+		// 只有 length 超过 HKDF 的输出上限（255 * 32 字节）时才会失败
+		panic("crypto: " + err.Error())
+	}
+	return key
+}
+
+// DeriveSaltedKey 与 DeriveKey 相同，但额外使用 salt，同一用途需要多个独立密钥时使用
+func DeriveSaltedKey(master, salt []byte, purpose string, length int) []byte {
+	key := make([]byte, length)
+	reader := hkdf.New(sha256.New, master, salt, []byte("aethertunnel "+purpose))
+	if _, err := io.ReadFull(reader, key); err != nil {
+		panic("crypto: " + err.Error())
+	}
+	return key
+}
+
+// decodeHighEntropy 尝试按 hex 和 Base64 解码令牌
+//
+// hex 字符也是合法的 Base64 字符，能按 hex 解码的令牌只按 hex 判断长度，
+// 否则不足 32 字节的 hex 令牌会被当作 Base64 解码而跳过 Argon2id。
+func decodeHighEntropy(secret string) ([]byte, bool) {
+	secret = strings.TrimSpace(secret)
+	if key, err := hex.DecodeString(secret); err == nil {
+		return key, len(key) >= highEntropyMinSize
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if key, err := encoding.DecodeString(secret); err == nil && len(key) >= highEntropyMinSize {
+			return key, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,134 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+)
+
+func TestDeriveMasterKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0xa5}, highEntropyMinSize)
+	extracted := hkdf.Extract(sha256.New, key, kdfSalt)[:MasterKeySize]
+	stretched := func(secret string) []byte {
+		return argon2.IDKey([]byte(secret), kdfSalt, argon2Time, argon2Memory, argon2Threads, MasterKeySize)
+	}
+
+	short := hex.EncodeToString(key[:highEntropyMinSize-1])
+	tests := []struct {
+		name   string
+		secret string
+		want   []byte
+	}{
+		// 32 字节以上的随机密钥按解码后的字节提取，编码方式不影响结果
+		{"hex", hex.EncodeToString(key), extracted},
+		{"hex with newline", hex.EncodeToString(key) + "\n", extracted},
+		{"base64", base64.StdEncoding.EncodeToString(key), extracted},
+		{"raw base64url", base64.RawURLEncoding.EncodeToString(key), extracted},
+		// 其他令牌按口令处理
+		{"31-byte hex", short, stretched(short)},
+		{"passphrase", "correct horse battery staple", stretched("correct horse battery staple")},
+	}
+	for _, tt := range tests {
+		got := DeriveMasterKey(tt.secret)
+		if len(got) != MasterKeySize || !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %x, expected %x", tt.name, got, tt.want)
+		}
+	}
+
+	longer := bytes.Repeat([]byte{0xa5}, 64)
+	if !bytes.Equal(DeriveMasterKey(hex.EncodeToString(longer)), hkdf.Extract(sha256.New, longer, kdfSalt)[:MasterKeySize]) {
+		t.Error("Expected a 64-byte key to be extracted with HKDF")
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	master := DeriveMasterKey(testSecret)
+
+	if !bytes.Equal(DeriveKey(master, PurposeEncryption, 32), DeriveKey(master, PurposeEncryption, 32)) {
+		t.Error("Expected DeriveKey to be deterministic")
+	}
+	// 较短的输出是较长输出的前缀（HKDF-Expand）
+	if !bytes.HasPrefix(DeriveKey(master, PurposeEncryption, 64), DeriveKey(master, PurposeEncryption, 32)) {
+		t.Error("Expected the 32-byte key to be a prefix of the 64-byte key")
+	}
+
+	seen := make(map[string]string)
+	for _, purpose := range []string{PurposeEncryption, PurposeKeyID, PurposeObfuscationMAC, PurposeObfuscationCipher, PurposeStream, PurposeMimicAuth} {
+		key := string(DeriveKey(master, purpose, 32))
+		if other, exists := seen[key]; exists {
+			t.Errorf("Purposes %s and %s derived the same key", purpose, other)
+		}
+		seen[key] = purpose
+	}
+	if bytes.Equal(DeriveKey(master, PurposeStream, 32), DeriveKey(DeriveMasterKey("other-"+testSecret), PurposeStream, 32)) {
+		t.Error("Expected different master keys to derive different keys")
+	}
+
+	salted := DeriveSaltedKey(master, []byte("salt-1"), PurposeStream, 32)
+	if bytes.Equal(salted, DeriveSaltedKey(master, []byte("salt-2"), PurposeStream, 32)) {
+		t.Error("Expected different salts to derive different keys")
+	}
+	if bytes.Equal(salted, DeriveKey(master, PurposeStream, 32)) {
+		t.Error("Expected the salted key to differ from the unsalted key")
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	a := NewEncryption(testSecret)
+	if a.KeyID() != NewEncryption(testSecret).KeyID() {
+		t.Error("Expected the key id to depend only on the token")
+	}
+	if len(a.KeyID()) != 2*KeyIDSize {
+		t.Errorf("Expected a %d-byte key id, got %q", KeyIDSize, a.KeyID())
+	}
+	if a.KeyID() == NewEncryption("passphrase").KeyID() {
+		t.Error("Expected different tokens to have different key ids")
+	}
+
+	// 重复的旧令牌只保留一个密钥
+	if n := len(NewEncryption(testSecret, testSecret, "passphrase", "passphrase").keys); n != 2 {
+		t.Errorf("Expected 2 keys, got %d", n)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	const previous = "old passphrase"
+	old := NewEncryption(previous)
+	rotated := NewEncryption(testSecret, previous)
+
+	sealed, err := old.Encrypt([]byte("before rotation"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := rotated.Decrypt(sealed); err != nil || string(got) != "before rotation" {
+		t.Errorf("Expected the previous token to decrypt, got %q, %v", got, err)
+	}
+	stream := sealStream(t, old, []byte("old stream"), nil)
+	if got, err := openStream(rotated, stream, nil); err != nil || string(got) != "old stream" {
+		t.Errorf("Expected the previous token to decrypt streams, got %q, %v", got, err)
+	}
+
+	// 新数据只用第一个令牌加密
+	if rotated.KeyID() != NewEncryption(testSecret).KeyID() {
+		t.Error("Expected the first token to be the encryption key")
+	}
+	sealed, _ = rotated.Encrypt([]byte("after rotation"))
+	if got, err := NewEncryption(testSecret).Decrypt(sealed); err != nil || string(got) != "after rotation" {
+		t.Errorf("Expected the new token alone to decrypt, got %q, %v", got, err)
+	}
+	_, err = old.Decrypt(sealed)
+	if !errors.Is(err, ErrUnknownKeyID) || !strings.Contains(err.Error(), rotated.KeyID()) {
+		t.Errorf("Expected ErrUnknownKeyID naming %s, got %v", rotated.KeyID(), err)
+	}
+	stream = sealStream(t, rotated, []byte("new stream"), nil)
+	if _, err := openStream(old, stream, nil); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("Expected ErrUnknownKeyID for streams, got %v", err)
+	}
+}
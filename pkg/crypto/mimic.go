@@ -0,0 +1,86 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// MimicSessionIDSize 携带隐蔽认证的 ClientHello session_id 长度
+const MimicSessionIDSize = 32
+
+// mimicTagSize session_id 中认证标签的长度，其余 4 字节为时间
+const mimicTagSize = MimicSessionIDSize - 4
+
+// 隐蔽认证失败的原因
+var (
+	ErrMimicAuth     = errors.New("invalid mimic authentication")
+	ErrMimicTimeDiff = errors.New("mimic authentication time is out of range")
+)
+
+// MimicAuth TLS 伪装的隐蔽认证
+//
+// 客户端把认证信息放在 ClientHello 的 session_id 中：前 4 字节为与掩码异或后的 Unix 时间，
+// 后 28 字节为对 client_random 和时间的 HMAC-SHA256。TLS 1.3 中 session_id 只用于兼容
+// 中间设备，浏览器发送 32 字节随机值，没有密钥时二者无法区分。client_random 每次握手随机生成，
+// 服务端需另外记录已使用的 client_random 以拒绝重放。
+type MimicAuth struct {
+	key []byte
+}
+
+// NewMimicAuth 由共享密钥创建隐蔽认证
+func NewMimicAuth(secret string) *MimicAuth {
+	return &MimicAuth{key: DeriveKey(DeriveMasterKey(secret), PurposeMimicAuth, 32)}
+}
+
+// SessionID 返回 now 时刻携带认证信息的 session_id
+func (m *MimicAuth) SessionID(random []byte, now time.Time) []byte {
+	sessionID := make([]byte, MimicSessionIDSize)
+	binary.BigEndian.PutUint32(sessionID, uint32(now.Unix()))
+	mask := m.mask(random)
+	for i := range 4 {
+		sessionID[i] ^= mask[i]
+	}
+	copy(sessionID[4:], m.tag(random, uint32(now.Unix())))
+	return sessionID
+}
+
+// Verify 验证 session_id，时间与 now 相差超过 maxDiff 时返回 ErrMimicTimeDiff
+func (m *MimicAuth) Verify(random, sessionID []byte, now time.Time, maxDiff time.Duration) error {
+	if len(sessionID) != MimicSessionIDSize {
+		return ErrMimicAuth
+	}
+	var ts [4]byte
+	mask := m.mask(random)
+	for i := range ts {
+		ts[i] = sessionID[i] ^ mask[i]
+	}
+	unix := binary.BigEndian.Uint32(ts[:])
+	if !hmac.Equal(sessionID[4:], m.tag(random, unix)) {
+		return ErrMimicAuth
+	}
+	diff := now.Sub(time.Unix(int64(unix), 0))
+	if diff > maxDiff || diff < -maxDiff {
+		return ErrMimicTimeDiff
+	}
+	return nil
+}
+
+// mask 返回隐藏时间的掩码
+func (m *MimicAuth) mask(random []byte) []byte {
+	h := hmac.New(sha256.New, m.key)
+	h.Write([]byte("mask"))
+	h.Write(random)
+	return h.Sum(nil)[:4]
+}
+
+// tag 返回认证标签
+func (m *MimicAuth) tag(random []byte, unix uint32) []byte {
+	h := hmac.New(sha256.New, m.key)
+	h.Write([]byte("tag"))
+	h.Write(random)
+	binary.Write(h, binary.BigEndian, unix)
+	return h.Sum(nil)[:mimicTagSize]
+}
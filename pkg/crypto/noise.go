@@ -0,0 +1,666 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/mlkem"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// NoiseKeySize X25519 密钥长度
+const NoiseKeySize = 32
+
+// noisePrologue 绑定到握手哈希中的协议标识
+const noisePrologue = "aethertunnel"
+
+// DerivePSK 的用途，同一令牌用于不同用途时得到不同的 PSK
+const (
+	PSKPurposeControl = "control" // 客户端与服务器之间
+	PSKPurposeRelay   = "relay"   // 客户端与中继之间
+)
+
+// NoisePattern 握手模式
+type NoisePattern byte
+
+const (
+	// NoiseNNpsk0 双方只共享令牌，用于中继逐跳认证
+	NoiseNNpsk0 NoisePattern = 1
+	// NoiseNKpsk2 服务端用静态密钥认证，客户端用令牌派生的 PSK 认证
+	NoiseNKpsk2 NoisePattern = 2
+	// NoiseIK 服务端和客户端都用静态密钥认证
+	NoiseIK NoisePattern = 3
+	// NoiseNK 服务端用静态密钥认证，客户端已由 TLS 客户端证书认证
+	NoiseNK NoisePattern = 4
+
+	// NoiseHybrid 与上面的模式按位或组合，启用 X25519 + ML-KEM-768 混合密钥交换（Noise HFS）：
+	// 发起方在第一条消息中附带临时 ML-KEM-768 公钥（e1），响应方同意时在第二条消息中
+	// 返回封装的密文（ekem1），两种共享密钥都进入密钥派生；响应方也可以拒绝，只用 X25519
+	NoiseHybrid NoisePattern = 0x80
+)
+
+// 握手使用的密钥交换，记录在会话信息中
+const (
+	KeyExchangeX25519         = "X25519"
+	KeyExchangeX25519MLKEM768 = "X25519+ML-KEM-768"
+)
+
+// noisePatternDef 握手模式定义，messages 为每条握手消息的 token 序列
+type noisePatternDef struct {
+	name            string
+	responderStatic bool // 发起方预先知道响应方的静态公钥
+	messages        [][]string
+}
+
+var noisePatterns = map[NoisePattern]noisePatternDef{
+	NoiseNNpsk0: {"NNpsk0", false, [][]string{{"psk", "e"}, {"e", "ee"}}},
+	NoiseNKpsk2: {"NKpsk2", true, [][]string{{"e", "es"}, {"e", "ee", "psk"}}},
+	NoiseIK:     {"IK", true, [][]string{{"e", "es", "s", "ss"}, {"e", "ee", "se"}}},
+	NoiseNK:     {"NK", true, [][]string{{"e", "es"}, {"e", "ee"}}},
+}
+
+var (
+	// ErrNoiseHandshake 握手消息无效或认证失败
+	ErrNoiseHandshake = errors.New("noise handshake failed")
+)
+
+// NoiseKeypair X25519 密钥对
+type NoiseKeypair struct {
+	Private [NoiseKeySize]byte
+	Public  [NoiseKeySize]byte
+}
+
+// GenerateNoiseKeypair 生成新的密钥对
+func GenerateNoiseKeypair() (*NoiseKeypair, error) {
+	kp := &NoiseKeypair{}
+	if _, err := rand.Read(kp.Private[:]); err != nil {
+		return nil, err
+	}
+	if err := kp.derivePublic(); err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
+
+func (kp *NoiseKeypair) derivePublic() error {
+	public, err := curve25519.X25519(kp.Private[:], curve25519.Basepoint)
+	if err != nil {
+		return err
+	}
+	copy(kp.Public[:], public)
+	return nil
+}
+
+// PublicKeyString 返回 Base64 编码的公钥
+func (kp *NoiseKeypair) PublicKeyString() string {
+	return base64.StdEncoding.EncodeToString(kp.Public[:])
+}
+
+// ParseNoisePublicKey 解析 Base64 编码的公钥
+func ParseNoisePublicKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if len(key) != NoiseKeySize {
+		return nil, fmt.Errorf("invalid public key length %d", len(key))
+	}
+	return key, nil
+}
+
+// LoadOrCreateNoiseKeypair 从文件读取 Base64 编码的私钥，文件不存在时生成并保存
+func LoadOrCreateNoiseKeypair(path string) (*NoiseKeypair, bool, error) {
+	content, err := os.ReadFile(path)
+	if err == nil {
+		private, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+		if err != nil || len(private) != NoiseKeySize {
+			return nil, false, fmt.Errorf("invalid key file %s", path)
+		}
+		kp := &NoiseKeypair{}
+		copy(kp.Private[:], private)
+		if err := kp.derivePublic(); err != nil {
+			return nil, false, err
+		}
+		return kp, false, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, false, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	kp, err := GenerateNoiseKeypair()
+	if err != nil {
+		return nil, false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, false, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(kp.Private[:]) + "\n"
+	if err := os.WriteFile(path, []byte(encoded), 0600); err != nil {
+		return nil, false, fmt.Errorf("failed to write key file: %w", err)
+	}
+	return kp, true, nil
+}
+
+// DerivePSK 由令牌派生握手使用的 PSK，purpose 区分不同用途
+func DerivePSK(token, purpose string) []byte {
+	mac := hmac.New(newBLAKE2s, []byte("aethertunnel psk "+purpose))
+	mac.Write([]byte(token))
+	return mac.Sum(nil)
+}
+
+func newBLAKE2s() hash.Hash {
+	h, _ := blake2s.New256(nil)
+	return h
+}
+
+// hybridPatternName 返回带 hfs 修饰符的模式名，如 NKpsk2 → NKhfs+psk2
+func hybridPatternName(name string) string {
+	base, modifiers := name[:2], name[2:]
+	if modifiers == "" {
+		return base + "hfs"
+	}
+	return base + "hfs+" + modifiers
+}
+
+// noiseHKDF Noise 规范中的 HKDF，返回 n 个 32 字节输出
+func noiseHKDF(chainingKey, ikm []byte, n int) [][]byte {
+	mac := hmac.New(newBLAKE2s, chainingKey)
+	mac.Write(ikm)
+	tempKey := mac.Sum(nil)
+
+	outputs := make([][]byte, 0, n)
+	var prev []byte
+	for i := 1; i <= n; i++ {
+		mac := hmac.New(newBLAKE2s, tempKey)
+		mac.Write(prev)
+		mac.Write([]byte{byte(i)})
+		prev = mac.Sum(nil)
+		outputs = append(outputs, prev)
+	}
+	return outputs
+}
+
+// noiseNonce ChaChaPoly 的 nonce：4 字节 0 加 8 字节小端计数器
+func noiseNonce(n uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], n)
+	return nonce
+}
+
+// noiseCipherState 握手阶段的 CipherState
+type noiseCipherState struct {
+	key    []byte
+	nonce  uint64
+	hasKey bool
+}
+
+func (c *noiseCipherState) initializeKey(key []byte) {
+	c.key = key
+	c.nonce = 0
+	c.hasKey = true
+}
+
+func (c *noiseCipherState) encrypt(ad, plaintext []byte) ([]byte, error) {
+	if !c.hasKey {
+		return plaintext, nil
+	}
+	aead, err := chacha20poly1305.New(c.key)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := aead.Seal(nil, noiseNonce(c.nonce), plaintext, ad)
+	c.nonce++
+	return ciphertext, nil
+}
+
+func (c *noiseCipherState) decrypt(ad, ciphertext []byte) ([]byte, error) {
+	if !c.hasKey {
+		return ciphertext, nil
+	}
+	aead, err := chacha20poly1305.New(c.key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, noiseNonce(c.nonce), ciphertext, ad)
+	if err != nil {
+		return nil, ErrNoiseHandshake
+	}
+	c.nonce++
+	return plaintext, nil
+}
+
+// noiseSymmetricState 握手阶段的 SymmetricState
+type noiseSymmetricState struct {
+	cs noiseCipherState
+	ck []byte
+	h  []byte
+}
+
+func (s *noiseSymmetricState) initialize(protocolName string) {
+	if len(protocolName) <= blake2s.Size {
+		s.h = make([]byte, blake2s.Size)
+		copy(s.h, protocolName)
+	} else {
+		sum := blake2s.Sum256([]byte(protocolName))
+		s.h = sum[:]
+	}
+	s.ck = append([]byte(nil), s.h...)
+}
+
+func (s *noiseSymmetricState) mixKey(ikm []byte) {
+	out := noiseHKDF(s.ck, ikm, 2)
+	s.ck = out[0]
+	s.cs.initializeKey(out[1])
+}
+
+func (s *noiseSymmetricState) mixHash(data []byte) {
+	h := newBLAKE2s()
+	h.Write(s.h)
+	h.Write(data)
+	s.h = h.Sum(nil)
+}
+
+func (s *noiseSymmetricState) mixKeyAndHash(ikm []byte) {
+	out := noiseHKDF(s.ck, ikm, 3)
+	s.ck = out[0]
+	s.mixHash(out[1])
+	s.cs.initializeKey(out[2])
+}
+
+func (s *noiseSymmetricState) encryptAndHash(plaintext []byte) ([]byte, error) {
+	ciphertext, err := s.cs.encrypt(s.h, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	s.mixHash(ciphertext)
+	return ciphertext, nil
+}
+
+func (s *noiseSymmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	plaintext, err := s.cs.decrypt(s.h, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	s.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// NoiseHandshake Noise 握手状态（Noise_*_25519_ChaChaPoly_BLAKE2s，混合模式为 Noise_*hfs_25519+MLKEM768_ChaChaPoly_BLAKE2s）
+//
+// 握手完成后由 Conn 派生两个方向的传输密钥，每个会话的临时密钥都是新生成的，
+// 泄露长期密钥或令牌也无法解密之前的会话。
+type NoiseHandshake struct {
+	ss          noiseSymmetricState
+	pattern     noisePatternDef
+	initiator   bool
+	psk         []byte
+	s           *NoiseKeypair
+	e           *NoiseKeypair
+	rs          []byte
+	re          []byte
+	hybrid      bool                       // 发起方提供了 ML-KEM 公钥
+	kemDeclined bool                       // 响应方拒绝使用 ML-KEM
+	kem         *mlkem.DecapsulationKey768 // 发起方的临时 ML-KEM 私钥
+	rkem        []byte                     // 响应方收到的 ML-KEM 公钥
+	step        int
+}
+
+// NewNoiseHandshake 创建握手状态
+//
+// static 为本地静态密钥，remoteStatic 为预先知道的对端静态公钥，psk 为 DerivePSK 的结果；
+// 模式不需要的参数传 nil。响应方的 psk 可以稍后用 SetPSK 设置。
+// pattern 包含 NoiseHybrid 时使用混合密钥交换。
+func NewNoiseHandshake(pattern NoisePattern, initiator bool, static *NoiseKeypair, remoteStatic, psk []byte) (*NoiseHandshake, error) {
+	return newNoiseHandshake(pattern, initiator, static, remoteStatic, psk, []byte(noisePrologue))
+}
+
+// newNoiseHandshake 以指定的 prologue 创建握手状态，测试向量使用空的 prologue
+func newNoiseHandshake(pattern NoisePattern, initiator bool, static *NoiseKeypair, remoteStatic, psk, prologue []byte) (*NoiseHandshake, error) {
+	def, exists := noisePatterns[pattern&^NoiseHybrid]
+	if !exists {
+		return nil, fmt.Errorf("unknown noise pattern %d", pattern)
+	}
+
+	h := &NoiseHandshake{
+		pattern:   def,
+		initiator: initiator,
+		psk:       psk,
+		s:         static,
+		rs:        remoteStatic,
+		hybrid:    pattern&NoiseHybrid != 0,
+	}
+
+	if strings.Contains(def.name, "psk") && initiator && len(psk) != NoiseKeySize {
+		return nil, fmt.Errorf("noise pattern %s requires a psk", def.name)
+	}
+	if def.responderStatic {
+		if initiator && len(remoteStatic) != NoiseKeySize {
+			return nil, fmt.Errorf("noise pattern %s requires the server public key", def.name)
+		}
+		if !initiator && static == nil {
+			return nil, fmt.Errorf("noise pattern %s requires a static key", def.name)
+		}
+	}
+	if pattern == NoiseIK && initiator && static == nil {
+		return nil, fmt.Errorf("noise pattern %s requires a client static key", def.name)
+	}
+
+	if h.hybrid {
+		h.ss.initialize("Noise_" + hybridPatternName(def.name) + "_25519+MLKEM768_ChaChaPoly_BLAKE2s")
+	} else {
+		h.ss.initialize("Noise_" + def.name + "_25519_ChaChaPoly_BLAKE2s")
+	}
+	h.ss.mixHash(prologue)
+	if def.responderStatic {
+		if initiator {
+			h.ss.mixHash(remoteStatic)
+		} else {
+			h.ss.mixHash(static.Public[:])
+		}
+	}
+
+	return h, nil
+}
+
+// DeclineHybrid 不使用 ML-KEM，第二条消息只用 X25519
+//
+// 响应方不支持混合密钥交换时在写第二条消息前调用；发起方得知响应方拒绝后在读第二条消息前调用。
+// 两端是否使用 ML-KEM 不一致时派生的密钥不同，握手会失败，中间人无法借此降级。
+func (h *NoiseHandshake) DeclineHybrid() {
+	h.kemDeclined = true
+}
+
+// Hybrid 判断握手是否使用了 ML-KEM
+func (h *NoiseHandshake) Hybrid() bool {
+	return h.hybrid && !h.kemDeclined
+}
+
+// KeyExchange 返回握手使用的密钥交换名称
+func (h *NoiseHandshake) KeyExchange() string {
+	if h.Hybrid() {
+		return KeyExchangeX25519MLKEM768
+	}
+	return KeyExchangeX25519
+}
+
+// tokens 返回当前消息的 token 序列，混合模式下在 e 之后加入 e1，在 ee 之后加入 ekem1
+func (h *NoiseHandshake) tokens() []string {
+	tokens := h.pattern.messages[h.step]
+	if !h.hybrid {
+		return tokens
+	}
+	out := make([]string, 0, len(tokens)+1)
+	for _, token := range tokens {
+		out = append(out, token)
+		switch {
+		case token == "e" && h.step == 0:
+			out = append(out, "e1")
+		case token == "ee" && h.step == 1 && !h.kemDeclined:
+			out = append(out, "ekem1")
+		}
+	}
+	return out
+}
+
+// readEncrypted 从消息开头读取 size 字节明文对应的（可能已加密的）数据
+func (h *NoiseHandshake) readEncrypted(message []byte, size int) ([]byte, []byte, error) {
+	if h.ss.cs.hasKey {
+		size += chacha20poly1305.Overhead
+	}
+	if len(message) < size {
+		return nil, nil, ErrNoiseHandshake
+	}
+	plaintext, err := h.ss.decryptAndHash(message[:size])
+	if err != nil {
+		return nil, nil, err
+	}
+	return plaintext, message[size:], nil
+}
+
+// usesPSK 判断模式是否包含 psk
+func (h *NoiseHandshake) usesPSK() bool {
+	return strings.Contains(h.pattern.name, "psk")
+}
+
+// SetPSK 设置 PSK，响应方可以在读取第一条消息、确定对端身份后再设置
+func (h *NoiseHandshake) SetPSK(psk []byte) error {
+	if len(psk) != NoiseKeySize {
+		return fmt.Errorf("invalid psk length %d", len(psk))
+	}
+	h.psk = psk
+	return nil
+}
+
+// mixPSK 处理 psk
+func (h *NoiseHandshake) mixPSK() error {
+	if len(h.psk) != NoiseKeySize {
+		return errors.New("noise: psk is not set")
+	}
+	h.ss.mixKeyAndHash(h.psk)
+	return nil
+}
+
+// dh 计算 X25519 共享密钥
+func dh(private *NoiseKeypair, public []byte) ([]byte, error) {
+	shared, err := curve25519.X25519(private.Private[:], public)
+	if err != nil {
+		return nil, ErrNoiseHandshake
+	}
+	return shared, nil
+}
+
+// mixDH 处理 ee、es、se、ss
+func (h *NoiseHandshake) mixDH(token string) error {
+	var (
+		shared []byte
+		err    error
+	)
+	switch token {
+	case "ee":
+		shared, err = dh(h.e, h.re)
+	case "es":
+		if h.initiator {
+			shared, err = dh(h.e, h.rs)
+		} else {
+			shared, err = dh(h.s, h.re)
+		}
+	case "se":
+		if h.initiator {
+			shared, err = dh(h.s, h.re)
+		} else {
+			shared, err = dh(h.e, h.rs)
+		}
+	case "ss":
+		shared, err = dh(h.s, h.rs)
+	}
+	if err != nil {
+		return err
+	}
+	h.ss.mixKey(shared)
+	return nil
+}
+
+// myTurn 判断当前是否轮到本方写消息
+func (h *NoiseHandshake) myTurn() bool {
+	return (h.step%2 == 0) == h.initiator
+}
+
+// WriteMessage 生成下一条握手消息，payload 会被加密（psk 模式的第一条消息起）
+func (h *NoiseHandshake) WriteMessage(payload []byte) ([]byte, error) {
+	if h.Complete() || !h.myTurn() {
+		return nil, errors.New("noise: unexpected write")
+	}
+
+	var out []byte
+	for _, token := range h.tokens() {
+		switch token {
+		case "e":
+			// 临时密钥只在测试向量中预先设置
+			if h.e == nil {
+				e, err := GenerateNoiseKeypair()
+				if err != nil {
+					return nil, err
+				}
+				h.e = e
+			}
+			out = append(out, h.e.Public[:]...)
+			h.ss.mixHash(h.e.Public[:])
+			if h.usesPSK() {
+				h.ss.mixKey(h.e.Public[:])
+			}
+		case "s":
+			ciphertext, err := h.ss.encryptAndHash(h.s.Public[:])
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, ciphertext...)
+		case "e1":
+			kem, err := mlkem.GenerateKey768()
+			if err != nil {
+				return nil, err
+			}
+			h.kem = kem
+			ciphertext, err := h.ss.encryptAndHash(kem.EncapsulationKey().Bytes())
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, ciphertext...)
+		case "ekem1":
+			ek, err := mlkem.NewEncapsulationKey768(h.rkem)
+			if err != nil {
+				return nil, ErrNoiseHandshake
+			}
+			shared, kemCiphertext := ek.Encapsulate()
+			ciphertext, err := h.ss.encryptAndHash(kemCiphertext)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, ciphertext...)
+			h.ss.mixKey(shared)
+		case "psk":
+			if err := h.mixPSK(); err != nil {
+				return nil, err
+			}
+		default:
+			if err := h.mixDH(token); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	ciphertext, err := h.ss.encryptAndHash(payload)
+	if err != nil {
+		return nil, err
+	}
+	h.step++
+	return append(out, ciphertext...), nil
+}
+
+// ReadMessage 处理对端的握手消息，返回解密后的 payload
+func (h *NoiseHandshake) ReadMessage(message []byte) ([]byte, error) {
+	if h.Complete() || h.myTurn() {
+		return nil, errors.New("noise: unexpected read")
+	}
+
+	for _, token := range h.tokens() {
+		switch token {
+		case "e":
+			if len(message) < NoiseKeySize {
+				return nil, ErrNoiseHandshake
+			}
+			h.re = append([]byte(nil), message[:NoiseKeySize]...)
+			message = message[NoiseKeySize:]
+			h.ss.mixHash(h.re)
+			if h.usesPSK() {
+				h.ss.mixKey(h.re)
+			}
+		case "s":
+			rs, rest, err := h.readEncrypted(message, NoiseKeySize)
+			if err != nil {
+				return nil, err
+			}
+			h.rs = rs
+			message = rest
+		case "e1":
+			rkem, rest, err := h.readEncrypted(message, mlkem.EncapsulationKeySize768)
+			if err != nil {
+				return nil, err
+			}
+			h.rkem = rkem
+			message = rest
+		case "ekem1":
+			kemCiphertext, rest, err := h.readEncrypted(message, mlkem.CiphertextSize768)
+			if err != nil {
+				return nil, err
+			}
+			shared, err := h.kem.Decapsulate(kemCiphertext)
+			if err != nil {
+				return nil, ErrNoiseHandshake
+			}
+			h.ss.mixKey(shared)
+			message = rest
+		case "psk":
+			if err := h.mixPSK(); err != nil {
+				return nil, err
+			}
+		default:
+			if err := h.mixDH(token); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	payload, err := h.ss.decryptAndHash(message)
+	if err != nil {
+		return nil, err
+	}
+	h.step++
+	return payload, nil
+}
+
+// Complete 判断握手是否完成
+func (h *NoiseHandshake) Complete() bool {
+	return h.step >= len(h.pattern.messages)
+}
+
+// RemoteStatic 返回对端的静态公钥，模式中没有时返回 nil
+func (h *NoiseHandshake) RemoteStatic() []byte {
+	return h.rs
+}
+
+// Conn 握手完成后派生传输密钥，返回以 suite 加密的连接
+//
+// 握手本身固定使用 ChaChaPoly，suite 只决定传输阶段的算法，由双方在握手载荷中协商。
+func (h *NoiseHandshake) Conn(conn net.Conn, suite CipherSuite) (*SecureConn, error) {
+	if !h.Complete() {
+		return nil, errors.New("noise: handshake not complete")
+	}
+
+	keys := noiseHKDF(h.ss.ck, nil, 2)
+	sendKey, recvKey := keys[0], keys[1]
+	if !h.initiator {
+		sendKey, recvKey = recvKey, sendKey
+	}
+
+	send, err := suite.New(sendKey[:suite.KeySize()])
+	if err != nil {
+		return nil, err
+	}
+	recv, err := suite.New(recvKey[:suite.KeySize()])
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecureConn{Conn: conn, suite: suite, keyExchange: h.KeyExchange(), send: send, recv: recv, remoteStatic: h.rs}, nil
+}
@@ -0,0 +1,426 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// noiseVector Noise 协议的公开测试向量（cacophony 格式，prologue 和握手载荷为空）
+//
+// 前两条消息是握手消息，后两条是握手完成后两个方向的第一条传输消息。
+type noiseVector struct {
+	pattern     NoisePattern
+	initStatic  string
+	respStatic  string
+	psk         string
+	ciphertexts []string
+}
+
+var noiseVectors = map[string]noiseVector{
+	"Noise_NNpsk0_25519_ChaChaPoly_BLAKE2s": {
+		pattern: NoiseNNpsk0,
+		psk:     "2176657279736563726574766572797365637265747665727973656372657421",
+		ciphertexts: []string{
+			"358072d6365880d1aeea329adf9121383851ed21a28e3b75e965d0d2cd16625429e02e0aff3585ba34213b02ce0584b1",
+			"64b101b1d0be5a8704bd078f9895001fc03e8e9f9522f188dd128d9846d484667e40f02cbf3aba406c8b3556958221ef",
+			"45229f0fb23ccd92b0554c5be976ab8ccecf5f1e7503af4c5a1e4e45d35dd5",
+			"fcf39b68313e893f9682801d60aee12337d52a64661af37a0366b7924d1657",
+		},
+	},
+	"Noise_NK_25519_ChaChaPoly_BLAKE2s": {
+		pattern:    NoiseNK,
+		respStatic: "0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20",
+		ciphertexts: []string{
+			"358072d6365880d1aeea329adf9121383851ed21a28e3b75e965d0d2cd166254c796bf92e018434c9b2146fab78f30d0",
+			"64b101b1d0be5a8704bd078f9895001fc03e8e9f9522f188dd128d9846d48466cb3abc71944afc6463300a32ba99b33d",
+			"56a475d3db0d0d5931542a93e3cd57c7dc51b29fc6d0a7cea41aea05d99fe5",
+			"5c239eb65b5f0d0641f6c6c20aec65646626249f9194e4211a2f8e761c2d72",
+		},
+	},
+	"Noise_NKpsk2_25519_ChaChaPoly_BLAKE2s": {
+		pattern:    NoiseNKpsk2,
+		respStatic: "0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20",
+		psk:        "2176657279736563726574766572797365637265747665727973656372657421",
+		ciphertexts: []string{
+			"358072d6365880d1aeea329adf9121383851ed21a28e3b75e965d0d2cd166254f944f03a16bec0d4ede15bbf507f7e25",
+			"64b101b1d0be5a8704bd078f9895001fc03e8e9f9522f188dd128d9846d4846634632482a2c45167231236b170f1fbc1",
+			"93bb4e3dd1995295277446d3010fc7299dd2d1f283d7ce9ee8934d1caa60ef",
+			"d7ce2e01658cebe3086b25ae67c184cbb26e4855bc9c03a82c149948ea9a4e",
+		},
+	},
+	"Noise_IK_25519_ChaChaPoly_BLAKE2s": {
+		pattern:    NoiseIK,
+		initStatic: "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+		respStatic: "0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20",
+		ciphertexts: []string{
+			"358072d6365880d1aeea329adf9121383851ed21a28e3b75e965d0d2cd166254c9f0dff42c86abe5677abe74f6c87301577dbc1f3ffb2213827ca694a057fdbbff7f7350265fe61102c24d7d7a7e960ba8b90a679895087c7d28b1d6703f9727",
+			"64b101b1d0be5a8704bd078f9895001fc03e8e9f9522f188dd128d9846d4846622bf9c6171ddd4c8f682080b03504eee",
+			"595694f9be48f03790f699455c84578b31d14a7baedfd736d73c53f66a5657",
+			"621ae446b11fda3cf08e56102dac9324dee37a4e536cdc878e8b454d98bcf2",
+		},
+	},
+}
+
+// 所有向量使用相同的临时密钥和传输消息
+const (
+	vectorInitEphemeral = "202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f"
+	vectorRespEphemeral = "4142434445464748494a4b4c4d4e4f505152535455565758595a5b5c5d5e5f60"
+	vectorPayload2      = "79656c6c6f777375626d6172696e65"
+	vectorPayload3      = "7375626d6172696e6579656c6c6f77"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// vectorKeypair 由十六进制私钥创建密钥对，s 为空时返回 nil
+func vectorKeypair(t *testing.T, s string) *NoiseKeypair {
+	t.Helper()
+	if s == "" {
+		return nil
+	}
+	kp := &NoiseKeypair{}
+	copy(kp.Private[:], mustHex(t, s))
+	if err := kp.derivePublic(); err != nil {
+		t.Fatal(err)
+	}
+	return kp
+}
+
+func TestNoiseVectors(t *testing.T) {
+	for name, v := range noiseVectors {
+		initStatic := vectorKeypair(t, v.initStatic)
+		respStatic := vectorKeypair(t, v.respStatic)
+		var psk, remoteStatic []byte
+		if v.psk != "" {
+			psk = mustHex(t, v.psk)
+		}
+		if respStatic != nil {
+			remoteStatic = respStatic.Public[:]
+		}
+
+		initiator, err := newNoiseHandshake(v.pattern, true, initStatic, remoteStatic, psk, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		responder, err := newNoiseHandshake(v.pattern, false, respStatic, nil, psk, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		initiator.e = vectorKeypair(t, vectorInitEphemeral)
+		responder.e = vectorKeypair(t, vectorRespEphemeral)
+
+		msg0, err := initiator.WriteMessage(nil)
+		if err != nil || !bytes.Equal(msg0, mustHex(t, v.ciphertexts[0])) {
+			t.Errorf("%s: message 0 is %x, %v", name, msg0, err)
+			continue
+		}
+		if _, err := responder.ReadMessage(msg0); err != nil {
+			t.Errorf("%s: failed to read message 0: %v", name, err)
+			continue
+		}
+		msg1, err := responder.WriteMessage(nil)
+		if err != nil || !bytes.Equal(msg1, mustHex(t, v.ciphertexts[1])) {
+			t.Errorf("%s: message 1 is %x, %v", name, msg1, err)
+			continue
+		}
+		if _, err := initiator.ReadMessage(msg1); err != nil {
+			t.Errorf("%s: failed to read message 1: %v", name, err)
+			continue
+		}
+
+		// Split 得到的第一个密钥用于发起方发送，第二个用于响应方发送
+		keys := noiseHKDF(initiator.ss.ck, nil, 2)
+		for i, payload := range []string{vectorPayload2, vectorPayload3} {
+			aead, _ := chacha20poly1305.New(keys[i])
+			ciphertext := aead.Seal(nil, noiseNonce(0), mustHex(t, payload), nil)
+			if !bytes.Equal(ciphertext, mustHex(t, v.ciphertexts[2+i])) {
+				t.Errorf("%s: transport message %d is %x", name, 2+i, ciphertext)
+			}
+		}
+	}
+}
+
+// noiseParties 握手双方的参数
+type noiseParties struct {
+	pattern              NoisePattern
+	client, server       *NoiseKeypair
+	serverKey            []byte // 客户端认为的服务端公钥
+	clientPSK, serverPSK []byte
+}
+
+// newNoiseParties 返回 pattern 双方参数正确的握手
+func newNoiseParties(t *testing.T, pattern NoisePattern) *noiseParties {
+	t.Helper()
+	client, _ := GenerateNoiseKeypair()
+	server, _ := GenerateNoiseKeypair()
+	psk := DerivePSK("token", PSKPurposeControl)
+	p := &noiseParties{pattern: pattern, serverKey: server.Public[:], clientPSK: psk, serverPSK: psk}
+	switch pattern &^ NoiseHybrid {
+	case NoiseNNpsk0:
+		p.serverKey = nil
+	case NoiseNKpsk2:
+		p.server = server
+	case NoiseIK:
+		p.client, p.server = client, server
+		p.clientPSK, p.serverPSK = nil, nil
+	case NoiseNK:
+		p.server = server
+		p.clientPSK, p.serverPSK = nil, nil
+	}
+	return p
+}
+
+// handshake 完成握手，tamper 可以修改传输中的消息，返回双方的握手状态
+func (p *noiseParties) handshake(tamper func(i int, msg []byte)) (*NoiseHandshake, *NoiseHandshake, error) {
+	initiator, err := NewNoiseHandshake(p.pattern, true, p.client, p.serverKey, p.clientPSK)
+	if err != nil {
+		return nil, nil, err
+	}
+	responder, err := NewNoiseHandshake(p.pattern, false, p.server, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msg0, err := initiator.WriteMessage([]byte("hello"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if tamper != nil {
+		tamper(0, msg0)
+	}
+	if p.serverPSK != nil {
+		if err := responder.SetPSK(p.serverPSK); err != nil {
+			return nil, nil, err
+		}
+	}
+	if payload, err := responder.ReadMessage(msg0); err != nil {
+		return nil, nil, err
+	} else if string(payload) != "hello" {
+		return nil, nil, errors.New("payload mismatch")
+	}
+
+	msg1, err := responder.WriteMessage([]byte("accept"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if tamper != nil {
+		tamper(1, msg1)
+	}
+	if payload, err := initiator.ReadMessage(msg1); err != nil {
+		return nil, nil, err
+	} else if string(payload) != "accept" {
+		return nil, nil, errors.New("payload mismatch")
+	}
+	return initiator, responder, nil
+}
+
+// exchange 用握手结果建立加密连接，检查发起方发送的数据能被响应方读取
+func exchange(initiator, responder *NoiseHandshake) error {
+	a, b := net.Pipe()
+	clientConn, err := initiator.Conn(a, CipherSuiteChaCha20Poly1305)
+	if err != nil {
+		return err
+	}
+	serverConn, err := responder.Conn(b, CipherSuiteChaCha20Poly1305)
+	if err != nil {
+		return err
+	}
+	defer serverConn.Close()
+	go func() {
+		clientConn.Write([]byte("ping"))
+		clientConn.Close()
+	}()
+	data, err := io.ReadAll(serverConn)
+	if err != nil {
+		return err
+	}
+	if string(data) != "ping" {
+		return fmt.Errorf("expected %q over the secure connection, got %q", "ping", data)
+	}
+	return nil
+}
+
+func TestNoiseHandshake(t *testing.T) {
+	patterns := map[string]NoisePattern{"NNpsk0": NoiseNNpsk0, "NKpsk2": NoiseNKpsk2, "IK": NoiseIK, "NK": NoiseNK}
+	for name, pattern := range patterns {
+		p := newNoiseParties(t, pattern)
+		initiator, responder, err := p.handshake(nil)
+		if err != nil {
+			t.Errorf("%s: handshake failed: %v", name, err)
+			continue
+		}
+		if pattern == NoiseIK && !bytes.Equal(responder.RemoteStatic(), p.client.Public[:]) {
+			t.Errorf("%s: expected the server to learn the client's static key", name)
+		}
+
+		if err := exchange(initiator, responder); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestNoiseHandshakeFailures(t *testing.T) {
+	wrongKey, _ := GenerateNoiseKeypair()
+	wrongPSK := DerivePSK("wrong token", PSKPurposeControl)
+
+	tests := []struct {
+		name    string
+		pattern NoisePattern
+		modify  func(p *noiseParties)
+		tamper  func(i int, msg []byte)
+	}{
+		{"NNpsk0 wrong psk", NoiseNNpsk0, func(p *noiseParties) { p.clientPSK = wrongPSK }, nil},
+		{"NKpsk2 wrong psk", NoiseNKpsk2, func(p *noiseParties) { p.serverPSK = wrongPSK }, nil},
+		{"NKpsk2 wrong server key", NoiseNKpsk2, func(p *noiseParties) { p.serverKey = wrongKey.Public[:] }, nil},
+		{"NK wrong server key", NoiseNK, func(p *noiseParties) { p.serverKey = wrongKey.Public[:] }, nil},
+		{"IK wrong server key", NoiseIK, func(p *noiseParties) { p.serverKey = wrongKey.Public[:] }, nil},
+		{"IK server with another key", NoiseIK, func(p *noiseParties) { p.server = wrongKey }, nil},
+		{"NKpsk2 tampered first message", NoiseNKpsk2, nil, func(i int, msg []byte) {
+			if i == 0 {
+				msg[len(msg)-1] ^= 1
+			}
+		}},
+		{"IK tampered static key", NoiseIK, nil, func(i int, msg []byte) {
+			if i == 0 {
+				msg[NoiseKeySize] ^= 1
+			}
+		}},
+		{"NK tampered ephemeral key", NoiseNK, nil, func(i int, msg []byte) {
+			if i == 1 {
+				msg[0] ^= 1
+			}
+		}},
+		{"NNpsk0 tampered second message", NoiseNNpsk0, nil, func(i int, msg []byte) {
+			if i == 1 {
+				msg[len(msg)-1] ^= 1
+			}
+		}},
+	}
+	for _, tt := range tests {
+		p := newNoiseParties(t, tt.pattern)
+		if tt.modify != nil {
+			tt.modify(p)
+		}
+		if _, _, err := p.handshake(tt.tamper); !errors.Is(err, ErrNoiseHandshake) {
+			t.Errorf("%s: expected ErrNoiseHandshake, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestNoiseSetPSK(t *testing.T) {
+	server, _ := GenerateNoiseKeypair()
+	responder, err := NewNoiseHandshake(NoiseNKpsk2, false, server, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := responder.SetPSK([]byte("short")); err == nil {
+		t.Error("Expected a psk of the wrong length to be rejected")
+	}
+	if _, err := NewNoiseHandshake(NoiseNKpsk2, true, nil, server.Public[:], nil); err == nil {
+		t.Error("Expected the initiator to require a psk")
+	}
+}
+
+// hybridHandshake 按控制连接的流程完成 NKpsk2 混合握手：客户端在模式字节中提出 NoiseHybrid，
+// 服务端 accept 时同意，并在回复的模式字节中告知客户端。flipRequest 和 flipReply 模拟中间人
+// 翻转两个方向模式字节中的 NoiseHybrid 位。
+func hybridHandshake(accept, flipRequest, flipReply bool) (*NoiseHandshake, *NoiseHandshake, error) {
+	server, _ := GenerateNoiseKeypair()
+	psk := DerivePSK("token", PSKPurposeControl)
+
+	pattern := NoiseNKpsk2 | NoiseHybrid
+	client, err := NewNoiseHandshake(pattern, true, nil, server.Public[:], psk)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg0, err := client.WriteMessage([]byte("hello"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if flipRequest {
+		pattern ^= NoiseHybrid
+	}
+
+	responder, err := NewNoiseHandshake(pattern, false, server, nil, psk)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := responder.ReadMessage(msg0); err != nil {
+		return nil, nil, err
+	}
+	if !accept {
+		responder.DeclineHybrid()
+	}
+	msg1, err := responder.WriteMessage([]byte("accept"))
+	if err != nil {
+		return nil, nil, err
+	}
+	mode := NoiseNKpsk2
+	if responder.Hybrid() {
+		mode |= NoiseHybrid
+	}
+	if flipReply {
+		mode ^= NoiseHybrid
+	}
+
+	if mode&NoiseHybrid == 0 {
+		client.DeclineHybrid()
+	}
+	if _, err := client.ReadMessage(msg1); err != nil {
+		return nil, nil, err
+	}
+	return client, responder, nil
+}
+
+func TestNoiseHybrid(t *testing.T) {
+	tests := []struct {
+		name        string
+		accept      bool
+		keyExchange string
+	}{
+		{"accepted", true, KeyExchangeX25519MLKEM768},
+		{"declined", false, KeyExchangeX25519},
+	}
+	for _, tt := range tests {
+		client, server, err := hybridHandshake(tt.accept, false, false)
+		if err != nil {
+			t.Errorf("%s: handshake failed: %v", tt.name, err)
+			continue
+		}
+		if client.KeyExchange() != tt.keyExchange || server.KeyExchange() != tt.keyExchange {
+			t.Errorf("%s: expected %s, client used %s and server %s", tt.name, tt.keyExchange, client.KeyExchange(), server.KeyExchange())
+		}
+		if err := exchange(client, server); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+func TestNoiseHybridModeTampering(t *testing.T) {
+	tests := []struct {
+		name                           string
+		accept, flipRequest, flipReply bool
+	}{
+		{"request downgraded", true, true, false},
+		{"acceptance hidden", true, false, true},
+		{"declined reported as accepted", false, false, true},
+	}
+	for _, tt := range tests {
+		if _, _, err := hybridHandshake(tt.accept, tt.flipRequest, tt.flipReply); !errors.Is(err, ErrNoiseHandshake) {
+			t.Errorf("%s: expected ErrNoiseHandshake, got %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// maxRecordPayload 单条加密记录的明文上限
+const maxRecordPayload = 16 * 1024
+
+// ErrRecordTooLarge 加密记录超过上限
+var ErrRecordTooLarge = errors.New("secure record too large")
+
+// SecureConn 以加密记录传输数据的连接
+//
+// 由 NoiseHandshake.Conn 创建。每条记录为 2 字节密文长度加 Noise 传输消息，
+// nonce 为递增计数器，记录被篡改、重放或重新排序都会导致读取失败。
+type SecureConn struct {
+	net.Conn
+	suite        CipherSuite
+	keyExchange  string
+	send         cipher.AEAD
+	recv         cipher.AEAD
+	remoteStatic []byte
+	sendSeq      uint64
+	recvSeq      uint64
+	plaintext    []byte
+	writeMu      sync.Mutex
+	readMu       sync.Mutex
+}
+
+// RemoteStatic 返回握手中认证的对端静态公钥，对端只用 PSK 认证时返回 nil
+func (c *SecureConn) RemoteStatic() []byte {
+	return c.remoteStatic
+}
+
+// CipherSuite 返回协商的传输加密算法
+func (c *SecureConn) CipherSuite() CipherSuite {
+	return c.suite
+}
+
+// KeyExchange 返回握手使用的密钥交换（X25519 或 X25519+ML-KEM-768）
+func (c *SecureConn) KeyExchange() string {
+	return c.keyExchange
+}
+
+// Write 加密并发送数据
+func (c *SecureConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	written := 0
+	for written < len(p) {
+		n := len(p) - written
+		if n > maxRecordPayload {
+			n = maxRecordPayload
+		}
+
+		record := make([]byte, 2, 2+n+c.send.Overhead())
+		record = c.send.Seal(record, c.suite.nonce(c.sendSeq), p[written:written+n], nil)
+		binary.BigEndian.PutUint16(record, uint16(len(record)-2))
+		c.sendSeq++
+
+		if _, err := c.Conn.Write(record); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Read 读取并解密数据
+func (c *SecureConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if len(c.plaintext) == 0 {
+		var header [2]byte
+		if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+			return 0, err
+		}
+		length := int(binary.BigEndian.Uint16(header[:]))
+		if length > maxRecordPayload+c.recv.Overhead() {
+			return 0, ErrRecordTooLarge
+		}
+
+		record := make([]byte, length)
+		if _, err := io.ReadFull(c.Conn, record); err != nil {
+			return 0, err
+		}
+		plaintext, err := c.recv.Open(record[:0], c.suite.nonce(c.recvSeq), record, nil)
+		if err != nil {
+			return 0, fmt.Errorf("secure record authentication failed: %w", err)
+		}
+		c.recvSeq++
+		c.plaintext = plaintext
+	}
+
+	n := copy(p, c.plaintext)
+	c.plaintext = c.plaintext[n:]
+	return n, nil
+}
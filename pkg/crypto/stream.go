@@ -0,0 +1,233 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// StreamChunkSize 流式加密每块的明文长度
+const StreamChunkSize = 64 * 1024
+
+// streamVersion 流格式版本
+//
+// 流格式：版本（1 字节）| 密钥标识（4 字节）| 盐（16 字节）| 若干密文块。
+// 每个流用盐和主密钥经 HKDF 派生独立的 ChaCha20-Poly1305 密钥，按 STREAM 构造加密：
+// 第 i 块的 nonce 为 11 字节大端计数器加 1 字节结束标志，流头和调用方附加数据参与每块的认证。
+// 除最后一块外每块都是 StreamChunkSize 字节明文，最后一块更短（可以为空）且带结束标志，
+// 块被篡改、重新排序或者流被截断都会导致读取失败。
+const streamVersion = 1
+
+// streamSaltSize 流头中盐的长度
+const streamSaltSize = 16
+
+// streamHeaderSize 流头长度
+const streamHeaderSize = 1 + KeyIDSize + streamSaltSize
+
+// streamChunkOverhead 每块密文比明文多出的长度
+const streamChunkOverhead = chacha20poly1305.Overhead
+
+// ErrStreamTampered 密文块认证失败或者流被截断
+var ErrStreamTampered = errors.New("encrypted stream is corrupted or truncated")
+
+// streamCipher 单个流的加密状态
+type streamCipher struct {
+	aead    cipher.AEAD
+	ad      []byte // 流头加调用方附加数据
+	counter uint64
+}
+
+// newStreamCipher 由流头中的盐派生这个流的密钥
+func newStreamCipher(master, header, ad []byte) (*streamCipher, error) {
+	salt := header[1+KeyIDSize:]
+	aead, err := CipherSuiteChaCha20Poly1305.New(DeriveSaltedKey(master, salt, PurposeStream, chacha20poly1305.KeySize))
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, len(header)+len(ad))
+	data = append(data, header...)
+	return &streamCipher{aead: aead, ad: append(data, ad...)}, nil
+}
+
+// nonce 返回下一块的 nonce
+func (s *streamCipher) nonce(last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce[3:11], s.counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// StreamWriter 把写入的数据分块加密后写到底层 Writer，须调用 Close 写入最后一块
+type StreamWriter struct {
+	w      io.Writer
+	cipher *streamCipher
+	header []byte // 尚未写出的流头，写出后为 nil
+	buf    []byte
+	err    error
+}
+
+// NewStreamWriter 创建流式加密器，ad 为参与每块认证的附加数据，解密时须相同
+func (e *Encryption) NewStreamWriter(w io.Writer, ad []byte) (*StreamWriter, error) {
+	key := e.keys[0]
+
+	header := make([]byte, streamHeaderSize)
+	header[0] = streamVersion
+	copy(header[1:], key.id[:])
+	if _, err := rand.Read(header[1+KeyIDSize:]); err != nil {
+		return nil, err
+	}
+
+	sc, err := newStreamCipher(key.master, header, ad)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamWriter{
+		w:      w,
+		cipher: sc,
+		header: header,
+		buf:    make([]byte, 0, StreamChunkSize+streamChunkOverhead),
+	}, nil
+}
+
+// Write 缓冲数据，每满一块加密写出一块
+func (sw *StreamWriter) Write(p []byte) (int, error) {
+	if sw.err != nil {
+		return 0, sw.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := copy(sw.buf[len(sw.buf):StreamChunkSize], p)
+		sw.buf = sw.buf[:len(sw.buf)+n]
+		p = p[n:]
+		written += n
+
+		// 缓冲满时立即写出，保证最后一块总是短于完整块
+		if len(sw.buf) == StreamChunkSize {
+			if err := sw.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close 加密写出带结束标志的最后一块，不关闭底层 Writer
+func (sw *StreamWriter) Close() error {
+	if sw.err != nil {
+		return sw.err
+	}
+	if err := sw.flush(true); err != nil {
+		return err
+	}
+	sw.err = errors.New("crypto: write to closed stream")
+	return nil
+}
+
+// flush 加密并写出缓冲中的数据
+func (sw *StreamWriter) flush(last bool) error {
+	if sw.header != nil {
+		if _, err := sw.w.Write(sw.header); err != nil {
+			sw.err = err
+			return err
+		}
+		sw.header = nil
+	}
+
+	chunk := sw.cipher.aead.Seal(sw.buf[:0], sw.cipher.nonce(last), sw.buf, sw.cipher.ad)
+	sw.cipher.counter++
+	if _, err := sw.w.Write(chunk); err != nil {
+		sw.err = err
+		return err
+	}
+	sw.buf = sw.buf[:0]
+	return nil
+}
+
+// StreamReader 从底层 Reader 读取并逐块解密 StreamWriter 写出的数据
+type StreamReader struct {
+	r         io.Reader
+	cipher    *streamCipher
+	chunk     []byte
+	plaintext []byte
+	done      bool // 已读到最后一块
+	err       error
+}
+
+// NewStreamReader 读取流头并创建流式解密器，ad 须与加密时相同
+func (e *Encryption) NewStreamReader(r io.Reader, ad []byte) (*StreamReader, error) {
+	header := make([]byte, streamHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrStreamTampered
+		}
+		return nil, err
+	}
+	if header[0] != streamVersion {
+		return nil, fmt.Errorf("unsupported stream version %d", header[0])
+	}
+	key := e.key(header[1 : 1+KeyIDSize])
+	if key == nil {
+		return nil, fmt.Errorf("%w %x", ErrUnknownKeyID, header[1:1+KeyIDSize])
+	}
+
+	sc, err := newStreamCipher(key.master, header, ad)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamReader{
+		r:      r,
+		cipher: sc,
+		chunk:  make([]byte, StreamChunkSize+streamChunkOverhead),
+	}, nil
+}
+
+// Read 读取解密后的数据，只返回已通过认证的明文
+func (sr *StreamReader) Read(p []byte) (int, error) {
+	for len(sr.plaintext) == 0 {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		if sr.done {
+			return 0, io.EOF
+		}
+		sr.err = sr.readChunk()
+	}
+
+	n := copy(p, sr.plaintext)
+	sr.plaintext = sr.plaintext[n:]
+	return n, nil
+}
+
+// readChunk 读取并解密下一块：完整长度的块不是最后一块，更短的块须带结束标志
+func (sr *StreamReader) readChunk() error {
+	n, err := io.ReadFull(sr.r, sr.chunk)
+	switch {
+	case err == nil:
+	case err == io.ErrUnexpectedEOF:
+		sr.done = true
+	case err == io.EOF:
+		// 没有读到带结束标志的块，流被截断
+		return ErrStreamTampered
+	default:
+		return err
+	}
+	if n < streamChunkOverhead {
+		return ErrStreamTampered
+	}
+
+	plaintext, err := sr.cipher.aead.Open(sr.chunk[:0], sr.cipher.nonce(sr.done), sr.chunk[:n], sr.cipher.ad)
+	if err != nil {
+		return ErrStreamTampered
+	}
+	sr.cipher.counter++
+	sr.plaintext = plaintext
+	return nil
+}
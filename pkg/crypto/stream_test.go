@@ -0,0 +1,227 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// testSecret 高熵令牌，派生密钥时不计算 Argon2id
+const testSecret = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+// sealStream 把 data 加密为完整的流
+func sealStream(t *testing.T, e *Encryption, data, ad []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := e.NewStreamWriter(&buf, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// openStream 解密完整的流
+func openStream(e *Encryption, stream, ad []byte) ([]byte, error) {
+	r, err := e.NewStreamReader(bytes.NewReader(stream), ad)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	e := NewEncryption(testSecret)
+	ad := []byte("backup.tar")
+	const chunk = StreamChunkSize + streamChunkOverhead
+
+	tests := []struct {
+		name   string
+		size   int
+		chunks int
+	}{
+		{"empty", 0, 1},
+		{"short", 100, 1},
+		{"one byte less than a chunk", StreamChunkSize - 1, 1},
+		// 长度是块长的整数倍时最后一块为空
+		{"one chunk", StreamChunkSize, 2},
+		{"two chunks", 2 * StreamChunkSize, 3},
+		{"partial last chunk", 2*StreamChunkSize + 7, 3},
+	}
+	for _, tt := range tests {
+		data := make([]byte, tt.size)
+		for i := range data {
+			data[i] = byte(i * 7)
+		}
+		stream := sealStream(t, e, data, ad)
+
+		last := tt.size - (tt.chunks-1)*StreamChunkSize
+		if want := streamHeaderSize + (tt.chunks-1)*chunk + last + streamChunkOverhead; len(stream) != want {
+			t.Errorf("%s: expected %d bytes, got %d", tt.name, want, len(stream))
+		}
+		got, err := openStream(e, stream, ad)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: round trip failed: %v", tt.name, err)
+		}
+	}
+}
+
+func TestStreamWriteInPieces(t *testing.T) {
+	e := NewEncryption(testSecret)
+	data := bytes.Repeat([]byte("0123456789"), StreamChunkSize/4)
+
+	var buf bytes.Buffer
+	w, _ := e.NewStreamWriter(&buf, nil)
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), 999)
+		w.Write(rest[:n])
+		rest = rest[n:]
+	}
+	w.Close()
+
+	if !bytes.Equal(buf.Bytes()[streamHeaderSize:], sealStreamWithHeader(t, e, buf.Bytes()[:streamHeaderSize], data)) {
+		t.Error("Expected the chunking not to depend on the size of writes")
+	}
+	if got, err := openStream(e, buf.Bytes(), nil); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Round trip failed: %v", err)
+	}
+}
+
+// sealStreamWithHeader 以给定的流头一次写入 data，返回流头之后的密文块
+func sealStreamWithHeader(t *testing.T, e *Encryption, header, data []byte) []byte {
+	t.Helper()
+	sc, err := newStreamCipher(e.keys[0].master, header, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []byte
+	for {
+		n := min(len(data), StreamChunkSize)
+		last := n < StreamChunkSize
+		out = sc.aead.Seal(out, sc.nonce(last), data[:n], sc.ad)
+		sc.counter++
+		data = data[n:]
+		if last {
+			return out
+		}
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	e := NewEncryption(testSecret)
+	ad := []byte("backup.tar")
+	const chunk = StreamChunkSize + streamChunkOverhead
+
+	// 三块：两个完整块和一个 100 字节的最后一块
+	stream := sealStream(t, e, make([]byte, 2*StreamChunkSize+100), ad)
+	header := stream[:streamHeaderSize]
+	chunks := [][]byte{
+		stream[streamHeaderSize : streamHeaderSize+chunk],
+		stream[streamHeaderSize+chunk : streamHeaderSize+2*chunk],
+		stream[streamHeaderSize+2*chunk:],
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(append([][]byte{header}, parts...), nil)
+	}
+	flip := func(offset int) []byte {
+		tampered := bytes.Clone(stream)
+		tampered[offset] ^= 1
+		return tampered
+	}
+
+	// 长度为块长整数倍的流以空的最后一块结束
+	exact := sealStream(t, e, make([]byte, StreamChunkSize), ad)
+
+	tests := []struct {
+		name   string
+		stream []byte
+		ad     []byte
+	}{
+		{"header only", join(), ad},
+		{"truncated header", stream[:streamHeaderSize-1], ad},
+		{"truncated before the last chunk", join(chunks[0], chunks[1]), ad},
+		{"truncated inside a chunk", stream[:streamHeaderSize+chunk+10], ad},
+		{"truncated last chunk", stream[:len(stream)-1], ad},
+		{"empty last chunk removed", exact[:len(exact)-streamChunkOverhead], ad},
+		{"reordered chunks", join(chunks[1], chunks[0], chunks[2]), ad},
+		{"duplicated chunk", join(chunks[0], chunks[0], chunks[1], chunks[2]), ad},
+		{"chunk removed", join(chunks[0], chunks[2]), ad},
+		{"appended chunk", join(chunks[0], chunks[1], chunks[2], chunks[2]), ad},
+		{"flipped bit in salt", flip(1 + KeyIDSize), ad},
+		{"flipped bit in first chunk", flip(streamHeaderSize + 5), ad},
+		{"flipped bit in last chunk", flip(len(stream) - 1), ad},
+		{"different associated data", stream, []byte("other.tar")},
+		{"missing associated data", stream, nil},
+	}
+	for _, tt := range tests {
+		if _, err := openStream(e, tt.stream, tt.ad); !errors.Is(err, ErrStreamTampered) {
+			t.Errorf("%s: expected ErrStreamTampered, got %v", tt.name, err)
+		}
+	}
+
+	if _, err := openStream(e, flip(0), ad); err == nil {
+		t.Error("Expected an unknown stream version to be rejected")
+	}
+	if _, err := openStream(NewEncryption("other-"+testSecret), stream, ad); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("Expected ErrUnknownKeyID, got %v", err)
+	}
+}
+
+func TestStreamReturnsOnlyAuthenticatedData(t *testing.T) {
+	e := NewEncryption(testSecret)
+	data := bytes.Repeat([]byte{1}, 2*StreamChunkSize)
+	stream := sealStream(t, e, data, nil)
+	// 篡改第二块，第一块的明文仍然可以读出，之后返回错误
+	stream[streamHeaderSize+StreamChunkSize+streamChunkOverhead+1] ^= 1
+
+	got, err := openStream(e, stream, nil)
+	if !errors.Is(err, ErrStreamTampered) {
+		t.Errorf("Expected ErrStreamTampered, got %v", err)
+	}
+	if !bytes.Equal(got, data[:StreamChunkSize]) {
+		t.Errorf("Expected only the first chunk, got %d bytes", len(got))
+	}
+}
+
+func TestSealOpenAssociatedData(t *testing.T) {
+	e := NewEncryption(testSecret)
+	plaintext := []byte("secret")
+
+	sealed, err := e.Seal(nil, plaintext, []byte("state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := e.Open(nil, sealed, []byte("state.json")); err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("Expected the same associated data to open, got %q, %v", got, err)
+	}
+	for name, ad := range map[string][]byte{"different": []byte("users.json"), "missing": nil} {
+		if _, err := e.Open(nil, sealed, ad); err == nil {
+			t.Errorf("Expected %s associated data to be rejected", name)
+		}
+	}
+
+	// 不带附加数据时 nil 和空切片等价
+	sealed, _ = e.Seal(nil, plaintext, nil)
+	if _, err := e.Open(nil, sealed, []byte{}); err != nil {
+		t.Errorf("Expected empty associated data to match nil, got %v", err)
+	}
+
+	// 密文头参与认证
+	tampered := bytes.Clone(sealed)
+	tampered[1] ^= 1
+	if _, err := e.Open(nil, tampered, nil); err == nil {
+		t.Error("Expected a tampered key id to be rejected")
+	}
+
+	// dst 已有内容时追加在后面
+	prefixed, _ := e.Seal([]byte("prefix"), plaintext, nil)
+	if got, err := e.Open([]byte("out:"), prefixed[len("prefix"):], nil); err != nil || string(got) != "out:secret" {
+		t.Errorf("Expected plaintext appended to dst, got %q, %v", got, err)
+	}
+}
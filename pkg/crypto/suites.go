@@ -0,0 +1,162 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/sys/cpu"
+)
+
+// CipherSuite AEAD 加密算法
+type CipherSuite uint8
+
+const (
+	CipherSuiteAES128GCM         CipherSuite = 1
+	CipherSuiteAES256GCM         CipherSuite = 2
+	CipherSuiteChaCha20Poly1305  CipherSuite = 3
+	CipherSuiteXChaCha20Poly1305 CipherSuite = 4
+)
+
+// cipherSuiteDef 加密算法定义
+type cipherSuiteDef struct {
+	name    string
+	keySize int
+	new     func(key []byte) (cipher.AEAD, error)
+}
+
+var cipherSuites = map[CipherSuite]cipherSuiteDef{
+	CipherSuiteAES128GCM:         {"AES-128-GCM", 16, newAESGCM},
+	CipherSuiteAES256GCM:         {"AES-256-GCM", 32, newAESGCM},
+	CipherSuiteChaCha20Poly1305:  {"ChaCha20-Poly1305", chacha20poly1305.KeySize, chacha20poly1305.New},
+	CipherSuiteXChaCha20Poly1305: {"XChaCha20-Poly1305", chacha20poly1305.KeySize, chacha20poly1305.NewX},
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// hasAESGCMHardware 判断 CPU 是否有 AES-GCM 硬件加速（与 crypto/tls 的判断相同）
+var hasAESGCMHardware = func() bool {
+	switch runtime.GOARCH {
+	case "amd64":
+		return cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ
+	case "arm64":
+		return cpu.ARM64.HasAES && cpu.ARM64.HasPMULL
+	case "s390x":
+		return cpu.S390X.HasAES && cpu.S390X.HasAESCTR && cpu.S390X.HasGHASH
+	}
+	return false
+}()
+
+// String 返回算法名称
+func (s CipherSuite) String() string {
+	if def, exists := cipherSuites[s]; exists {
+		return def.name
+	}
+	return fmt.Sprintf("CipherSuite(%d)", uint8(s))
+}
+
+// KeySize 返回密钥长度
+func (s CipherSuite) KeySize() int {
+	return cipherSuites[s].keySize
+}
+
+// New 用 key 创建 AEAD
+func (s CipherSuite) New(key []byte) (cipher.AEAD, error) {
+	def, exists := cipherSuites[s]
+	if !exists {
+		return nil, fmt.Errorf("unknown cipher suite %d", uint8(s))
+	}
+	if len(key) != def.keySize {
+		return nil, fmt.Errorf("%s requires a %d-byte key", def.name, def.keySize)
+	}
+	return def.new(key)
+}
+
+// nonce 返回计数器 n 对应的 nonce：计数器放在最后 8 字节，
+// AES-GCM 用大端序，ChaCha20 系列用小端序，与 Noise 规范一致
+func (s CipherSuite) nonce(n uint64) []byte {
+	size := chacha20poly1305.NonceSize
+	if s == CipherSuiteXChaCha20Poly1305 {
+		size = chacha20poly1305.NonceSizeX
+	}
+	nonce := make([]byte, size)
+	if s == CipherSuiteAES128GCM || s == CipherSuiteAES256GCM {
+		binary.BigEndian.PutUint64(nonce[size-8:], n)
+	} else {
+		binary.LittleEndian.PutUint64(nonce[size-8:], n)
+	}
+	return nonce
+}
+
+// ParseCipherSuite 按名称（不区分大小写）查找加密算法
+func ParseCipherSuite(name string) (CipherSuite, error) {
+	for suite, def := range cipherSuites {
+		if strings.EqualFold(def.name, name) {
+			return suite, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown cipher suite %q", name)
+}
+
+// ParseCipherSuites 解析按偏好排序的算法列表，为空时返回 DefaultCipherSuites
+func ParseCipherSuites(names []string) ([]CipherSuite, error) {
+	if len(names) == 0 {
+		return DefaultCipherSuites(), nil
+	}
+	suites := make([]CipherSuite, 0, len(names))
+	seen := make(map[CipherSuite]bool)
+	for _, name := range names {
+		suite, err := ParseCipherSuite(name)
+		if err != nil {
+			return nil, err
+		}
+		if seen[suite] {
+			return nil, fmt.Errorf("duplicate cipher suite %s", suite)
+		}
+		seen[suite] = true
+		suites = append(suites, suite)
+	}
+	return suites, nil
+}
+
+// DefaultCipherSuites 返回默认的偏好顺序：有 AES 硬件加速时 AES-GCM 优先，否则 ChaCha20 优先
+func DefaultCipherSuites() []CipherSuite {
+	if hasAESGCMHardware {
+		return []CipherSuite{CipherSuiteAES128GCM, CipherSuiteAES256GCM, CipherSuiteChaCha20Poly1305, CipherSuiteXChaCha20Poly1305}
+	}
+	return []CipherSuite{CipherSuiteChaCha20Poly1305, CipherSuiteXChaCha20Poly1305, CipherSuiteAES128GCM, CipherSuiteAES256GCM}
+}
+
+// NegotiateCipherSuite 按响应方 supported 的偏好顺序选择发起方也提供的第一个算法
+//
+// 由响应方决定顺序：服务器承载所有客户端的流量，按自己的 AES 硬件加速情况排序
+// （DefaultCipherSuites）最能节省资源。发起方提供的顺序只在服务器认可时起作用。
+func NegotiateCipherSuite(offered, supported []CipherSuite) (CipherSuite, bool) {
+	for _, suite := range supported {
+		for _, o := range offered {
+			if suite == o {
+				return suite, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// CipherSuiteNames 返回算法名称列表
+func CipherSuiteNames(suites []CipherSuite) []string {
+	names := make([]string, len(suites))
+	for i, suite := range suites {
+		names[i] = suite.String()
+	}
+	return names
+}
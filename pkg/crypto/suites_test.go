@@ -0,0 +1,74 @@
+package crypto
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNegotiateCipherSuite(t *testing.T) {
+	aes128, aes256 := CipherSuiteAES128GCM, CipherSuiteAES256GCM
+	chacha, xchacha := CipherSuiteChaCha20Poly1305, CipherSuiteXChaCha20Poly1305
+
+	tests := []struct {
+		name      string
+		offered   []CipherSuite
+		supported []CipherSuite
+		want      CipherSuite
+		ok        bool
+	}{
+		// 响应方的偏好优先，与发起方的顺序无关
+		{"responder order", []CipherSuite{chacha, aes256}, []CipherSuite{aes256, chacha}, aes256, true},
+		{"responder order reversed", []CipherSuite{aes256, chacha}, []CipherSuite{chacha, aes256}, chacha, true},
+		{"skips unoffered", []CipherSuite{xchacha, aes128}, []CipherSuite{aes256, chacha, aes128, xchacha}, aes128, true},
+		{"single offer", []CipherSuite{xchacha}, DefaultCipherSuites(), xchacha, true},
+		{"no overlap", []CipherSuite{aes128, aes256}, []CipherSuite{chacha, xchacha}, 0, false},
+		{"nothing offered", nil, DefaultCipherSuites(), 0, false},
+		{"nothing supported", DefaultCipherSuites(), nil, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := NegotiateCipherSuite(tt.offered, tt.supported)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: got %s, %v, expected %s, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDefaultCipherSuitesPreference(t *testing.T) {
+	defaults := DefaultCipherSuites()
+	if len(defaults) != len(cipherSuites) {
+		t.Errorf("Expected every suite to be allowed by default, got %v", defaults)
+	}
+
+	// 客户端提供全部算法时，服务器按自己是否有 AES 硬件加速选择
+	offered := []CipherSuite{CipherSuiteXChaCha20Poly1305, CipherSuiteChaCha20Poly1305, CipherSuiteAES256GCM, CipherSuiteAES128GCM}
+	want := CipherSuiteChaCha20Poly1305
+	if hasAESGCMHardware {
+		want = CipherSuiteAES128GCM
+	}
+	if got, _ := NegotiateCipherSuite(offered, defaults); got != want {
+		t.Errorf("Expected %s (AES hardware: %v), got %s", want, hasAESGCMHardware, got)
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	suites, err := ParseCipherSuites([]string{"chacha20-poly1305", "AES-256-GCM"})
+	if err != nil || !slices.Equal(suites, []CipherSuite{CipherSuiteChaCha20Poly1305, CipherSuiteAES256GCM}) {
+		t.Errorf("Expected names to be parsed in order ignoring case, got %v, %v", suites, err)
+	}
+	if suites, err := ParseCipherSuites(nil); err != nil || !slices.Equal(suites, DefaultCipherSuites()) {
+		t.Errorf("Expected the default suites, got %v, %v", suites, err)
+	}
+
+	for _, names := range [][]string{
+		{"AES-256-GCM", "RC4"},
+		{"AES-256-GCM", "aes-256-gcm"},
+		{""},
+	} {
+		if _, err := ParseCipherSuites(names); err == nil {
+			t.Errorf("Expected %q to be rejected", names)
+		}
+	}
+	if name := CipherSuite(99).String(); name != "CipherSuite(99)" {
+		t.Errorf("Expected an unknown suite to print its number, got %s", name)
+	}
+}
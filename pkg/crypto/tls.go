@@ -0,0 +1,13 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+)
+
+// SPKIPin 返回证书公钥指纹（SubjectPublicKeyInfo 的 SHA-256，Base64），用于客户端 pin_spki
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,122 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// TokenSize GenerateToken 生成的令牌的随机字节数
+const TokenSize = 32
+
+// 令牌哈希默认的 Argon2id 参数（OWASP 推荐的最低配置）
+//
+// 握手时每个未缓存的令牌都要验证一次，内存参数比 DeriveMasterKey 低；
+// GenerateToken 生成的令牌本身是高熵随机数，强度不依赖哈希的成本。
+const (
+	tokenHashTime    = 2
+	tokenHashMemory  = 19 * 1024 // KiB
+	tokenHashThreads = 1
+	tokenHashSalt    = 16
+	tokenHashSize    = 32
+)
+
+// 令牌哈希参数的上限，防止配置的哈希在验证时占用过多资源
+const (
+	maxTokenHashTime    = 16
+	maxTokenHashMemory  = 1024 * 1024 // KiB
+	maxTokenHashThreads = 16
+)
+
+// ErrInvalidTokenHash 令牌哈希格式错误
+var ErrInvalidTokenHash = errors.New("invalid token hash")
+
+// TokenHash 解析后的 Argon2id 令牌哈希
+//
+// 文本格式与 PHC 字符串格式相同：$argon2id$v=19$m=<KiB>,t=<次数>,p=<并行度>$<盐>$<哈希>，
+// 盐和哈希为不带填充的标准 Base64，可以由 argon2 命令行工具或其他库生成。
+type TokenHash struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	hash    []byte
+}
+
+// GenerateToken 生成随机令牌（32 字节，hex 编码）
+func GenerateToken() (string, error) {
+	token := make([]byte, TokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// HashToken 用随机盐和默认参数计算令牌的 Argon2id 哈希
+func HashToken(token string) (string, error) {
+	salt := make([]byte, tokenHashSalt)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	h := &TokenHash{
+		time:    tokenHashTime,
+		memory:  tokenHashMemory,
+		threads: tokenHashThreads,
+		salt:    salt,
+	}
+	h.hash = h.compute(token, tokenHashSize)
+	return h.String(), nil
+}
+
+// ParseTokenHash 解析令牌哈希
+func ParseTokenHash(s string) (*TokenHash, error) {
+	parts := strings.Split(strings.TrimSpace(s), "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return nil, fmt.Errorf("%w: expected $argon2id$v=19$m=...,t=...,p=...$salt$hash", ErrInvalidTokenHash)
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("%w: unsupported version %q", ErrInvalidTokenHash, parts[2])
+	}
+
+	h := &TokenHash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return nil, fmt.Errorf("%w: parameters %q", ErrInvalidTokenHash, parts[3])
+	}
+	if h.time == 0 || h.time > maxTokenHashTime ||
+		h.threads == 0 || h.threads > maxTokenHashThreads ||
+		h.memory < 8*uint32(h.threads) || h.memory > maxTokenHashMemory {
+		return nil, fmt.Errorf("%w: parameters %q out of range", ErrInvalidTokenHash, parts[3])
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(h.salt) < 8 {
+		return nil, fmt.Errorf("%w: salt", ErrInvalidTokenHash)
+	}
+	if h.hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.hash) < 16 {
+		return nil, fmt.Errorf("%w: hash", ErrInvalidTokenHash)
+	}
+	return h, nil
+}
+
+// Verify 判断令牌是否与哈希匹配，比较时间与内容无关
+func (h *TokenHash) Verify(token string) bool {
+	return subtle.ConstantTimeCompare(h.compute(token, uint32(len(h.hash))), h.hash) == 1
+}
+
+// String 返回 PHC 格式的哈希
+func (h *TokenHash) String() string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(h.salt), base64.RawStdEncoding.EncodeToString(h.hash))
+}
+
+func (h *TokenHash) compute(token string, length uint32) []byte {
+	return argon2.IDKey([]byte(token), h.salt, h.time, h.memory, h.threads, length)
+}
@@ -0,0 +1,51 @@
+package crypto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTokenHash(t *testing.T) {
+	encoded, err := HashToken("alice-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := ParseTokenHash(encoded)
+	if err != nil {
+		t.Fatalf("Failed to parse token hash: %v", err)
+	}
+	if !hash.Verify("alice-token") {
+		t.Error("Expected the token to match its hash")
+	}
+	if hash.Verify("other-token") || hash.Verify("") {
+		t.Error("Expected other tokens not to match")
+	}
+	if hash.String() != encoded {
+		t.Errorf("Expected %s, got %s", encoded, hash.String())
+	}
+
+	// 每个哈希使用随机盐，同一令牌的哈希不同，无法预先计算
+	again, _ := HashToken("alice-token")
+	if again == encoded {
+		t.Error("Expected hashes of the same token to use different salts")
+	}
+
+	// argon2 命令行工具等生成的其他参数的哈希也可以验证
+	other := "$argon2id$v=19$m=8,t=1,p=1$c2FsdHNhbHQ$9wfQB+7yfEWTtdn/aSzEq/dAw5DCuxDzN2k3fItQ5zs"
+	if hash, err := ParseTokenHash(other); err != nil || hash.Verify("alice-token") {
+		t.Errorf("Expected the hash to parse and not match, got %v", err)
+	}
+
+	invalid := map[string]string{
+		"not argon2id":    strings.Replace(encoded, "argon2id", "argon2i", 1),
+		"version":         strings.Replace(encoded, "v=19", "v=16", 1),
+		"too much memory": strings.Replace(encoded, "m=19456", "m=4194304", 1),
+		"no hash":         encoded[:strings.LastIndex(encoded, "$")] + "$",
+	}
+	for name, s := range invalid {
+		if _, err := ParseTokenHash(s); !errors.Is(err, ErrInvalidTokenHash) {
+			t.Errorf("%s: expected ErrInvalidTokenHash, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+// Package logging 基于 log/slog 的日志输出
+//
+// Setup 把 slog 的默认 Logger 和标准库 log 包都接到同一个 Handler 上。本项目的代码使用
+// slog.Debug/Info/Warn/Error 和键值对字段，依赖库通过 log 包输出的日志按 info 级别记录。
+//
+// 组件是调用方的 Go 包名，从记录的调用位置得出，不需要在调用处指定。字段名为 token、password
+// 等的值总被隐藏，config.Secret 类型的值只输出隐藏后的形式。
+package logging
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+)
+
+// redacted 被隐藏字段的输出值
+const redacted = "[redacted]"
+
+// secretKeys 始终隐藏的字段名，也匹配以 "_" 加这些名称结尾的字段（如 auth_token）
+var secretKeys = []string{"token", "password", "secret", "psk", "private_key", "authorization"}
+
+// Setup 按配置设置默认日志输出，返回打开的日志文件，未配置 log_file 时为 nil
+func Setup(cfg *config.LoggingConfig) (*RotatingFile, error) {
+	level, components, err := cfg.Levels()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		writers []io.Writer
+		file    *RotatingFile
+	)
+	if cfg.LogFile != "" {
+		maxSize, maxBackups, err := cfg.Rotation()
+		if err != nil {
+			return nil, err
+		}
+		if file, err = OpenRotatingFile(cfg.LogFile, maxSize, maxBackups); err != nil {
+			return nil, err
+		}
+		writers = append(writers, file)
+	}
+	if cfg.Console() {
+		writers = append(writers, os.Stderr)
+	}
+	var out io.Writer = os.Stderr
+	if len(writers) == 1 {
+		out = writers[0]
+	} else if len(writers) > 1 {
+		out = io.MultiWriter(writers...)
+	}
+
+	keys := make(map[string]bool)
+	for _, key := range append(secretKeys, cfg.RedactKeys...) {
+		keys[strings.ToLower(key)] = true
+	}
+	opts := &slog.HandlerOptions{
+		Level: slog.LevelDebug, // 级别由 handler 按组件判断
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if isSecretKey(keys, a.Key) && a.Value.Kind() != slog.KindGroup {
+				return slog.String(a.Key, redacted)
+			}
+			return a
+		},
+	}
+
+	var base slog.Handler
+	if cfg.Format == config.LogFormatJSON {
+		base = slog.NewJSONHandler(out, opts)
+	} else {
+		base = slog.NewTextHandler(out, opts)
+	}
+
+	h := &handler{
+		shared: &shared{
+			base:       base,
+			level:      level,
+			components: components,
+			min:        level,
+		},
+	}
+	for _, componentLevel := range components {
+		if componentLevel < h.shared.min {
+			h.shared.min = componentLevel
+		}
+	}
+	if cfg.Sampling.Initial > 0 {
+		interval, err := cfg.Sampling.SampleInterval()
+		if err != nil {
+			return nil, err
+		}
+		h.shared.sampler = &sampler{
+			initial:    uint64(cfg.Sampling.Initial),
+			thereafter: uint64(cfg.Sampling.Thereafter),
+			interval:   interval,
+			counts:     make(map[sampleKey]uint64),
+		}
+	}
+
+	// 标准库 log 记录调用位置，用于得出组件
+	log.SetFlags(log.Lshortfile)
+	slog.SetDefault(slog.New(h))
+	return file, nil
+}
+
+// isSecretKey 判断字段的值是否需要隐藏
+func isSecretKey(keys map[string]bool, key string) bool {
+	key = strings.ToLower(key)
+	if keys[key] {
+		return true
+	}
+	if i := strings.LastIndexByte(key, '_'); i >= 0 {
+		return keys[key[i+1:]]
+	}
+	return false
+}
+
+// shared 同一次 Setup 创建的 handler 共享的状态
+type shared struct {
+	base       slog.Handler
+	level      slog.Level            // 默认级别
+	components map[string]slog.Level // 按组件覆盖的级别
+	min        slog.Level            // 所有级别中最低的，用于 Enabled
+	sampler    *sampler              // 为 nil 时不采样
+	pcs        sync.Map              // 调用位置到组件名的缓存
+}
+
+// handler 按组件过滤、采样并在记录中加入 component 字段
+type handler struct {
+	shared *shared
+	ops    []func(slog.Handler) slog.Handler // WithAttrs 和 WithGroup，在 component 字段之后应用
+	cache  sync.Map                          // 组件名到下层 handler 的缓存
+}
+
+// Enabled 实现 slog.Handler，记录的组件在 Handle 中才能确定
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.shared.min
+}
+
+// Handle 实现 slog.Handler
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	component := h.shared.component(r.PC)
+	level := h.shared.level
+	if componentLevel, exists := h.shared.components[component]; exists {
+		level = componentLevel
+	}
+	if r.Level < level {
+		return nil
+	}
+	if h.shared.sampler != nil && r.Level < slog.LevelWarn && !h.shared.sampler.allow(component, r.Message, r.Time) {
+		return nil
+	}
+	return h.next(component).Handle(ctx, r)
+}
+
+// next 返回组件对应的下层 handler
+func (h *handler) next(component string) slog.Handler {
+	if cached, exists := h.cache.Load(component); exists {
+		return cached.(slog.Handler)
+	}
+	next := h.shared.base
+	if component != "" {
+		next = next.WithAttrs([]slog.Attr{slog.String("component", component)})
+	}
+	for _, op := range h.ops {
+		next = op(next)
+	}
+	h.cache.Store(component, next)
+	return next
+}
+
+// with 返回增加了一个操作的新 handler
+func (h *handler) with(op func(slog.Handler) slog.Handler) *handler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &handler{shared: h.shared, ops: append(ops, op)}
+}
+
+// WithAttrs 实现 slog.Handler
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+// WithGroup 实现 slog.Handler
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+// component 返回调用位置所在的包名，未知时为空
+func (s *shared) component(pc uintptr) string {
+	if pc == 0 {
+		return ""
+	}
+	if cached, exists := s.pcs.Load(pc); exists {
+		return cached.(string)
+	}
+
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	// 函数名形如 github.com/aethertunnel/aethertunnel/pkg/server.(*ProxyManager).serve
+	name := frame.Function
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	s.pcs.Store(pc, name)
+	return name
+}
+
+// sampleKey 采样计数的键
+type sampleKey struct {
+	component string
+	message   string
+}
+
+// sampler 按组件和消息计数，每个时间窗口重新计数
+type sampler struct {
+	initial    uint64
+	thereafter uint64
+	interval   time.Duration
+	counts     map[sampleKey]uint64
+	reset      time.Time // 当前窗口的结束时间
+	mu         sync.Mutex
+}
+
+// allow 判断是否输出这条消息
+func (s *sampler) allow(component, message string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !now.Before(s.reset) {
+		clear(s.counts)
+		s.reset = now.Add(s.interval)
+	}
+	key := sampleKey{component, message}
+	s.counts[key]++
+	n := s.counts[key]
+	if n <= s.initial {
+		return true
+	}
+	return s.thereafter > 0 && (n-s.initial)%s.thereafter == 0
+}
@@ -0,0 +1,325 @@
+package net
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ProxyDialer 经上游 HTTP 或 SOCKS5 代理建立 TCP 连接
+//
+// 代理地址为空或目标匹配 NO_PROXY 规则时直接连接。
+type ProxyDialer struct {
+	proxy   *url.URL
+	noProxy []string
+	direct  net.Dialer
+}
+
+// NewProxyDialer 创建代理拨号器
+//
+// proxyURL 支持 http://、socks5:// 和 socks5h://，用户名和密码写在 URL 中；
+// noProxy 为逗号分隔的主机、域名后缀（.corp.local）、IP 或 CIDR，"*" 表示全部直连。
+func NewProxyDialer(proxyURL, noProxy string, timeout time.Duration) (*ProxyDialer, error) {
+	d := &ProxyDialer{direct: net.Dialer{Timeout: timeout}}
+
+	if proxyURL != "" {
+		u, err := ParseProxyURL(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		d.proxy = u
+	}
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry != "" {
+			d.noProxy = append(d.noProxy, entry)
+		}
+	}
+
+	return d, nil
+}
+
+// ParseProxyURL 解析并检查上游代理地址
+func ParseProxyURL(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy url %s has no host", proxyURL)
+	}
+	if u.Port() == "" {
+		port := "1080"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	return u, nil
+}
+
+// Proxy 返回使用的代理地址，直连时返回 nil
+func (d *ProxyDialer) Proxy() *url.URL {
+	return d.proxy
+}
+
+// bypass 判断目标地址是否匹配 NO_PROXY 规则
+func (d *ProxyDialer) bypass(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+
+	for _, entry := range d.noProxy {
+		if entry == "*" {
+			return true
+		}
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		// 去掉规则中的端口
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.TrimPrefix(entry, "*")
+		if strings.HasPrefix(entry, ".") {
+			if strings.HasSuffix(host, entry) || host == entry[1:] {
+				return true
+			}
+			continue
+		}
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dial 建立连接
+func (d *ProxyDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext 建立连接，network 只支持 tcp
+func (d *ProxyDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if d.proxy == nil || d.bypass(address) {
+		return d.direct.DialContext(ctx, network, address)
+	}
+	if !strings.HasPrefix(network, "tcp") {
+		return nil, fmt.Errorf("proxy does not support network %s", network)
+	}
+
+	conn, err := d.direct.DialContext(ctx, "tcp", d.proxy.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %w", d.proxy.Host, err)
+	}
+
+	// 握手期间使用拨号超时
+	deadline, ok := ctx.Deadline()
+	if !ok && d.direct.Timeout > 0 {
+		deadline = time.Now().Add(d.direct.Timeout)
+	}
+	conn.SetDeadline(deadline)
+
+	if d.proxy.Scheme == "http" {
+		conn, err = httpConnect(conn, d.proxy, address)
+	} else {
+		err = socks5Connect(conn, d.proxy, address)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", d.proxy.Host, err)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// WebSocketDialer 返回经代理连接的 WebSocket 拨号器
+func (d *ProxyDialer) WebSocketDialer() *websocket.Dialer {
+	return &websocket.Dialer{
+		NetDialContext:   d.DialContext,
+		HandshakeTimeout: d.direct.Timeout,
+	}
+}
+
+// HTTPTransport 返回经代理连接的 HTTP Transport
+func (d *ProxyDialer) HTTPTransport() *http.Transport {
+	return &http.Transport{
+		DialContext:         d.DialContext,
+		TLSHandshakeTimeout: d.direct.Timeout,
+	}
+}
+
+// bufferedConn 保留握手时多读的数据
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// httpConnect 通过 HTTP CONNECT 建立隧道
+func httpConnect(conn net.Conn, proxy *url.URL, address string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := proxy.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT %s: %s", address, resp.Status)
+	}
+
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// socks5Connect 通过 SOCKS5 CONNECT 建立隧道，支持用户名密码认证（RFC 1929）
+func socks5Connect(conn net.Conn, proxy *url.URL, address string) error {
+	methods := []byte{0x00}
+	if proxy.User != nil {
+		methods = []byte{0x00, 0x02}
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return errors.New("invalid socks5 response")
+	}
+
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if proxy.User == nil {
+			return errors.New("socks5 proxy requires authentication")
+		}
+		username := proxy.User.Username()
+		password, _ := proxy.User.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return errors.New("socks5 credentials too long")
+		}
+
+		auth := []byte{0x01, byte(len(username))}
+		auth = append(auth, username...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply[:]); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("socks5 authentication failed")
+		}
+	default:
+		return errors.New("socks5 proxy rejected all authentication methods")
+	}
+
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return fmt.Errorf("invalid port in %s", address)
+	}
+
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(append(req, 0x01), ip4...)
+		} else {
+			req = append(append(req, 0x04), ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host too long: %s", host)
+		}
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// VER REP RSV ATYP
+	var header [4]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("socks5 connect to %s failed with code %d", address, header[1])
+	}
+
+	// 丢弃绑定地址
+	var skip int
+	switch header[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		var length [1]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return err
+		}
+		skip = int(length[0])
+	default:
+		return fmt.Errorf("invalid socks5 address type %d", header[3])
+	}
+	_, err = io.CopyN(io.Discard, conn, int64(skip+2))
+	return err
+}
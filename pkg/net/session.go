@@ -0,0 +1,542 @@
+package net
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// 帧类型
+const (
+	frameSYN    byte = 1 // 打开流，内容为打开参数
+	frameACK    byte = 2 // 接受打开请求
+	frameData   byte = 3 // 数据
+	frameWindow byte = 4 // 增加发送窗口，内容为 4 字节增量
+	frameFIN    byte = 5 // 关闭流
+	frameRST    byte = 6 // 拒绝或重置流，内容为原因
+)
+
+const (
+	frameHeaderSize = 5
+	// MaxFramePayload 单帧数据上限
+	MaxFramePayload = 32 * 1024
+	// streamWindow 每个流的接收窗口
+	streamWindow = 256 * 1024
+)
+
+var (
+	// ErrSessionClosed 会话已关闭
+	ErrSessionClosed = errors.New("session closed")
+	// ErrStreamClosed 流已关闭
+	ErrStreamClosed = errors.New("stream closed")
+	// ErrStreamReset 流被对端重置
+	ErrStreamReset = errors.New("stream reset by peer")
+)
+
+// StreamError 对端拒绝打开流
+type StreamError struct {
+	Reason string
+}
+
+func (e *StreamError) Error() string {
+	return "stream rejected: " + e.Reason
+}
+
+// AcceptFunc 处理对端打开的流，处理函数必须调用 Accept 或 Reject
+type AcceptFunc func(stream *Stream, meta []byte)
+
+// Session 在一条已有连接上复用多个双向流
+//
+// Session 本身不读写底层连接：发送的帧交给 send，收到的帧由调用方通过
+// HandleFrame 交给 Session，因此可以嵌入控制连接的消息协议中。
+// 每个流有独立的接收窗口，慢速的流不会阻塞整个会话。
+type Session struct {
+	send    func(frame []byte) error
+	accept  AcceptFunc
+	streams map[uint32]*Stream
+	nextID  uint32
+	closed  bool
+	mu      sync.Mutex
+}
+
+// NewSession 创建会话，客户端使用奇数流 ID，服务端使用偶数流 ID
+func NewSession(client bool, send func(frame []byte) error, accept AcceptFunc) *Session {
+	nextID := uint32(2)
+	if client {
+		nextID = 1
+	}
+
+	return &Session{
+		send:    send,
+		accept:  accept,
+		streams: make(map[uint32]*Stream),
+		nextID:  nextID,
+	}
+}
+
+// writeFrame 编码并发送一帧
+func (s *Session) writeFrame(id uint32, typ byte, payload []byte) error {
+	frame := make([]byte, frameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame, id)
+	frame[4] = typ
+	copy(frame[frameHeaderSize:], payload)
+	return s.send(frame)
+}
+
+// Open 打开新的流并等待对端接受
+func (s *Session) Open(meta []byte, timeout time.Duration) (*Stream, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrSessionClosed
+	}
+	id := s.nextID
+	s.nextID += 2
+	stream := newStream(id, s)
+	stream.established = make(chan error, 1)
+	stream.pending = true
+	s.streams[id] = stream
+	s.mu.Unlock()
+
+	if err := s.writeFrame(id, frameSYN, meta); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-stream.established:
+		if err != nil {
+			s.removeStream(id)
+			return nil, err
+		}
+		return stream, nil
+	case <-timer.C:
+		stream.Reset()
+		return nil, fmt.Errorf("open stream: %w", os.ErrDeadlineExceeded)
+	}
+}
+
+// HandleFrame 处理从底层连接收到的一帧，返回错误表示帧格式无效
+func (s *Session) HandleFrame(frame []byte) error {
+	if len(frame) < frameHeaderSize {
+		return fmt.Errorf("frame too short: %d bytes", len(frame))
+	}
+	id := binary.BigEndian.Uint32(frame)
+	typ := frame[4]
+	payload := frame[frameHeaderSize:]
+
+	if typ == frameSYN {
+		return s.handleSYN(id, payload)
+	}
+
+	s.mu.Lock()
+	stream, exists := s.streams[id]
+	s.mu.Unlock()
+	if !exists {
+		// 本地已关闭的流可能还会收到少量数据，直接丢弃
+		return nil
+	}
+
+	switch typ {
+	case frameACK:
+		stream.settle(nil)
+	case frameData:
+		if len(payload) > MaxFramePayload {
+			stream.Reset()
+			return fmt.Errorf("frame payload too large: %d bytes", len(payload))
+		}
+		stream.pushData(payload)
+	case frameWindow:
+		if len(payload) != 4 {
+			return fmt.Errorf("invalid window update")
+		}
+		stream.addCredit(int(binary.BigEndian.Uint32(payload)))
+	case frameFIN:
+		stream.remoteClose()
+	case frameRST:
+		if stream.settle(&StreamError{Reason: string(payload)}) {
+			return nil
+		}
+		stream.closeWithError(ErrStreamReset)
+		s.removeStream(id)
+	default:
+		return fmt.Errorf("unknown frame type %d", typ)
+	}
+	return nil
+}
+
+// handleSYN 处理对端打开流的请求
+func (s *Session) handleSYN(id uint32, meta []byte) error {
+	// 对端只能使用自己一侧的流 ID
+	if id%2 == s.nextID%2 {
+		return fmt.Errorf("invalid stream id %d", id)
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	if _, exists := s.streams[id]; exists {
+		s.mu.Unlock()
+		return fmt.Errorf("duplicate stream id %d", id)
+	}
+	if s.accept == nil {
+		s.mu.Unlock()
+		return s.writeFrame(id, frameRST, []byte("streams are not accepted"))
+	}
+	stream := newStream(id, s)
+	s.streams[id] = stream
+	s.mu.Unlock()
+
+	go s.accept(stream, append([]byte(nil), meta...))
+	return nil
+}
+
+// removeStream 从会话中移除流
+func (s *Session) removeStream(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+// NumStreams 返回活动的流数量
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.streams)
+}
+
+// Close 关闭会话和所有流，不会关闭底层连接
+func (s *Session) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	streams := s.streams
+	s.streams = make(map[uint32]*Stream)
+	s.mu.Unlock()
+
+	for _, stream := range streams {
+		stream.settle(ErrSessionClosed)
+		stream.closeWithError(ErrSessionClosed)
+	}
+}
+
+// Stream 会话中的一个双向流，实现 net.Conn
+type Stream struct {
+	id          uint32
+	session     *Session
+	established chan error // 仅本端打开的流使用
+	pending     bool       // 等待对端接受
+
+	buf           []byte
+	unacked       int // 已读取但尚未归还给对端的窗口
+	credit        int // 剩余发送窗口
+	remoteClosed  bool
+	localClosed   bool
+	err           error
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readCh        chan struct{}
+	writeCh       chan struct{}
+	mu            sync.Mutex
+}
+
+func newStream(id uint32, session *Session) *Stream {
+	return &Stream{
+		id:      id,
+		session: session,
+		credit:  streamWindow,
+		readCh:  make(chan struct{}, 1),
+		writeCh: make(chan struct{}, 1),
+	}
+}
+
+// notify 唤醒等待中的读写
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// wait 等待通知或超时
+func wait(ch chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-ch
+		return nil
+	}
+
+	d := time.Until(deadline)
+	if d <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ch:
+		return nil
+	case <-timer.C:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// ID 返回流 ID
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// Accept 接受对端打开的流
+func (st *Stream) Accept() error {
+	return st.session.writeFrame(st.id, frameACK, nil)
+}
+
+// Reject 拒绝对端打开的流
+func (st *Stream) Reject(reason string) error {
+	st.closeWithError(ErrStreamClosed)
+	st.session.removeStream(st.id)
+	return st.session.writeFrame(st.id, frameRST, []byte(reason))
+}
+
+// settle 结束本端打开流的等待，流不在等待中时返回 false
+func (st *Stream) settle(err error) bool {
+	st.mu.Lock()
+	pending := st.pending
+	st.pending = false
+	st.mu.Unlock()
+
+	if pending {
+		st.established <- err
+	}
+	return pending
+}
+
+// pushData 保存收到的数据
+func (st *Stream) pushData(data []byte) {
+	st.mu.Lock()
+	if st.localClosed || st.err != nil {
+		st.mu.Unlock()
+		return
+	}
+	if len(st.buf)+len(data) > streamWindow {
+		// 对端超出窗口发送
+		st.mu.Unlock()
+		st.Reset()
+		return
+	}
+	st.buf = append(st.buf, data...)
+	st.mu.Unlock()
+
+	notify(st.readCh)
+}
+
+// addCredit 增加发送窗口
+func (st *Stream) addCredit(n int) {
+	st.mu.Lock()
+	st.credit += n
+	st.mu.Unlock()
+
+	notify(st.writeCh)
+}
+
+// remoteClose 对端不再发送数据
+func (st *Stream) remoteClose() {
+	st.mu.Lock()
+	st.remoteClosed = true
+	done := st.localClosed
+	st.mu.Unlock()
+
+	notify(st.readCh)
+	notify(st.writeCh)
+	if done {
+		st.session.removeStream(st.id)
+	}
+}
+
+// closeWithError 以错误结束流，唤醒所有等待者
+func (st *Stream) closeWithError(err error) {
+	st.mu.Lock()
+	if st.err == nil {
+		st.err = err
+	}
+	st.mu.Unlock()
+
+	notify(st.readCh)
+	notify(st.writeCh)
+}
+
+// Read 读取数据
+func (st *Stream) Read(p []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		if len(st.buf) > 0 {
+			n := copy(p, st.buf)
+			st.buf = st.buf[n:]
+			st.unacked += n
+
+			var update int
+			if st.unacked >= streamWindow/2 {
+				update = st.unacked
+				st.unacked = 0
+			}
+			st.mu.Unlock()
+
+			if update > 0 {
+				var increment [4]byte
+				binary.BigEndian.PutUint32(increment[:], uint32(update))
+				st.session.writeFrame(st.id, frameWindow, increment[:])
+			}
+			return n, nil
+		}
+		if st.localClosed {
+			st.mu.Unlock()
+			return 0, ErrStreamClosed
+		}
+		if st.err != nil {
+			err := st.err
+			st.mu.Unlock()
+			return 0, err
+		}
+		if st.remoteClosed {
+			st.mu.Unlock()
+			return 0, io.EOF
+		}
+		deadline := st.readDeadline
+		st.mu.Unlock()
+
+		if err := wait(st.readCh, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write 写入数据，发送窗口用尽时阻塞
+func (st *Stream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		st.mu.Lock()
+		if st.localClosed {
+			st.mu.Unlock()
+			return written, ErrStreamClosed
+		}
+		if st.err != nil {
+			err := st.err
+			st.mu.Unlock()
+			return written, err
+		}
+		if st.remoteClosed {
+			st.mu.Unlock()
+			return written, io.ErrClosedPipe
+		}
+		if st.credit == 0 {
+			deadline := st.writeDeadline
+			st.mu.Unlock()
+			if err := wait(st.writeCh, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		n := len(p) - written
+		if n > st.credit {
+			n = st.credit
+		}
+		if n > MaxFramePayload {
+			n = MaxFramePayload
+		}
+		st.credit -= n
+		st.mu.Unlock()
+
+		if err := st.session.writeFrame(st.id, frameData, p[written:written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Close 关闭流
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.localClosed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.localClosed = true
+	st.buf = nil
+	failed := st.err != nil
+	done := st.remoteClosed || failed
+	st.mu.Unlock()
+
+	notify(st.readCh)
+	notify(st.writeCh)
+	if done {
+		st.session.removeStream(st.id)
+	}
+	if failed {
+		return nil
+	}
+	return st.session.writeFrame(st.id, frameFIN, nil)
+}
+
+// Reset 立即终止流并通知对端
+func (st *Stream) Reset() {
+	st.closeWithError(ErrStreamClosed)
+	st.session.removeStream(st.id)
+	st.session.writeFrame(st.id, frameRST, nil)
+}
+
+// streamAddr 流的地址
+type streamAddr uint32
+
+func (a streamAddr) Network() string { return "stream" }
+func (a streamAddr) String() string  { return fmt.Sprintf("stream-%d", uint32(a)) }
+
+// LocalAddr 获取本地地址
+func (st *Stream) LocalAddr() net.Addr {
+	return streamAddr(st.id)
+}
+
+// RemoteAddr 获取远程地址
+func (st *Stream) RemoteAddr() net.Addr {
+	return streamAddr(st.id)
+}
+
+// SetDeadline 设置读写超时
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	return st.SetWriteDeadline(t)
+}
+
+// SetReadDeadline 设置读超时
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline = t
+	st.mu.Unlock()
+
+	notify(st.readCh)
+	return nil
+}
+
+// SetWriteDeadline 设置写超时
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.writeDeadline = t
+	st.mu.Unlock()
+
+	notify(st.writeCh)
+	return nil
+}
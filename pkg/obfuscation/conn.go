@@ -0,0 +1,80 @@
+package obfuscation
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// maxPacketSize bounds a sealed packet on the wire, well above the largest
+// packet a session produces (64 KiB payload plus headers)
+const maxPacketSize = 128 << 10
+
+// Role is the side of a connection a PacketConn runs on. The HTTP framing
+// sends requests from the client and responses from the server.
+type Role uint8
+
+const (
+	RoleClient Role = iota
+	RoleServer
+)
+
+// PacketConn carries sealed packets, as produced by Session.ObfuscatePacket,
+// over a stream connection. The framing wraps the sealed packets, so what
+// goes on the wire is decided outside the encrypted envelope. WritePacket may
+// keep packet until it is sent.
+type PacketConn interface {
+	WritePacket(packet []byte) error
+	ReadPacket() ([]byte, error)
+	Close() error
+}
+
+// NewPacketConn returns a PacketConn that delimits packets with a 4-byte
+// length prefix
+func NewPacketConn(conn net.Conn) PacketConn {
+	return &streamConn{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// streamConn is a PacketConn with length-prefixed framing
+type streamConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mu     sync.Mutex // serializes writes
+}
+
+func (c *streamConn) WritePacket(packet []byte) error {
+	if len(packet) > maxPacketSize {
+		return fmt.Errorf("packet too large: %d bytes", len(packet))
+	}
+	frame := make([]byte, 4+len(packet))
+	binary.BigEndian.PutUint32(frame, uint32(len(packet)))
+	copy(frame[4:], packet)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *streamConn) ReadPacket() ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length > maxPacketSize {
+		return nil, fmt.Errorf("packet too large: %d bytes", length)
+	}
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, packet); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+func (c *streamConn) Close() error {
+	return c.conn.Close()
+}
@@ -2,16 +2,13 @@ package obfuscation
 
 import (
 	"bytes"
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/base64"
 	"encoding/binary"
 	"fmt"
 	"io"
-	"strings"
+	"log/slog"
 	"sync"
 	"time"
 
@@ -24,7 +21,7 @@ type Obfuscation struct {
 	obfuscators map[string]Obfuscator
 	macKey      []byte
 	cipherKey   []byte
-	seqNum      uint64
+	maxSkew     time.Duration // allowed clock difference for packet timestamps, 0 disables the check
 	mu          sync.RWMutex
 }
 
@@ -38,6 +35,7 @@ type Obfuscator interface {
 // ObfuscatedPacket represents an obfuscated packet
 type ObfuscatedPacket struct {
 	Type        uint8
+	SessionID   uint64
 	Sequence    uint64
 	Timestamp   uint64
 	Obfuscation string
@@ -45,12 +43,14 @@ type ObfuscatedPacket struct {
 	MAC         []byte
 }
 
-// NewObfuscation creates a new obfuscation manager
-func NewObfuscation(encryption *crypto.Encryption) *Obfuscation {
+// NewObfuscation creates a new obfuscation manager. Packets are exchanged
+// through sessions created with NewSession; maxSkew bounds the accepted
+// difference between a packet timestamp and the local clock.
+func NewObfuscation(encryption *crypto.Encryption, maxSkew time.Duration) *Obfuscation {
 	obf := &Obfuscation{
 		encryption:  encryption,
 		obfuscators: make(map[string]Obfuscator),
-		seqNum:      0,
+		maxSkew:     maxSkew,
 	}
 
 	// Generate keys
@@ -62,29 +62,26 @@ func NewObfuscation(encryption *crypto.Encryption) *Obfuscation {
 	return obf
 }
 
-// generateKeys generates keys for obfuscation
+// generateKeys derives the obfuscation keys from the encryption key
 func (o *Obfuscation) generateKeys() {
-	// Derive keys from encryption key
-	hash := sha256.New()
-	hash.Write([]byte("obfuscation-mac-key"))
-	hash.Write(o.encryption.Key())
-	o.macKey = hash.Sum(nil)
-
-	hash.Reset()
-	hash.Write([]byte("obfuscation-cipher-key"))
-	hash.Write(o.encryption.Key())
-	o.cipherKey = hash.Sum(nil)[:32]
+	o.macKey = o.encryption.DeriveKey(crypto.PurposeObfuscationMAC, 32)
+	o.cipherKey = o.encryption.DeriveKey(crypto.PurposeObfuscationCipher, 32)
 }
 
-// registerObfuscators registers all obfuscation methods
+// registerObfuscators creates an instance of every registered obfuscation method
 func (o *Obfuscation) registerObfuscators() {
-	// Register various obfuscation methods
-	o.register("none", &NoObfuscation{})
-	o.register("xor", &XORObfuscation{key: o.cipherKey[:16]})
-	o.register("aes", &AESObfuscation{cipherKey: o.cipherKey})
-	o.register("chacha", &ChaChaObfuscation{key: o.cipherKey})
-	o.register("stego", &StegoObfuscation{key: o.macKey})
-	o.register("morph", &MorphObfuscation{key: o.cipherKey})
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	keys := Keys{Cipher: o.cipherKey, MAC: o.macKey}
+	for name, factory := range registry {
+		obfuscator, err := factory(keys)
+		if err != nil {
+			slog.Error("Obfuscator is unavailable", "obfuscator", name, "err", err)
+			continue
+		}
+		o.register(name, obfuscator)
+	}
 }
 
 // register registers an obfuscator
@@ -94,13 +91,12 @@ func (o *Obfuscation) register(name string, obfuscator Obfuscator) {
 	o.obfuscators[name] = obfuscator
 }
 
-// ObfuscatePacket obfuscates a packet
-func (o *Obfuscation) ObfuscatePacket(data []byte, obfuscationType string) (*ObfuscatedPacket, error) {
-	o.mu.Lock()
-	defer o.mu.Unlock()
-
+// obfuscatePacket obfuscates data as packet seq of the given session
+func (o *Obfuscation) obfuscatePacket(data []byte, obfuscationType string, sessionID, seq uint64) ([]byte, error) {
 	// Get obfuscator
+	o.mu.RLock()
 	obfuscator, exists := o.obfuscators[obfuscationType]
+	o.mu.RUnlock()
 	if !exists {
 		return nil, fmt.Errorf("unknown obfuscation type: %s", obfuscationType)
 	}
@@ -114,15 +110,13 @@ func (o *Obfuscation) ObfuscatePacket(data []byte, obfuscationType string) (*Obf
 	// Create packet
 	packet := &ObfuscatedPacket{
 		Type:        1, // Data packet
-		Sequence:    o.seqNum,
+		SessionID:   sessionID,
+		Sequence:    seq,
 		Timestamp:   uint64(time.Now().UnixNano() / 1000000),
 		Obfuscation: obfuscationType,
 		Payload:     obfuscatedPayload,
 	}
 
-	// Increment sequence number
-	o.seqNum++
-
 	// Calculate MAC
 	mac, err := o.calculateMAC(packet)
 	if err != nil {
@@ -130,31 +124,24 @@ func (o *Obfuscation) ObfuscatePacket(data []byte, obfuscationType string) (*Obf
 	}
 	packet.MAC = mac
 
-	// Encrypt the entire packet
-	encryptedPacket, err := o.encryption.Encrypt(marshalPacket(packet))
+	// Encrypt the entire packet behind the obfuscation type byte, which is authenticated as well
+	typeByte := []byte{o.ObfuscationTypeByte(obfuscationType)}
+	finalPayload, err := o.encryption.Seal(typeByte, marshalPacket(packet), typeByte)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encrypt packet: %v", err)
 	}
 
-	// Create final obfuscated payload
-	finalPayload := make([]byte, 1+len(encryptedPacket))
-	finalPayload[0] = o.ObfuscationTypeByte(obfuscationType)
-	copy(finalPayload[1:], encryptedPacket)
-
-	return packet, nil
+	return finalPayload, nil
 }
 
-// DeobfuscatePacket deobfuscates a packet
-func (o *Obfuscation) DeobfuscatePacket(data []byte) (*ObfuscatedPacket, error) {
+// openPacket decrypts a packet and verifies its MAC
+func (o *Obfuscation) openPacket(data []byte) (*ObfuscatedPacket, error) {
 	if len(data) < 2 {
 		return nil, fmt.Errorf("packet too short")
 	}
 
-	// Extract encrypted data
-	encryptedData := data[1:]
-
 	// Decrypt the packet
-	decryptedData, err := o.encryption.Decrypt(base64.StdEncoding.EncodeToString(encryptedData))
+	decryptedData, err := o.encryption.Open(nil, data[1:], data[:1])
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt packet: %v", err)
 	}
@@ -170,8 +157,15 @@ func (o *Obfuscation) DeobfuscatePacket(data []byte) (*ObfuscatedPacket, error)
 		return nil, fmt.Errorf("MAC verification failed")
 	}
 
+	return packet, nil
+}
+
+// deobfuscatePayload restores the payload of an authenticated packet
+func (o *Obfuscation) deobfuscatePayload(packet *ObfuscatedPacket) (*ObfuscatedPacket, error) {
 	// Get obfuscator
+	o.mu.RLock()
 	obfuscator, exists := o.obfuscators[packet.Obfuscation]
+	o.mu.RUnlock()
 	if !exists {
 		return nil, fmt.Errorf("unknown obfuscation type: %s", packet.Obfuscation)
 	}
@@ -193,6 +187,7 @@ func (o *Obfuscation) calculateMAC(packet *ObfuscatedPacket) ([]byte, error) {
 
 	// Write all fields except MAC
 	h.Write([]byte{packet.Type})
+	binary.Write(h, binary.BigEndian, packet.SessionID)
 	binary.Write(h, binary.BigEndian, packet.Sequence)
 	binary.Write(h, binary.BigEndian, packet.Timestamp)
 	h.Write([]byte(packet.Obfuscation))
@@ -213,18 +208,24 @@ func (o *Obfuscation) verifyMAC(packet *ObfuscatedPacket) bool {
 
 // marshalPacket marshals a packet to bytes
 func marshalPacket(packet *ObfuscatedPacket) []byte {
-	data := make([]byte, 1+8+8+len(packet.Obfuscation)+2+len(packet.Payload)+32)
+	data := make([]byte, 1+8+8+8+1+len(packet.Obfuscation)+2+len(packet.Payload)+32)
 
 	offset := 0
 	data[offset] = packet.Type
 	offset++
 
+	binary.BigEndian.PutUint64(data[offset:], packet.SessionID)
+	offset += 8
+
 	binary.BigEndian.PutUint64(data[offset:], packet.Sequence)
 	offset += 8
 
 	binary.BigEndian.PutUint64(data[offset:], packet.Timestamp)
 	offset += 8
 
+	// Obfuscation type is prefixed with its length
+	data[offset] = byte(len(packet.Obfuscation))
+	offset++
 	copy(data[offset:], []byte(packet.Obfuscation))
 	offset += len(packet.Obfuscation)
 
@@ -241,7 +242,7 @@ func marshalPacket(packet *ObfuscatedPacket) []byte {
 
 // unmarshalPacket unmarshals bytes to packet
 func unmarshalPacket(data []byte) (*ObfuscatedPacket, error) {
-	if len(data) < 1+8+8+1+2+32 {
+	if len(data) < 1+8+8+8+1+2+32 {
 		return nil, fmt.Errorf("packet too short")
 	}
 
@@ -251,6 +252,9 @@ func unmarshalPacket(data []byte) (*ObfuscatedPacket, error) {
 	packet.Type = data[offset]
 	offset++
 
+	packet.SessionID = binary.BigEndian.Uint64(data[offset:])
+	offset += 8
+
 	packet.Sequence = binary.BigEndian.Uint64(data[offset:])
 	offset += 8
 
@@ -268,6 +272,7 @@ func unmarshalPacket(data []byte) (*ObfuscatedPacket, error) {
 		offset += obfTypeLen
 	} else {
 		packet.Obfuscation = "none"
+		offset++
 	}
 
 	// Read payload
@@ -303,12 +308,12 @@ func (o *Obfuscation) ObfuscationTypeByte(obfuscation string) byte {
 		return 1
 	case "aes":
 		return 2
-	case "chacha":
-		return 3
-	case "stego":
-		return 4
+	// 3 was "chacha", which did not encrypt and is replaced by "xchacha"
+	// 4 was "stego", which is now the HTTP framing of NewHTTPPacketConn
 	case "morph":
 		return 5
+	case "xchacha":
+		return 6
 	default:
 		return 0xFF
 	}
@@ -350,16 +355,14 @@ func (o *Obfuscation) GetConnectionType(data []byte) string {
 // AdaptiveObfuscation selects the best obfuscation method based on connection type
 func (o *Obfuscation) AdaptiveObfuscation(connType string) string {
 	switch connType {
-	case "http":
-		return "stego"
-	case "https":
+	case "http", "https":
 		return "morph"
 	case "ssh":
 		return "xor"
 	case "vpn":
 		return "aes"
 	default:
-		return "chacha"
+		return "xchacha"
 	}
 }
 
@@ -409,96 +412,65 @@ type AESObfuscation struct {
 }
 
 func (a *AESObfuscation) Obfuscate(data []byte) ([]byte, error) {
-	block, err := aes.NewCipher(a.cipherKey)
-	if err != nil {
-		return nil, err
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, err
-	}
-
-	ciphertext := gcm.Seal(nonce, nonce, data, nil)
-	return ciphertext, nil
+	return sealAEAD(crypto.CipherSuiteAES256GCM, a.cipherKey, data)
 }
 
 func (a *AESObfuscation) Deobfuscate(data []byte) ([]byte, error) {
-	block, err := aes.NewCipher(a.cipherKey)
-	if err != nil {
-		return nil, err
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-
-	nonceSize := gcm.NonceSize()
-	if len(data) < nonceSize {
-		return nil, fmt.Errorf("ciphertext too short")
-	}
-
-	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
-	return gcm.Open(nil, nonce, ciphertext, nil)
+	return openAEAD(crypto.CipherSuiteAES256GCM, a.cipherKey, data)
 }
 
 func (a *AESObfuscation) GetType() string {
 	return "aes"
 }
 
-// ChaChaObfuscation performs ChaCha-based obfuscation
+// ChaChaObfuscation encrypts packets with XChaCha20-Poly1305. It is
+// registered as "xchacha": the old "chacha" type sent packets unchanged, and
+// the new name keeps old and new peers from silently misreading each other.
 type ChaChaObfuscation struct {
 	key []byte
 }
 
 func (c *ChaChaObfuscation) Obfuscate(data []byte) ([]byte, error) {
-	// Simplified ChaCha implementation
-	// In production, use golang.org/x/crypto/chacha20poly1305
-	return data, nil
+	return sealAEAD(crypto.CipherSuiteXChaCha20Poly1305, c.key, data)
 }
 
 func (c *ChaChaObfuscation) Deobfuscate(data []byte) ([]byte, error) {
-	return c.Obfuscate(data)
+	return openAEAD(crypto.CipherSuiteXChaCha20Poly1305, c.key, data)
 }
 
 func (c *ChaChaObfuscation) GetType() string {
-	return "chacha"
+	return "xchacha"
 }
 
-// StegoObfuscation performs steganographic obfuscation
-type StegoObfuscation struct {
-	key []byte
-}
+// sealAEAD encrypts data with a random nonce prepended to the ciphertext
+func sealAEAD(suite crypto.CipherSuite, key, data []byte) ([]byte, error) {
+	aead, err := suite.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
 
-func (s *StegoObfuscation) Obfuscate(data []byte) ([]byte, error) {
-	headers := "GET / HTTP/1.1\r\nHost: example.com\r\n"
-	encodedData := base64.StdEncoding.EncodeToString(data)
-	payload := []byte(headers + "\r\n" + encodedData)
-	return payload, nil
+	return aead.Seal(nonce, nonce, data, nil), nil
 }
 
-func (s *StegoObfuscation) Deobfuscate(data []byte) ([]byte, error) {
-	dataStr := string(data)
-	if strings.Contains(dataStr, "\r\n\r\n") {
-		parts := strings.Split(dataStr, "\r\n\r\n")
-		if len(parts) >= 2 {
-			decoded, err := base64.StdEncoding.DecodeString(parts[1])
-			if err == nil {
-				return decoded, nil
-			}
-		}
+// openAEAD decrypts data produced by sealAEAD
+func openAEAD(suite crypto.CipherSuite, key, data []byte) ([]byte, error) {
+	aead, err := suite.New(key)
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("no hidden data found")
-}
 
-func (s *StegoObfuscation) GetType() string {
-	return "stego"
+	nonceSize := aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+	return aead.Open(nil, nonce, ciphertext, nil)
 }
 
 // MorphObfuscation performs traffic morphing
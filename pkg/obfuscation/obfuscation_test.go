@@ -0,0 +1,47 @@
+package obfuscation
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/crypto"
+)
+
+func TestChaChaObfuscation(t *testing.T) {
+	c := &ChaChaObfuscation{key: bytes.Repeat([]byte{7}, 32)}
+	plaintext := []byte("payload that must not appear on the wire")
+
+	sealed, err := c.Obfuscate(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, plaintext) {
+		t.Error("Expected the payload to be encrypted")
+	}
+	if got, err := c.Deobfuscate(sealed); err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("Round trip failed: %q, %v", got, err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := c.Deobfuscate(sealed); err == nil {
+		t.Error("Expected a tampered payload to be rejected")
+	}
+
+	// The old "chacha" type sent payloads unchanged; it is not accepted under the new name
+	obf := NewObfuscation(crypto.NewEncryption("test-token"), time.Minute)
+	if !obf.Has("xchacha") || obf.Has("chacha") {
+		t.Errorf("Expected xchacha to replace chacha, got %v", obf.Types())
+	}
+	sender, _ := obf.NewSession()
+	receiver, _ := obf.NewSession()
+	packet, err := sender.ObfuscatePacket(plaintext, "xchacha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := receiver.DeobfuscatePacket(packet); err != nil || !bytes.Equal(got.Payload, plaintext) {
+		t.Errorf("Expected the xchacha packet to round trip, got %v", err)
+	}
+	if _, err := sender.ObfuscatePacket(plaintext, "chacha"); err == nil {
+		t.Error("Expected the chacha type to be unknown")
+	}
+}
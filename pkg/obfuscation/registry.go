@@ -0,0 +1,194 @@
+package obfuscation
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// maxTypeNameLen bounds obfuscator and pipeline names, which travel in every
+// packet behind a one-byte length prefix
+const maxTypeNameLen = 64
+
+// Keys are the per-instance keys an obfuscator may use. Both are derived from
+// the shared auth token, so the two ends of a tunnel build identical
+// obfuscators. Factories must not modify them.
+type Keys struct {
+	Cipher []byte // 32 bytes
+	MAC    []byte // 32 bytes
+}
+
+// Factory creates an obfuscator for one Obfuscation instance
+type Factory func(keys Keys) (Obfuscator, error)
+
+var (
+	registry   = make(map[string]Factory)
+	registryMu sync.RWMutex
+)
+
+func init() {
+	MustRegister("none", func(Keys) (Obfuscator, error) { return &NoObfuscation{}, nil })
+	MustRegister("xor", func(k Keys) (Obfuscator, error) { return &XORObfuscation{key: k.Cipher[:16]}, nil })
+	MustRegister("aes", func(k Keys) (Obfuscator, error) { return &AESObfuscation{cipherKey: k.Cipher}, nil })
+	MustRegister("xchacha", func(k Keys) (Obfuscator, error) { return &ChaChaObfuscation{key: k.Cipher}, nil })
+	MustRegister("morph", func(k Keys) (Obfuscator, error) { return &MorphObfuscation{key: k.Cipher}, nil })
+}
+
+// Register adds an obfuscator under name. Call it from an init function:
+// instances created by NewObfuscation only see obfuscators registered before
+// them. Both ends of a tunnel must register the same obfuscators.
+func Register(name string, factory Factory) error {
+	if err := validateTypeName(name); err != nil {
+		return err
+	}
+	if factory == nil {
+		return fmt.Errorf("obfuscator %s: factory is nil", name)
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[name]; exists {
+		return fmt.Errorf("obfuscator %s is already registered", name)
+	}
+	registry[name] = factory
+	return nil
+}
+
+// MustRegister is like Register but panics on error
+func MustRegister(name string, factory Factory) {
+	if err := Register(name, factory); err != nil {
+		panic(err)
+	}
+}
+
+// Registered returns the names of all registered obfuscators, sorted
+func Registered() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateTypeName checks an obfuscator or pipeline name
+func validateTypeName(name string) error {
+	if name == "" {
+		return fmt.Errorf("obfuscator name cannot be empty")
+	}
+	if len(name) > maxTypeNameLen {
+		return fmt.Errorf("obfuscator name %q is longer than %d bytes", name, maxTypeNameLen)
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("obfuscator name %q may only contain a-z, 0-9, '-' and '_'", name)
+		}
+	}
+	return nil
+}
+
+// Pipeline chains several obfuscators. Obfuscate runs the stages in order and
+// Deobfuscate undoes them in exactly the reverse order.
+type Pipeline struct {
+	name   string
+	stages []Obfuscator
+}
+
+// NewPipeline creates a pipeline of the given stages
+func NewPipeline(name string, stages ...Obfuscator) (*Pipeline, error) {
+	if err := validateTypeName(name); err != nil {
+		return nil, err
+	}
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("pipeline %s has no stages", name)
+	}
+	return &Pipeline{name: name, stages: stages}, nil
+}
+
+func (p *Pipeline) Obfuscate(data []byte) ([]byte, error) {
+	for _, stage := range p.stages {
+		var err error
+		if data, err = stage.Obfuscate(data); err != nil {
+			return nil, fmt.Errorf("pipeline %s, stage %s: %v", p.name, stage.GetType(), err)
+		}
+	}
+	return data, nil
+}
+
+func (p *Pipeline) Deobfuscate(data []byte) ([]byte, error) {
+	for i := len(p.stages) - 1; i >= 0; i-- {
+		var err error
+		if data, err = p.stages[i].Deobfuscate(data); err != nil {
+			return nil, fmt.Errorf("pipeline %s, stage %s: %v", p.name, p.stages[i].GetType(), err)
+		}
+	}
+	return data, nil
+}
+
+func (p *Pipeline) GetType() string {
+	return p.name
+}
+
+// Stages returns the names of the pipeline stages in send order
+func (p *Pipeline) Stages() []string {
+	names := make([]string, len(p.stages))
+	for i, stage := range p.stages {
+		names[i] = stage.GetType()
+	}
+	return names
+}
+
+// AddPipeline registers a pipeline of registered obfuscators with this
+// instance. The pipeline is selected by name like any other obfuscation type.
+// Stages cannot be pipelines themselves.
+func (o *Obfuscation) AddPipeline(name string, stages []string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, exists := o.obfuscators[name]; exists {
+		return fmt.Errorf("pipeline %s: name is already used by an obfuscator", name)
+	}
+	obfuscators := make([]Obfuscator, len(stages))
+	for i, stage := range stages {
+		obfuscator, exists := o.obfuscators[stage]
+		if !exists {
+			return fmt.Errorf("pipeline %s: unknown obfuscator %s", name, stage)
+		}
+		if _, nested := obfuscator.(*Pipeline); nested {
+			return fmt.Errorf("pipeline %s: stage %s is a pipeline", name, stage)
+		}
+		obfuscators[i] = obfuscator
+	}
+
+	pipeline, err := NewPipeline(name, obfuscators...)
+	if err != nil {
+		return err
+	}
+	o.obfuscators[name] = pipeline
+	return nil
+}
+
+// Has reports whether an obfuscation type or pipeline is available on this instance
+func (o *Obfuscation) Has(name string) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	_, exists := o.obfuscators[name]
+	return exists
+}
+
+// Types returns the obfuscation types available on this instance, including
+// pipelines, sorted
+func (o *Obfuscation) Types() []string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	names := make([]string, 0, len(o.obfuscators))
+	for name := range o.obfuscators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
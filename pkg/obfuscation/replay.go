@@ -0,0 +1,135 @@
+package obfuscation
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Replay protection errors, callers count them separately from other failures
+var (
+	ErrReplayedPacket = errors.New("replayed packet")
+	ErrStalePacket    = errors.New("packet timestamp outside the allowed skew")
+)
+
+// Replay window geometry: a ring of 64-bit blocks as in RFC 6479. One block is
+// kept free so the window can slide without clearing bits that are still valid.
+const (
+	replayBlockBits  = 64
+	replayRingBlocks = 128
+	// ReplayWindowSize is how far behind the newest sequence number a packet may arrive
+	ReplayWindowSize = (replayRingBlocks - 1) * replayBlockBits
+)
+
+// ReplayWindow is a sliding-window replay filter over packet sequence numbers
+type ReplayWindow struct {
+	last uint64
+	ring [replayRingBlocks]uint64
+}
+
+// Check reports whether seq has not been seen and is inside the window, and
+// marks it as seen. Only call it for packets that passed authentication.
+func (w *ReplayWindow) Check(seq uint64) bool {
+	block := seq / replayBlockBits
+	if seq > w.last {
+		current := w.last / replayBlockBits
+		diff := block - current
+		if diff > replayRingBlocks {
+			diff = replayRingBlocks
+		}
+		for i := current + 1; i <= current+diff; i++ {
+			w.ring[i%replayRingBlocks] = 0
+		}
+		w.last = seq
+	} else if w.last-seq > ReplayWindowSize {
+		return false
+	}
+
+	index := block % replayRingBlocks
+	bit := uint64(1) << (seq % replayBlockBits)
+	if w.ring[index]&bit != 0 {
+		return false
+	}
+	w.ring[index] |= bit
+	return true
+}
+
+// Session holds the per-peer packet state: the outgoing sequence number and
+// the replay window for incoming packets. Each connection uses its own session.
+type Session struct {
+	obf *Obfuscation
+
+	id      uint64 // random session identifier carried in outgoing packets
+	sendSeq uint64
+
+	peerID    uint64 // session identifier of the peer, bound on the first valid packet
+	peerBound bool
+	replay    ReplayWindow
+
+	mu sync.Mutex
+}
+
+// NewSession creates a session with a random identifier
+func (o *Obfuscation) NewSession() (*Session, error) {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	return &Session{obf: o, id: binary.BigEndian.Uint64(id[:])}, nil
+}
+
+// ObfuscatePacket obfuscates data and returns the packet ready to send
+func (s *Session) ObfuscatePacket(data []byte, obfuscationType string) ([]byte, error) {
+	s.mu.Lock()
+	seq := s.sendSeq
+	s.sendSeq++
+	s.mu.Unlock()
+
+	return s.obf.obfuscatePacket(data, obfuscationType, s.id, seq)
+}
+
+// DeobfuscatePacket authenticates and deobfuscates a received packet, rejecting
+// packets that are replayed, belong to another session or are too old
+func (s *Session) DeobfuscatePacket(data []byte) (*ObfuscatedPacket, error) {
+	packet, err := s.obf.openPacket(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.obf.checkTimestamp(packet.Timestamp); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.peerBound && packet.SessionID != s.peerID {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: packet belongs to session %016x", ErrReplayedPacket, packet.SessionID)
+	}
+	if !s.replay.Check(packet.Sequence) {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: sequence %d", ErrReplayedPacket, packet.Sequence)
+	}
+	s.peerID = packet.SessionID
+	s.peerBound = true
+	s.mu.Unlock()
+
+	return s.obf.deobfuscatePayload(packet)
+}
+
+// checkTimestamp rejects packets whose timestamp differs from the local clock by more than maxSkew
+func (o *Obfuscation) checkTimestamp(timestamp uint64) error {
+	if o.maxSkew <= 0 {
+		return nil
+	}
+	skew := time.Since(time.UnixMilli(int64(timestamp)))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > o.maxSkew {
+		return fmt.Errorf("%w: off by %s", ErrStalePacket, skew.Round(time.Millisecond))
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package obfuscation
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/crypto"
+)
+
+func TestReplayWindow(t *testing.T) {
+	const ring = replayRingBlocks * replayBlockBits
+
+	tests := []struct {
+		name string
+		seqs []uint64
+		want []bool
+	}{
+		{"in order", []uint64{0, 1, 2, 3}, []bool{true, true, true, true}},
+		{"duplicate", []uint64{0, 1, 1, 0}, []bool{true, true, false, false}},
+		{"reordered inside window", []uint64{10, 5, 9, 5}, []bool{true, true, true, false}},
+		{"oldest in window", []uint64{ReplayWindowSize, 0, 0}, []bool{true, true, false}},
+		{"older than window", []uint64{ReplayWindowSize + 1, 0, 1}, []bool{true, false, true}},
+		{"jump larger than ring", []uint64{1, 2, 5 * ring, 1, 2, 5 * ring}, []bool{true, true, true, false, false, false}},
+		{"jump larger than ring clears old bits", []uint64{ring - 1, 5 * ring, 5*ring - 1}, []bool{true, true, true}},
+		{"block boundary", []uint64{63, 64, 63, 64, 65}, []bool{true, true, false, false, true}},
+	}
+	for _, tt := range tests {
+		var w ReplayWindow
+		for i, seq := range tt.seqs {
+			if got := w.Check(seq); got != tt.want[i] {
+				t.Errorf("%s: Check(%d) = %v, expected %v", tt.name, seq, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestSessionBindsToPeer(t *testing.T) {
+	obf := NewObfuscation(crypto.NewEncryption("test-token"), time.Minute)
+	alice, _ := obf.NewSession()
+	mallory, _ := obf.NewSession()
+	receiver, _ := obf.NewSession()
+
+	first, _ := alice.ObfuscatePacket([]byte("one"), "xor")
+	second, _ := alice.ObfuscatePacket([]byte("two"), "xor")
+	if _, err := receiver.DeobfuscatePacket(first); err != nil {
+		t.Fatalf("Failed to deobfuscate first packet: %v", err)
+	}
+	if _, err := receiver.DeobfuscatePacket(first); !errors.Is(err, ErrReplayedPacket) {
+		t.Errorf("Expected replayed packet to be rejected, got %v", err)
+	}
+
+	// Another session's packets are rejected even with a fresh sequence number
+	other, _ := mallory.ObfuscatePacket([]byte("other"), "xor")
+	if _, err := receiver.DeobfuscatePacket(other); !errors.Is(err, ErrReplayedPacket) {
+		t.Errorf("Expected packet of another session to be rejected, got %v", err)
+	}
+
+	packet, err := receiver.DeobfuscatePacket(second)
+	if err != nil || string(packet.Payload) != "two" {
+		t.Errorf("Expected second packet to be accepted, got %v", err)
+	}
+
+	// A packet from a session of another key fails authentication
+	foreign, _ := NewObfuscation(crypto.NewEncryption("other-token"), time.Minute).NewSession()
+	forged, _ := foreign.ObfuscatePacket([]byte("forged"), "xor")
+	if _, err := receiver.DeobfuscatePacket(forged); err == nil || errors.Is(err, ErrReplayedPacket) {
+		t.Errorf("Expected packet under another key to fail authentication, got %v", err)
+	}
+}
+
+func TestSessionRejectsStalePackets(t *testing.T) {
+	obf := NewObfuscation(crypto.NewEncryption("test-token"), time.Second)
+	sender, _ := obf.NewSession()
+	receiver, _ := obf.NewSession()
+
+	packet, _ := sender.ObfuscatePacket([]byte("fresh"), "none")
+	if _, err := receiver.DeobfuscatePacket(packet); err != nil {
+		t.Fatalf("Expected fresh packet to be accepted, got %v", err)
+	}
+
+	obf.maxSkew = time.Nanosecond
+	packet, _ = sender.ObfuscatePacket([]byte("late"), "none")
+	time.Sleep(time.Millisecond)
+	if _, err := receiver.DeobfuscatePacket(packet); !errors.Is(err, ErrStalePacket) {
+		t.Errorf("Expected stale packet to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,481 @@
+package obfuscation
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Camouflage vocabulary. A connection picks its host, user agent and cookies
+// once, like a browser talking to one site; paths, queries and body framing
+// change with every message.
+var (
+	stegoHosts = []string{
+		"cdn.jsdelivr.net", "static.cloudflareinsights.com", "api.segment.io",
+		"www.google-analytics.com", "events.statsigapi.net", "browser-intake-datadoghq.com",
+	}
+	stegoUserAgents = []string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.6 Safari/605.1.15",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:133.0) Gecko/20100101 Firefox/133.0",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:133.0) Gecko/20100101 Firefox/133.0",
+	}
+	stegoPaths = []string{
+		"/api/v1/events", "/api/v2/track", "/collect", "/v1/batch", "/upload",
+		"/cdn-cgi/rum", "/graphql", "/sync", "/api/telemetry", "/beacon",
+	}
+	stegoContentTypes = []string{
+		"application/octet-stream", "application/x-protobuf", "image/webp", "application/grpc-web+proto",
+	}
+	stegoServers = []string{"nginx", "cloudflare", "openresty", "Apache"}
+)
+
+const (
+	// stegoChunkSize bounds the size of each chunk of a chunked body
+	stegoChunkSize = 4096
+	// stegoMaxBatch bounds the packets carried in one message body, a larger
+	// packet still travels alone
+	stegoMaxBatch = 256 << 10
+	// stegoMaxBody bounds the body of a received message
+	stegoMaxBody = 1 << 20
+	// stegoMaxQueue bounds the packets waiting for the next message
+	stegoMaxQueue = 256
+	// stegoPollMin and stegoPollMax bound how often an idle client asks the
+	// server for packets
+	stegoPollMin = 50 * time.Millisecond
+	stegoPollMax = 2 * time.Second
+)
+
+var errQueueFull = errors.New("packet queue is full")
+
+// NewHTTPPacketConn returns a PacketConn that disguises the connection as
+// HTTP/1.1 traffic. The client sends POST requests and the server answers each
+// one with a response; the sealed packets are the binary message bodies,
+// framed with Content-Length or chunked encoding, so they survive
+// standards-compliant proxies that re-frame bodies or rewrite headers.
+//
+// A server only speaks when asked, so an idle client polls with empty requests,
+// backing off from stegoPollMin to stegoPollMax. A proxy in between must
+// forward the requests of one client connection over one upstream connection,
+// as HTTP/1.1 proxies do for sequential keep-alive requests.
+func NewHTTPPacketConn(conn net.Conn, role Role) PacketConn {
+	return newHTTPConn(conn, role, func() bool { return randIntn(2) == 0 })
+}
+
+// httpConn is a PacketConn with HTTP/1.1 framing. A reader goroutine parses
+// incoming messages and a writer goroutine sends the queued packets.
+type httpConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	framing *stegoFraming
+	chunked func() bool // picks the body framing of each message
+
+	incoming  chan []byte
+	kick      chan struct{} // wakes the writer
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu         sync.Mutex
+	queue      [][]byte
+	unanswered int  // server: requests waiting for a response
+	inFlight   bool // client: a request is waiting for its response
+	pollNow    bool // client: the last response carried packets, ask again right away
+	err        error
+}
+
+func newHTTPConn(conn net.Conn, role Role, chunked func() bool) *httpConn {
+	c := &httpConn{
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		framing:  newStegoFraming(role),
+		chunked:  chunked,
+		incoming: make(chan []byte, 64),
+		kick:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go c.readLoop()
+	if role == RoleServer {
+		go c.respondLoop()
+	} else {
+		go c.requestLoop()
+	}
+	return c
+}
+
+func (c *httpConn) WritePacket(packet []byte) error {
+	if len(packet) > maxPacketSize {
+		return fmt.Errorf("packet too large: %d bytes", len(packet))
+	}
+
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return err
+	}
+	if len(c.queue) >= stegoMaxQueue {
+		c.mu.Unlock()
+		return errQueueFull
+	}
+	c.queue = append(c.queue, packet)
+	c.mu.Unlock()
+
+	c.signal()
+	return nil
+}
+
+func (c *httpConn) ReadPacket() ([]byte, error) {
+	packet, ok := <-c.incoming
+	if !ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return nil, c.err
+	}
+	return packet, nil
+}
+
+func (c *httpConn) Close() error {
+	c.fail(net.ErrClosed)
+	return nil
+}
+
+// fail records the first error and closes the connection
+func (c *httpConn) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mu.Unlock()
+
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// signal wakes the writer without blocking
+func (c *httpConn) signal() {
+	select {
+	case c.kick <- struct{}{}:
+	default:
+	}
+}
+
+// readLoop delivers the packets of incoming messages
+func (c *httpConn) readLoop() {
+	defer close(c.incoming)
+	for {
+		body, err := c.framing.readMessage(c.reader)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		packets, err := splitBatch(body)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		c.mu.Lock()
+		if c.framing.role == RoleServer {
+			c.unanswered++
+		} else {
+			c.inFlight = false
+			c.pollNow = len(packets) > 0
+		}
+		c.mu.Unlock()
+		c.signal()
+
+		for _, packet := range packets {
+			select {
+			case c.incoming <- packet:
+			case <-c.done:
+				return
+			}
+		}
+	}
+}
+
+// respondLoop answers every request at once with the queued packets, if any
+func (c *httpConn) respondLoop() {
+	for {
+		select {
+		case <-c.kick:
+		case <-c.done:
+			return
+		}
+
+		for {
+			c.mu.Lock()
+			if c.unanswered == 0 {
+				c.mu.Unlock()
+				break
+			}
+			c.unanswered--
+			batch := c.takeBatch()
+			c.mu.Unlock()
+
+			if err := c.framing.writeMessage(c.conn, joinBatch(batch), c.chunked()); err != nil {
+				c.fail(err)
+				return
+			}
+		}
+	}
+}
+
+// requestLoop sends a request whenever packets are queued or a poll is due,
+// with at most one request waiting for its response
+func (c *httpConn) requestLoop() {
+	poll := stegoPollMin
+	nextPoll := time.Now().Add(poll)
+	timer := time.NewTimer(poll)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-c.kick:
+		case <-timer.C:
+		case <-c.done:
+			return
+		}
+
+		c.mu.Lock()
+		if c.inFlight {
+			// The response wakes us up again
+			c.mu.Unlock()
+			continue
+		}
+		if len(c.queue) == 0 && !c.pollNow && time.Now().Before(nextPoll) {
+			c.mu.Unlock()
+			timer.Reset(time.Until(nextPoll))
+			continue
+		}
+		batch := c.takeBatch()
+		if len(batch) > 0 || c.pollNow {
+			poll = stegoPollMin
+		} else {
+			poll = min(2*poll, stegoPollMax)
+		}
+		c.inFlight = true
+		c.pollNow = false
+		c.mu.Unlock()
+
+		if err := c.framing.writeMessage(c.conn, joinBatch(batch), c.chunked()); err != nil {
+			c.fail(err)
+			return
+		}
+		nextPoll = time.Now().Add(poll)
+	}
+}
+
+// takeBatch removes the packets for the next message from the queue, the
+// caller holds c.mu
+func (c *httpConn) takeBatch() [][]byte {
+	n, size := 0, 0
+	for n < len(c.queue) && (n == 0 || size+4+len(c.queue[n]) <= stegoMaxBatch) {
+		size += 4 + len(c.queue[n])
+		n++
+	}
+	batch := c.queue[:n:n]
+	c.queue = c.queue[n:]
+	return batch
+}
+
+// joinBatch concatenates packets, each behind a 4-byte length
+func joinBatch(packets [][]byte) []byte {
+	size := 0
+	for _, packet := range packets {
+		size += 4 + len(packet)
+	}
+	body := make([]byte, 0, size)
+	for _, packet := range packets {
+		body = binary.BigEndian.AppendUint32(body, uint32(len(packet)))
+		body = append(body, packet...)
+	}
+	return body
+}
+
+// splitBatch splits a message body produced by joinBatch
+func splitBatch(body []byte) ([][]byte, error) {
+	var packets [][]byte
+	for len(body) > 0 {
+		if len(body) < 4 {
+			return nil, fmt.Errorf("truncated packet length")
+		}
+		length := binary.BigEndian.Uint32(body)
+		if length > maxPacketSize || int(length) > len(body)-4 {
+			return nil, fmt.Errorf("invalid packet length %d", length)
+		}
+		packets = append(packets, body[4:4+length])
+		body = body[4+length:]
+	}
+	return packets, nil
+}
+
+// stegoFraming writes and parses the HTTP messages of one side of a connection:
+// the client writes requests and reads responses, the server the other way round
+type stegoFraming struct {
+	role      Role
+	host      string
+	userAgent string
+	server    string
+	cookies   string
+}
+
+// newStegoFraming creates the HTTP camouflage of one side of a connection
+func newStegoFraming(role Role) *stegoFraming {
+	now := time.Now().Unix()
+	return &stegoFraming{
+		role:      role,
+		host:      pick(stegoHosts),
+		userAgent: pick(stegoUserAgents),
+		server:    pick(stegoServers),
+		cookies: fmt.Sprintf("_ga=GA1.1.%d.%d; sid=%s; _gid=GA1.1.%d.%d",
+			randIntn(1<<30), now-int64(randIntn(86400*90)), randomToken(16), randIntn(1<<30), now),
+	}
+}
+
+// writeMessage writes body as one HTTP message
+func (s *stegoFraming) writeMessage(w io.Writer, body []byte, chunked bool) error {
+	var buf bytes.Buffer
+	if s.role == RoleServer {
+		s.writeResponseHead(&buf, len(body), chunked)
+	} else {
+		s.writeRequestHead(&buf, len(body), chunked)
+	}
+	writeBody(&buf, body, chunked)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// readMessage reads one HTTP message of the peer and returns its body
+func (s *stegoFraming) readMessage(r *bufio.Reader) ([]byte, error) {
+	var body io.ReadCloser
+	if s.role == RoleServer {
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HTTP request: %w", err)
+		}
+		body = req.Body
+	} else {
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HTTP response: %w", err)
+		}
+		body = resp.Body
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, stegoMaxBody+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP body: %w", err)
+	}
+	if len(data) > stegoMaxBody {
+		return nil, fmt.Errorf("HTTP body larger than %d bytes", stegoMaxBody)
+	}
+	return data, nil
+}
+
+// writeRequestHead writes the request line and headers of a browser-like POST
+func (s *stegoFraming) writeRequestHead(buf *bytes.Buffer, length int, chunked bool) {
+	fmt.Fprintf(buf, "POST %s HTTP/1.1\r\n", randomPath())
+	fmt.Fprintf(buf, "Host: %s\r\n", s.host)
+	buf.WriteString("Connection: keep-alive\r\n")
+	writeFraming(buf, length, chunked)
+	fmt.Fprintf(buf, "User-Agent: %s\r\n", s.userAgent)
+	fmt.Fprintf(buf, "Content-Type: %s\r\n", pick(stegoContentTypes))
+	buf.WriteString("Accept: */*\r\n")
+	fmt.Fprintf(buf, "Origin: https://%s\r\n", s.host)
+	buf.WriteString("Sec-Fetch-Site: cross-site\r\nSec-Fetch-Mode: cors\r\nSec-Fetch-Dest: empty\r\n")
+	buf.WriteString("Accept-Encoding: gzip, deflate, br\r\n")
+	buf.WriteString("Accept-Language: en-US,en;q=0.9\r\n")
+	fmt.Fprintf(buf, "Cookie: %s\r\n\r\n", s.cookies)
+}
+
+// writeResponseHead writes the status line and headers of a typical origin response
+func (s *stegoFraming) writeResponseHead(buf *bytes.Buffer, length int, chunked bool) {
+	buf.WriteString("HTTP/1.1 200 OK\r\n")
+	fmt.Fprintf(buf, "Server: %s\r\n", s.server)
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().UTC().Format(http.TimeFormat))
+	fmt.Fprintf(buf, "Content-Type: %s\r\n", pick(stegoContentTypes))
+	writeFraming(buf, length, chunked)
+	buf.WriteString("Connection: keep-alive\r\n")
+	buf.WriteString("Cache-Control: no-store, max-age=0\r\n")
+	if randIntn(8) == 0 {
+		fmt.Fprintf(buf, "Set-Cookie: __cf_bm=%s; path=/; expires=%s; domain=.%s; HttpOnly; Secure; SameSite=None\r\n",
+			randomToken(32), time.Now().Add(30*time.Minute).UTC().Format(http.TimeFormat), s.host)
+	}
+	fmt.Fprintf(buf, "X-Request-Id: %s\r\n\r\n", randomHex(16))
+}
+
+// writeFraming writes the header that delimits the body
+func writeFraming(buf *bytes.Buffer, length int, chunked bool) {
+	if chunked {
+		buf.WriteString("Transfer-Encoding: chunked\r\n")
+	} else {
+		fmt.Fprintf(buf, "Content-Length: %d\r\n", length)
+	}
+}
+
+// writeBody writes data as the message body, split into chunks of random size when chunked
+func writeBody(buf *bytes.Buffer, data []byte, chunked bool) {
+	if !chunked {
+		buf.Write(data)
+		return
+	}
+	for len(data) > 0 {
+		n := 1 + randIntn(stegoChunkSize)
+		if n > len(data) {
+			n = len(data)
+		}
+		fmt.Fprintf(buf, "%x\r\n", n)
+		buf.Write(data[:n])
+		buf.WriteString("\r\n")
+		data = data[n:]
+	}
+	buf.WriteString("0\r\n\r\n")
+}
+
+// randomPath returns a request target with a random suffix and query
+func randomPath() string {
+	path := pick(stegoPaths)
+	switch randIntn(3) {
+	case 0:
+		path += "/" + randomHex(8)
+	case 1:
+		path += "?v=" + strconv.Itoa(1+randIntn(9)) + "&t=" + strconv.FormatInt(time.Now().UnixMilli(), 10)
+	default:
+		path += "?id=" + randomToken(12)
+	}
+	return path
+}
+
+// pick returns a random element of values
+func pick(values []string) string {
+	return values[randIntn(len(values))]
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// randomToken returns n random bytes encoded as URL-safe base64
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,145 @@
+package obfuscation
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/crypto"
+)
+
+// TestHTTPPacketConnThroughProxy exchanges sealed packets through
+// httputil.ReverseProxy, which parses every message and writes it again
+func TestHTTPPacketConnThroughProxy(t *testing.T) {
+	for _, chunked := range []bool{false, true} {
+		t.Run(fmt.Sprintf("chunked=%v", chunked), func(t *testing.T) {
+			testHTTPPacketConnThroughProxy(t, chunked)
+		})
+	}
+}
+
+func testHTTPPacketConnThroughProxy(t *testing.T, chunked bool) {
+	framing := func() bool { return chunked }
+	obf := NewObfuscation(crypto.NewEncryption("test-token"), time.Minute)
+
+	// The server echoes every packet back through its own session
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		server := newHTTPConn(conn, RoleServer, framing)
+		defer server.Close()
+		session, _ := obf.NewSession()
+		for {
+			data, err := server.ReadPacket()
+			if err != nil {
+				return
+			}
+			packet, err := session.DeobfuscatePacket(data)
+			if err != nil {
+				t.Errorf("Server failed to deobfuscate: %v", err)
+				return
+			}
+			reply, _ := session.ObfuscatePacket(packet.Payload, "morph")
+			server.WritePacket(reply)
+		}
+	}()
+
+	// Count the framing of the requests the proxy receives
+	var requests, chunkedRequests atomic.Int32
+	target, _ := url.Parse("http://" + backend.Addr().String())
+	reverseProxy := httputil.NewSingleHostReverseProxy(target)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if len(r.TransferEncoding) > 0 {
+			chunkedRequests.Add(1)
+		}
+		reverseProxy.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newHTTPConn(conn, RoleClient, framing)
+	defer client.Close()
+	session, _ := obf.NewSession()
+
+	var sent [][]byte
+	for i := 0; i < 40; i++ {
+		payload := bytes.Repeat([]byte{byte(i), '\r', '\n', 0}, i*400)
+		packet, err := session.ObfuscatePacket(payload, "aes")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.WritePacket(packet); err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, payload)
+	}
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	for i, payload := range sent {
+		data, err := client.ReadPacket()
+		if err != nil {
+			t.Fatalf("Failed to read packet %d: %v", i, err)
+		}
+		packet, err := session.DeobfuscatePacket(data)
+		if err != nil {
+			t.Fatalf("Failed to deobfuscate packet %d: %v", i, err)
+		}
+		if !bytes.Equal(packet.Payload, payload) {
+			t.Fatalf("Packet %d: payload mismatch", i)
+		}
+	}
+
+	if requests.Load() == 0 {
+		t.Fatal("Expected the packets to pass the proxy")
+	}
+	if chunked && chunkedRequests.Load() != requests.Load() {
+		t.Errorf("Expected all %d requests to be chunked, got %d", requests.Load(), chunkedRequests.Load())
+	}
+	if !chunked && chunkedRequests.Load() != 0 {
+		t.Errorf("Expected requests with Content-Length, got %d chunked", chunkedRequests.Load())
+	}
+}
+
+func TestStegoFramingMessages(t *testing.T) {
+	body := []byte("body\r\n\r\nwith\x00binary")
+	for _, role := range []Role{RoleClient, RoleServer} {
+		for _, chunked := range []bool{false, true} {
+			var buf bytes.Buffer
+			if err := newStegoFraming(role).writeMessage(&buf, body, chunked); err != nil {
+				t.Fatal(err)
+			}
+			// The peer reads what this side writes
+			peer := newStegoFraming(RoleServer - role)
+			got, err := peer.readMessage(bufio.NewReader(&buf))
+			if err != nil {
+				t.Fatalf("role %d, chunked %v: %v\n%s", role, chunked, err, buf.Bytes())
+			}
+			if !bytes.Equal(got, body) {
+				t.Errorf("role %d, chunked %v: got %q", role, chunked, got)
+			}
+		}
+	}
+
+	if _, err := splitBatch([]byte{0, 0, 0, 9, 1}); err == nil {
+		t.Error("Expected truncated batch to be rejected")
+	}
+}
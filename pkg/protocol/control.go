@@ -0,0 +1,84 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// AuthPayload 认证消息内容
+//
+// 认证消息在 Noise 握手建立的加密连接上发送，令牌不再以明文出现在线路上。
+type AuthPayload struct {
+	ClientID string `json:"client_id"`
+}
+
+// WorkConnPayload 工作连接消息内容
+//
+// 服务端通过控制连接发送 MessageTypeReqWorkConn 请求工作连接，
+// 客户端新建一条连接并以携带相同 WorkID 的 MessageTypeProxy 消息开头。
+type WorkConnPayload struct {
+	Name     string `json:"name"`
+	ClientID string `json:"client_id"`
+	WorkID   string `json:"work_id"`
+}
+
+// RelayPayload 中继请求消息内容
+//
+// 客户端连接中继服务器后发送 MessageTypeRelay，内容为 Noise NNpsk0 的第一条握手消息，
+// RelayPayload 作为其中的加密载荷。中继解密成功即说明客户端持有令牌，连接 Target 后
+// 回复第二条握手消息，载荷为 "OK" 或拒绝原因，此后只转发字节。
+type RelayPayload struct {
+	Target string `json:"target"`
+}
+
+// SecureHelloPayload 控制端口 Noise 握手第一条消息的加密载荷
+//
+// 客户端列出支持的传输加密算法，服务端按自己的偏好顺序选定后在第二条握手消息的载荷中
+// 返回 SecureAcceptPayload。两条消息都计入握手哈希，篡改列表会导致握手失败。
+type SecureHelloPayload struct {
+	User         string   `json:"user,omitempty"`  // PSK 模式下据此选择用户
+	Token        string   `json:"token,omitempty"` // PSK 模式下的令牌，服务端验证后才回复第二条消息
+	Timestamp    int64    `json:"timestamp"`       // 客户端的 Unix 时间，服务端据此和临时公钥拒绝重放
+	CipherSuites []string `json:"cipher_suites"`
+}
+
+// SecureAcceptPayload 控制端口 Noise 握手第二条消息的加密载荷
+type SecureAcceptPayload struct {
+	CipherSuite string `json:"cipher_suite"`
+}
+
+// CloseProxyPayload 关闭代理消息内容
+type CloseProxyPayload struct {
+	Name string `json:"name"`
+}
+
+// CertRenewPayload 客户端证书续期消息内容
+//
+// 客户端发送新私钥的 PEM 证书签名请求，服务端用内置 CA 签发与当前证书同名的新证书，
+// 失败时返回原因。
+type CertRenewPayload struct {
+	CSR         string `json:"csr,omitempty"`
+	Certificate string `json:"certificate,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// NewJSONMessage 创建以 JSON 编码内容的消息
+func NewJSONMessage(msgType MessageType, v interface{}) (*Message, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	return &Message{
+		Type:    msgType,
+		Payload: payload,
+	}, nil
+}
+
+// DecodeJSON 解码 JSON 消息内容
+func (m *Message) DecodeJSON(v interface{}) error {
+	if err := json.Unmarshal(m.Payload, v); err != nil {
+		return fmt.Errorf("failed to decode message: %w", err)
+	}
+	return nil
+}
@@ -5,7 +5,7 @@ import (
 	"compress/gzip"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"strconv"
@@ -286,7 +286,7 @@ func (s *HTTPServer) Start(addr string) error {
 	// Start HTTP server
 	go s.httpServer.Serve(listener)
 
-	log.Printf("HTTP server started on %s", addr)
+	slog.Info("HTTP server started", "addr", addr)
 	return nil
 }
 
@@ -315,7 +315,7 @@ func (s *HTTPServer) Stop() error {
 
 	// Shutdown HTTP server
 	if err := s.httpServer.Shutdown(nil); err != nil {
-		log.Printf("HTTP server shutdown error: %v", err)
+		slog.Warn("HTTP server shutdown error", "err", err)
 	}
 
 	// Close listener
@@ -324,7 +324,7 @@ func (s *HTTPServer) Stop() error {
 		s.listener = nil
 	}
 
-	log.Println("HTTP server stopped")
+	slog.Info("HTTP server stopped")
 	return nil
 }
 
@@ -16,6 +16,14 @@ const (
 	MessageTypeProxy     MessageType = 3 // 代理数据
 	MessageTypeData      MessageType = 4 // 数据传输
 	MessageTypeError     MessageType = 5 // 错误
+
+	MessageTypeNewProxy    MessageType = 6 // 注册或更新代理
+	MessageTypeCloseProxy  MessageType = 7 // 关闭代理
+	MessageTypeReqWorkConn MessageType = 8 // 服务端请求客户端建立工作连接
+	MessageTypeStream      MessageType = 9 // 控制连接上复用的流数据帧
+	MessageTypeRelay       MessageType = 10 // 请求中继服务器连接下一跳，内容为 Noise 握手消息
+	MessageTypeSecure      MessageType = 11 // Noise 握手，内容为 1 字节握手模式加握手消息
+	MessageTypeCertRenew   MessageType = 12 // 客户端证书续期请求和响应
 )
 
 // Message 消息结构
@@ -63,10 +71,6 @@ func ReadMessage(conn io.Reader) (*Message, error) {
 	if payloadLen > 10*1024*1024 { // 限制 10MB
 		return nil, errors.New("message payload too large")
 	}
-	if payloadLen == 0 {
-		return nil, errors.New("message payload is empty")
-	}
-
 	// 读取消息内容
 	payload := make([]byte, payloadLen)
 	if _, err := io.ReadFull(conn, payload); err != nil {
@@ -2,7 +2,7 @@ package protocol
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"sync"
 	"time"
@@ -139,7 +139,7 @@ func (c *SCTPConn) handleIncomingData() {
 			select {
 			case c.dataChan <- data:
 			case <-time.After(5 * time.Second):
-				log.Printf("SCTP data channel full, dropping packet")
+				slog.Warn("SCTP data channel full, dropping packet")
 			}
 		}
 	}
@@ -0,0 +1,77 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// StreamDenied 服务端因访问控制拒绝打开流时，拒绝原因以此开头
+const StreamDenied = "destination not allowed"
+
+// StreamOpenPayload 客户端通过控制连接打开流时携带的参数
+//
+// Network 为 tcp 时服务端连接 Address 后双向转发；
+// 为 udp 时流上承载 WriteDatagram 编码的数据报，目标地址逐个指定。
+// Forward 不为空时表示来自客户端的静态转发，服务端按 [forward] 规则检查。
+type StreamOpenPayload struct {
+	Network string `json:"network"`
+	Address string `json:"address,omitempty"`
+	Forward string `json:"forward,omitempty"`
+}
+
+// IsStreamDenied 判断拒绝原因是否为访问控制拒绝
+func IsStreamDenied(reason string) bool {
+	return strings.HasPrefix(reason, StreamDenied)
+}
+
+// maxDatagramSize 单个数据报上限
+const maxDatagramSize = 65535
+
+// WriteDatagram 在流上写入一个数据报：2 字节地址长度、地址、2 字节数据长度、数据
+func WriteDatagram(w io.Writer, addr string, data []byte) error {
+	if len(addr) > 255 {
+		return errors.New("datagram address too long")
+	}
+	if len(data) > maxDatagramSize {
+		return errors.New("datagram too large")
+	}
+
+	buf := make([]byte, 0, 4+len(addr)+len(data))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(addr)))
+	buf = append(buf, addr...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(data)))
+	buf = append(buf, data...)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadDatagram 从流上读取一个数据报
+func ReadDatagram(r io.Reader) (string, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return "", nil, err
+	}
+	addrLen := binary.BigEndian.Uint16(header[:])
+	if addrLen > 255 {
+		return "", nil, fmt.Errorf("invalid datagram address length %d", addrLen)
+	}
+
+	addr := make([]byte, addrLen)
+	if _, err := io.ReadFull(r, addr); err != nil {
+		return "", nil, err
+	}
+
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return "", nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(header[:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", nil, err
+	}
+
+	return string(addr), data, nil
+}
@@ -3,7 +3,7 @@ package protocol
 import (
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"sync"
@@ -194,7 +194,7 @@ func (s *WebSocketServer) Start(addr string) error {
 	// Start accepting connections
 	go s.acceptConnections()
 
-	log.Printf("WebSocket server started on %s", addr)
+	slog.Info("WebSocket server started", "addr", addr)
 	return nil
 }
 
@@ -218,7 +218,7 @@ func (s *WebSocketServer) handleWebSocketUpgrade(w http.ResponseWriter, r *http.
 	// Upgrade connection
 	wsConn, err := s.upgrader.Upgrade(rw, r, rw.Header())
 	if err != nil {
-		log.Printf("Failed to upgrade WebSocket connection: %v", err)
+		slog.Warn("Failed to upgrade WebSocket connection", "remote", r.RemoteAddr, "err", err)
 		return
 	}
 
@@ -290,7 +290,7 @@ func (s *WebSocketServer) acceptConnections() {
 			if !s.running {
 				return
 			}
-			log.Printf("Failed to accept connection: %v", err)
+			slog.Warn("Failed to accept connection", "err", err)
 			continue
 		}
 
@@ -323,7 +323,7 @@ func (s *WebSocketServer) Stop() error {
 		s.listener = nil
 	}
 
-	log.Println("WebSocket server stopped")
+	slog.Info("WebSocket server stopped")
 	return nil
 }
 
@@ -360,7 +360,7 @@ func (c *WebSocketConn) handleIncomingMessages() {
 		select {
 		case c.dataChan <- data:
 		case <-time.After(c.config.WriteTimeout):
-			log.Printf("WebSocket data channel full, dropping message")
+			slog.Warn("WebSocket data channel full, dropping message")
 		}
 	}
 }
@@ -378,7 +378,7 @@ func (c *WebSocketConn) handlePings() {
 				c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
 				if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 					c.mu.RUnlock()
-					log.Printf("Failed to send WebSocket ping: %v", err)
+					slog.Warn("Failed to send WebSocket ping", "err", err)
 					return
 				}
 			}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/logging"
+)
+
+// combinedTemplate 类 Apache combined 格式
+const combinedTemplate = `{{.SourceIP}} {{or .ClientID "-"}} [{{.Time.Format "02/Jan/2006:15:04:05 -0700"}}] ` +
+	`"{{or .Method "-"}} {{or .Path "-"}}" {{.Status}} {{.BytesOut}} {{.BytesIn}} {{.DurationMs}}ms "{{.Proxy}}" "{{or .CloseReason "-"}}"`
+
+// AccessLogEntry 访问日志记录
+type AccessLogEntry struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"` // conn 或 http
+	Proxy       string    `json:"proxy"`
+	ClientID    string    `json:"client_id"`
+	User        string    `json:"user,omitempty"`
+	SourceIP    string    `json:"source_ip"`
+	BytesIn     int64     `json:"bytes_in"`
+	BytesOut    int64     `json:"bytes_out"`
+	DurationMs  int64     `json:"duration_ms"`
+	CloseReason string    `json:"close_reason,omitempty"`
+
+	// HTTP 请求字段
+	Method    string `json:"method,omitempty"`
+	Host      string `json:"host,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Status    int    `json:"status,omitempty"`
+	LatencyMs int64  `json:"latency_ms,omitempty"`
+}
+
+// AccessLogger 访问日志记录器
+type AccessLogger struct {
+	out      io.Writer
+	file     string // 共享日志文件的路径，输出到标准输出时为空
+	template *template.Template
+	mu       sync.Mutex
+}
+
+// accessLogFile 按路径共享的日志文件，最后一个使用者关闭时才关闭文件
+type accessLogFile struct {
+	*logging.RotatingFile
+	cfg  config.AccessLogConfig
+	refs int
+}
+
+// accessLogFiles 按路径共享的日志文件，多个代理可以写同一个文件
+var (
+	accessLogFiles   = make(map[string]*accessLogFile)
+	accessLogFilesMu sync.Mutex
+)
+
+// NewAccessLogger 根据配置创建访问日志记录器，未启用时返回 nil
+func NewAccessLogger(cfg config.AccessLogConfig) (*AccessLogger, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	logger := &AccessLogger{}
+
+	switch cfg.LogFormat {
+	case "combined":
+		logger.template = template.Must(template.New("access_log").Parse(combinedTemplate))
+	case "template":
+		tmpl, err := template.New("access_log").Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid access log template: %w", err)
+		}
+		logger.template = tmpl
+	}
+
+	if cfg.LogFile == "" || cfg.LogFile == "stdout" {
+		logger.out = os.Stdout
+		return logger, nil
+	}
+
+	var maxSize int64
+	if cfg.MaxSize != "" {
+		size, err := config.ParseSize(cfg.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+		maxSize = size
+	}
+
+	accessLogFilesMu.Lock()
+	defer accessLogFilesMu.Unlock()
+
+	file, exists := accessLogFiles[cfg.LogFile]
+	if exists && !file.cfg.SameRotation(&cfg) {
+		return nil, fmt.Errorf("access log %s is already open with different max_size or max_backups", cfg.LogFile)
+	}
+	if !exists {
+		rotating, err := logging.OpenRotatingFile(cfg.LogFile, maxSize, cfg.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		file = &accessLogFile{RotatingFile: rotating, cfg: cfg}
+		accessLogFiles[cfg.LogFile] = file
+	}
+	file.refs++
+	logger.out = file
+	logger.file = cfg.LogFile
+
+	return logger, nil
+}
+
+// Close 释放共享的日志文件，最后一个记录器关闭时才关闭文件，logger 为 nil 时不做任何事
+func (l *AccessLogger) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	accessLogFilesMu.Lock()
+	defer accessLogFilesMu.Unlock()
+
+	file, exists := accessLogFiles[l.file]
+	if l.file == "" || !exists {
+		return nil
+	}
+	l.file = ""
+
+	file.refs--
+	if file.refs > 0 {
+		return nil
+	}
+	delete(accessLogFiles, file.cfg.LogFile)
+	return file.Close()
+}
+
+// Log 写入一条访问日志，logger 为 nil 时不做任何事
+func (l *AccessLogger) Log(entry *AccessLogEntry) {
+	if l == nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	if l.template != nil {
+		if err := l.template.Execute(&buf, entry); err != nil {
+			return
+		}
+		buf.WriteByte('\n')
+	} else if err := json.NewEncoder(&buf).Encode(entry); err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(buf.Bytes())
+}
+
+// countingConn 统计读写字节数的连接
+type countingConn struct {
+	net.Conn
+	bytesRead    int64
+	bytesWritten int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.bytesRead, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.bytesWritten, int64(n))
+	return n, err
+}
+
+// BytesRead 返回已读取的字节数
+func (c *countingConn) BytesRead() int64 {
+	return atomic.LoadInt64(&c.bytesRead)
+}
+
+// BytesWritten 返回已写入的字节数
+func (c *countingConn) BytesWritten() int64 {
+	return atomic.LoadInt64(&c.bytesWritten)
+}
+
+// sourceIP 返回连接的来源 IP
+func sourceIP(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
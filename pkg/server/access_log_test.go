@@ -0,0 +1,58 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+)
+
+func TestAccessLogFileShared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	cfg := config.AccessLogConfig{Enabled: true, LogFile: path, MaxSize: "1MB", MaxBackups: 3}
+
+	first, err := NewAccessLogger(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewAccessLogger(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 轮转参数不同的代理不能共用同一个文件
+	conflict := cfg
+	conflict.MaxBackups = 5
+	if _, err := NewAccessLogger(conflict); err == nil {
+		t.Error("Expected conflicting rotation settings to be rejected")
+	}
+
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Close(); err != nil {
+		t.Errorf("Expected second Close to be a no-op, got %v", err)
+	}
+	second.Log(&AccessLogEntry{Proxy: "web"})
+	if data, _ := os.ReadFile(path); len(data) == 0 {
+		t.Error("Expected the file to stay open while another proxy uses it")
+	}
+
+	if err := second.Close(); err != nil {
+		t.Fatal(err)
+	}
+	accessLogFilesMu.Lock()
+	_, open := accessLogFiles[path]
+	accessLogFilesMu.Unlock()
+	if open {
+		t.Error("Expected the file to be closed after the last proxy released it")
+	}
+
+	// 全部关闭后可以用新的轮转参数重新打开
+	reopened, err := NewAccessLogger(conflict)
+	if err != nil {
+		t.Errorf("Expected the file to reopen with new settings, got %v", err)
+	}
+	reopened.Close()
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/audit"
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+)
+
+// defaultAuditLimit 查询审计日志默认返回的记录数
+const defaultAuditLimit = 100
+
+// auditAPI 审计日志查询 API
+type auditAPI struct {
+	proxies *ProxyManager
+}
+
+// registerAuditAPI 注册审计日志查询路由
+func registerAuditAPI(mux *http.ServeMux, cfg *config.DashboardConfig, pm *ProxyManager) {
+	api := &auditAPI{proxies: pm}
+
+	mux.Handle("GET /api/audit", requireAuth(cfg, http.HandlerFunc(api.handleQuery)))
+}
+
+// handleQuery GET /api/audit?from=&to=&actor=&action=&limit=
+//
+// from 和 to 为 RFC 3339 时间，actor 可以是完整的操作者（如 "admin:root"）或只写名称，
+// 返回满足条件的最近 limit 条记录（默认 100），按时间顺序排列。
+func (a *auditAPI) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if a.proxies.audit == nil {
+		writeAPIError(w, http.StatusNotFound, "audit log is disabled")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Limit:  defaultAuditLimit,
+	}
+	var err error
+	if value := query.Get("from"); value != "" {
+		if filter.From, err = time.Parse(time.RFC3339, value); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid from: "+err.Error())
+			return
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if filter.To, err = time.Parse(time.RFC3339, value); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid to: "+err.Error())
+			return
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 0 {
+			writeAPIError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	entries, err := a.proxies.audit.Query(filter)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
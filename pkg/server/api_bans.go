@@ -0,0 +1,41 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/aethertunnel/aethertunnel/pkg/audit"
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+)
+
+// banAPI IP 封禁管理 API
+type banAPI struct {
+	proxies *ProxyManager
+}
+
+// registerBanAPI 注册封禁列表路由
+func registerBanAPI(mux *http.ServeMux, cfg *config.DashboardConfig, pm *ProxyManager) {
+	api := &banAPI{proxies: pm}
+
+	mux.Handle("GET /api/bans", requireAuth(cfg, http.HandlerFunc(api.handleList)))
+	mux.Handle("DELETE /api/bans/{ip}", requireAuth(cfg, http.HandlerFunc(api.handleUnban)))
+}
+
+// handleList GET /api/bans，返回生效中的封禁
+func (a *banAPI) handleList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.proxies.guard.List())
+}
+
+// handleUnban DELETE /api/bans/{ip}
+func (a *banAPI) handleUnban(w http.ResponseWriter, r *http.Request) {
+	if err := a.proxies.guard.Unban(r.PathValue("ip")); err != nil {
+		if errors.Is(err, ErrBanNotFound) {
+			writeAPIError(w, http.StatusNotFound, err.Error())
+		} else {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+	a.proxies.recordAdmin(r, audit.Event{Action: audit.ActionUnban, Target: r.PathValue("ip")})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/audit"
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+)
+
+// IssueCertRequest 签发客户端证书的请求
+type IssueCertRequest struct {
+	User     string `json:"user"`
+	ClientID string `json:"client_id,omitempty"` // 写入证书 CN，限定客户端标识
+	CSR      string `json:"csr,omitempty"`       // PEM 证书签名请求，为空时由 CA 生成私钥
+	TTL      string `json:"ttl,omitempty"`       // 有效期，如 "12h"，默认 cert_manager.cert_ttl
+}
+
+// RevokeCertRequest 吊销证书的请求
+type RevokeCertRequest struct {
+	Serial string `json:"serial"` // 十六进制序列号
+	Reason string `json:"reason,omitempty"`
+}
+
+// certAPI 证书管理 API
+type certAPI struct {
+	proxies *ProxyManager
+}
+
+// registerCertAPI 注册证书签发和吊销路由
+func registerCertAPI(mux *http.ServeMux, cfg *config.DashboardConfig, pm *ProxyManager) {
+	api := &certAPI{proxies: pm}
+
+	mux.Handle("POST /api/certs", requireAuth(cfg, api.enabled(api.handleIssue)))
+	mux.Handle("GET /api/certs/revoked", requireAuth(cfg, api.enabled(api.handleRevoked)))
+	mux.Handle("POST /api/certs/revoked", requireAuth(cfg, api.enabled(api.handleRevoke)))
+}
+
+// enabled 未启用内置 CA 时返回 404
+func (a *certAPI) enabled(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.proxies.certs == nil {
+			writeAPIError(w, http.StatusNotFound, "certificate manager is disabled")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// ParseCertTTL 解析签发请求的有效期，为空时返回 0（使用默认有效期）
+func ParseCertTTL(value string, max time.Duration) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ttl: %w", err)
+	}
+	if ttl <= 0 || ttl > max {
+		return 0, fmt.Errorf("ttl must be between 0 and %s", max)
+	}
+	return ttl, nil
+}
+
+// handleIssue POST /api/certs
+func (a *certAPI) handleIssue(w http.ResponseWriter, r *http.Request) {
+	var req IssueCertRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, exists := a.proxies.users.Get(req.User); !exists {
+		writeAPIError(w, http.StatusNotFound, "user not found or disabled")
+		return
+	}
+	ttl, err := ParseCertTTL(req.TTL, a.proxies.certs.TTL())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	commonName := CertificateCommonName(req.User, req.ClientID)
+	var issued *IssuedCert
+	if req.CSR != "" {
+		issued, err = a.proxies.certs.SignCSR(req.CSR, commonName, ttl)
+	} else {
+		issued, err = a.proxies.certs.Issue(commonName, ttl)
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	a.proxies.recordAdmin(r, audit.Event{
+		Action: audit.ActionCertIssue,
+		Target: commonName,
+		Detail: fmt.Sprintf("serial %s, expires %s", issued.Serial, issued.NotAfter.Format(time.RFC3339)),
+	})
+	writeJSON(w, http.StatusCreated, issued)
+}
+
+// handleRevoked GET /api/certs/revoked
+func (a *certAPI) handleRevoked(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.proxies.certs.Revoked())
+}
+
+// handleRevoke POST /api/certs/revoked，吊销证书并断开正在使用它的会话
+func (a *certAPI) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	var req RevokeCertRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	revocation, err := a.proxies.certs.Revoke(req.Serial, req.Reason)
+	if revocation.Serial == "" {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 持久化失败时吊销仍在内存中生效
+	a.proxies.Control().KickCert(revocation.Serial)
+	a.proxies.recordAdmin(r, audit.Event{Action: audit.ActionCertRevoke, Target: revocation.Serial, Detail: req.Reason})
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, revocation)
+}
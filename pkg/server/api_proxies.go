@@ -0,0 +1,206 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/aethertunnel/aethertunnel/pkg/audit"
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+)
+
+// maxAPIBodySize 管理 API 请求体大小上限
+const maxAPIBodySize = 1 << 20
+
+// ProxyInfo 管理 API 返回的代理信息
+type ProxyInfo struct {
+	config.ProxyConfig
+	ClientID     string `json:"client_id"`
+	User         string `json:"user"`
+	Dynamic      bool   `json:"dynamic"`
+	Running      bool   `json:"running"`
+	AssignedPort int    `json:"assigned_port"` // 实际监听的端口
+}
+
+// proxyRequest 创建代理的请求体
+type proxyRequest struct {
+	config.ProxyConfig
+	ClientID string `json:"client_id"`
+}
+
+// ListProxies 返回按名称排序的代理信息
+func (pm *ProxyManager) ListProxies() []ProxyInfo {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	infos := make([]ProxyInfo, 0, len(pm.proxies))
+	for _, proxy := range pm.proxies {
+		infos = append(infos, proxy.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// info 返回代理信息，调用方需持有锁
+func (p *Proxy) info() ProxyInfo {
+	return ProxyInfo{
+		ProxyConfig:  p.Config(),
+		ClientID:     p.ClientID,
+		User:         p.User,
+		Dynamic:      p.Dynamic,
+		Running:      p.Running(),
+		AssignedPort: p.Port(),
+	}
+}
+
+// proxyInfo 返回单个代理信息
+func (pm *ProxyManager) proxyInfo(name string) (ProxyInfo, bool) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	proxy, exists := pm.proxies[name]
+	if !exists {
+		return ProxyInfo{}, false
+	}
+	return proxy.info(), true
+}
+
+// proxyAPI 代理管理 API
+type proxyAPI struct {
+	proxies *ProxyManager
+}
+
+// registerProxyAPI 注册代理管理路由
+func registerProxyAPI(mux *http.ServeMux, cfg *config.DashboardConfig, pm *ProxyManager) {
+	api := &proxyAPI{proxies: pm}
+
+	mux.Handle("GET /api/proxies", requireAuth(cfg, http.HandlerFunc(api.handleList)))
+	mux.Handle("POST /api/proxies", requireAuth(cfg, http.HandlerFunc(api.handleCreate)))
+	mux.Handle("PATCH /api/proxies/{name}", requireAuth(cfg, http.HandlerFunc(api.handleUpdate)))
+	mux.Handle("DELETE /api/proxies/{name}", requireAuth(cfg, http.HandlerFunc(api.handleDelete)))
+	mux.Handle("GET /api/forwards", requireAuth(cfg, http.HandlerFunc(api.handleForwards)))
+}
+
+// requireAuth 使用面板用户名和密码进行 HTTP Basic 认证
+func requireAuth(cfg *config.DashboardConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.Username == "" || cfg.Password == "" {
+			writeAPIError(w, http.StatusForbidden, "dashboard credentials are not configured")
+			return
+		}
+
+		username, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(cfg.Username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(cfg.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="AetherTunnel"`)
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleList GET /api/proxies
+func (a *proxyAPI) handleList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.proxies.ListProxies())
+}
+
+// handleCreate POST /api/proxies
+func (a *proxyAPI) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var req proxyRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	proxy := NewProxy(req.ProxyConfig)
+	proxy.ClientID = req.ClientID
+	if err := a.proxies.AddProxy(proxy); err != nil {
+		writeProxyError(w, err)
+		return
+	}
+
+	info, _ := a.proxies.proxyInfo(req.Name)
+	a.proxies.recordAdmin(r, audit.Event{Action: audit.ActionProxyCreate, Target: req.Name, After: info.ProxyConfig})
+	writeJSON(w, http.StatusCreated, info)
+}
+
+// handleUpdate PATCH /api/proxies/{name}，请求体中出现的字段覆盖现有配置
+func (a *proxyAPI) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	before, exists := a.proxies.proxyInfo(name)
+	if !exists {
+		writeAPIError(w, http.StatusNotFound, "proxy not found")
+		return
+	}
+
+	cfg := before.ProxyConfig
+	if err := decodeJSONBody(w, r, &cfg); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.proxies.UpdateProxy(name, cfg); err != nil {
+		writeProxyError(w, err)
+		return
+	}
+
+	info, _ := a.proxies.proxyInfo(name)
+	a.proxies.recordAdmin(r, audit.Event{Action: audit.ActionProxyUpdate, Target: name, Before: before.ProxyConfig, After: info.ProxyConfig})
+	writeJSON(w, http.StatusOK, info)
+}
+
+// handleDelete DELETE /api/proxies/{name}
+func (a *proxyAPI) handleDelete(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	before, _ := a.proxies.proxyInfo(name)
+	if err := a.proxies.RemoveProxy(name); err != nil {
+		writeProxyError(w, err)
+		return
+	}
+	a.proxies.recordAdmin(r, audit.Event{Action: audit.ActionProxyDelete, Target: name, Before: before.ProxyConfig})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleForwards GET /api/forwards
+func (a *proxyAPI) handleForwards(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.proxies.Control().ForwardStats())
+}
+
+// decodeJSONBody 解码请求体，拒绝未知字段
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodySize))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// writeProxyError 将代理管理错误转换为 HTTP 状态码
+func writeProxyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrProxyNotFound):
+		writeAPIError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrProxyExists), errors.Is(err, ErrPortInUse):
+		writeAPIError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrPortNotAllowed), errors.Is(err, ErrPermissionDenied):
+		writeAPIError(w, http.StatusForbidden, err.Error())
+	default:
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+// writeAPIError 输出 JSON 格式的错误
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// writeJSON 输出 JSON 响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
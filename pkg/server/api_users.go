@@ -0,0 +1,192 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/audit"
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+)
+
+// ClientInfo 管理 API 返回的在线客户端信息
+type ClientInfo struct {
+	ClientID    string    `json:"client_id"`
+	User        string    `json:"user"`
+	RemoteAddr  string    `json:"remote_addr"`
+	CipherSuite string    `json:"cipher_suite"` // 控制连接协商的传输加密算法
+	KeyExchange string    `json:"key_exchange"` // X25519 或 X25519+ML-KEM-768
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// userAPI 用户管理 API
+type userAPI struct {
+	proxies *ProxyManager
+}
+
+// registerUserAPI 注册用户和客户端管理路由
+func registerUserAPI(mux *http.ServeMux, cfg *config.DashboardConfig, pm *ProxyManager) {
+	api := &userAPI{proxies: pm}
+
+	mux.Handle("GET /api/users", requireAuth(cfg, http.HandlerFunc(api.handleList)))
+	mux.Handle("POST /api/users", requireAuth(cfg, http.HandlerFunc(api.handleCreate)))
+	mux.Handle("PATCH /api/users/{name}", requireAuth(cfg, http.HandlerFunc(api.handleUpdate)))
+	mux.Handle("DELETE /api/users/{name}", requireAuth(cfg, http.HandlerFunc(api.handleDelete)))
+	mux.Handle("POST /api/users/{name}/revoke", requireAuth(cfg, http.HandlerFunc(api.handleRevoke)))
+	mux.Handle("GET /api/clients", requireAuth(cfg, http.HandlerFunc(api.handleClients)))
+}
+
+// ListClients 返回按客户端标识排序的在线客户端
+func (cm *ControlManager) ListClients() []ClientInfo {
+	connections := cm.GetConnections()
+	clients := make([]ClientInfo, 0, len(connections))
+	for _, conn := range connections {
+		clients = append(clients, ClientInfo{
+			ClientID:    conn.clientID,
+			User:        conn.user.Name,
+			RemoteAddr:  conn.remoteAddr,
+			CipherSuite: conn.cipherSuite.String(),
+			KeyExchange: conn.keyExchange,
+			LastSeen:    conn.lastSeen,
+		})
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ClientID < clients[j].ClientID })
+	return clients
+}
+
+// withUsage 补充用户的在线客户端数和运行中的代理数
+func (a *userAPI) withUsage(infos []UserInfo) []UserInfo {
+	clients := make(map[string]int)
+	for _, client := range a.proxies.Control().ListClients() {
+		clients[client.User]++
+	}
+	proxies := make(map[string]int)
+	for _, proxy := range a.proxies.ListProxies() {
+		if proxy.Running {
+			proxies[proxy.User]++
+		}
+	}
+
+	for i := range infos {
+		infos[i].Clients = clients[infos[i].Name]
+		infos[i].Proxies = proxies[infos[i].Name]
+	}
+	return infos
+}
+
+// writeUser 输出单个用户信息
+func (a *userAPI) writeUser(w http.ResponseWriter, status int, name string) {
+	info, _ := a.proxies.users.Info(name)
+	writeJSON(w, status, a.withUsage([]UserInfo{info})[0])
+}
+
+// handleList GET /api/users
+func (a *userAPI) handleList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.withUsage(a.proxies.users.List()))
+}
+
+// handleCreate POST /api/users
+func (a *userAPI) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var cfg config.UserConfig
+	if err := decodeJSONBody(w, r, &cfg); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.proxies.users.Create(cfg); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	a.proxies.recordAdmin(r, audit.Event{Action: audit.ActionUserCreate, Target: cfg.Name, After: a.proxies.auditUserConfig(cfg.Name)})
+	a.writeUser(w, http.StatusCreated, cfg.Name)
+}
+
+// handleUpdate PATCH /api/users/{name}，请求体中出现的字段覆盖现有配置
+//
+// 内置用户和用户文件中的用户只能修改 enabled。修改后用户的会话会被断开，
+// 客户端重连时按新的配置认证。
+func (a *userAPI) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	existing, source, exists := a.proxies.users.Config(name)
+	if !exists {
+		writeAPIError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	cfg := existing
+	cfg.AllowDomains = append([]string(nil), existing.AllowDomains...)
+	cfg.ProxyTypes = append([]string(nil), existing.ProxyTypes...)
+	if err := decodeJSONBody(w, r, &cfg); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var err error
+	if source == userSourceAPI {
+		err = a.proxies.users.Update(name, cfg)
+	} else {
+		enabled := cfg.IsEnabled()
+		cfg.Enabled, existing.Enabled = nil, nil
+		if !reflect.DeepEqual(cfg, existing) {
+			err = ErrUserReadOnly
+		} else {
+			err = a.proxies.users.SetEnabled(name, enabled)
+		}
+	}
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	a.proxies.KickUser(name)
+	a.proxies.recordAdmin(r, audit.Event{Action: audit.ActionUserUpdate, Target: name, Before: auditUser(existing), After: a.proxies.auditUserConfig(name)})
+	a.writeUser(w, http.StatusOK, name)
+}
+
+// handleDelete DELETE /api/users/{name}
+func (a *userAPI) handleDelete(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	before := a.proxies.auditUserConfig(name)
+	if err := a.proxies.users.Delete(name); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	a.proxies.KickUser(name)
+	a.proxies.recordAdmin(r, audit.Event{Action: audit.ActionUserDelete, Target: name, Before: before})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRevoke POST /api/users/{name}/revoke，停用用户并立即断开其会话
+func (a *userAPI) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	before := a.proxies.auditUserConfig(name)
+	if err := a.proxies.users.SetEnabled(name, false); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	a.proxies.KickUser(name)
+	a.proxies.recordAdmin(r, audit.Event{Action: audit.ActionUserUpdate, Target: name, Detail: "revoked", Before: before, After: a.proxies.auditUserConfig(name)})
+	a.writeUser(w, http.StatusOK, name)
+}
+
+// handleClients GET /api/clients
+func (a *userAPI) handleClients(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.proxies.Control().ListClients())
+}
+
+// writeUserError 将用户管理错误转换为 HTTP 状态码
+func writeUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		writeAPIError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrUserExists), errors.Is(err, ErrUserReadOnly):
+		writeAPIError(w, http.StatusConflict, err.Error())
+	default:
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/aethertunnel/aethertunnel/pkg/audit"
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+)
+
+// 审计记录中的操作者
+const (
+	actorSystem  = "system"
+	actorUnknown = "unknown"
+)
+
+// userActor 返回用户的操作者名称
+func userActor(name string) string {
+	return "user:" + name
+}
+
+// adminActor 返回管理 API 请求的操作者名称（Basic 认证的用户名）
+func adminActor(r *http.Request) string {
+	username, _, _ := r.BasicAuth()
+	return "admin:" + username
+}
+
+// addrIP 返回地址中的 IP
+func addrIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// auditUser 返回写入审计日志的用户配置，令牌只记录是否修改过
+func auditUser(cfg config.UserConfig) config.UserConfig {
+	if cfg.Token != "" {
+		cfg.Token = "[redacted]"
+	}
+	if cfg.TokenHash != "" {
+		// 哈希本身加盐且计算缓慢，记录其摘要只用于看出令牌是否更换
+		sum := sha256.Sum256([]byte(cfg.TokenHash))
+		cfg.TokenHash = "sha256:" + hex.EncodeToString(sum[:8])
+	}
+	return cfg
+}
+
+// statusRecorder 记录响应状态码
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// auditRequests 记录管理 API 的修改请求和认证失败，查询请求不记录
+func auditRequests(auditLog *audit.Log, next http.Handler) http.Handler {
+	if auditLog == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			return
+		}
+		event := audit.Event{
+			Actor:  adminActor(r),
+			Source: addrIP(r.RemoteAddr),
+			Target: r.URL.Path,
+			Detail: fmt.Sprintf("%s %s -> %d", r.Method, r.URL.RequestURI(), recorder.status),
+			Failed: recorder.status >= 400,
+		}
+		switch {
+		case recorder.status == http.StatusUnauthorized:
+			event.Action = audit.ActionAuthFailure
+		case r.Method != http.MethodGet && r.Method != http.MethodHead:
+			event.Action = audit.ActionAdminRequest
+		default:
+			return
+		}
+		auditLog.Record(event)
+	})
+}
+
+// recordAdmin 记录管理 API 完成的操作，操作者为请求的管理员
+func (pm *ProxyManager) recordAdmin(r *http.Request, ev audit.Event) {
+	ev.Actor = adminActor(r)
+	ev.Source = addrIP(r.RemoteAddr)
+	pm.audit.Record(ev)
+}
+
+// auditUserConfig 返回用户当前配置的审计副本，用户不存在时返回 nil
+func (pm *ProxyManager) auditUserConfig(name string) interface{} {
+	cfg, _, exists := pm.users.Config(name)
+	if !exists {
+		return nil
+	}
+	return auditUser(cfg)
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/audit"
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/store"
+)
+
+// storeKeyBans 封禁列表在状态存储中的键
+const storeKeyBans = "bans"
+
+// ErrBanNotFound 该 IP 没有被封禁
+var ErrBanNotFound = errors.New("ban not found")
+
+// Ban 被封禁的来源 IP
+type Ban struct {
+	IP       string    `json:"ip"`
+	Reason   string    `json:"reason"`
+	Count    int       `json:"count"`    // 累计被封禁的次数，决定下次封禁的时长
+	Rejected uint64    `json:"rejected"` // 封禁期间被拒绝的连接数
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+}
+
+// failures 时间窗口内的认证失败次数
+type failures struct {
+	count int
+	first time.Time
+}
+
+// AuthGuard 统计控制端口的认证失败并封禁来源 IP
+//
+// 失败按来源 IP 和用户分别统计，封禁记录保存在状态存储中，重启后仍然有效。
+// 封禁到期后记录保留 max_block_duration，期间再次被封禁时长加倍。
+type AuthGuard struct {
+	ipAttempts   int
+	userAttempts int
+	window       time.Duration
+	block        time.Duration
+	maxBlock     time.Duration
+
+	bans   map[netip.Addr]*Ban
+	ips    map[netip.Addr]*failures
+	users  map[string]*failures
+	store  *store.Store
+	audit  *audit.Log
+	pruned time.Time // 上次清理过期记录的时间
+	mu     sync.Mutex
+}
+
+// NewAuthGuard 创建封禁管理，st 为 nil 时封禁不持久化，auditLog 为 nil 时不记录审计日志
+func NewAuthGuard(cfg *config.SecurityConfig, st *store.Store, auditLog *audit.Log) (*AuthGuard, error) {
+	window, err := cfg.Window()
+	if err != nil {
+		return nil, err
+	}
+	block, maxBlock, err := cfg.BlockDurations()
+	if err != nil {
+		return nil, err
+	}
+
+	g := &AuthGuard{
+		window:   window,
+		block:    block,
+		maxBlock: maxBlock,
+		bans:     make(map[netip.Addr]*Ban),
+		ips:      make(map[netip.Addr]*failures),
+		users:    make(map[string]*failures),
+		store:    st,
+		audit:    auditLog,
+	}
+	g.ipAttempts, g.userAttempts = cfg.Attempts()
+
+	if st != nil {
+		var persisted []*Ban
+		if _, err := st.Get(storeKeyBans, &persisted); err != nil {
+			return nil, err
+		}
+		for _, ban := range persisted {
+			ip, err := netip.ParseAddr(ban.IP)
+			if err != nil {
+				slog.Warn("Skipping persisted ban", "ip", ban.IP, "err", err)
+				continue
+			}
+			g.bans[ip] = ban
+		}
+	}
+	return g, nil
+}
+
+// remoteIP 返回连接的来源 IP
+func remoteIP(addr net.Addr) (netip.Addr, bool) {
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addrPort.Addr().Unmap(), true
+}
+
+// Allow 判断是否接受来自 addr 的连接，在解析任何协议数据之前调用
+func (g *AuthGuard) Allow(addr net.Addr) bool {
+	ip, ok := remoteIP(addr)
+	if !ok {
+		return true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ban, exists := g.bans[ip]
+	if !exists || !time.Now().Before(ban.Until) {
+		return true
+	}
+	ban.Rejected++
+	return false
+}
+
+// Fail 记录一次认证失败，user 为已知的用户名，未知时为空
+func (g *AuthGuard) Fail(addr net.Addr, user, reason string) {
+	actor := actorUnknown
+	if user != "" {
+		actor = userActor(user)
+	}
+	g.audit.Record(audit.Event{
+		Action: audit.ActionAuthFailure,
+		Actor:  actor,
+		Source: addrIP(addr.String()),
+		Detail: reason,
+		Failed: true,
+	})
+
+	ip, ok := remoteIP(addr)
+	if !ok {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	g.prune(now)
+
+	if user != "" && g.userAttempts > 0 {
+		f, exists := g.users[user]
+		if !exists {
+			f = &failures{first: now}
+			g.users[user] = f
+		}
+		if n := f.add(now, g.window); n >= g.userAttempts {
+			if n == g.userAttempts {
+				slog.Warn("User reached the authentication failure limit, banning every failing source", "user", user, "failures", n, "window", g.window)
+			}
+			g.banIP(ip, fmt.Sprintf("%s (user %s under attack)", reason, user), now)
+			return
+		}
+	}
+	if g.ipAttempts > 0 {
+		f, exists := g.ips[ip]
+		if !exists {
+			f = &failures{first: now}
+			g.ips[ip] = f
+		}
+		if f.add(now, g.window) >= g.ipAttempts {
+			g.banIP(ip, reason, now)
+		}
+	}
+}
+
+// Succeed 认证成功，清除该 IP 的失败次数
+func (g *AuthGuard) Succeed(addr net.Addr) {
+	ip, ok := remoteIP(addr)
+	if !ok {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.ips, ip)
+}
+
+// add 累加一次失败并返回窗口内的累计次数，窗口已过时重新计数
+func (f *failures) add(now time.Time, window time.Duration) int {
+	if now.Sub(f.first) > window {
+		f.count, f.first = 0, now
+	}
+	f.count++
+	return f.count
+}
+
+// banIP 封禁 IP，调用方需持有锁
+func (g *AuthGuard) banIP(ip netip.Addr, reason string, now time.Time) {
+	delete(g.ips, ip)
+
+	ban, exists := g.bans[ip]
+	if !exists {
+		ban = &Ban{IP: ip.String()}
+		g.bans[ip] = ban
+	}
+	duration := g.block
+	for i := 0; i < ban.Count && duration < g.maxBlock; i++ {
+		duration *= 2
+	}
+	if duration > g.maxBlock {
+		duration = g.maxBlock
+	}
+
+	ban.Count++
+	ban.Reason = reason
+	ban.Rejected = 0
+	ban.Since = now
+	ban.Until = now.Add(duration)
+	slog.Warn("Banned after repeated authentication failures", "ip", ip, "duration", duration, "reason", reason)
+	g.audit.Record(audit.Event{
+		Action: audit.ActionBan,
+		Actor:  actorSystem,
+		Source: ip.String(),
+		Target: ip.String(),
+		Detail: fmt.Sprintf("%s, banned for %s", reason, duration),
+	})
+
+	if err := g.persist(); err != nil {
+		slog.Error("Failed to persist bans", "err", err)
+	}
+}
+
+// prune 清理过期的失败记录和封禁历史，每个时间窗口最多执行一次，调用方需持有锁
+func (g *AuthGuard) prune(now time.Time) {
+	if now.Sub(g.pruned) < g.window {
+		return
+	}
+	g.pruned = now
+
+	for ip, f := range g.ips {
+		if now.Sub(f.first) > g.window {
+			delete(g.ips, ip)
+		}
+	}
+	for user, f := range g.users {
+		if now.Sub(f.first) > g.window {
+			delete(g.users, user)
+		}
+	}
+
+	changed := false
+	for ip, ban := range g.bans {
+		if now.Sub(ban.Until) > g.maxBlock {
+			delete(g.bans, ip)
+			changed = true
+		}
+	}
+	if changed {
+		if err := g.persist(); err != nil {
+			slog.Error("Failed to persist bans", "err", err)
+		}
+	}
+}
+
+// List 返回生效中的封禁，按到期时间排序
+func (g *AuthGuard) List() []Ban {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	bans := make([]Ban, 0, len(g.bans))
+	for _, ban := range g.bans {
+		if now.Before(ban.Until) {
+			bans = append(bans, *ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Until.Before(bans[j].Until) })
+	return bans
+}
+
+// Unban 解除封禁并清除该 IP 的封禁历史
+func (g *AuthGuard) Unban(value string) error {
+	ip, err := netip.ParseAddr(value)
+	if err != nil {
+		return fmt.Errorf("invalid ip %q", value)
+	}
+	ip = ip.Unmap()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ban, exists := g.bans[ip]
+	if !exists || !time.Now().Before(ban.Until) {
+		return fmt.Errorf("%w: %s", ErrBanNotFound, ip)
+	}
+	delete(g.bans, ip)
+	delete(g.ips, ip)
+	slog.Info("Unbanned", "ip", ip)
+	return g.persist()
+}
+
+// persist 保存封禁记录，调用方需持有锁
+func (g *AuthGuard) persist() error {
+	if g.store == nil {
+		return nil
+	}
+
+	bans := make([]*Ban, 0, len(g.bans))
+	for _, ban := range g.bans {
+		bans = append(bans, ban)
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].IP < bans[j].IP })
+
+	if err := g.store.Put(storeKeyBans, bans); err != nil {
+		return fmt.Errorf("failed to persist bans: %w", err)
+	}
+	return nil
+}
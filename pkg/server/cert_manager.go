@@ -0,0 +1,427 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/store"
+)
+
+// 证书记录在状态存储中的键
+const (
+	storeKeyRevokedCerts = "revoked_certs" // 吊销列表
+	storeKeyIssuedCerts  = "issued_certs"  // 签发的证书的序列号到 NotAfter 的映射，证书过期后删除
+)
+
+var (
+	// ErrCertRevoked 证书已被吊销
+	ErrCertRevoked = errors.New("certificate is revoked")
+	// ErrCertNotIssued 证书不是内置 CA 签发的
+	ErrCertNotIssued = errors.New("certificate is not issued by the built-in CA")
+)
+
+// CertRevocation 吊销列表中的一项
+//
+// 吊销记录保留到证书过期（Expires 即证书的 NotAfter）后删除。不是由运行中的服务端签发的证书
+// （如 cert issue 命令签发的）无法得知有效期，保留到 CA 证书过期。
+type CertRevocation struct {
+	Serial    string    `json:"serial"`
+	Reason    string    `json:"reason,omitempty"`
+	RevokedAt time.Time `json:"revoked_at"`
+	Expires   time.Time `json:"expires"`
+}
+
+// IssuedCert 签发的证书
+type IssuedCert struct {
+	Serial      string    `json:"serial"`
+	CommonName  string    `json:"common_name"`
+	NotAfter    time.Time `json:"not_after"`
+	Certificate string    `json:"certificate"`           // PEM
+	PrivateKey  string    `json:"private_key,omitempty"` // PEM，由 CA 生成私钥时返回
+
+	cert *x509.Certificate
+}
+
+// CertManager 内置 CA，签发客户端证书并维护吊销列表
+type CertManager struct {
+	cert    *x509.Certificate
+	key     crypto.Signer
+	ttl     time.Duration
+	store   *store.Store
+	revoked map[string]CertRevocation // 按序列号索引
+	issued  map[string]time.Time      // 签发的证书的 NotAfter，按序列号索引
+	mu      sync.RWMutex
+}
+
+// LoadCertManager 加载 CA 证书和私钥，文件不存在时生成，st 为 nil 时不读取吊销列表
+func LoadCertManager(cfg *config.CertManagerConfig, st *store.Store) (*CertManager, bool, error) {
+	ttl, err := cfg.TTL()
+	if err != nil {
+		return nil, false, err
+	}
+
+	certFile := cfg.CACertFile
+	if certFile == "" {
+		certFile = "data/ca.crt"
+	}
+	keyFile := cfg.CAKeyFile
+	if keyFile == "" {
+		keyFile = "data/ca.key"
+	}
+
+	cert, key, created, err := loadOrCreateCA(certFile, keyFile)
+	if err != nil {
+		return nil, false, err
+	}
+
+	cm := &CertManager{
+		cert:    cert,
+		key:     key,
+		ttl:     ttl,
+		store:   st,
+		revoked: make(map[string]CertRevocation),
+		issued:  make(map[string]time.Time),
+	}
+
+	if st != nil {
+		var persisted []CertRevocation
+		if _, err := st.Get(storeKeyRevokedCerts, &persisted); err != nil {
+			return nil, false, err
+		}
+		now := time.Now()
+		for _, revocation := range persisted {
+			if revocation.Expires.After(now) {
+				cm.revoked[revocation.Serial] = revocation
+			}
+		}
+		var issued map[string]time.Time
+		if _, err := st.Get(storeKeyIssuedCerts, &issued); err != nil {
+			return nil, false, err
+		}
+		for serial, notAfter := range issued {
+			if notAfter.After(now) {
+				cm.issued[serial] = notAfter
+			}
+		}
+	}
+
+	return cm, created, nil
+}
+
+// loadOrCreateCA 读取 PEM 格式的 CA 证书和私钥，两者都不存在时生成自签名 CA
+func loadOrCreateCA(certFile, keyFile string) (*x509.Certificate, crypto.Signer, bool, error) {
+	certPEM, certErr := os.ReadFile(certFile)
+	keyPEM, keyErr := os.ReadFile(keyFile)
+	if os.IsNotExist(certErr) && os.IsNotExist(keyErr) {
+		cert, key, err := createCA(certFile, keyFile)
+		return cert, key, true, err
+	}
+	if certErr != nil {
+		return nil, nil, false, fmt.Errorf("failed to read CA certificate: %w", certErr)
+	}
+	if keyErr != nil {
+		return nil, nil, false, fmt.Errorf("failed to read CA key: %w", keyErr)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, nil, false, fmt.Errorf("invalid CA certificate %s", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("invalid CA certificate %s: %w", certFile, err)
+	}
+
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, false, fmt.Errorf("invalid CA key %s", keyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("invalid CA key %s: %w", keyFile, err)
+	}
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, nil, false, fmt.Errorf("unsupported CA key type %T", parsed)
+	}
+
+	return cert, key, false, nil
+}
+
+// createCA 生成有效期十年的自签名 CA 并保存
+func createCA(certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "AetherTunnel Client CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := writePEM(keyFile, "PRIVATE KEY", keyDER, 0600); err != nil {
+		return nil, nil, err
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der, 0644); err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// writePEM 以 PEM 格式写入文件
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// randomSerial 生成 128 位随机序列号
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// certSerial 返回证书序列号的十六进制表示，作为吊销列表的键
+func certSerial(cert *x509.Certificate) string {
+	return cert.SerialNumber.Text(16)
+}
+
+// CertificateCommonName 返回客户端证书的 CN，客户端标识为空时只包含用户名
+func CertificateCommonName(user, clientID string) string {
+	if clientID == "" {
+		return user
+	}
+	return user + "/" + clientID
+}
+
+// CACertificate 返回 CA 证书
+func (cm *CertManager) CACertificate() *x509.Certificate {
+	return cm.cert
+}
+
+// TTL 返回签发证书的有效期上限
+func (cm *CertManager) TTL() time.Duration {
+	return cm.ttl
+}
+
+// Sign 为公钥签发客户端证书，ttl 为 0 或超过 cert_ttl 时使用 cert_ttl
+func (cm *CertManager) Sign(commonName string, public crypto.PublicKey, ttl time.Duration) (*x509.Certificate, error) {
+	if ttl <= 0 || ttl > cm.ttl {
+		ttl = cm.ttl
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	// 允许少量时钟偏差；提前量须小于有效期，否则客户端按剩余三分之一续期会立即再次续期
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(ttl),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, cm.cert, public, cm.key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	cm.track(cert)
+	return cert, nil
+}
+
+// track 记录签发的证书的有效期，吊销时据此决定吊销记录保留多久
+func (cm *CertManager) track(cert *x509.Certificate) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	now := time.Now()
+	for serial, notAfter := range cm.issued {
+		if !notAfter.After(now) {
+			delete(cm.issued, serial)
+		}
+	}
+	cm.issued[certSerial(cert)] = cert.NotAfter
+	if cm.store == nil {
+		return
+	}
+	// 保存失败时本次运行中的吊销仍使用正确的有效期，重启后按 CA 证书的有效期保留
+	if err := cm.store.Put(storeKeyIssuedCerts, cm.issued); err != nil {
+		slog.Warn("Failed to persist issued certificates", "serial", certSerial(cert), "err", err)
+	}
+}
+
+// Issue 生成私钥并签发客户端证书
+func (cm *CertManager) Issue(commonName string, ttl time.Duration) (*IssuedCert, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := cm.Sign(commonName, &key.PublicKey, ttl)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	issued := newIssuedCert(cert)
+	issued.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	return issued, nil
+}
+
+// SignCSR 校验 PEM 格式的证书签名请求并为其公钥签发证书，CN 由调用方决定
+func (cm *CertManager) SignCSR(csrPEM, commonName string, ttl time.Duration) (*IssuedCert, error) {
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("invalid certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate request: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid certificate request: %w", err)
+	}
+
+	cert, err := cm.Sign(commonName, csr.PublicKey, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return newIssuedCert(cert), nil
+}
+
+// newIssuedCert 转换为 API 返回的格式
+func newIssuedCert(cert *x509.Certificate) *IssuedCert {
+	return &IssuedCert{
+		Serial:      certSerial(cert),
+		CommonName:  cert.Subject.CommonName,
+		NotAfter:    cert.NotAfter,
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+		cert:        cert,
+	}
+}
+
+// Issued 判断证书是否由内置 CA 签发
+func (cm *CertManager) Issued(cert *x509.Certificate) bool {
+	return cert.CheckSignatureFrom(cm.cert) == nil
+}
+
+// Check 检查证书是否已被吊销，在 TLS 握手中调用
+func (cm *CertManager) Check(cert *x509.Certificate) error {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	if _, revoked := cm.revoked[certSerial(cert)]; revoked {
+		return fmt.Errorf("%w: %s", ErrCertRevoked, certSerial(cert))
+	}
+	return nil
+}
+
+// Revoke 吊销证书并持久化吊销列表
+func (cm *CertManager) Revoke(serial, reason string) (CertRevocation, error) {
+	parsed, ok := new(big.Int).SetString(serial, 16)
+	if !ok || parsed.Sign() <= 0 {
+		return CertRevocation{}, fmt.Errorf("invalid serial %q", serial)
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	revocation := CertRevocation{
+		Serial:    parsed.Text(16),
+		Reason:    reason,
+		RevokedAt: time.Now(),
+		Expires:   cm.cert.NotAfter,
+	}
+	if notAfter, exists := cm.issued[revocation.Serial]; exists {
+		revocation.Expires = notAfter
+	}
+	cm.revoked[revocation.Serial] = revocation
+	return revocation, cm.persist()
+}
+
+// Revoked 返回按吊销时间排序的吊销列表，顺带删除过期的记录
+func (cm *CertManager) Revoked() []CertRevocation {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	now := time.Now()
+	revoked := make([]CertRevocation, 0, len(cm.revoked))
+	for serial, revocation := range cm.revoked {
+		if !revocation.Expires.After(now) {
+			delete(cm.revoked, serial)
+			continue
+		}
+		revoked = append(revoked, revocation)
+	}
+	sort.Slice(revoked, func(i, j int) bool { return revoked[i].RevokedAt.Before(revoked[j].RevokedAt) })
+	return revoked
+}
+
+// persist 保存吊销列表，调用方需持有锁
+func (cm *CertManager) persist() error {
+	if cm.store == nil {
+		return nil
+	}
+
+	revoked := make([]CertRevocation, 0, len(cm.revoked))
+	for _, revocation := range cm.revoked {
+		revoked = append(revoked, revocation)
+	}
+	sort.Slice(revoked, func(i, j int) bool { return revoked[i].Serial < revoked[j].Serial })
+	if err := cm.store.Put(storeKeyRevokedCerts, revoked); err != nil {
+		return fmt.Errorf("failed to persist revoked certificates: %w", err)
+	}
+	return nil
+}
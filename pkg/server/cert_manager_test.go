@@ -0,0 +1,54 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/store"
+)
+
+func TestRevocationExpiresWithCertificate(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.CertManagerConfig{
+		CACertFile: filepath.Join(dir, "ca.crt"),
+		CAKeyFile:  filepath.Join(dir, "ca.key"),
+		CertTTL:    "1h",
+	}
+	st, err := store.Open(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm, _, err := LoadCertManager(cfg, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issued, err := cm.Issue("alice/laptop", 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocation, err := cm.Revoke(issued.Serial, "lost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 吊销记录保留到证书过期，与签发时的有效期无关
+	if !revocation.Expires.Equal(issued.NotAfter) {
+		t.Errorf("Expected the revocation to expire at %v, got %v", issued.NotAfter, revocation.Expires)
+	}
+
+	// 重启后仍然知道证书的 NotAfter
+	reloaded, _, err := LoadCertManager(cfg, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revocation, _ := reloaded.Revoke(issued.Serial, "lost"); !revocation.Expires.Equal(issued.NotAfter) {
+		t.Errorf("Expected the reloaded revocation to expire at %v, got %v", issued.NotAfter, revocation.Expires)
+	}
+
+	// 未记录的证书保留到 CA 过期
+	if revocation, _ := cm.Revoke("1234abcd", "unknown"); !revocation.Expires.Equal(cm.CACertificate().NotAfter) {
+		t.Errorf("Expected an unknown serial to expire with the CA at %v, got %v", cm.CACertificate().NotAfter, revocation.Expires)
+	}
+}
@@ -1,29 +1,33 @@
 package server
 
 import (
+	"crypto/x509"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/aethertunnel/aethertunnel/pkg/audit"
 	"github.com/aethertunnel/aethertunnel/pkg/config"
 	"github.com/aethertunnel/aethertunnel/pkg/crypto"
+	tunnelnet "github.com/aethertunnel/aethertunnel/pkg/net"
 	"github.com/aethertunnel/aethertunnel/pkg/protocol"
 )
 
-// maskToken 隐藏认证令牌的一部分
-func maskToken(token string) string {
-	if len(token) <= 8 {
-		return token
-	}
-	return token[:4] + "****" + token[len(token)-4:]
-}
-
 // ControlConnection 控制连接
 type ControlConnection struct {
 	conn          net.Conn
 	remoteAddr    string
+	clientID      string
+	user          *User                            // 握手中认证的用户
+	cipherSuite   crypto.CipherSuite               // 握手协商的传输加密算法
+	keyExchange   string                           // 握手使用的密钥交换
+	cert          atomic.Pointer[x509.Certificate] // TLS 客户端证书，续期后替换为新证书
 	authenticated bool
+	lastSeen      time.Time
+	session       *tunnelnet.Session // 控制连接上复用的流
 	mu            sync.RWMutex
 }
 
@@ -32,127 +36,375 @@ func NewControlConnection(conn net.Conn) *ControlConnection {
 		conn:          conn,
 		remoteAddr:    conn.RemoteAddr().String(),
 		authenticated: false,
+		lastSeen:      time.Now(),
 	}
 }
 
+// WriteMessage 向客户端发送消息，可并发调用
+func (c *ControlConnection) WriteMessage(msg *protocol.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return protocol.WriteMessage(c.conn, msg)
+}
+
+// ClientID 返回客户端标识
+func (c *ControlConnection) ClientID() string {
+	return c.clientID
+}
+
+// User 返回客户端登录的用户名
+func (c *ControlConnection) User() string {
+	return c.user.Name
+}
+
 // ControlManager 控制管理器
 type ControlManager struct {
-	connections map[string]*ControlConnection
+	connections map[string]*ControlConnection // 按客户端标识索引
 	config      *config.Config
-	encryption  *crypto.Encryption
+	proxies     *ProxyManager
+	egress      *EgressPolicy
+	forward     *EgressPolicy
+	forwards    map[string]*forwardStat // 按 "客户端/转发名" 索引
+	users       *UserManager
+	certs       *CertManager
+	audit       *audit.Log
 	mu          sync.RWMutex
+	statsMu     sync.Mutex
 }
 
-func NewControlManager(cfg *config.Config, encryption *crypto.Encryption) *ControlManager {
+func NewControlManager(cfg *config.Config) *ControlManager {
+	egress, err := NewEgressPolicy(&cfg.Egress)
+	if err != nil {
+		slog.Error("Invalid egress policy, egress is disabled", "err", err)
+		egress = &EgressPolicy{}
+	}
+	forward, err := NewEgressPolicy(&cfg.Forward)
+	if err != nil {
+		slog.Error("Invalid forward policy, forwards are disabled", "err", err)
+		forward = &EgressPolicy{}
+	}
+
 	return &ControlManager{
 		connections: make(map[string]*ControlConnection),
 		config:      cfg,
-		encryption:  encryption,
+		egress:      egress,
+		forward:     forward,
+		forwards:    make(map[string]*forwardStat),
 	}
 }
 
-// HandleConnection 处理新的控制连接
-func (cm *ControlManager) HandleConnection(conn net.Conn) {
-	connID := conn.RemoteAddr().String()
+// HandleControl 处理以认证消息开头的控制连接，直到连接断开
+func (cm *ControlManager) HandleControl(conn net.Conn, authPayload []byte, p *peer) {
+	defer conn.Close()
 
+	connObj := NewControlConnection(conn)
+	connObj.user = p.user
+	connObj.cipherSuite = p.suite
+	connObj.keyExchange = p.kex
+	connObj.cert.Store(p.cert)
+	if !cm.handleAuth(connObj, authPayload, p) {
+		return
+	}
+
+	if !cm.addConnection(connObj) {
+		return
+	}
+	defer cm.removeConnection(connObj)
+
+	connObj.session = tunnelnet.NewSession(false, func(frame []byte) error {
+		return connObj.WriteMessage(&protocol.Message{Type: protocol.MessageTypeStream, Payload: frame})
+	}, func(stream *tunnelnet.Stream, meta []byte) {
+		cm.handleStream(connObj, stream, meta)
+	})
+	defer connObj.session.Close()
+
+	slog.Info("Client online", "client", connObj.clientID, "user", connObj.user.Name, "remote", connObj.remoteAddr, "key_exchange", connObj.keyExchange, "cipher_suite", connObj.cipherSuite)
+	cm.record(connObj, audit.Event{
+		Action: audit.ActionLogin,
+		Target: connObj.clientID,
+		Detail: fmt.Sprintf("%s, %s", connObj.keyExchange, connObj.cipherSuite),
+	})
+	closeReason := "connection closed"
+	defer func() {
+		cm.record(connObj, audit.Event{Action: audit.ActionLogout, Target: connObj.clientID, Detail: closeReason})
+	}()
+	if cm.proxies != nil {
+		cm.proxies.ClientOnline(connObj.clientID, connObj.user)
+	}
+
+	for {
+		msg, err := protocol.ReadMessage(conn)
+		if err != nil {
+			slog.Info("Control connection closed", "client", connObj.clientID, "user", connObj.user.Name, "err", err)
+			closeReason = err.Error()
+			return
+		}
+
+		switch msg.Type {
+		case protocol.MessageTypeHeartbeat:
+			cm.handleHeartbeat(connObj)
+
+		case protocol.MessageTypeNewProxy:
+			cm.handleNewProxy(connObj, msg)
+
+		case protocol.MessageTypeStream:
+			if err := connObj.session.HandleFrame(msg.Payload); err != nil {
+				slog.Warn("Invalid stream frame", "client", connObj.clientID, "err", err)
+				closeReason = fmt.Sprintf("invalid stream frame: %v", err)
+				return
+			}
+
+		case protocol.MessageTypeCloseProxy:
+			var req protocol.CloseProxyPayload
+			if err := msg.DecodeJSON(&req); err != nil {
+				slog.Warn("Invalid close proxy request", "client", connObj.clientID, "err", err)
+				continue
+			}
+			if cm.proxies.UnregisterProxy(connObj.clientID, req.Name) {
+				cm.record(connObj, audit.Event{Action: audit.ActionProxyDelete, Target: req.Name})
+			}
+
+		case protocol.MessageTypeCertRenew:
+			cm.handleCertRenew(connObj, msg)
+
+		default:
+			slog.Warn("Unexpected message type on control connection", "type", msg.Type, "remote", connObj.remoteAddr)
+		}
+	}
+}
+
+// handleAuth 处理认证
+//
+// 连接已经在 Noise 握手中完成认证，这里只登记客户端标识。server.authorized_keys
+// 中的密钥只能使用其绑定的标识。
+func (cm *ControlManager) handleAuth(conn *ControlConnection, payload []byte, p *peer) bool {
+	var auth protocol.AuthPayload
+	if err := (&protocol.Message{Payload: payload}).DecodeJSON(&auth); err != nil {
+		slog.Warn("Invalid auth request", "remote", conn.remoteAddr, "err", err)
+		return false
+	}
+
+	if p.clientID != "" {
+		if auth.ClientID == "" {
+			auth.ClientID = p.clientID
+		}
+		if auth.ClientID != p.clientID {
+			slog.Warn("Client uses the key of another client", "client", auth.ClientID, "remote", conn.remoteAddr, "key_client", p.clientID)
+
+			// 发送认证失败消息
+			errMsg := protocol.NewErrorMessage("client id does not match key")
+			if err := conn.WriteMessage(errMsg); err != nil {
+				slog.Debug("Failed to write error message", "remote", conn.remoteAddr, "err", err)
+			}
+			return false
+		}
+	}
+	if auth.ClientID == "" {
+		auth.ClientID = conn.remoteAddr
+	}
+
+	// 标记为已认证
+	conn.authenticated = true
+	conn.clientID = auth.ClientID
+	slog.Debug("Client authenticated", "remote", conn.remoteAddr, "user", conn.user.Name)
+
+	// 发送认证成功消息
+	successMsg := protocol.NewAuthMessage("OK")
+	if err := conn.WriteMessage(successMsg); err != nil {
+		slog.Warn("Failed to write auth success message", "remote", conn.remoteAddr, "err", err)
+		return false
+	}
+
+	return true
+}
+
+// addConnection 登记已认证的控制连接，同一客户端的旧连接会被替换
+func (cm *ControlManager) addConnection(conn *ControlConnection) bool {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
+	old, exists := cm.connections[conn.clientID]
+
+	// 客户端标识被其他用户占用
+	if exists && old.user.Name != conn.user.Name {
+		slog.Warn("Client rejected, id is in use by another user", "client", conn.clientID, "user", conn.user.Name, "owner", old.user.Name)
+		errMsg := protocol.NewErrorMessage("client id is in use by another user")
+		if err := conn.WriteMessage(errMsg); err != nil {
+			slog.Debug("Failed to write error message", "remote", conn.remoteAddr, "err", err)
+		}
+		return false
+	}
+
+	// 握手之后用户可能已被停用
+	if _, enabled := cm.users.Get(conn.user.Name); !enabled {
+		slog.Warn("Client rejected, user is disabled", "client", conn.clientID, "user", conn.user.Name)
+		return false
+	}
+
+	// 超过用户的客户端数上限
+	if limit := conn.user.MaxClients; limit > 0 && !exists {
+		online := 0
+		for _, c := range cm.connections {
+			if c.user.Name == conn.user.Name {
+				online++
+			}
+		}
+		if online >= limit {
+			slog.Warn("Client rejected, user reached max_clients", "client", conn.clientID, "user", conn.user.Name, "max_clients", limit)
+			errMsg := protocol.NewErrorMessage("too many clients for user")
+			if err := conn.WriteMessage(errMsg); err != nil {
+				slog.Debug("Failed to write error message", "remote", conn.remoteAddr, "err", err)
+			}
+			return false
+		}
+	}
+
 	// 超过最大连接数
-	maxConnections := 100
-	if len(cm.connections) >= maxConnections {
-		log.Printf("Too many connections, rejecting: %s", connID)
+	maxConnections := cm.config.Server.MaxConnections
+	if maxConnections <= 0 {
+		maxConnections = 100
+	}
+	if !exists && len(cm.connections) >= maxConnections {
+		slog.Warn("Too many connections, rejecting", "remote", conn.remoteAddr)
 		errMsg := protocol.NewErrorMessage("too many connections")
-		if err := protocol.WriteMessage(conn, errMsg); err != nil {
-			log.Printf("Failed to write error message: %v", err)
+		if err := conn.WriteMessage(errMsg); err != nil {
+			slog.Debug("Failed to write error message", "remote", conn.remoteAddr, "err", err)
 		}
-		conn.Close()
-		return
+		return false
 	}
 
-	// 添加到连接池
-	connObj := NewControlConnection(conn)
-	cm.connections[connID] = connObj
-
-	log.Printf("New control connection: %s (total: %d)", connID, len(cm.connections))
+	if exists {
+		slog.Info("Client reconnected, closing old session", "client", conn.clientID, "remote", conn.remoteAddr)
+		old.conn.Close()
+	}
 
-	// 启动连接处理
-	go cm.handleConnection(connObj)
+	cm.connections[conn.clientID] = conn
+	slog.Info("New control connection", "client", conn.clientID, "user", conn.user.Name, "total", len(cm.connections))
+	return true
 }
 
-// handleConnection 处理连接
-func (cm *ControlManager) handleConnection(conn *ControlConnection) {
-	defer conn.conn.Close()
+// removeConnection 移除控制连接并下线客户端的代理
+func (cm *ControlManager) removeConnection(conn *ControlConnection) {
+	cm.mu.Lock()
+	current, exists := cm.connections[conn.clientID]
+	if exists && current == conn {
+		delete(cm.connections, conn.clientID)
+	}
+	cm.mu.Unlock()
 
-	// 读取第一个消息（应该是认证）
-	msg, err := protocol.ReadMessage(conn.conn)
-	if err != nil {
-		log.Printf("Failed to read message: %v", err)
-		return
+	// 已被新连接替换时不下线代理
+	if exists && current == conn && cm.proxies != nil {
+		cm.proxies.ClientOffline(conn.clientID)
 	}
+}
 
-	log.Printf("Received message type: %d from %s", msg.Type, conn.remoteAddr)
+// handleHeartbeat 处理心跳
+func (cm *ControlManager) handleHeartbeat(conn *ControlConnection) {
+	if !conn.authenticated {
+		slog.Warn("Heartbeat from unauthenticated client", "remote", conn.remoteAddr)
+		return
+	}
 
-	switch msg.Type {
-	case protocol.MessageTypeAuth:
-		// 处理认证
-		cm.handleAuth(conn, msg.Payload)
+	conn.lastSeen = time.Now()
+	if err := conn.WriteMessage(protocol.NewHeartbeatMessage()); err != nil {
+		slog.Warn("Failed to write heartbeat", "client", conn.clientID, "err", err)
+	}
+}
 
-	case protocol.MessageTypeHeartbeat:
-		// 心跳消息
-		cm.handleHeartbeat(conn)
+// handleNewProxy 处理客户端注册代理
+func (cm *ControlManager) handleNewProxy(conn *ControlConnection, msg *protocol.Message) {
+	var proxyCfg config.ProxyConfig
+	if err := msg.DecodeJSON(&proxyCfg); err != nil {
+		slog.Warn("Invalid proxy registration", "client", conn.clientID, "err", err)
+		return
+	}
 
-	case protocol.MessageTypeProxy, protocol.MessageTypeData:
-		// 数据消息（不应该出现在控制连接中）
-		log.Printf("Unexpected data message on control connection: %s", conn.remoteAddr)
-		errMsg := protocol.NewErrorMessage("unexpected message type")
-		if err := protocol.WriteMessage(conn.conn, errMsg); err != nil {
-			log.Printf("Failed to write error message: %v", err)
+	if err := cm.proxies.RegisterProxy(conn.user, conn.clientID, proxyCfg); err != nil {
+		slog.Warn("Failed to register proxy", "proxy", proxyCfg.Name, "client", conn.clientID, "user", conn.user.Name, "err", err)
+		cm.record(conn, audit.Event{Action: audit.ActionProxyCreate, Target: proxyCfg.Name, Detail: err.Error(), Failed: true, After: proxyCfg})
+		errMsg := protocol.NewErrorMessage(fmt.Sprintf("proxy %s: %v", proxyCfg.Name, err))
+		if err := conn.WriteMessage(errMsg); err != nil {
+			slog.Debug("Failed to write error message", "remote", conn.remoteAddr, "err", err)
 		}
-
-	default:
-		log.Printf("Unknown message type: %d", msg.Type)
+		return
 	}
+
+	slog.Info("Proxy registered", "proxy", proxyCfg.Name, "client", conn.clientID, "user", conn.user.Name)
+	cm.record(conn, audit.Event{Action: audit.ActionProxyCreate, Target: proxyCfg.Name, After: proxyCfg})
 }
 
-// handleAuth 处理认证
-func (cm *ControlManager) handleAuth(conn *ControlConnection, payload []byte) {
-	token := string(payload)
+// record 记录客户端触发的审计事件，操作者为连接登录的用户
+func (cm *ControlManager) record(conn *ControlConnection, ev audit.Event) {
+	ev.Actor = userActor(conn.user.Name)
+	ev.Source = addrIP(conn.remoteAddr)
+	cm.audit.Record(ev)
+}
 
-	log.Printf("Auth attempt from %s: %s", conn.remoteAddr, maskToken(token))
+// handleCertRenew 为连接使用的客户端证书续期
+//
+// 只续期内置 CA 签发的证书，新证书与当前证书同名，公钥来自客户端的证书签名请求。
+func (cm *ControlManager) handleCertRenew(conn *ControlConnection, msg *protocol.Message) {
+	var req protocol.CertRenewPayload
+	if err := msg.DecodeJSON(&req); err != nil {
+		slog.Warn("Invalid certificate renewal", "client", conn.clientID, "err", err)
+		return
+	}
 
-	// 验证令牌
-	if token != cm.config.Server.AuthToken {
-		log.Printf("Invalid auth token: %s", token)
+	var resp protocol.CertRenewPayload
+	current := conn.cert.Load()
 
-		// 发送认证失败消息
-		errMsg := protocol.NewErrorMessage("invalid auth token")
-		if err := protocol.WriteMessage(conn.conn, errMsg); err != nil {
-			log.Printf("Failed to write error message: %v", err)
+	switch {
+	case cm.certs == nil:
+		resp.Error = "certificate manager is disabled"
+	case current == nil:
+		resp.Error = "connection has no client certificate"
+	case !cm.certs.Issued(current):
+		resp.Error = ErrCertNotIssued.Error()
+	default:
+		issued, err := cm.certs.SignCSR(req.CSR, current.Subject.CommonName, 0)
+		if err != nil {
+			resp.Error = err.Error()
+			break
 		}
-		return
-	}
+		resp.Certificate = issued.Certificate
+		slog.Info("Renewed certificate", "subject", current.Subject.CommonName, "client", conn.clientID, "user", conn.user.Name, "serial", issued.Serial, "expires", issued.NotAfter.Format(time.RFC3339))
 
-	// 标记为已认证
-	conn.authenticated = true
-	log.Printf("Client %s authenticated successfully", conn.remoteAddr)
+		cm.record(conn, audit.Event{
+			Action: audit.ActionCertIssue,
+			Target: current.Subject.CommonName,
+			Detail: fmt.Sprintf("renewed, serial %s, expires %s", issued.Serial, issued.NotAfter.Format(time.RFC3339)),
+		})
 
-	// 发送认证成功消息
-	successMsg := protocol.NewAuthMessage("OK")
-	if err := protocol.WriteMessage(conn.conn, successMsg); err != nil {
-		log.Printf("Failed to write auth success message: %v", err)
+		// 之后按新证书的序列号吊销
+		conn.cert.Store(issued.cert)
+	}
+	if resp.Error != "" {
+		slog.Warn("Certificate renewal failed", "client", conn.clientID, "user", conn.user.Name, "err", resp.Error)
+	}
+
+	reply, err := protocol.NewJSONMessage(protocol.MessageTypeCertRenew, &resp)
+	if err != nil {
 		return
 	}
+	if err := conn.WriteMessage(reply); err != nil {
+		slog.Warn("Failed to write certificate renewal", "client", conn.clientID, "err", err)
+	}
 }
 
-// handleHeartbeat 处理心跳
-func (cm *ControlManager) handleHeartbeat(conn *ControlConnection) {
-	if !conn.authenticated {
-		log.Printf("Heartbeat from unauthenticated client: %s", conn.remoteAddr)
-		return
+// Send 通过控制连接向客户端发送消息
+func (cm *ControlManager) Send(clientID string, msg *protocol.Message) error {
+	conn, exists := cm.GetConnection(clientID)
+	if !exists {
+		return fmt.Errorf("client %s is not connected", clientID)
 	}
+	return conn.WriteMessage(msg)
+}
 
-	log.Printf("Heartbeat from %s", conn.remoteAddr)
+// IsOnline 判断客户端是否在线
+func (cm *ControlManager) IsOnline(clientID string) bool {
+	_, exists := cm.GetConnection(clientID)
+	return exists
 }
 
 // GetConnection 获取连接
@@ -164,6 +416,44 @@ func (cm *ControlManager) GetConnection(id string) (*ControlConnection, bool) {
 	return conn, exists
 }
 
+// GetConnections 获取所有连接
+func (cm *ControlManager) GetConnections() []*ControlConnection {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	connections := make([]*ControlConnection, 0, len(cm.connections))
+	for _, conn := range cm.connections {
+		connections = append(connections, conn)
+	}
+	return connections
+}
+
+// KickUser 关闭用户的所有控制连接，读循环退出后会下线其代理
+func (cm *ControlManager) KickUser(name string) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	for _, conn := range cm.connections {
+		if conn.user.Name == name {
+			slog.Info("Kicking client", "client", conn.clientID, "user", name)
+			conn.conn.Close()
+		}
+	}
+}
+
+// KickCert 关闭使用该证书的控制连接
+func (cm *ControlManager) KickCert(serial string) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	for _, conn := range cm.connections {
+		if cert := conn.cert.Load(); cert != nil && certSerial(cert) == serial {
+			slog.Info("Kicking client, certificate revoked", "client", conn.clientID, "user", conn.user.Name, "serial", serial)
+			conn.conn.Close()
+		}
+	}
+}
+
 // RemoveConnection 移除连接
 func (cm *ControlManager) RemoveConnection(id string) error {
 	cm.mu.Lock()
@@ -174,7 +464,7 @@ func (cm *ControlManager) RemoveConnection(id string) error {
 		return fmt.Errorf("connection %s not found", id)
 	}
 
-	// 关闭连接
+	// 关闭连接，读循环退出后会完成清理
 	conn.conn.Close()
 	return nil
 }
@@ -3,14 +3,14 @@ package server
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/aethertunnel/aethertunnel/pkg/config"
 )
 
 // StartDashboard 启动 Web 面板
-func StartDashboard(port int, cfg *config.Config) error {
+func StartDashboard(port int, cfg *config.Config, pm *ProxyManager) error {
 	// 创建文件服务器
 	fs := http.FileServer(http.Dir("../../web/dashboard"))
 
@@ -33,14 +33,19 @@ func StartDashboard(port int, cfg *config.Config) error {
 	// API 路由
 	mux.HandleFunc("/api/status", handleAPIStatus)
 	mux.HandleFunc("/api/config", handleAPIConfig)
+	registerProxyAPI(mux, &cfg.Dashboard, pm)
+	registerUserAPI(mux, &cfg.Dashboard, pm)
+	registerCertAPI(mux, &cfg.Dashboard, pm)
+	registerBanAPI(mux, &cfg.Dashboard, pm)
+	registerAuditAPI(mux, &cfg.Dashboard, pm)
 
 	// 启动服务器
-	addr := fmt.Sprintf(":%d", port)
-	log.Printf("Dashboard starting on %s", addr)
+	addr := fmt.Sprintf("%s:%d", cfg.Dashboard.BindAddr, port)
+	slog.Info("Dashboard starting", "addr", addr)
 
 	go func() {
-		if err := http.ListenAndServe(addr, mux); err != nil {
-			log.Printf("Dashboard failed to start: %v", err)
+		if err := http.ListenAndServe(addr, auditRequests(pm.audit, mux)); err != nil {
+			slog.Error("Dashboard failed to start", "addr", addr, "err", err)
 		}
 	}()
 
@@ -0,0 +1,329 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	tunnelnet "github.com/aethertunnel/aethertunnel/pkg/net"
+	"github.com/aethertunnel/aethertunnel/pkg/protocol"
+)
+
+const (
+	egressDialTimeout = 10 * time.Second
+	udpIdleTimeout    = 60 * time.Second
+	maxUDPTargets     = 1024 // 单个 UDP 关联缓存的目标地址数
+)
+
+// ErrEgressDenied 目标地址不在用户的白名单内
+var ErrEgressDenied = errors.New(protocol.StreamDenied)
+
+// egressRule 编译后的白名单规则
+type egressRule struct {
+	nets    []*net.IPNet
+	ports   []config.PortRange
+	domains []string
+}
+
+// EgressPolicy 客户端经服务端访问内网的访问控制，规则按用户名配置
+type EgressPolicy struct {
+	enabled bool
+	rules   map[string]*egressRule
+}
+
+// NewEgressPolicy 根据 [egress] 配置创建访问控制
+func NewEgressPolicy(cfg *config.EgressConfig) (*EgressPolicy, error) {
+	policy := &EgressPolicy{
+		enabled: cfg.Enabled,
+		rules:   make(map[string]*egressRule),
+	}
+
+	for user, rule := range cfg.Users {
+		compiled := &egressRule{}
+		for _, cidr := range rule.AllowCIDRs {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("egress.users.%s: invalid cidr %q", user, cidr)
+			}
+			compiled.nets = append(compiled.nets, ipNet)
+		}
+
+		ports, err := config.ParsePortRanges(rule.AllowPorts)
+		if err != nil {
+			return nil, fmt.Errorf("egress.users.%s: %w", user, err)
+		}
+		compiled.ports = ports
+
+		for _, domain := range rule.AllowDomains {
+			compiled.domains = append(compiled.domains, normalizeDomain(domain))
+		}
+		policy.rules[user] = compiled
+	}
+
+	return policy, nil
+}
+
+// normalizeDomain 统一域名大小写并去掉末尾的点
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(domain), ".")
+}
+
+// rule 返回用户适用的规则，没有时返回 nil
+func (p *EgressPolicy) rule(user string) *egressRule {
+	if rule, exists := p.rules[user]; exists {
+		return rule
+	}
+	return p.rules[config.EgressDefaultRule]
+}
+
+// allowIP 判断 IP 是否在允许的网段内
+func (r *egressRule) allowIP(ip net.IP) bool {
+	for _, ipNet := range r.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowDomain 判断域名是否在白名单内
+func (r *egressRule) allowDomain(host string) bool {
+	return matchDomain(r.domains, host)
+}
+
+// matchDomain 判断域名是否匹配列表中的域名，"*." 开头的匹配所有子域名
+func matchDomain(domains []string, host string) bool {
+	host = normalizeDomain(host)
+	for _, domain := range domains {
+		if suffix, ok := strings.CutPrefix(domain, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve 检查目标地址并返回实际连接的地址
+//
+// 未在域名白名单中的域名会先解析，连接的是通过检查的 IP，
+// 避免检查之后再次解析得到不同的地址。
+func (p *EgressPolicy) Resolve(user, address string) (string, error) {
+	if !p.enabled {
+		return "", fmt.Errorf("%w: disabled on server", ErrEgressDenied)
+	}
+
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %s: %w", address, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", fmt.Errorf("invalid port in %s", address)
+	}
+
+	rule := p.rule(user)
+	if rule == nil {
+		return "", fmt.Errorf("%w: %s", ErrEgressDenied, address)
+	}
+	if len(rule.ports) > 0 && !inRanges(rule.ports, port) {
+		return "", fmt.Errorf("%w: %s", ErrEgressDenied, address)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if rule.allowIP(ip) {
+			return address, nil
+		}
+		return "", fmt.Errorf("%w: %s", ErrEgressDenied, address)
+	}
+
+	if rule.allowDomain(host) {
+		return address, nil
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	for _, ip := range ips {
+		if rule.allowIP(ip) {
+			return net.JoinHostPort(ip.String(), portStr), nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrEgressDenied, address)
+}
+
+// resolve 按用户的域名白名单和策略检查目标地址，返回实际连接的地址
+func (cm *ControlManager) resolve(policy *EgressPolicy, conn *ControlConnection, address string) (string, error) {
+	if host, _, err := net.SplitHostPort(address); err == nil && net.ParseIP(host) == nil && !conn.user.AllowsDomain(host) {
+		return "", fmt.Errorf("%w: %s is not allowed for user %s", ErrEgressDenied, address, conn.user.Name)
+	}
+	return policy.Resolve(conn.user.Name, address)
+}
+
+// handleStream 处理客户端打开的流
+func (cm *ControlManager) handleStream(conn *ControlConnection, stream *tunnelnet.Stream, meta []byte) {
+	var req protocol.StreamOpenPayload
+	if err := json.Unmarshal(meta, &req); err != nil {
+		slog.Warn("Invalid stream request", "client", conn.clientID, "err", err)
+		stream.Reject("invalid stream request")
+		return
+	}
+	if req.Forward != "" {
+		cm.handleForwardStream(conn, stream, &req)
+		return
+	}
+
+	switch req.Network {
+	case "tcp":
+		cm.handleTCPStream(conn, stream, req.Address)
+	case "udp":
+		cm.handleUDPStream(conn, stream)
+	default:
+		stream.Reject(fmt.Sprintf("unsupported network %q", req.Network))
+	}
+}
+
+// handleTCPStream 连接目标地址并与流双向转发
+func (cm *ControlManager) handleTCPStream(conn *ControlConnection, stream *tunnelnet.Stream, address string) {
+	target, err := cm.resolve(cm.egress, conn, address)
+	if err != nil {
+		slog.Warn("Egress rejected", "client", conn.clientID, "user", conn.user.Name, "address", address, "err", err)
+		stream.Reject(err.Error())
+		return
+	}
+
+	targetConn, err := net.DialTimeout("tcp", target, egressDialTimeout)
+	if err != nil {
+		slog.Warn("Egress failed", "client", conn.clientID, "user", conn.user.Name, "address", address, "err", err)
+		stream.Reject(fmt.Sprintf("failed to connect to %s", address))
+		return
+	}
+
+	if err := stream.Accept(); err != nil {
+		targetConn.Close()
+		return
+	}
+
+	slog.Debug("Egress", "client", conn.clientID, "user", conn.user.Name, "address", address)
+	join(stream, targetConn)
+}
+
+// handleUDPStream 在流和 UDP 套接字之间转发数据报，每个数据报单独检查目标地址
+func (cm *ControlManager) handleUDPStream(conn *ControlConnection, stream *tunnelnet.Stream) {
+	if !cm.egress.enabled {
+		stream.Reject(fmt.Sprintf("%s: disabled on server", protocol.StreamDenied))
+		return
+	}
+
+	udpConn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		stream.Reject("failed to open udp socket")
+		return
+	}
+	if err := stream.Accept(); err != nil {
+		udpConn.Close()
+		return
+	}
+
+	var once sync.Once
+	closeAll := func() {
+		once.Do(func() {
+			udpConn.Close()
+			stream.Close()
+		})
+	}
+	defer closeAll()
+
+	// 目标地址到回包来源地址的映射，回包时告诉客户端数据来自哪个原始地址
+	var (
+		resolved = make(map[string]string)
+		origin   = make(map[string]string)
+		mu       sync.Mutex
+	)
+
+	go func() {
+		defer closeAll()
+
+		buf := make([]byte, 65535)
+		for {
+			udpConn.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+			n, from, err := udpConn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			addr, exists := origin[from.String()]
+			mu.Unlock()
+			if !exists {
+				addr = from.String()
+			}
+			if err := protocol.WriteDatagram(stream, addr, buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		address, data, err := protocol.ReadDatagram(stream)
+		if err != nil {
+			return
+		}
+
+		mu.Lock()
+		target, exists := resolved[address]
+		mu.Unlock()
+		if !exists {
+			target, err = cm.resolve(cm.egress, conn, address)
+			if err != nil {
+				slog.Warn("Egress datagram rejected", "client", conn.clientID, "user", conn.user.Name, "address", address, "err", err)
+				continue
+			}
+			mu.Lock()
+			if len(resolved) >= maxUDPTargets {
+				resolved = make(map[string]string)
+				origin = make(map[string]string)
+			}
+			resolved[address] = target
+			origin[target] = address
+			mu.Unlock()
+		}
+
+		udpAddr, err := net.ResolveUDPAddr("udp", target)
+		if err != nil {
+			continue
+		}
+		udpConn.WriteToUDP(data, udpAddr)
+	}
+}
+
+// join 双向转发数据，任一方向结束后关闭两端，返回 a 读取和写入的字节数
+func join(a, b net.Conn) (int64, int64) {
+	var in, out int64
+	done := make(chan struct{})
+	go func() {
+		in, _ = io.Copy(b, a)
+		a.Close()
+		b.Close()
+		close(done)
+	}()
+
+	out, _ = io.Copy(a, b)
+	a.Close()
+	b.Close()
+	<-done
+	return in, out
+}
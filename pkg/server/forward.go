@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	tunnelnet "github.com/aethertunnel/aethertunnel/pkg/net"
+	"github.com/aethertunnel/aethertunnel/pkg/protocol"
+)
+
+// ForwardStats 客户端静态转发的统计
+type ForwardStats struct {
+	ClientID      string    `json:"client_id"`
+	User          string    `json:"user"`
+	Name          string    `json:"name"`
+	Target        string    `json:"target"`
+	ActiveConns   int64     `json:"active_conns"`
+	TotalConns    int64     `json:"total_conns"`
+	RejectedConns int64     `json:"rejected_conns"`
+	BytesSent     int64     `json:"bytes_sent"`     // 客户端发往目标的字节数
+	BytesReceived int64     `json:"bytes_received"` // 目标发往客户端的字节数
+	LastUsed      time.Time `json:"last_used"`
+}
+
+// forwardStat 单个转发的统计，可并发更新
+type forwardStat struct {
+	stats ForwardStats
+	mu    sync.Mutex
+}
+
+func (s *forwardStat) update(fn func(stats *ForwardStats)) {
+	s.mu.Lock()
+	fn(&s.stats)
+	s.mu.Unlock()
+}
+
+// forwardConn 统计转发流量的目标连接
+type forwardConn struct {
+	net.Conn
+	stat *forwardStat
+}
+
+func (c *forwardConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.stat.update(func(stats *ForwardStats) { stats.BytesReceived += int64(n) })
+	return n, err
+}
+
+func (c *forwardConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.stat.update(func(stats *ForwardStats) { stats.BytesSent += int64(n) })
+	return n, err
+}
+
+// forwardStat 返回客户端某个转发的统计，不存在时创建
+func (cm *ControlManager) forwardStat(clientID, name string) *forwardStat {
+	key := clientID + "/" + name
+
+	cm.statsMu.Lock()
+	defer cm.statsMu.Unlock()
+
+	stat, exists := cm.forwards[key]
+	if !exists {
+		stat = &forwardStat{stats: ForwardStats{ClientID: clientID, Name: name}}
+		cm.forwards[key] = stat
+	}
+	return stat
+}
+
+// ForwardStats 返回按客户端和名称排序的转发统计
+func (cm *ControlManager) ForwardStats() []ForwardStats {
+	cm.statsMu.Lock()
+	defer cm.statsMu.Unlock()
+
+	result := make([]ForwardStats, 0, len(cm.forwards))
+	for _, stat := range cm.forwards {
+		stat.mu.Lock()
+		result = append(result, stat.stats)
+		stat.mu.Unlock()
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ClientID != result[j].ClientID {
+			return result[i].ClientID < result[j].ClientID
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// handleForwardStream 处理客户端静态转发打开的流，按 [forward] 规则检查目标
+func (cm *ControlManager) handleForwardStream(conn *ControlConnection, stream *tunnelnet.Stream, req *protocol.StreamOpenPayload) {
+	if req.Network != "tcp" {
+		stream.Reject(fmt.Sprintf("unsupported network %q", req.Network))
+		return
+	}
+
+	stat := cm.forwardStat(conn.clientID, req.Forward)
+	stat.update(func(stats *ForwardStats) {
+		stats.User = conn.user.Name
+		stats.Target = req.Address
+		stats.LastUsed = time.Now()
+	})
+	reject := func(reason string) {
+		stat.update(func(stats *ForwardStats) { stats.RejectedConns++ })
+		stream.Reject(reason)
+	}
+
+	target, err := cm.resolve(cm.forward, conn, req.Address)
+	if err != nil {
+		slog.Warn("Forward rejected", "forward", req.Forward, "client", conn.clientID, "user", conn.user.Name, "address", req.Address, "err", err)
+		reject(err.Error())
+		return
+	}
+
+	targetConn, err := net.DialTimeout("tcp", target, egressDialTimeout)
+	if err != nil {
+		slog.Warn("Forward failed", "forward", req.Forward, "client", conn.clientID, "user", conn.user.Name, "address", req.Address, "err", err)
+		reject(fmt.Sprintf("failed to connect to %s", req.Address))
+		return
+	}
+
+	if err := stream.Accept(); err != nil {
+		targetConn.Close()
+		return
+	}
+
+	stat.update(func(stats *ForwardStats) {
+		stats.ActiveConns++
+		stats.TotalConns++
+	})
+	defer stat.update(func(stats *ForwardStats) { stats.ActiveConns-- })
+
+	join(stream, &forwardConn{Conn: targetConn, stat: stat})
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+)
+
+// transformRule 编译后的改写规则
+type transformRule struct {
+	config.TransformRule
+	from      *regexp.Regexp
+	matchPath *regexp.Regexp
+}
+
+// httpTransformer HTTP 请求/响应改写器
+type httpTransformer struct {
+	request  []*transformRule
+	response []*transformRule
+}
+
+// transformVars 占位符取值
+type transformVars struct {
+	clientIP     string
+	responseTime time.Duration
+}
+
+// newHTTPTransformer 编译改写规则，规则为空时返回 nil
+func newHTTPTransformer(request, response []config.TransformRule) (*httpTransformer, error) {
+	if len(request) == 0 && len(response) == 0 {
+		return nil, nil
+	}
+
+	t := &httpTransformer{}
+	for i, rule := range request {
+		compiled, err := compileTransformRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("request_transform[%d]: %w", i, err)
+		}
+		t.request = append(t.request, compiled)
+	}
+	for i, rule := range response {
+		compiled, err := compileTransformRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("response_transform[%d]: %w", i, err)
+		}
+		t.response = append(t.response, compiled)
+	}
+
+	return t, nil
+}
+
+// compileTransformRule 编译单条规则
+func compileTransformRule(rule config.TransformRule) (*transformRule, error) {
+	compiled := &transformRule{TransformRule: rule}
+
+	if rule.From != "" {
+		re, err := regexp.Compile(rule.From)
+		if err != nil {
+			return nil, err
+		}
+		compiled.from = re
+	}
+	if rule.Match.Path != "" {
+		re, err := regexp.Compile(rule.Match.Path)
+		if err != nil {
+			return nil, err
+		}
+		compiled.matchPath = re
+	}
+
+	return compiled, nil
+}
+
+// matches 判断请求是否满足规则的匹配条件
+func (r *transformRule) matches(req *http.Request) bool {
+	if r.Match.Host != "" && !matchHost(r.Match.Host, req.Host) {
+		return false
+	}
+	if r.matchPath != nil && !r.matchPath.MatchString(req.URL.Path) {
+		return false
+	}
+	if len(r.Match.Methods) > 0 {
+		found := false
+		for _, method := range r.Match.Methods {
+			if method == req.Method {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// matchHost 匹配主机名，支持 *.example.com 形式的通配
+func matchHost(pattern, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+// expand 替换占位符
+func (v *transformVars) expand(value string, req *http.Request) string {
+	if !strings.Contains(value, "{{") {
+		return value
+	}
+	return strings.NewReplacer(
+		"{{client_ip}}", v.clientIP,
+		"{{host}}", req.Host,
+		"{{path}}", req.URL.Path,
+		"{{method}}", req.Method,
+		"{{response_time}}", strconv.FormatInt(v.responseTime.Milliseconds(), 10),
+	).Replace(value)
+}
+
+// applyHeader 执行头部操作
+func applyHeader(header http.Header, rule *transformRule, value string) {
+	switch rule.Type {
+	case config.TransformSetHeader:
+		header.Set(rule.Name, value)
+	case config.TransformAddHeader:
+		header.Add(rule.Name, value)
+	case config.TransformDelHeader:
+		header.Del(rule.Name)
+	}
+}
+
+// applyRequest 按顺序执行请求规则，命中重定向规则时返回要直接回给访问者的响应
+func (t *httpTransformer) applyRequest(req *http.Request, vars *transformVars) *http.Response {
+	if t == nil {
+		return nil
+	}
+
+	for _, rule := range t.request {
+		if !rule.matches(req) {
+			continue
+		}
+
+		switch rule.Type {
+		case config.TransformRewritePath:
+			if rule.from.MatchString(req.URL.Path) {
+				req.URL.Path = rule.from.ReplaceAllString(req.URL.Path, rule.To)
+				req.URL.RawPath = ""
+			}
+
+		case config.TransformRedirect:
+			if !rule.from.MatchString(req.URL.Path) {
+				continue
+			}
+			status := rule.Status
+			if status == 0 {
+				status = http.StatusFound
+			}
+			location := vars.expand(rule.from.ReplaceAllString(req.URL.Path, rule.To), req)
+			return newRedirectResponse(req, status, location)
+
+		default:
+			applyHeader(req.Header, rule, vars.expand(rule.Value, req))
+		}
+	}
+	return nil
+}
+
+// applyResponse 按顺序执行响应规则，匹配条件基于原始请求
+func (t *httpTransformer) applyResponse(resp *http.Response, req *http.Request, vars *transformVars) {
+	if t == nil {
+		return
+	}
+
+	for _, rule := range t.response {
+		if rule.matches(req) {
+			applyHeader(resp.Header, rule, vars.expand(rule.Value, req))
+		}
+	}
+}
+
+// newRedirectResponse 构造重定向响应
+func newRedirectResponse(req *http.Request, status int, location string) *http.Response {
+	header := make(http.Header)
+	header.Set("Location", location)
+	header.Set("Content-Length", "0")
+
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       http.NoBody,
+		Request:    req,
+		Close:      req.Close,
+	}
+}
+
+// serveHTTP 逐个转发 HTTP 请求，途中执行改写规则并记录访问日志
+func (pm *ProxyManager) serveHTTP(conn, targetConn net.Conn, proxy *Proxy, clientID string) {
+	defer conn.Close()
+	defer targetConn.Close()
+
+	start := time.Now()
+	visitor := &countingConn{Conn: conn}
+	clientIP := sourceIP(conn)
+	reason := "client_closed"
+
+	defer func() {
+		proxy.accessLog.Log(&AccessLogEntry{
+			Time:        start,
+			Type:        "conn",
+			Proxy:       proxy.Name,
+			ClientID:    clientID,
+			User:        proxy.User,
+			SourceIP:    clientIP,
+			BytesIn:     visitor.BytesRead(),
+			BytesOut:    visitor.BytesWritten(),
+			DurationMs:  time.Since(start).Milliseconds(),
+			CloseReason: reason,
+		})
+	}()
+
+	connReader := bufio.NewReader(visitor)
+	targetReader := bufio.NewReader(targetConn)
+
+	for {
+		// 读取请求前记录计数，预读到缓冲区的字节计入下一个请求
+		bytesIn := visitor.BytesRead() - int64(connReader.Buffered())
+		bytesOut := visitor.BytesWritten()
+
+		req, err := http.ReadRequest(connReader)
+		if err != nil {
+			if err != io.EOF {
+				reason = "error: " + err.Error()
+			}
+			return
+		}
+
+		reqStart := time.Now()
+		vars := &transformVars{clientIP: clientIP}
+		entry := &AccessLogEntry{
+			Time:     reqStart,
+			Type:     "http",
+			Proxy:    proxy.Name,
+			ClientID: clientID,
+			User:     proxy.User,
+			SourceIP: clientIP,
+			Method:   req.Method,
+			Host:     req.Host,
+			Path:     req.URL.Path,
+		}
+
+		resp := proxy.transformer.applyRequest(req, vars)
+		if resp != nil {
+			req.Body.Close()
+		} else {
+			if err := req.Write(targetConn); err != nil {
+				reason = "error: " + err.Error()
+				return
+			}
+
+			resp, err = http.ReadResponse(targetReader, req)
+			if err != nil {
+				reason = "target_closed"
+				if err != io.EOF && err != io.ErrUnexpectedEOF {
+					reason = "error: " + err.Error()
+				}
+				return
+			}
+
+			vars.responseTime = time.Since(reqStart)
+			proxy.transformer.applyResponse(resp, req, vars)
+		}
+
+		err = resp.Write(visitor)
+		resp.Body.Close()
+
+		entry.Status = resp.StatusCode
+		entry.LatencyMs = time.Since(reqStart).Milliseconds()
+		entry.DurationMs = entry.LatencyMs
+		entry.BytesIn = visitor.BytesRead() - int64(connReader.Buffered()) - bytesIn
+		entry.BytesOut = visitor.BytesWritten() - bytesOut
+		proxy.accessLog.Log(entry)
+
+		if err != nil {
+			reason = "error: " + err.Error()
+			return
+		}
+
+		// 协议升级（如 WebSocket）后退化为原始字节转发
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			go func() {
+				io.Copy(targetConn, connReader)
+				targetConn.Close()
+			}()
+			io.Copy(visitor, targetReader)
+			reason = "target_closed"
+			return
+		}
+
+		if req.Close || resp.Close {
+			reason = "server_closed"
+			return
+		}
+	}
+}
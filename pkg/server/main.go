@@ -2,14 +2,14 @@ package server
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/aethertunnel/aethertunnel/pkg/config"
 )
 
 func RunServer(cfg *config.ServerConfig) error {
-	log.Printf("AetherTunnel Server starting on %s:%d\n", cfg.BindAddr, cfg.BindPort)
+	slog.Info("AetherTunnel Server starting", "bind_addr", cfg.BindAddr, "bind_port", cfg.BindPort)
 
 	// 创建HTTP服务器
 	mux := http.NewServeMux()
@@ -19,7 +19,7 @@ func RunServer(cfg *config.ServerConfig) error {
 
 	// 启动HTTP服务器
 	addr := fmt.Sprintf("%s:%d", cfg.BindAddr, cfg.BindPort)
-	log.Printf("Server listening on %s\n", addr)
+	slog.Info("Server listening", "addr", addr)
 	if err := http.ListenAndServe(addr, mux); err != nil {
 		return fmt.Errorf("failed to start server: %w", err)
 	}
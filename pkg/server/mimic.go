@@ -0,0 +1,247 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/crypto"
+)
+
+const (
+	// maxTLSRecordSize TLS 记录头和最大明文记录的长度
+	maxTLSRecordSize = 5 + 1<<14
+	// decoyDialTimeout 连接伪装站点的超时时间
+	decoyDialTimeout = 10 * time.Second
+)
+
+// Mimic 控制端口的 TLS 1.3 伪装
+//
+// 读取第一个 TLS 记录中的 ClientHello，SNI 和 session_id 中的隐蔽认证都通过、且 client_random
+// 没有用过时由服务器完成 TLS 握手；其余连接连同已读取的数据原样转发到伪装站点，
+// 探测者看到的是伪装站点真实的握手和证书。TLS 1.3 的证书是加密传输的，旁观者看不到服务器证书。
+type Mimic struct {
+	auth        *crypto.MimicAuth
+	decoy       string
+	serverNames map[string]bool
+	maxDiff     time.Duration
+	tlsConfig   *tls.Config
+	seen        *replayCache // 已认证的 client_random
+}
+
+// NewMimic 根据 [server.mimic] 创建 TLS 伪装
+//
+// 认证通过的连接使用 cert_file 和 key_file 中的证书，未配置时生成伪装站点域名的自签名证书。
+func NewMimic(cfg *config.ServerConfig) (*Mimic, error) {
+	maxDiff, err := cfg.Mimic.TimeDiff()
+	if err != nil {
+		return nil, err
+	}
+
+	serverNames := make(map[string]bool)
+	for _, name := range cfg.Mimic.ServerNames {
+		serverNames[strings.ToLower(name)] = true
+	}
+
+	var cert tls.Certificate
+	if cfg.CertFile != "" {
+		if cert, err = tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile); err != nil {
+			return nil, fmt.Errorf("failed to load certificate: %w", err)
+		}
+	} else {
+		host, _, _ := net.SplitHostPort(cfg.Mimic.Decoy)
+		if len(cfg.Mimic.ServerNames) > 0 {
+			host = cfg.Mimic.ServerNames[0]
+		}
+		if cert, err = selfSignedCert(host); err != nil {
+			return nil, fmt.Errorf("failed to create certificate: %w", err)
+		}
+	}
+
+	return &Mimic{
+		auth:        crypto.NewMimicAuth(string(cfg.Mimic.Key)),
+		decoy:       cfg.Mimic.Decoy,
+		serverNames: serverNames,
+		maxDiff:     maxDiff,
+		tlsConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS13,
+			NextProtos:   []string{"h2", "http/1.1"},
+		},
+		seen: newReplayCache(maxDiff),
+	}, nil
+}
+
+// selfSignedCert 生成自签名证书
+func selfSignedCert(host string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// Handler 返回先完成伪装握手再交给 handle 处理连接的函数
+//
+// 通过隐蔽认证后 TLS 握手仍然失败的连接交给 probe 处理。
+func (m *Mimic) Handler(handle func(net.Conn), probe *ProbeGuard) func(net.Conn) {
+	return func(conn net.Conn) {
+		remoteAddr := conn.RemoteAddr().String()
+
+		conn.SetDeadline(time.Now().Add(tlsDetectTimeout))
+		// 启用探测防护时记录原始数据并改用随机超时
+		conn = probe.Wrap(conn)
+		reader := bufio.NewReaderSize(conn, maxTLSRecordSize)
+		if err := m.authenticate(reader); err != nil {
+			// 没有发送任何数据的连接也交给伪装站点，超时后的表现与伪装站点相同
+			slog.Debug("Relaying to decoy", "remote", remoteAddr, "reason", err)
+			// 伪装站点接管连接，不再需要记录
+			probe.Accept(conn)
+			m.fallback(conn, reader)
+			return
+		}
+
+		tlsConn := tls.Server(&peekedConn{Conn: conn, reader: reader}, m.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			slog.Warn("TLS handshake failed", "remote", remoteAddr, "err", err)
+			probe.Reject(tlsConn)
+			return
+		}
+		conn.SetDeadline(time.Time{})
+		handle(tlsConn)
+	}
+}
+
+// authenticate 预读 ClientHello 并验证隐蔽认证，不消耗读取的数据
+func (m *Mimic) authenticate(reader *bufio.Reader) error {
+	header, err := reader.Peek(5)
+	if err != nil {
+		return err
+	}
+	if header[0] != tlsRecordHandshake {
+		return errors.New("not a TLS handshake")
+	}
+	length := int(header[3])<<8 | int(header[4])
+	record, err := reader.Peek(5 + length)
+	if err != nil {
+		return err
+	}
+
+	hello, err := parseClientHello(record[5:])
+	if err != nil {
+		return err
+	}
+	if len(m.serverNames) > 0 && !m.serverNames[strings.ToLower(hello.serverName)] {
+		return fmt.Errorf("server name %q not accepted", hello.serverName)
+	}
+	now := time.Now()
+	if err := m.auth.Verify(hello.random, hello.sessionID, now, m.maxDiff); err != nil {
+		return err
+	}
+	if !m.seen.remember(hello.random, now) {
+		return errors.New("replayed ClientHello")
+	}
+	return nil
+}
+
+// fallback 把连接连同已读取的数据转发到伪装站点
+func (m *Mimic) fallback(conn net.Conn, reader *bufio.Reader) {
+	decoy, err := net.DialTimeout("tcp", m.decoy, decoyDialTimeout)
+	if err != nil {
+		slog.Warn("Failed to connect to decoy", "decoy", m.decoy, "err", err)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	join(&peekedConn{Conn: conn, reader: reader}, decoy)
+}
+
+// clientHello ClientHello 中用于认证的字段
+type clientHello struct {
+	random     []byte
+	sessionID  []byte
+	serverName string
+}
+
+// parseClientHello 解析 TLS 记录中的 ClientHello，握手消息需完整包含在该记录中
+func parseClientHello(data []byte) (*clientHello, error) {
+	errInvalid := errors.New("invalid ClientHello")
+	s := cryptobyte.String(data)
+
+	var msgType uint8
+	var body cryptobyte.String
+	if !s.ReadUint8(&msgType) || msgType != 1 || !s.ReadUint24LengthPrefixed(&body) {
+		return nil, errInvalid
+	}
+
+	hello := &clientHello{}
+	var sessionID, cipherSuites, compression cryptobyte.String
+	if !body.Skip(2) || !body.ReadBytes(&hello.random, 32) ||
+		!body.ReadUint8LengthPrefixed(&sessionID) ||
+		!body.ReadUint16LengthPrefixed(&cipherSuites) ||
+		!body.ReadUint8LengthPrefixed(&compression) {
+		return nil, errInvalid
+	}
+	hello.sessionID = sessionID
+
+	var extensions cryptobyte.String
+	if !body.ReadUint16LengthPrefixed(&extensions) {
+		return nil, errInvalid
+	}
+	for !extensions.Empty() {
+		var extType uint16
+		var extData cryptobyte.String
+		if !extensions.ReadUint16(&extType) || !extensions.ReadUint16LengthPrefixed(&extData) {
+			return nil, errInvalid
+		}
+		if extType != 0 { // server_name
+			continue
+		}
+		var names cryptobyte.String
+		if !extData.ReadUint16LengthPrefixed(&names) {
+			return nil, errInvalid
+		}
+		for !names.Empty() {
+			var nameType uint8
+			var name cryptobyte.String
+			if !names.ReadUint8(&nameType) || !names.ReadUint16LengthPrefixed(&name) {
+				return nil, errInvalid
+			}
+			if nameType == 0 {
+				hello.serverName = string(name)
+			}
+		}
+	}
+	return hello, nil
+}
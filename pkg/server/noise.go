@@ -0,0 +1,219 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/crypto"
+	"github.com/aethertunnel/aethertunnel/pkg/protocol"
+)
+
+// handshakeMaxTimeDiff 握手第一条消息中的时间与服务器时间允许的偏差
+const handshakeMaxTimeDiff = 2 * time.Minute
+
+// peer 握手中认证的对端身份
+type peer struct {
+	user     *User
+	clientID string             // 静态密钥或证书绑定的客户端标识，为空不限制
+	cert     *x509.Certificate  // TLS 客户端证书，没有时为 nil
+	suite    crypto.CipherSuite // 协商的传输加密算法
+	kex      string             // 握手使用的密钥交换
+}
+
+// handleSecure 完成 Noise 握手，之后在加密连接上处理控制连接或工作连接
+//
+// 客户端用令牌（NKpsk2）或自己的静态密钥（IK）认证。第一条消息的加密载荷携带用户名、
+// 客户端支持的传输加密算法，PSK 模式下还有令牌本身（只有服务端静态私钥能解密）。
+// 服务端用用户的令牌或 Argon2id 令牌哈希验证令牌，通过后才把由令牌派生的 PSK 混入握手
+// 并回复第二条消息，其中带有选定的算法。保存的令牌哈希不能代替令牌完成握手。
+//
+// 认证失败计入 pm.guard，达到阈值后来源 IP 被封禁。
+//
+// 持有 TLS 客户端证书的连接可以只用证书认证（NK）；同时使用令牌或静态密钥时，
+// 认证的用户须与证书一致。
+//
+// 客户端提出混合密钥交换（NoiseHybrid）时，服务端启用 post_quantum 才同意，否则只用 X25519；
+// 回复的第一个字节告知客户端结果。
+//
+// 认证完成前服务端不发送任何内容，失败的连接交给 pm.probe 处理。第一条消息带有客户端的
+// 时间，与服务器相差超过 handshakeMaxTimeDiff 或临时公钥已经用过（重放）的消息同样按
+// 认证失败处理，截获的第一条消息不能让服务端回复。不存在的用户也计算一次 Argon2id，
+// 探测者无法从耗时判断用户是否存在。
+func (pm *ProxyManager) handleSecure(conn net.Conn, payload []byte) {
+	remoteAddr := conn.RemoteAddr().String()
+
+	if len(payload) == 0 {
+		pm.probe.Reject(conn)
+		return
+	}
+
+	certified, err := pm.certPeer(conn)
+	if err != nil {
+		slog.Warn("Rejected client certificate", "remote", remoteAddr, "err", err)
+		pm.reject(conn, "", "client certificate rejected")
+		return
+	}
+
+	pattern := crypto.NoisePattern(payload[0])
+	switch pattern &^ crypto.NoiseHybrid {
+	case crypto.NoiseNKpsk2, crypto.NoiseIK:
+	case crypto.NoiseNK:
+		if certified == nil {
+			slog.Warn("Handshake without credentials, client certificate required", "remote", remoteAddr)
+			pm.reject(conn, "", "handshake without credentials")
+			return
+		}
+	default:
+		slog.Warn("Unsupported handshake pattern", "remote", remoteAddr, "pattern", int(pattern))
+		pm.reject(conn, "", "unsupported handshake")
+		return
+	}
+
+	handshake, err := crypto.NewNoiseHandshake(pattern, false, pm.static, nil, nil)
+	if err != nil {
+		slog.Warn("Handshake failed", "remote", remoteAddr, "err", err)
+		pm.probe.Reject(conn)
+		return
+	}
+	helloPayload, err := handshake.ReadMessage(payload[1:])
+	if err != nil {
+		slog.Warn("Handshake failed", "remote", remoteAddr, "err", err)
+		pm.reject(conn, "", "handshake failed")
+		return
+	}
+	var hello protocol.SecureHelloPayload
+	if err := json.Unmarshal(helloPayload, &hello); err != nil {
+		slog.Warn("Invalid handshake payload", "remote", remoteAddr, "err", err)
+		pm.reject(conn, "", "invalid handshake payload")
+		return
+	}
+	now := time.Now()
+	if diff := now.Sub(time.Unix(hello.Timestamp, 0)); diff > handshakeMaxTimeDiff || diff < -handshakeMaxTimeDiff {
+		slog.Warn("Handshake time out of range", "remote", remoteAddr, "diff", diff)
+		pm.reject(conn, "", "handshake time out of range")
+		return
+	}
+	// 第一条消息以临时公钥开头，ReadMessage 成功时长度足够
+	if !pm.replays.remember(payload[1:1+crypto.NoiseKeySize], now) {
+		slog.Warn("Replayed handshake", "remote", remoteAddr)
+		pm.reject(conn, "", "replayed handshake")
+		return
+	}
+	suite, ok := pm.negotiateCipherSuite(hello.CipherSuites)
+	if !ok {
+		slog.Warn("No common cipher suite", "remote", remoteAddr, "offered", hello.CipherSuites)
+		pm.probe.Reject(conn)
+		return
+	}
+
+	var p peer
+	switch pattern &^ crypto.NoiseHybrid {
+	case crypto.NoiseNK:
+		p = *certified
+	case crypto.NoiseIK:
+		user, clientID, exists := pm.users.ByKey(handshake.RemoteStatic())
+		if !exists {
+			slog.Warn("Unknown client key", "remote", remoteAddr)
+			pm.reject(conn, "", "unknown client key")
+			return
+		}
+		p = peer{user: user, clientID: clientID}
+	default:
+		name := hello.User
+		if name == "" {
+			name = config.DefaultUser
+		}
+		user, exists := pm.users.Get(name)
+		if !exists {
+			verifyUnknownUser(hello.Token)
+			slog.Warn("Unknown or disabled user", "remote", remoteAddr, "user", name)
+			pm.reject(conn, "", "unknown user")
+			return
+		}
+		psk, err := user.PSK(hello.Token)
+		if err != nil {
+			slog.Warn("Token authentication failed", "remote", remoteAddr, "user", name, "err", err)
+			pm.reject(conn, name, "token rejected")
+			return
+		}
+		if err := handshake.SetPSK(psk); err != nil {
+			slog.Warn("Handshake failed", "remote", remoteAddr, "user", name, "err", err)
+			pm.probe.Reject(conn)
+			return
+		}
+		p = peer{user: user}
+	}
+
+	if certified != nil && pattern&^crypto.NoiseHybrid != crypto.NoiseNK {
+		if certified.user.Name != p.user.Name {
+			slog.Warn("Client certificate does not match user", "remote", remoteAddr, "user", p.user.Name, "cert_user", certified.user.Name)
+			pm.reject(conn, p.user.Name, "client certificate mismatch")
+			return
+		}
+		if certified.clientID != "" {
+			if p.clientID != "" && p.clientID != certified.clientID {
+				slog.Warn("Client certificate does not match key", "remote", remoteAddr, "client", p.clientID, "cert_client", certified.clientID)
+				pm.reject(conn, p.user.Name, "client certificate mismatch")
+				return
+			}
+			p.clientID = certified.clientID
+		}
+		p.cert = certified.cert
+	}
+
+	p.suite = suite
+	if !pm.config.Server.PostQuantum {
+		handshake.DeclineHybrid()
+	}
+	p.kex = handshake.KeyExchange()
+
+	accept, err := json.Marshal(&protocol.SecureAcceptPayload{CipherSuite: suite.String()})
+	if err != nil {
+		pm.probe.Reject(conn)
+		return
+	}
+	reply, err := handshake.WriteMessage(accept)
+	if err != nil {
+		pm.probe.Reject(conn)
+		return
+	}
+	pm.probe.Accept(conn)
+	mode := pattern &^ crypto.NoiseHybrid
+	if handshake.Hybrid() {
+		mode |= crypto.NoiseHybrid
+	}
+	if err := protocol.WriteMessage(conn, &protocol.Message{Type: protocol.MessageTypeSecure, Payload: append([]byte{byte(mode)}, reply...)}); err != nil {
+		conn.Close()
+		return
+	}
+
+	secure, err := handshake.Conn(conn, suite)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	pm.guard.Succeed(conn.RemoteAddr())
+
+	pm.serve(secure, &p)
+}
+
+// reject 记录一次认证失败并按探测防护处理连接，user 为已确认存在的用户名
+func (pm *ProxyManager) reject(conn net.Conn, user, reason string) {
+	pm.guard.Fail(conn.RemoteAddr(), user, reason)
+	pm.probe.Reject(conn)
+}
+
+// negotiateCipherSuite 按服务端的偏好顺序选择客户端提供的传输加密算法，忽略不认识的算法
+func (pm *ProxyManager) negotiateCipherSuite(names []string) (crypto.CipherSuite, bool) {
+	offered := make([]crypto.CipherSuite, 0, len(names))
+	for _, name := range names {
+		if suite, err := crypto.ParseCipherSuite(name); err == nil {
+			offered = append(offered, suite)
+		}
+	}
+	return crypto.NegotiateCipherSuite(offered, pm.suites)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/crypto"
+	"github.com/aethertunnel/aethertunnel/pkg/protocol"
+)
+
+func TestNegotiateCipherSuite(t *testing.T) {
+	pm := &ProxyManager{suites: []crypto.CipherSuite{crypto.CipherSuiteAES256GCM, crypto.CipherSuiteChaCha20Poly1305}}
+
+	tests := []struct {
+		name    string
+		offered []string
+		want    crypto.CipherSuite
+		ok      bool
+	}{
+		{"server preference", []string{"ChaCha20-Poly1305", "AES-256-GCM"}, crypto.CipherSuiteAES256GCM, true},
+		// 不认识的算法（例如较新的客户端提供的）被忽略
+		{"unknown names ignored", []string{"Future-Cipher", "chacha20-poly1305"}, crypto.CipherSuiteChaCha20Poly1305, true},
+		{"only unknown names", []string{"Future-Cipher", ""}, 0, false},
+		{"not allowed", []string{"AES-128-GCM", "XChaCha20-Poly1305"}, 0, false},
+		{"nothing offered", nil, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := pm.negotiateCipherSuite(tt.offered)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: got %s, %v, expected %s, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// helloMessage 生成 PSK 模式的握手第一条消息
+func helloMessage(t *testing.T, server *crypto.NoiseKeypair, user, token string, now time.Time) []byte {
+	t.Helper()
+	handshake, err := crypto.NewNoiseHandshake(crypto.NoiseNKpsk2, true, nil, server.Public[:], crypto.DerivePSK(token, crypto.PSKPurposeControl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(&protocol.SecureHelloPayload{
+		User:         user,
+		Token:        token,
+		Timestamp:    now.Unix(),
+		CipherSuites: []string{crypto.CipherSuiteChaCha20Poly1305.String()},
+	})
+	message, err := handshake.WriteMessage(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	protocol.WriteMessage(&buf, &protocol.Message{Type: protocol.MessageTypeSecure, Payload: append([]byte{byte(crypto.NoiseNKpsk2)}, message...)})
+	return buf.Bytes()
+}
+
+func TestHandshakeProvesTokenBeforeReply(t *testing.T) {
+	static, err := crypto.GenerateNoiseKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := crypto.HashToken("server-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{}
+	cfg.Server.AuthTokenHash = hash
+	// 不封禁，每种失败都按自己的原因处理
+	cfg.Security.MaxFailedAttempts = -1
+	users, err := NewUserManager(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guard, err := NewAuthGuard(&cfg.Security, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := &ProbeGuard{mode: config.ProbeModeDrain, min: 300 * time.Millisecond, max: 300 * time.Millisecond}
+	pm := NewProxyManager(cfg, static, users, nil, guard, probe, nil, nil)
+
+	// send 发送 message，返回服务端是否回复了第二条握手消息和等待的时间
+	send := func(message []byte) (bool, time.Duration) {
+		conn := serveOnce(t, pm.HandleConnection)
+		start := time.Now()
+		conn.Write(message)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		reply, err := protocol.ReadMessage(conn)
+		return err == nil && reply.Type == protocol.MessageTypeSecure, time.Since(start)
+	}
+
+	valid := helloMessage(t, static, "", "server-token", time.Now())
+	if replied, _ := send(valid); !replied {
+		t.Fatal("Expected the server to reply to a valid token")
+	}
+
+	// 令牌错误、用户不存在、时间超出范围和重放都得不到任何回复，连接在随机超时后才关闭
+	tests := []struct {
+		name    string
+		message []byte
+	}{
+		{"wrong token", helloMessage(t, static, "", "wrong-token", time.Now())},
+		{"unknown user", helloMessage(t, static, "mallory", "server-token", time.Now())},
+		{"stored hash as token", helloMessage(t, static, "", hash, time.Now())},
+		{"stale", helloMessage(t, static, "", "server-token", time.Now().Add(-2*handshakeMaxTimeDiff))},
+		{"replayed", valid},
+	}
+	for _, tt := range tests {
+		replied, elapsed := send(tt.message)
+		if replied {
+			t.Errorf("%s: expected no reply", tt.name)
+		}
+		if elapsed < probe.min {
+			t.Errorf("%s: expected the connection to stay open for %v, closed after %v", tt.name, probe.min, elapsed)
+		}
+	}
+}
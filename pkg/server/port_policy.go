@@ -0,0 +1,228 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+)
+
+var (
+	// ErrPortNotAllowed 远程端口不在允许范围内
+	ErrPortNotAllowed = errors.New("remote port is not allowed")
+	// ErrPortInUse 远程端口已被其他代理占用
+	ErrPortInUse = errors.New("remote port is already in use")
+)
+
+// PortPolicy 远程端口分配策略，users 按用户名限定端口范围
+type PortPolicy struct {
+	allow []config.PortRange
+	deny  []config.PortRange
+	users map[string][]config.PortRange
+}
+
+// NewPortPolicy 根据 [proxy] 配置创建端口策略
+func NewPortPolicy(cfg *config.ProxyPolicyConfig) (*PortPolicy, error) {
+	allow, err := config.ParsePortRanges(cfg.AllowPorts)
+	if err != nil {
+		return nil, fmt.Errorf("allow_ports: %w", err)
+	}
+	deny, err := config.ParsePortRanges(cfg.DenyPorts)
+	if err != nil {
+		return nil, fmt.Errorf("deny_ports: %w", err)
+	}
+
+	policy := &PortPolicy{
+		allow: allow,
+		deny:  deny,
+		users: make(map[string][]config.PortRange),
+	}
+	for user, ports := range cfg.UserPorts {
+		ranges, err := config.ParsePortRanges(ports)
+		if err != nil {
+			return nil, fmt.Errorf("user_ports.%s: %w", user, err)
+		}
+		policy.users[user] = ranges
+	}
+
+	return policy, nil
+}
+
+// warnUnknownPolicyUsers 提示 user_ports、[egress] 和 [forward] 中不是用户名的键
+//
+// 这些策略按用户名匹配，按客户端标识写的旧配置不再生效。通过管理 API 稍后创建的用户
+// 也可能出现在这里，因此只记录警告。
+func warnUnknownPolicyUsers(cfg *config.Config, users *UserManager) {
+	warn := func(section, name string) {
+		if _, exists := users.Info(name); !exists && name != config.EgressDefaultRule {
+			slog.Warn("Policy entry does not name a user, policies are keyed by user name", "section", section, "user", name)
+		}
+	}
+	for name := range cfg.ProxyPolicy.UserPorts {
+		warn("proxy.user_ports", name)
+	}
+	for name := range cfg.Egress.Users {
+		warn("egress.users", name)
+	}
+	for name := range cfg.Forward.Users {
+		warn("forward.users", name)
+	}
+}
+
+// inRanges 判断端口是否落在任一范围内
+func inRanges(ranges []config.PortRange, port int) bool {
+	for _, r := range ranges {
+		if r.Contains(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed 判断用户能否使用该端口，需同时满足全局和 user_ports 中该用户的范围
+func (p *PortPolicy) Allowed(user string, port int) bool {
+	if port < 1 || port > 65535 {
+		return false
+	}
+	if inRanges(p.deny, port) {
+		return false
+	}
+	if len(p.allow) > 0 && !inRanges(p.allow, port) {
+		return false
+	}
+	if ranges, exists := p.users[user]; exists && !inRanges(ranges, port) {
+		return false
+	}
+	return true
+}
+
+// pool 返回自动分配时使用的端口池，为空表示交给系统分配
+func (p *PortPolicy) pool(user string) []config.PortRange {
+	if ranges, exists := p.users[user]; exists {
+		return ranges
+	}
+	return p.allow
+}
+
+// candidates 按随机起点遍历端口池中允许使用的端口
+func (p *PortPolicy) candidates(pool []config.PortRange, user string, yield func(port int) bool) {
+	total := 0
+	for _, r := range pool {
+		total += r.End - r.Start + 1
+	}
+	if total == 0 {
+		return
+	}
+
+	offset := rand.Intn(total)
+	for i := 0; i < total; i++ {
+		n := (offset + i) % total
+		for _, r := range pool {
+			size := r.End - r.Start + 1
+			if n < size {
+				port := r.Start + n
+				if p.Allowed(user, port) && !yield(port) {
+					return
+				}
+				break
+			}
+			n -= size
+		}
+	}
+}
+
+// portOwner 返回占用端口的其他代理，调用方需持有锁
+//
+// 正在监听的代理占用其实际端口，离线的动态代理保留其配置的端口。
+func (pm *ProxyManager) portOwner(port int, exclude string) *Proxy {
+	for name, proxy := range pm.proxies {
+		if name == exclude {
+			continue
+		}
+		if proxy.Running() && proxy.port == port {
+			return proxy
+		}
+		if proxy.Dynamic && proxy.RemotePort == port {
+			return proxy
+		}
+	}
+	return nil
+}
+
+// checkPort 检查代理配置的远程端口是否可用，调用方需持有锁
+func (pm *ProxyManager) checkPort(proxy *Proxy) error {
+	if proxy.RemotePort == 0 {
+		return nil
+	}
+	if !pm.policy.Allowed(proxy.User, proxy.RemotePort) {
+		return fmt.Errorf("%w: %d", ErrPortNotAllowed, proxy.RemotePort)
+	}
+	if user, exists := pm.users.Get(proxy.User); exists && !user.AllowsPort(proxy.RemotePort) {
+		return fmt.Errorf("%w: %d for user %s", ErrPortNotAllowed, proxy.RemotePort, user.Name)
+	}
+	if owner := pm.portOwner(proxy.RemotePort, proxy.Name); owner != nil {
+		return fmt.Errorf("%w: %d is owned by proxy %s (client %s)",
+			ErrPortInUse, proxy.RemotePort, owner.Name, owner.ClientID)
+	}
+	return nil
+}
+
+// listenProxy 监听代理端口，remote_port 为 0 时从端口池中自动分配，调用方需持有锁
+func (pm *ProxyManager) listenProxy(proxy *Proxy) (net.Listener, int, error) {
+	bindAddr := pm.config.ProxyPolicy.BindAddr
+	if bindAddr == "" {
+		bindAddr = pm.config.Server.BindAddr
+	}
+
+	if proxy.RemotePort != 0 {
+		if err := pm.checkPort(proxy); err != nil {
+			return nil, 0, err
+		}
+		addr := net.JoinHostPort(bindAddr, fmt.Sprint(proxy.RemotePort))
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		return listener, proxy.RemotePort, nil
+	}
+
+	// user_ports 和全局都未配置端口池时使用用户的 allow_ports，都未配置时交给系统分配
+	pool := pm.policy.pool(proxy.User)
+	user, _ := pm.users.Get(proxy.User)
+	if len(pool) == 0 && user != nil {
+		pool = user.ports
+	}
+	if len(pool) == 0 {
+		listener, err := net.Listen("tcp", net.JoinHostPort(bindAddr, "0"))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to listen on %s: %w", bindAddr, err)
+		}
+		return listener, listener.Addr().(*net.TCPAddr).Port, nil
+	}
+
+	var (
+		listener net.Listener
+		assigned int
+	)
+	pm.policy.candidates(pool, proxy.User, func(port int) bool {
+		if user != nil && !user.AllowsPort(port) {
+			return true
+		}
+		if pm.portOwner(port, proxy.Name) != nil {
+			return true
+		}
+		l, err := net.Listen("tcp", net.JoinHostPort(bindAddr, fmt.Sprint(port)))
+		if err != nil {
+			return true
+		}
+		listener, assigned = l, port
+		return false
+	})
+	if listener == nil {
+		return nil, 0, fmt.Errorf("no free remote port available for client %s", proxy.ClientID)
+	}
+	return listener, assigned, nil
+}
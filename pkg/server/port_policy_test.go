@@ -0,0 +1,58 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+)
+
+func TestPoliciesKeyedByUser(t *testing.T) {
+	ports, err := NewPortPolicy(&config.ProxyPolicyConfig{
+		AllowPorts: "2000-3000",
+		UserPorts:  map[string]string{"office": "2200-2299"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 同一用户的所有客户端共用 user_ports，其他用户只受全局范围限制
+	if !ports.Allowed("office", 2250) || ports.Allowed("office", 2500) {
+		t.Error("Expected office to be limited to 2200-2299")
+	}
+	if !ports.Allowed("home", 2500) || ports.Allowed("home", 3500) {
+		t.Error("Expected other users to be limited to the global range")
+	}
+	if pool := ports.pool("office"); len(pool) != 1 || pool[0].Start != 2200 {
+		t.Errorf("Expected office to allocate from its own range, got %v", pool)
+	}
+
+	egress, err := NewEgressPolicy(&config.EgressConfig{
+		Enabled: true,
+		Users: map[string]config.EgressRule{
+			"office":                 {AllowCIDRs: []string{"10.0.0.0/8"}},
+			config.EgressDefaultRule: {AllowCIDRs: []string{"192.168.1.0/24"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		user    string
+		address string
+		allowed bool
+	}{
+		{"office", "10.1.2.3:22", true},
+		{"office", "192.168.1.10:22", false},
+		{"home", "192.168.1.10:22", true},
+		{"home", "10.1.2.3:22", false},
+	}
+	for _, tt := range tests {
+		_, err := egress.Resolve(tt.user, tt.address)
+		if allowed := err == nil; allowed != tt.allowed {
+			t.Errorf("%s to %s: expected allowed=%v, got %v", tt.user, tt.address, tt.allowed, err)
+		}
+		if err != nil && !errors.Is(err, ErrEgressDenied) {
+			t.Errorf("%s to %s: expected ErrEgressDenied, got %v", tt.user, tt.address, err)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+)
+
+// maxProbeRecord 认证前最多记录的数据量，超出后无法完整转发到 decoy，改为静默读取
+const maxProbeRecord = 64 << 10
+
+// ProbeGuard 认证完成前的探测防护
+//
+// Wrap 记录认证前读取的数据并设置随机的认证超时；认证失败时调用 Reject 代替关闭连接，
+// 认证成功后调用 Accept。未启用探测防护时为 nil，方法仍可调用：Reject 直接关闭连接。
+//
+// 连接可以多次包装（如 TLSHandler 记录原始数据，握手后 HandleConnection 再记录明文），
+// Reject 总是使用最靠近套接字的一层：转发到 decoy 的是对端发送的原始字节。
+type ProbeGuard struct {
+	mode  string
+	decoy string
+	min   time.Duration
+	max   time.Duration
+}
+
+// NewProbeGuard 根据 [server.probe_resistance] 创建探测防护，未启用时返回 nil
+func NewProbeGuard(cfg *config.ProbeResistanceConfig) (*ProbeGuard, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	min, max, err := cfg.Timeouts()
+	if err != nil {
+		return nil, err
+	}
+	return &ProbeGuard{mode: cfg.Mode, decoy: cfg.Decoy, min: min, max: max}, nil
+}
+
+// timeout 返回随机超时
+func (g *ProbeGuard) timeout() time.Duration {
+	if g.max == g.min {
+		return g.min
+	}
+	return g.min + rand.N(g.max-g.min)
+}
+
+// Wrap 返回记录读取数据的连接，并设置认证阶段的随机超时
+func (g *ProbeGuard) Wrap(conn net.Conn) net.Conn {
+	if g == nil {
+		return conn
+	}
+	conn.SetDeadline(time.Now().Add(g.timeout()))
+	return &probeConn{Conn: conn, recording: true}
+}
+
+// Accept 连接已认证，停止记录并取消认证超时
+func (g *ProbeGuard) Accept(conn net.Conn) {
+	if g == nil {
+		return
+	}
+	for _, pc := range probeConns(conn) {
+		pc.stop()
+	}
+	conn.SetDeadline(time.Time{})
+}
+
+// Reject 处理未通过认证的连接，不向对端发送任何可识别的内容，返回时连接已关闭
+func (g *ProbeGuard) Reject(conn net.Conn) {
+	if g == nil {
+		conn.Close()
+		return
+	}
+
+	var (
+		recorded []byte
+		complete = true
+	)
+	if pcs := probeConns(conn); len(pcs) > 0 {
+		for _, pc := range pcs {
+			recorded, complete = pc.stop()
+		}
+		// 之后直接读写套接字，上层缓冲的数据已包含在记录中
+		conn = pcs[len(pcs)-1].Conn
+	}
+
+	if g.mode == config.ProbeModeDecoy && complete {
+		decoy, err := net.DialTimeout("tcp", g.decoy, decoyDialTimeout)
+		if err == nil {
+			if _, err = decoy.Write(recorded); err == nil {
+				conn.SetDeadline(time.Time{})
+				join(conn, decoy)
+				return
+			}
+			decoy.Close()
+		}
+		slog.Warn("Failed to connect to decoy", "decoy", g.decoy, "err", err)
+	}
+
+	conn.SetDeadline(time.Now().Add(g.timeout()))
+	io.Copy(io.Discard, conn)
+	conn.Close()
+}
+
+// probeConn 记录认证前读取数据的连接
+type probeConn struct {
+	net.Conn
+	recorded  []byte
+	recording bool
+	overflow  bool // 记录的数据超过 maxProbeRecord
+	mu        sync.Mutex
+}
+
+func (c *probeConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.mu.Lock()
+		if c.recording {
+			if len(c.recorded)+n > maxProbeRecord {
+				c.overflow = true
+				c.recording = false
+				c.recorded = nil
+			} else {
+				c.recorded = append(c.recorded, p[:n]...)
+			}
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// stop 停止记录，返回记录的数据和数据是否完整
+func (c *probeConn) stop() ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	recorded, complete := c.recorded, !c.overflow
+	c.recording = false
+	c.recorded = nil
+	return recorded, complete
+}
+
+// probeConns 返回连接包装链中的 probeConn，由外到内排列
+func probeConns(conn net.Conn) []*probeConn {
+	var pcs []*probeConn
+	for {
+		switch c := conn.(type) {
+		case *probeConn:
+			pcs = append(pcs, c)
+			conn = c.Conn
+		case *peekedConn:
+			conn = c.Conn
+		case *tls.Conn:
+			conn = c.NetConn()
+		default:
+			return pcs
+		}
+	}
+}
+
+// unwrapProbe 返回探测防护包装之前的连接
+func unwrapProbe(conn net.Conn) net.Conn {
+	if pc, ok := conn.(*probeConn); ok {
+		return pc.Conn
+	}
+	return conn
+}
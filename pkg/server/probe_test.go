@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+)
+
+// serveOnce 接受一个连接并交给 handle 处理，返回客户端连接
+func serveOnce(t *testing.T, handle func(net.Conn)) net.Conn {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		handle(conn)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// startDecoy 启动伪装站点并回复 reply，返回的函数等待对端关闭并返回伪装站点收到的数据
+func startDecoy(t *testing.T, reply string) (string, func() []byte) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte(reply))
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		data, _ := io.ReadAll(conn)
+		received <- data
+	}()
+	return listener.Addr().String(), func() []byte {
+		select {
+		case data := <-received:
+			return data
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the connection to be relayed to the decoy")
+			return nil
+		}
+	}
+}
+
+func TestProbeGuardDrain(t *testing.T) {
+	guard := &ProbeGuard{mode: config.ProbeModeDrain, min: 200 * time.Millisecond, max: 400 * time.Millisecond}
+
+	rejected := make(chan time.Time, 1)
+	conn := serveOnce(t, func(conn net.Conn) {
+		conn = guard.Wrap(conn)
+		buf := make([]byte, 4)
+		io.ReadFull(conn, buf)
+		rejected <- time.Now()
+		guard.Reject(conn)
+	})
+
+	conn.Write([]byte("probe"))
+	start := <-rejected
+	// 拒绝后服务端继续读取，不回复任何内容
+	conn.Write([]byte("more data"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(make([]byte, 16))
+	elapsed := time.Since(start)
+	if n != 0 || err == nil {
+		t.Errorf("Expected the connection to close without a reply, got %d bytes, %v", n, err)
+	}
+	if elapsed < guard.min || elapsed > guard.max+200*time.Millisecond {
+		t.Errorf("Expected the connection to close after %v-%v, closed after %v", guard.min, guard.max, elapsed)
+	}
+}
+
+func TestProbeGuardDecoy(t *testing.T) {
+	decoy, received := startDecoy(t, "decoy reply")
+	guard := &ProbeGuard{mode: config.ProbeModeDecoy, decoy: decoy, min: time.Second, max: time.Second}
+
+	rejected := make(chan struct{})
+	conn := serveOnce(t, func(conn net.Conn) {
+		conn = guard.Wrap(conn)
+		// 模拟协议解析时的预读
+		buf := make([]byte, 4)
+		io.ReadFull(conn, buf)
+		close(rejected)
+		guard.Reject(conn)
+	})
+
+	conn.Write([]byte("first "))
+	<-rejected
+	conn.Write([]byte("second"))
+
+	reply := make([]byte, len("decoy reply"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "decoy reply" {
+		t.Fatalf("Expected the decoy's reply, got %q, %v", reply, err)
+	}
+	conn.Close()
+	if data := received(); string(data) != "first second" {
+		t.Errorf("Expected the decoy to receive %q, got %q", "first second", data)
+	}
+}
+
+func TestTLSHandlerRejectsToDecoy(t *testing.T) {
+	cert, err := selfSignedCert("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	handled := func(conn net.Conn) {
+		t.Error("Expected the connection not to be handled")
+		conn.Close()
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"plain", []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")},
+		// 记录类型是握手但内容无效，TLS 握手失败
+		{"invalid handshake", append([]byte{tlsRecordHandshake, 3, 1, 0, 8}, bytes.Repeat([]byte{0xff}, 8)...)},
+	}
+	for _, tt := range tests {
+		decoy, received := startDecoy(t, "")
+		guard := &ProbeGuard{mode: config.ProbeModeDecoy, decoy: decoy, min: time.Second, max: time.Second}
+		conn := serveOnce(t, TLSHandler(handled, tlsConfig, false, guard))
+
+		conn.Write(tt.data)
+		time.Sleep(100 * time.Millisecond)
+		conn.Close()
+		if data := received(); !bytes.Equal(data, tt.data) {
+			t.Errorf("%s: expected the decoy to receive %q, got %q", tt.name, tt.data, data)
+		}
+	}
+}
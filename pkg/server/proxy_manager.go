@@ -1,16 +1,34 @@
 package server
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net"
 	"sync"
 	"time"
 
+	"github.com/aethertunnel/aethertunnel/pkg/audit"
 	"github.com/aethertunnel/aethertunnel/pkg/config"
 	"github.com/aethertunnel/aethertunnel/pkg/crypto"
 	"github.com/aethertunnel/aethertunnel/pkg/protocol"
+	"github.com/aethertunnel/aethertunnel/pkg/store"
+)
+
+// workConnTimeout 等待客户端建立工作连接的超时时间
+const workConnTimeout = 10 * time.Second
+
+// storeKeyProxies 动态代理在状态存储中的键
+const storeKeyProxies = "proxies"
+
+var (
+	// ErrProxyNotFound 代理不存在
+	ErrProxyNotFound = errors.New("proxy not found")
+	// ErrProxyExists 代理名称已被占用
+	ErrProxyExists = errors.New("proxy already exists")
 )
 
 // Proxy 代理配置
@@ -20,150 +38,574 @@ type Proxy struct {
 	LocalIP    string
 	LocalPort  int
 	RemotePort int
+
+	RequestTransform  []config.TransformRule
+	ResponseTransform []config.TransformRule
+
+	AccessLog config.AccessLogConfig
+
+	ClientID string // 所属客户端
+	User     string // 所属客户端登录的用户，客户端上线前为空
+	Dynamic  bool   // 通过管理 API 创建，需要持久化
+
+	transformer *httpTransformer
+	accessLog   *AccessLogger
+	listener    net.Listener
+	port        int // 实际监听的端口，remote_port 为 0 时由服务端分配
+}
+
+// pendingVisitor 等待工作连接的访问者连接
+type pendingVisitor struct {
+	conn  net.Conn
+	proxy *Proxy
+}
+
+// persistedProxy 持久化的动态代理
+type persistedProxy struct {
+	ClientID string             `json:"client_id"`
+	Config   config.ProxyConfig `json:"config"`
 }
 
 // ProxyManager 代理管理器
 type ProxyManager struct {
-	proxies    map[string]*Proxy
-	config     *config.Config
-	encryption *crypto.Encryption
-	mu         sync.RWMutex
+	proxies   map[string]*Proxy
+	pending   map[string]*pendingVisitor
+	config    *config.Config
+	control   *ControlManager
+	policy    *PortPolicy
+	store     *store.Store
+	static    *crypto.NoiseKeypair // Noise 握手使用的服务器静态密钥
+	suites    []crypto.CipherSuite // 允许客户端协商的传输加密算法
+	users     *UserManager
+	certs     *CertManager        // 内置 CA，未启用时为 nil
+	guard     *AuthGuard          // 认证失败统计和 IP 封禁
+	probe     *ProbeGuard         // 认证前的探测防护，未启用时为 nil
+	replays   *replayCache        // 握手第一条消息的临时公钥，拒绝重放
+	audit     *audit.Log          // 审计日志，未启用时为 nil
+	workConns map[net.Conn]string // 正在转发的工作连接到所属用户
+	mu        sync.RWMutex
 }
 
-// NewProxyManager 创建代理管理器
-func NewProxyManager(cfg *config.Config, encryption *crypto.Encryption) *ProxyManager {
+// NewProxyManager 创建代理管理器，st 为 nil 时动态代理不持久化，auditLog 为 nil 时不记录审计日志
+func NewProxyManager(cfg *config.Config, static *crypto.NoiseKeypair, users *UserManager, certs *CertManager, guard *AuthGuard, probe *ProbeGuard, auditLog *audit.Log, st *store.Store) *ProxyManager {
 	pm := &ProxyManager{
-		proxies:    make(map[string]*Proxy),
-		config:     cfg,
-		encryption: encryption,
+		proxies:   make(map[string]*Proxy),
+		pending:   make(map[string]*pendingVisitor),
+		config:    cfg,
+		store:     st,
+		static:    static,
+		users:     users,
+		certs:     certs,
+		guard:     guard,
+		probe:     probe,
+		replays:   newReplayCache(handshakeMaxTimeDiff),
+		audit:     auditLog,
+		workConns: make(map[net.Conn]string),
+	}
+	pm.control = NewControlManager(cfg)
+	pm.control.proxies = pm
+	pm.control.users = users
+	pm.control.certs = certs
+	pm.control.audit = auditLog
+
+	policy, err := NewPortPolicy(&cfg.ProxyPolicy)
+	if err != nil {
+		slog.Error("Invalid port policy, ports are unrestricted", "err", err)
+		policy = &PortPolicy{}
 	}
+	pm.policy = policy
+	warnUnknownPolicyUsers(cfg, users)
 
-	// 加载代理配置
+	suites, err := crypto.ParseCipherSuites(cfg.Server.CipherSuites)
+	if err != nil {
+		slog.Error("Invalid cipher suites, all suites are allowed", "err", err)
+		suites = crypto.DefaultCipherSuites()
+	}
+	pm.suites = suites
+
+	// 加载代理配置，监听在所属客户端注册后启动
 	for _, proxy := range cfg.Proxies {
-		pm.proxies[proxy.Name] = &Proxy{
-			Name:       proxy.Name,
-			Type:       proxy.Type,
-			LocalIP:    proxy.LocalIP,
-			LocalPort:  proxy.LocalPort,
-			RemotePort: proxy.RemotePort,
+		p := NewProxy(proxy)
+		if err := p.init(); err != nil {
+			slog.Error("Invalid proxy", "proxy", p.Name, "err", err)
+			continue
+		}
+		pm.proxies[proxy.Name] = p
+	}
+
+	// 恢复通过管理 API 创建的代理
+	if st != nil {
+		var persisted []persistedProxy
+		if _, err := st.Get(storeKeyProxies, &persisted); err != nil {
+			slog.Error("Failed to load persisted proxies", "err", err)
+		}
+		for _, item := range persisted {
+			p := NewProxy(item.Config)
+			p.ClientID = item.ClientID
+			p.Dynamic = true
+			if err := p.init(); err != nil {
+				slog.Error("Invalid proxy", "proxy", p.Name, "err", err)
+				continue
+			}
+			pm.proxies[p.Name] = p
 		}
 	}
 
 	return pm
 }
 
+// NewProxy 根据配置创建代理
+func NewProxy(cfg config.ProxyConfig) *Proxy {
+	return &Proxy{
+		Name:              cfg.Name,
+		Type:              cfg.Type,
+		LocalIP:           cfg.LocalIP,
+		LocalPort:         cfg.LocalPort,
+		RemotePort:        cfg.RemotePort,
+		RequestTransform:  cfg.RequestTransform,
+		ResponseTransform: cfg.ResponseTransform,
+		AccessLog:         cfg.AccessLog,
+	}
+}
+
+// Config 返回代理的配置形式
+func (p *Proxy) Config() config.ProxyConfig {
+	return config.ProxyConfig{
+		Name:              p.Name,
+		Type:              p.Type,
+		LocalIP:           p.LocalIP,
+		LocalPort:         p.LocalPort,
+		RemotePort:        p.RemotePort,
+		RequestTransform:  p.RequestTransform,
+		ResponseTransform: p.ResponseTransform,
+		AccessLog:         p.AccessLog,
+	}
+}
+
+// Running 判断代理是否正在监听
+func (p *Proxy) Running() bool {
+	return p.listener != nil
+}
+
+// Port 返回实际监听的端口，未监听时返回 0
+func (p *Proxy) Port() int {
+	return p.port
+}
+
+// init 编译代理的 HTTP 改写规则并打开访问日志
+func (p *Proxy) init() error {
+	transformer, err := newHTTPTransformer(p.RequestTransform, p.ResponseTransform)
+	if err != nil {
+		return err
+	}
+	accessLog, err := NewAccessLogger(p.AccessLog)
+	if err != nil {
+		return err
+	}
+
+	p.transformer = transformer
+	p.accessLog = accessLog
+	return nil
+}
+
+// Control 返回控制管理器
+func (pm *ProxyManager) Control() *ControlManager {
+	return pm.control
+}
+
 // HandleConnection 处理连接
 func (pm *ProxyManager) HandleConnection(conn net.Conn) {
+	slog.Debug("Handling connection", "remote", conn.RemoteAddr().String())
+	pm.serve(pm.probe.Wrap(conn), nil)
+}
+
+// serve 按第一个消息分发连接，p 为 nil 表示尚未完成握手
+//
+// 只有经过 Noise 握手的连接才能作为控制连接或工作连接，握手完成前出错的连接交给 pm.probe 处理。
+func (pm *ProxyManager) serve(conn net.Conn, p *peer) {
 	remoteAddr := conn.RemoteAddr().String()
-	log.Printf("Handling connection from %s", remoteAddr)
 
 	// 读取第一个消息
 	msg, err := protocol.ReadMessage(conn)
 	if err != nil {
-		log.Printf("Failed to read message from %s: %v", remoteAddr, err)
+		slog.Debug("Failed to read message", "remote", remoteAddr, "err", err)
+		if p == nil {
+			pm.probe.Reject(conn)
+			return
+		}
 		conn.Close()
 		return
 	}
 
-	log.Printf("Received message type: %d from %s", msg.Type, remoteAddr)
+	slog.Debug("Received message", "type", msg.Type, "remote", remoteAddr)
+
+	if p == nil {
+		if msg.Type != protocol.MessageTypeSecure {
+			slog.Warn("Unauthenticated message", "type", msg.Type, "remote", remoteAddr)
+			pm.reject(conn, "", "unauthenticated message")
+			return
+		}
+		// 先完成握手，之后的消息都经过加密
+		pm.handleSecure(conn, msg.Payload)
+		return
+	}
 
 	switch msg.Type {
 	case protocol.MessageTypeAuth:
-		// 处理认证
-		pm.handleAuth(conn, msg.Payload)
+		// 控制连接
+		pm.control.HandleControl(conn, msg.Payload, p)
 
 	case protocol.MessageTypeHeartbeat:
 		// 处理心跳
 		pm.handleHeartbeat(conn)
 
 	case protocol.MessageTypeProxy:
-		// 处理代理请求
-		pm.handleProxyRequest(conn, msg.Payload)
+		// 工作连接
+		pm.handleWorkConn(conn, msg, p.user)
 
 	default:
-		log.Printf("Unknown message type: %d from %s", msg.Type, remoteAddr)
+		slog.Warn("Unknown message type", "type", msg.Type, "remote", remoteAddr, "user", p.user.Name)
 		conn.Close()
 	}
 }
 
-// handleAuth 处理认证
-func (pm *ProxyManager) handleAuth(conn net.Conn, payload []byte) {
-	token := string(payload)
+// handleHeartbeat 处理心跳
+func (pm *ProxyManager) handleHeartbeat(conn net.Conn) {
+	slog.Debug("Heartbeat", "remote", conn.RemoteAddr().String())
+}
+
+// RegisterProxy 注册客户端上报的代理并启动监听
+func (pm *ProxyManager) RegisterProxy(user *User, clientID string, cfg config.ProxyConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	proxy := NewProxy(cfg)
+	proxy.ClientID = clientID
+	proxy.User = user.Name
+	if err := proxy.init(); err != nil {
+		return err
+	}
+
+	msg, err := pm.registerProxy(user, proxy)
+	if err != nil {
+		proxy.accessLog.Close()
+		return err
+	}
+
+	// 回报实际分配的端口
+	if err := pm.control.Send(clientID, msg); err != nil {
+		slog.Warn("Failed to report proxy", "proxy", proxy.Name, "client", clientID, "err", err)
+	}
+	return nil
+}
 
-	// 验证令牌
-	if token != pm.config.Server.AuthToken {
-		log.Printf("Invalid auth token from %s", conn.RemoteAddr())
+// registerProxy 在锁内替换同名代理并启动监听，返回要回报给客户端的消息
+func (pm *ProxyManager) registerProxy(user *User, proxy *Proxy) (*protocol.Message, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
 
-		// 发送认证失败消息
-		errMsg := protocol.NewErrorMessage("invalid auth token")
-		if err := protocol.WriteMessage(conn, errMsg); err != nil {
-			log.Printf("Failed to write error message: %v", err)
+	if err := pm.checkUser(user, proxy); err != nil {
+		return nil, err
+	}
+	if existing, exists := pm.proxies[proxy.Name]; exists {
+		if existing.ClientID != "" && existing.ClientID != proxy.ClientID && pm.control.IsOnline(existing.ClientID) {
+			return nil, fmt.Errorf("%w: owned by client %s", ErrProxyExists, existing.ClientID)
 		}
-		return
+		proxy.Dynamic = existing.Dynamic
+		pm.closeProxy(existing)
+	}
+
+	if err := pm.startProxy(proxy); err != nil {
+		return nil, err
 	}
+	pm.proxies[proxy.Name] = proxy
+	return proxyMessage(proxy)
+}
+
+// UnregisterProxy 关闭客户端自己的代理，代理不存在或不属于该客户端时返回 false
+func (pm *ProxyManager) UnregisterProxy(clientID, name string) bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
 
-	log.Printf("Client %s authenticated successfully", conn.RemoteAddr())
+	proxy, exists := pm.proxies[name]
+	if !exists || proxy.ClientID != clientID {
+		return false
+	}
 
-	// 发送认证成功消息
-	successMsg := protocol.NewAuthMessage("OK")
-	if err := protocol.WriteMessage(conn, successMsg); err != nil {
-		log.Printf("Failed to write auth success message: %v", err)
+	if proxy.Dynamic {
+		pm.stopProxy(proxy)
+	} else {
+		pm.closeProxy(proxy)
+		delete(pm.proxies, name)
 	}
+	return true
 }
 
-// handleHeartbeat 处理心跳
-func (pm *ProxyManager) handleHeartbeat(conn net.Conn) {
-	log.Printf("Heartbeat from %s", conn.RemoteAddr())
+// ClientOnline 客户端上线后推送并启动属于它的动态代理，超出用户权限的代理不启动
+func (pm *ProxyManager) ClientOnline(clientID string, user *User) {
+	pm.mu.Lock()
+	msgs := make(map[string]*protocol.Message)
+	for _, proxy := range pm.proxies {
+		if proxy.ClientID != clientID || !proxy.Dynamic {
+			continue
+		}
+		proxy.User = user.Name
+		if err := pm.checkUser(user, proxy); err != nil {
+			slog.Warn("Proxy not started", "proxy", proxy.Name, "client", clientID, "user", user.Name, "err", err)
+			continue
+		}
+		if err := pm.startProxy(proxy); err != nil {
+			slog.Error("Failed to start proxy", "proxy", proxy.Name, "err", err)
+		}
+		msg, err := proxyMessage(proxy)
+		if err != nil {
+			slog.Warn("Failed to push proxy", "proxy", proxy.Name, "client", clientID, "err", err)
+			continue
+		}
+		msgs[proxy.Name] = msg
+	}
+	pm.mu.Unlock()
+
+	// 写控制连接可能阻塞，不能持有锁
+	for name, msg := range msgs {
+		if err := pm.control.Send(clientID, msg); err != nil {
+			slog.Warn("Failed to push proxy", "proxy", name, "client", clientID, "err", err)
+		}
+	}
 }
 
-// handleProxyRequest 处理代理请求
-func (pm *ProxyManager) handleProxyRequest(conn net.Conn, payload []byte) {
-	var proxyReq struct {
-		Name string `json:"name"`
+// ClientOffline 客户端下线后停止属于它的代理
+func (pm *ProxyManager) ClientOffline(clientID string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for name, proxy := range pm.proxies {
+		if proxy.ClientID != clientID {
+			continue
+		}
+		if proxy.Dynamic {
+			pm.stopProxy(proxy)
+		} else {
+			pm.closeProxy(proxy)
+			delete(pm.proxies, name)
+		}
 	}
+}
 
-	if err := json.Unmarshal(payload, &proxyReq); err != nil {
-		log.Printf("Failed to unmarshal proxy request: %v", err)
-		return
+// startProxy 在远程端口上启动监听，调用方需持有锁
+func (pm *ProxyManager) startProxy(proxy *Proxy) error {
+	if proxy.listener != nil {
+		return nil
+	}
+	if proxy.Type == "udp" {
+		return fmt.Errorf("udp proxies are not supported yet")
 	}
 
-	proxy, exists := pm.proxies[proxyReq.Name]
-	if !exists {
-		log.Printf("Proxy %s not found", proxyReq.Name)
+	listener, port, err := pm.listenProxy(proxy)
+	if err != nil {
+		return err
+	}
+
+	proxy.listener = listener
+	proxy.port = port
+	slog.Info("Proxy listening", "proxy", proxy.Name, "addr", listener.Addr().String(), "client", proxy.ClientID, "user", proxy.User)
+
+	go pm.acceptVisitors(proxy, listener)
+	return nil
+}
+
+// stopProxy 停止监听，调用方需持有锁
+func (pm *ProxyManager) stopProxy(proxy *Proxy) {
+	if proxy.listener == nil {
 		return
 	}
+	proxy.listener.Close()
+	proxy.listener = nil
+	proxy.port = 0
+	slog.Info("Proxy stopped", "proxy", proxy.Name)
+}
 
-	log.Printf("Proxy request for %s (type: %s)", proxy.Name, proxy.Type)
+// closeProxy 停止监听并关闭访问日志，用于不再使用的代理，调用方需持有锁
+func (pm *ProxyManager) closeProxy(proxy *Proxy) {
+	pm.stopProxy(proxy)
+	if err := proxy.accessLog.Close(); err != nil {
+		slog.Warn("Failed to close access log", "proxy", proxy.Name, "err", err)
+	}
+}
 
-	// 创建到目标服务器的连接
-	targetAddr := fmt.Sprintf("127.0.0.1:%d", proxy.RemotePort)
-	targetConn, err := net.DialTimeout("tcp", targetAddr, 30*time.Second)
-	if err != nil {
-		log.Printf("Failed to connect to target %s: %v", targetAddr, err)
+// proxyMessage 生成下发给所属客户端的代理配置，remote_port 为实际监听的端口，调用方需持有锁
+func proxyMessage(proxy *Proxy) (*protocol.Message, error) {
+	cfg := proxy.Config()
+	if proxy.Running() {
+		cfg.RemotePort = proxy.port
+	}
+	return protocol.NewJSONMessage(protocol.MessageTypeNewProxy, cfg)
+}
+
+// acceptVisitors 接受访问者连接并向客户端请求工作连接
+func (pm *ProxyManager) acceptVisitors(proxy *Proxy, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Warn("Proxy accept error", "proxy", proxy.Name, "err", err)
+			}
+			return
+		}
 
-		// 发送错误消息
-		errMsg := protocol.NewErrorMessage(err.Error())
-		if err := protocol.WriteMessage(conn, errMsg); err != nil {
-			log.Printf("Failed to write error message: %v", err)
+		workID, err := newWorkID()
+		if err != nil {
+			conn.Close()
+			continue
 		}
+
+		pm.mu.Lock()
+		pm.pending[workID] = &pendingVisitor{conn: conn, proxy: proxy}
+		pm.mu.Unlock()
+
+		msg, err := protocol.NewJSONMessage(protocol.MessageTypeReqWorkConn, &protocol.WorkConnPayload{
+			Name:     proxy.Name,
+			ClientID: proxy.ClientID,
+			WorkID:   workID,
+		})
+		if err == nil {
+			err = pm.control.Send(proxy.ClientID, msg)
+		}
+		if err != nil {
+			slog.Warn("Failed to request work connection", "proxy", proxy.Name, "err", err)
+		}
+
+		// 超时未收到工作连接则关闭访问者连接
+		time.AfterFunc(workConnTimeout, func() {
+			if visitor := pm.takePending(workID); visitor != nil {
+				slog.Warn("Work connection timed out", "proxy", proxy.Name)
+				visitor.conn.Close()
+			}
+		})
+	}
+}
+
+// takePending 取出等待中的访问者连接
+func (pm *ProxyManager) takePending(workID string) *pendingVisitor {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	visitor, exists := pm.pending[workID]
+	if exists {
+		delete(pm.pending, workID)
+	}
+	return visitor
+}
+
+// newWorkID 生成不可预测的工作连接标识，同时作为工作连接的凭证
+func newWorkID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// handleWorkConn 将客户端的工作连接与等待中的访问者配对
+func (pm *ProxyManager) handleWorkConn(conn net.Conn, msg *protocol.Message, user *User) {
+	var req protocol.WorkConnPayload
+	if err := msg.DecodeJSON(&req); err != nil {
+		slog.Warn("Invalid work connection", "remote", conn.RemoteAddr().String(), "err", err)
+		conn.Close()
 		return
 	}
 
-	// 发送成功消息（使用代理消息类型）
-	successMsg := protocol.NewProxyMessage([]byte("proxy established"))
-	if err := protocol.WriteMessage(conn, successMsg); err != nil {
-		log.Printf("Failed to write success message: %v", err)
-		targetConn.Close()
+	visitor := pm.takePending(req.WorkID)
+	if visitor == nil || visitor.proxy.Name != req.Name || visitor.proxy.User != user.Name {
+		slog.Warn("Unknown work connection", "proxy", req.Name, "remote", conn.RemoteAddr().String())
+		if visitor != nil {
+			visitor.conn.Close()
+		}
+		conn.Close()
 		return
 	}
 
-	// 开始在两个连接之间复制数据
-	go pm.copyData(conn, targetConn)
-	go pm.copyData(targetConn, conn)
+	proxy := visitor.proxy
+	slog.Debug("Work connection", "proxy", proxy.Name, "type", proxy.Type, "user", proxy.User)
+
+	pm.mu.Lock()
+	pm.workConns[conn] = proxy.User
+	pm.mu.Unlock()
+
+	go func() {
+		defer func() {
+			pm.mu.Lock()
+			delete(pm.workConns, conn)
+			pm.mu.Unlock()
+		}()
+
+		// HTTP 代理配置了改写规则或访问日志时按请求转发
+		if proxy.Type == "http" && (proxy.transformer != nil || proxy.accessLog != nil) {
+			pm.serveHTTP(visitor.conn, conn, proxy, proxy.ClientID)
+			return
+		}
+
+		// 开始在两个连接之间复制数据
+		pm.relay(visitor.conn, conn, proxy, proxy.ClientID)
+	}()
 }
 
-// copyData 在两个连接之间复制数据
-func (pm *ProxyManager) copyData(src, dst net.Conn) {
+// KickUser 断开用户的所有控制连接和正在转发的工作连接
+func (pm *ProxyManager) KickUser(name string) {
+	pm.control.KickUser(name)
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for conn, user := range pm.workConns {
+		if user == name {
+			conn.Close()
+		}
+	}
+}
+
+// relay 双向转发数据，结束后写访问日志
+func (pm *ProxyManager) relay(conn, targetConn net.Conn, proxy *Proxy, clientID string) {
+	start := time.Now()
+	visitor := &countingConn{Conn: conn}
+
+	reasons := make(chan string, 2)
+	go func() {
+		reasons <- closeReason(pm.copyData(visitor, targetConn), "client_closed")
+	}()
+	go func() {
+		reasons <- closeReason(pm.copyData(targetConn, visitor), "target_closed")
+	}()
+
+	// 以先结束的方向作为关闭原因
+	reason := <-reasons
+	<-reasons
+
+	proxy.accessLog.Log(&AccessLogEntry{
+		Time:        start,
+		Type:        "conn",
+		Proxy:       proxy.Name,
+		ClientID:    clientID,
+		User:        proxy.User,
+		SourceIP:    sourceIP(conn),
+		BytesIn:     visitor.BytesRead(),
+		BytesOut:    visitor.BytesWritten(),
+		DurationMs:  time.Since(start).Milliseconds(),
+		CloseReason: reason,
+	})
+}
+
+// closeReason 将转发结束时的错误转换为关闭原因
+func closeReason(err error, eofReason string) string {
+	if err == nil || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return eofReason
+	}
+	return "error: " + err.Error()
+}
+
+// copyData 在两个连接之间复制数据，返回导致结束的错误
+func (pm *ProxyManager) copyData(src, dst net.Conn) error {
 	defer src.Close()
 	defer dst.Close()
 
@@ -173,19 +615,15 @@ func (pm *ProxyManager) copyData(src, dst net.Conn) {
 	for {
 		// 从源连接读取数据
 		n, err := src.Read(buf)
-		if err != nil {
-			log.Printf("Read error: %v", err)
-			return
-		}
-
 		if n > 0 {
 			// 向目标连接写入数据
-			_, err := dst.Write(buf[:n])
-			if err != nil {
-				log.Printf("Write error: %v", err)
-				return
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return werr
 			}
 		}
+		if err != nil {
+			return err
+		}
 	}
 }
 
@@ -197,20 +635,210 @@ func (pm *ProxyManager) GetProxyConfig(name string) *Proxy {
 	return pm.proxies[name]
 }
 
-// AddProxy 添加代理
-func (pm *ProxyManager) AddProxy(proxy *Proxy) {
+// AddProxy 添加动态代理，所属客户端在线时立即下发并启动监听
+func (pm *ProxyManager) AddProxy(proxy *Proxy) error {
+	cfg := proxy.Config()
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if proxy.ClientID == "" {
+		return fmt.Errorf("proxy %s: client_id is required", proxy.Name)
+	}
+	if err := proxy.init(); err != nil {
+		return fmt.Errorf("proxy %s: %w", proxy.Name, err)
+	}
+	proxy.Dynamic = true
+
+	msg, err := pm.addProxy(proxy)
+	if err != nil {
+		proxy.accessLog.Close()
+		return err
+	}
+
+	if msg != nil {
+		if err := pm.control.Send(proxy.ClientID, msg); err != nil {
+			slog.Warn("Failed to push proxy", "proxy", proxy.Name, "client", proxy.ClientID, "err", err)
+		}
+	}
+	return nil
+}
+
+// addProxy 在锁内保存代理，所属客户端在线时启动监听并返回要下发的消息，保存失败时撤销
+func (pm *ProxyManager) addProxy(proxy *Proxy) (*protocol.Message, error) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
+	if _, exists := pm.proxies[proxy.Name]; exists {
+		return nil, fmt.Errorf("proxy %s: %w", proxy.Name, ErrProxyExists)
+	}
+	if err := pm.checkPort(proxy); err != nil {
+		return nil, err
+	}
+
+	var msg *protocol.Message
+	if conn, online := pm.control.GetConnection(proxy.ClientID); online {
+		proxy.User = conn.user.Name
+		if err := pm.checkUser(conn.user, proxy); err != nil {
+			return nil, err
+		}
+		if err := pm.startProxy(proxy); err != nil {
+			return nil, err
+		}
+		var err error
+		if msg, err = proxyMessage(proxy); err != nil {
+			pm.stopProxy(proxy)
+			return nil, err
+		}
+	}
+
 	pm.proxies[proxy.Name] = proxy
+	if err := pm.persist(); err != nil {
+		delete(pm.proxies, proxy.Name)
+		pm.stopProxy(proxy)
+		return nil, err
+	}
+	return msg, nil
 }
 
-// RemoveProxy 移除代理
-func (pm *ProxyManager) RemoveProxy(name string) {
+// UpdateProxy 用新配置替换动态代理
+func (pm *ProxyManager) UpdateProxy(name string, cfg config.ProxyConfig) error {
+	if cfg.Name != name {
+		return fmt.Errorf("proxy name cannot be changed")
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	proxy := NewProxy(cfg)
+	if err := proxy.init(); err != nil {
+		return err
+	}
+
+	msg, err := pm.updateProxy(proxy)
+	if err != nil {
+		proxy.accessLog.Close()
+		return err
+	}
+
+	if msg != nil {
+		if err := pm.control.Send(proxy.ClientID, msg); err != nil {
+			slog.Warn("Failed to push proxy", "proxy", name, "client", proxy.ClientID, "err", err)
+		}
+	}
+	return nil
+}
+
+// updateProxy 在锁内用 proxy 替换同名动态代理，原来在监听时返回要下发的消息，失败时恢复原来的代理
+func (pm *ProxyManager) updateProxy(proxy *Proxy) (*protocol.Message, error) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
+	name := proxy.Name
+	existing, exists := pm.proxies[name]
+	if !exists {
+		return nil, fmt.Errorf("proxy %s: %w", name, ErrProxyNotFound)
+	}
+	if !existing.Dynamic {
+		return nil, fmt.Errorf("proxy %s is managed by its client and cannot be changed", name)
+	}
+
+	proxy.ClientID = existing.ClientID
+	proxy.User = existing.User
+	proxy.Dynamic = true
+
+	if err := pm.checkPort(proxy); err != nil {
+		return nil, err
+	}
+	if conn, online := pm.control.GetConnection(proxy.ClientID); online {
+		if err := pm.checkUser(conn.user, proxy); err != nil {
+			return nil, err
+		}
+	}
+
+	wasRunning := existing.Running()
+	pm.stopProxy(existing)
+	var msg *protocol.Message
+	if wasRunning {
+		err := pm.startProxy(proxy)
+		if err == nil {
+			msg, err = proxyMessage(proxy)
+		}
+		if err != nil {
+			pm.restoreProxy(proxy, existing, wasRunning)
+			return nil, err
+		}
+	}
+
+	pm.proxies[name] = proxy
+	if err := pm.persist(); err != nil {
+		pm.restoreProxy(proxy, existing, wasRunning)
+		return nil, err
+	}
+
+	pm.closeProxy(existing)
+	return msg, nil
+}
+
+// restoreProxy 替换失败时停止新代理并恢复原来的代理，调用方需持有锁
+func (pm *ProxyManager) restoreProxy(proxy, existing *Proxy, restart bool) {
+	pm.stopProxy(proxy)
+	pm.proxies[existing.Name] = existing
+	if !restart {
+		return
+	}
+	if err := pm.startProxy(existing); err != nil {
+		slog.Error("Failed to restart proxy", "proxy", existing.Name, "err", err)
+	}
+}
+
+// RemoveProxy 移除代理并通知所属客户端
+func (pm *ProxyManager) RemoveProxy(name string) error {
+	pm.mu.Lock()
+	proxy, exists := pm.proxies[name]
+	if !exists {
+		pm.mu.Unlock()
+		return fmt.Errorf("proxy %s: %w", name, ErrProxyNotFound)
+	}
+
+	pm.closeProxy(proxy)
 	delete(pm.proxies, name)
+
+	var err error
+	if proxy.Dynamic {
+		err = pm.persist()
+	}
+	pm.mu.Unlock()
+
+	// 解锁后再通知客户端
+	if proxy.ClientID != "" && pm.control.IsOnline(proxy.ClientID) {
+		msg, sendErr := protocol.NewJSONMessage(protocol.MessageTypeCloseProxy, &protocol.CloseProxyPayload{Name: name})
+		if sendErr == nil {
+			sendErr = pm.control.Send(proxy.ClientID, msg)
+		}
+		if sendErr != nil {
+			slog.Warn("Failed to notify client of removed proxy", "client", proxy.ClientID, "proxy", name, "err", sendErr)
+		}
+	}
+	return err
+}
+
+// persist 保存动态代理，调用方需持有锁
+func (pm *ProxyManager) persist() error {
+	if pm.store == nil {
+		return nil
+	}
+
+	persisted := make([]persistedProxy, 0)
+	for _, proxy := range pm.proxies {
+		if proxy.Dynamic {
+			persisted = append(persisted, persistedProxy{ClientID: proxy.ClientID, Config: proxy.Config()})
+		}
+	}
+
+	if err := pm.store.Put(storeKeyProxies, persisted); err != nil {
+		return fmt.Errorf("failed to persist proxies: %w", err)
+	}
+	return nil
 }
 
 // GetProxies 获取所有代理
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/protocol"
+	"github.com/aethertunnel/aethertunnel/pkg/store"
+)
+
+// newTestProxyManager 创建带一个在线客户端 c1 的代理管理器，返回客户端一侧的控制连接
+func newTestProxyManager(t *testing.T, st *store.Store) (*ProxyManager, net.Conn) {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Server.AuthToken = "server-token"
+	cfg.ProxyPolicy.BindAddr = "127.0.0.1"
+	users, err := NewUserManager(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := newUser(config.UserConfig{Name: "alice", Token: "alice-token"}, userSourceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := NewProxyManager(cfg, nil, users, nil, nil, nil, nil, st)
+
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	pm.control.connections["c1"] = &ControlConnection{conn: server, remoteAddr: "pipe", clientID: "c1", user: user}
+	return pm, client
+}
+
+// accessLogOpen 判断访问日志文件是否仍被代理持有
+func accessLogOpen(path string) bool {
+	accessLogFilesMu.Lock()
+	defer accessLogFilesMu.Unlock()
+	_, open := accessLogFiles[path]
+	return open
+}
+
+func TestProxyPushWithoutLock(t *testing.T) {
+	pm, client := newTestProxyManager(t, nil)
+	dir := t.TempDir()
+	cfg := config.ProxyConfig{Name: "ssh", Type: "tcp", LocalPort: 22, AccessLog: config.AccessLogConfig{
+		Enabled: true, LogFile: filepath.Join(dir, "ssh.log"),
+	}}
+
+	// 客户端不读控制连接时下发会阻塞，但不能阻塞其他持锁的操作
+	added := make(chan error, 1)
+	go func() {
+		added <- pm.AddProxy(&Proxy{Name: cfg.Name, Type: cfg.Type, LocalPort: cfg.LocalPort, AccessLog: cfg.AccessLog, ClientID: "c1"})
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for pm.GetProxyConfig("ssh") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the proxy to be added while the push is blocked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg, err := protocol.ReadMessage(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != protocol.MessageTypeNewProxy {
+		t.Errorf("Expected a new proxy message, got %v", msg.Type)
+	}
+	if err := <-added; err != nil {
+		t.Fatal(err)
+	}
+	oldLog := cfg.AccessLog.LogFile
+	if !accessLogOpen(oldLog) {
+		t.Fatal("Expected the access log to be open")
+	}
+
+	// 替换代理后关闭原来的访问日志
+	cfg.AccessLog.LogFile = filepath.Join(dir, "ssh-new.log")
+	updated := make(chan error, 1)
+	go func() { updated <- pm.UpdateProxy("ssh", cfg) }()
+	if _, err := protocol.ReadMessage(client); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-updated; err != nil {
+		t.Fatal(err)
+	}
+	if accessLogOpen(oldLog) {
+		t.Error("Expected the replaced proxy's access log to be closed")
+	}
+
+	removed := make(chan error, 1)
+	go func() { removed <- pm.RemoveProxy("ssh") }()
+	if msg, err := protocol.ReadMessage(client); err != nil || msg.Type != protocol.MessageTypeCloseProxy {
+		t.Fatalf("Expected a close proxy message, got %v, %v", msg, err)
+	}
+	if err := <-removed; err != nil {
+		t.Fatal(err)
+	}
+	if accessLogOpen(cfg.AccessLog.LogFile) {
+		t.Error("Expected the removed proxy's access log to be closed")
+	}
+}
+
+func TestAddProxyRollsBackOnPersistError(t *testing.T) {
+	// 打开后把状态文件所在目录换成普通文件，保存必然失败
+	dir := t.TempDir()
+	st, err := store.Open(filepath.Join(dir, "state", "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "state"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	pm, _ := newTestProxyManager(t, st)
+
+	logFile := filepath.Join(dir, "web.log")
+	proxy := &Proxy{Name: "web", Type: "tcp", LocalPort: 80, ClientID: "c1", AccessLog: config.AccessLogConfig{Enabled: true, LogFile: logFile}}
+	if err := pm.AddProxy(proxy); err == nil {
+		t.Fatal("Expected the persist error to be returned")
+	}
+	if pm.GetProxyConfig("web") != nil {
+		t.Error("Expected the proxy to be removed after the persist error")
+	}
+	if proxy.Running() {
+		t.Error("Expected the proxy to stop listening after the persist error")
+	}
+	if accessLogOpen(logFile) {
+		t.Error("Expected the access log to be closed after the persist error")
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/crypto"
+	"github.com/aethertunnel/aethertunnel/pkg/protocol"
+)
+
+const (
+	relayHandshakeTimeout = 10 * time.Second
+	relayDialTimeout      = 10 * time.Second
+)
+
+// Relay 中继服务器，验证令牌后把连接转发到下一跳
+//
+// 客户端用 Noise NNpsk0 握手证明持有令牌，令牌本身不会出现在线路上。
+// 中继不解析之后的数据，客户端和最终服务器之间的内容是端到端加密的。
+type Relay struct {
+	psk     []byte
+	allowed map[string]bool
+	guard   *AuthGuard
+	probe   *ProbeGuard
+}
+
+// NewRelay 创建中继，令牌错误的连接计入 guard 的认证失败并交给 probe 处理
+func NewRelay(cfg *config.Config, guard *AuthGuard, probe *ProbeGuard) *Relay {
+	allowed := make(map[string]bool)
+	for _, target := range cfg.Relay.AllowedTargets {
+		allowed[target] = true
+	}
+
+	return &Relay{
+		psk:     crypto.DerivePSK(string(cfg.Server.AuthToken), crypto.PSKPurposeRelay),
+		allowed: allowed,
+		guard:   guard,
+		probe:   probe,
+	}
+}
+
+// HandleConnection 处理以中继请求开头的连接
+func (r *Relay) HandleConnection(conn net.Conn) {
+	remoteAddr := conn.RemoteAddr().String()
+
+	conn.SetReadDeadline(time.Now().Add(relayHandshakeTimeout))
+	// 启用探测防护时改用随机超时
+	conn = r.probe.Wrap(conn)
+	msg, err := protocol.ReadMessage(conn)
+	if err != nil || msg.Type != protocol.MessageTypeRelay {
+		slog.Warn("Invalid relay request", "remote", remoteAddr)
+		if err == nil {
+			r.guard.Fail(conn.RemoteAddr(), "", "invalid relay request")
+		}
+		r.probe.Reject(conn)
+		return
+	}
+
+	handshake, err := crypto.NewNoiseHandshake(crypto.NoiseNNpsk0, false, nil, nil, r.psk)
+	if err != nil {
+		r.probe.Reject(conn)
+		return
+	}
+	payload, err := handshake.ReadMessage(msg.Payload)
+	if err != nil {
+		// 令牌错误时不回复任何内容
+		slog.Warn("Relay handshake failed", "remote", remoteAddr, "err", err)
+		r.guard.Fail(conn.RemoteAddr(), "", "relay token rejected")
+		r.probe.Reject(conn)
+		return
+	}
+	r.guard.Succeed(conn.RemoteAddr())
+	r.probe.Accept(conn)
+
+	var req protocol.RelayPayload
+	if err := (&protocol.Message{Payload: payload}).DecodeJSON(&req); err != nil {
+		slog.Warn("Invalid relay request", "remote", remoteAddr, "err", err)
+		r.reply(conn, handshake, "invalid relay request")
+		conn.Close()
+		return
+	}
+
+	if len(r.allowed) > 0 && !r.allowed[req.Target] {
+		slog.Warn("Relay target not allowed", "remote", remoteAddr, "target", req.Target)
+		r.reply(conn, handshake, "relay target not allowed")
+		conn.Close()
+		return
+	}
+
+	next, err := net.DialTimeout("tcp", req.Target, relayDialTimeout)
+	if err != nil {
+		slog.Warn("Relay to next hop failed", "remote", remoteAddr, "target", req.Target, "err", err)
+		r.reply(conn, handshake, "failed to connect to next hop")
+		conn.Close()
+		return
+	}
+
+	if err := r.reply(conn, handshake, "OK"); err != nil {
+		conn.Close()
+		next.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	slog.Debug("Relaying", "remote", remoteAddr, "target", req.Target)
+	join(conn, next)
+}
+
+// reply 以第二条握手消息回复结果，result 为 "OK" 或拒绝原因
+func (r *Relay) reply(conn net.Conn, handshake *crypto.NoiseHandshake, result string) error {
+	message, err := handshake.WriteMessage([]byte(result))
+	if err != nil {
+		return err
+	}
+	return protocol.WriteMessage(conn, &protocol.Message{Type: protocol.MessageTypeRelay, Payload: message})
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// replayCache 记录时间窗口内已认证的一次性随机值（ClientHello 的 client_random、
+// 握手第一条消息的临时公钥），拒绝重放
+//
+// 认证本身要求时间与服务器相差不超过 maxDiff，超过 2*maxDiff 的记录可以删除：
+// 同一消息再出现时会因时间被拒绝。
+type replayCache struct {
+	maxDiff   time.Duration
+	seen      map[[32]byte]time.Time // 随机值到过期时间
+	nextSweep time.Time
+	mu        sync.Mutex
+}
+
+// newReplayCache 创建重放记录，maxDiff 为认证允许的时钟偏差
+func newReplayCache(maxDiff time.Duration) *replayCache {
+	return &replayCache{maxDiff: maxDiff, seen: make(map[[32]byte]time.Time)}
+}
+
+// remember 记录 now 时刻认证的随机值，已经用过时返回 false
+func (c *replayCache) remember(random []byte, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.After(c.nextSweep) {
+		for key, expires := range c.seen {
+			if now.After(expires) {
+				delete(c.seen, key)
+			}
+		}
+		c.nextSweep = now.Add(c.maxDiff)
+	}
+
+	var key [32]byte
+	copy(key[:], random)
+	if _, exists := c.seen[key]; exists {
+		return false
+	}
+	c.seen[key] = now.Add(2 * c.maxDiff)
+	return true
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/crypto"
+)
+
+// tlsDetectTimeout 等待客户端第一个字节和完成 TLS 握手的超时时间
+const tlsDetectTimeout = 10 * time.Second
+
+// tlsRecordHandshake TLS 握手记录的类型字节，协议消息类型不会使用该值
+const tlsRecordHandshake = 0x16
+
+// NewTLSConfig 根据服务端配置创建控制端口的 TLS 配置
+//
+// certs 不为 nil 时同时接受内置 CA 签发的客户端证书，并拒绝吊销列表中的证书。
+func NewTLSConfig(cfg *config.ServerConfig, certs *CertManager) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	var pool *x509.CertPool
+	if cfg.ClientCAFile != "" {
+		if pool, err = loadCertPool(cfg.ClientCAFile); err != nil {
+			return nil, err
+		}
+	}
+	if certs != nil {
+		if pool == nil {
+			pool = x509.NewCertPool()
+		}
+		pool.AddCert(certs.CACertificate())
+		tlsConfig.VerifyPeerCertificate = func(_ [][]byte, chains [][]*x509.Certificate) error {
+			if len(chains) == 0 {
+				return nil
+			}
+			return certs.Check(chains[0][0])
+		}
+	}
+
+	if pool != nil {
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.RequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// CertificatePin 返回 TLS 配置中证书的公钥指纹，客户端可配置为 pin_spki
+func CertificatePin(tlsConfig *tls.Config) (string, error) {
+	leaf, err := x509.ParseCertificate(tlsConfig.Certificates[0].Certificate[0])
+	if err != nil {
+		return "", err
+	}
+	return crypto.SPKIPin(leaf), nil
+}
+
+// loadCertPool 加载 PEM 格式的证书包
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", filename)
+	}
+	return pool, nil
+}
+
+// peekedConn 已预读部分数据的连接
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// TLSHandler 返回先完成 TLS 握手再交给 handle 处理连接的函数
+//
+// 通过第一个字节区分 TLS 和明文连接：allowPlain 时明文连接原样交给 handle，
+// 便于客户端逐步迁移到 TLS。不允许的明文连接、超时前没有数据和 TLS 握手失败的连接
+// 都交给 probe 处理。
+func TLSHandler(handle func(net.Conn), tlsConfig *tls.Config, allowPlain bool, probe *ProbeGuard) func(net.Conn) {
+	return func(conn net.Conn) {
+		remoteAddr := conn.RemoteAddr().String()
+
+		conn.SetDeadline(time.Now().Add(tlsDetectTimeout))
+		// 启用探测防护时记录原始数据并改用随机超时
+		conn = probe.Wrap(conn)
+		reader := bufio.NewReader(conn)
+		peeked := &peekedConn{Conn: conn, reader: reader}
+		first, err := reader.Peek(1)
+		if err != nil {
+			slog.Debug("No data before TLS detection timeout", "remote", remoteAddr, "err", err)
+			probe.Reject(peeked)
+			return
+		}
+
+		if first[0] != tlsRecordHandshake {
+			if !allowPlain {
+				slog.Warn("Rejected plain connection, TLS is required", "remote", remoteAddr)
+				probe.Reject(peeked)
+				return
+			}
+			slog.Debug("Plain connection", "remote", remoteAddr)
+			conn.SetDeadline(time.Time{})
+			handle(peeked)
+			return
+		}
+
+		tlsConn := tls.Server(peeked, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			slog.Warn("TLS handshake failed", "remote", remoteAddr, "err", err)
+			probe.Reject(tlsConn)
+			return
+		}
+		conn.SetDeadline(time.Time{})
+		handle(tlsConn)
+	}
+}
+
+// certPeer 返回 TLS 客户端证书映射的身份，没有客户端证书时返回 nil
+//
+// 证书 CN 为 "用户名" 或 "用户名/客户端标识"，后者同时限定客户端标识。
+func (pm *ProxyManager) certPeer(conn net.Conn) (*peer, error) {
+	tlsConn, ok := unwrapProbe(conn).(*tls.Conn)
+	if !ok {
+		return nil, nil
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, nil
+	}
+
+	name, clientID, _ := strings.Cut(certs[0].Subject.CommonName, "/")
+	user, exists := pm.users.Get(name)
+	if !exists {
+		return nil, fmt.Errorf("certificate user %q is unknown or disabled", name)
+	}
+	return &peer{user: user, clientID: clientID, cert: certs[0]}, nil
+}
@@ -0,0 +1,576 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/crypto"
+	"github.com/aethertunnel/aethertunnel/pkg/store"
+)
+
+// 用户在状态存储中的键
+const (
+	storeKeyUsers         = "users"          // 通过管理 API 创建的用户
+	storeKeyDisabledUsers = "disabled_users" // 被停用的内置用户和用户文件中的用户
+)
+
+// 用户来源
+const (
+	userSourceConfig = "config" // 内置的 default 用户
+	userSourceFile   = "file"   // 用户文件
+	userSourceAPI    = "api"    // 管理 API
+)
+
+var (
+	// ErrUserNotFound 用户不存在
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserExists 用户名或公钥已被占用
+	ErrUserExists = errors.New("user already exists")
+	// ErrUserReadOnly 用户来自配置文件，只能启用或停用
+	ErrUserReadOnly = errors.New("user is managed by the config file")
+	// ErrPermissionDenied 用户没有该权限
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// User 用户账号，创建后不再修改，更新时整体替换
+type User struct {
+	config.UserConfig
+	Source string
+
+	psk       []byte
+	tokenHash *crypto.TokenHash
+	verified  *verifiedTokens
+	ports     []config.PortRange
+}
+
+// verifiedTokens 已通过哈希验证的令牌
+//
+// 工作连接也要握手，每次都计算 Argon2id 代价太高。这里只保存令牌的带密钥 MAC，
+// 密钥每次启动随机生成，内存中的内容不能用来认证。
+type verifiedTokens struct {
+	key  []byte
+	macs map[string]bool
+	mu   sync.Mutex
+}
+
+// maxVerifiedTokens 每个用户缓存的令牌数上限，超过后清空重新验证
+const maxVerifiedTokens = 16
+
+// ErrTokenRejected 客户端发送的令牌与用户的令牌或令牌哈希不匹配
+var ErrTokenRejected = errors.New("token rejected")
+
+// newUser 编译用户配置
+func newUser(cfg config.UserConfig, source string) (*User, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	ports, err := config.ParsePortRanges(cfg.AllowPorts)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{UserConfig: cfg, Source: source, ports: ports}
+	if cfg.Token != "" {
+		user.psk = crypto.DerivePSK(string(cfg.Token), crypto.PSKPurposeControl)
+	}
+	if cfg.TokenHash != "" {
+		if user.tokenHash, err = crypto.ParseTokenHash(cfg.TokenHash); err != nil {
+			return nil, fmt.Errorf("user %s: token_hash: %w", cfg.Name, err)
+		}
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		user.verified = &verifiedTokens{key: key, macs: make(map[string]bool)}
+	}
+	if len(cfg.AllowDomains) > 0 {
+		user.AllowDomains = make([]string, 0, len(cfg.AllowDomains))
+		for _, domain := range cfg.AllowDomains {
+			user.AllowDomains = append(user.AllowDomains, normalizeDomain(domain))
+		}
+	}
+	return user, nil
+}
+
+// PSK 验证客户端在握手第一条消息中发送的令牌，返回由它派生的握手 PSK
+//
+// 配置了令牌明文时与由它派生的 PSK 比较；只配置了令牌哈希时用哈希验证。令牌不正确或
+// 用户不能用令牌认证时返回错误。PSK 总是由令牌本身派生，保存的哈希不能代替令牌。
+func (u *User) PSK(token string) ([]byte, error) {
+	if token == "" {
+		return nil, fmt.Errorf("%w: client did not send a token", ErrTokenRejected)
+	}
+	psk := crypto.DerivePSK(token, crypto.PSKPurposeControl)
+	switch {
+	case u.psk != nil:
+		if !hmac.Equal(psk, u.psk) {
+			return nil, ErrTokenRejected
+		}
+	case u.tokenHash == nil:
+		return nil, fmt.Errorf("%w: user has no token", ErrTokenRejected)
+	case !u.verified.check(token) && !u.tokenHash.Verify(token):
+		return nil, ErrTokenRejected
+	default:
+		u.verified.add(token)
+	}
+	return psk, nil
+}
+
+// unknownUserHash 验证不存在的用户时使用的令牌哈希，由随机令牌生成
+var unknownUserHash = sync.OnceValue(func() *crypto.TokenHash {
+	token, err := crypto.GenerateToken()
+	if err != nil {
+		return nil
+	}
+	encoded, err := crypto.HashToken(token)
+	if err != nil {
+		return nil
+	}
+	hash, _ := crypto.ParseTokenHash(encoded)
+	return hash
+})
+
+// verifyUnknownUser 对不存在的用户做一次与令牌哈希相同的计算，使拒绝的耗时与存在的用户一致
+func verifyUnknownUser(token string) {
+	if hash := unknownUserHash(); hash != nil && token != "" {
+		hash.Verify(token)
+	}
+}
+
+// mac 计算令牌的 MAC
+func (v *verifiedTokens) mac(token string) string {
+	mac := hmac.New(sha256.New, v.key)
+	mac.Write([]byte(token))
+	return string(mac.Sum(nil))
+}
+
+// check 判断令牌是否已验证过
+func (v *verifiedTokens) check(token string) bool {
+	mac := v.mac(token)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.macs[mac]
+}
+
+// add 记录验证通过的令牌
+func (v *verifiedTokens) add(token string) {
+	mac := v.mac(token)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.macs) >= maxVerifiedTokens {
+		v.macs = make(map[string]bool)
+	}
+	v.macs[mac] = true
+}
+
+// AllowsType 判断用户能否使用该类型的代理
+func (u *User) AllowsType(proxyType string) bool {
+	if len(u.ProxyTypes) == 0 {
+		return true
+	}
+	for _, allowed := range u.ProxyTypes {
+		if allowed == proxyType {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsPort 判断用户能否使用该远程端口
+func (u *User) AllowsPort(port int) bool {
+	return len(u.ports) == 0 || inRanges(u.ports, port)
+}
+
+// AllowsDomain 判断用户能否经出口或转发连接该域名，IP 地址由 [egress] 规则限制
+func (u *User) AllowsDomain(host string) bool {
+	if len(u.AllowDomains) == 0 {
+		return true
+	}
+	return matchDomain(u.AllowDomains, host)
+}
+
+// keyOwner 静态公钥的归属
+type keyOwner struct {
+	user     string
+	clientID string // server.authorized_keys 绑定的客户端标识，为空不限制
+}
+
+// UserManager 用户管理
+//
+// 用户来自三处：由 server.auth_token 构成的 default 用户、server.users_file
+// 中的用户、通过管理 API 创建并保存在状态存储中的用户。
+type UserManager struct {
+	users    map[string]*User
+	keys     map[string]keyOwner // Base64 公钥到归属
+	disabled map[string]bool     // 停用的非 API 用户
+	store    *store.Store
+	mu       sync.RWMutex
+}
+
+// NewUserManager 加载用户
+func NewUserManager(cfg *config.Config, st *store.Store) (*UserManager, error) {
+	um := &UserManager{
+		users:    make(map[string]*User),
+		keys:     make(map[string]keyOwner),
+		disabled: make(map[string]bool),
+		store:    st,
+	}
+
+	defaultUser, err := newUser(config.UserConfig{
+		Name:      config.DefaultUser,
+		Token:     cfg.Server.AuthToken,
+		TokenHash: cfg.Server.AuthTokenHash,
+	}, userSourceConfig)
+	if err != nil {
+		return nil, err
+	}
+	um.users[config.DefaultUser] = defaultUser
+	for clientID, key := range cfg.Server.AuthorizedKeys {
+		public, err := crypto.ParseNoisePublicKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("authorized key for %s: %w", clientID, err)
+		}
+		um.keys[base64.StdEncoding.EncodeToString(public)] = keyOwner{user: config.DefaultUser, clientID: clientID}
+	}
+
+	if cfg.Server.UsersFile != "" {
+		users, err := config.LoadUsers(cfg.Server.UsersFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, userCfg := range users {
+			if err := um.add(userCfg, userSourceFile); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if st != nil {
+		var persisted []config.UserConfig
+		if _, err := st.Get(storeKeyUsers, &persisted); err != nil {
+			return nil, err
+		}
+		migrated := false
+		for _, userCfg := range persisted {
+			// 早期版本保存了令牌明文，加载时换成哈希
+			if userCfg.Token != "" {
+				if err := hashToken(&userCfg); err != nil {
+					return nil, err
+				}
+				migrated = true
+			}
+			if err := um.add(userCfg, userSourceAPI); err != nil {
+				slog.Warn("Skipping persisted user", "user", userCfg.Name, "err", err)
+			}
+		}
+
+		var disabled []string
+		if _, err := st.Get(storeKeyDisabledUsers, &disabled); err != nil {
+			return nil, err
+		}
+		for _, name := range disabled {
+			um.disabled[name] = true
+		}
+
+		if migrated {
+			if err := um.persist(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return um, nil
+}
+
+// hashToken 把令牌明文替换为 Argon2id 哈希，通过管理 API 创建的用户只保存哈希
+func hashToken(cfg *config.UserConfig) error {
+	if cfg.Token == "" {
+		return nil
+	}
+	if cfg.TokenHash != "" {
+		return fmt.Errorf("user %s: token and token_hash cannot both be set", cfg.Name)
+	}
+	hash, err := crypto.HashToken(string(cfg.Token))
+	if err != nil {
+		return err
+	}
+	cfg.Token, cfg.TokenHash = "", hash
+	return nil
+}
+
+// add 登记用户，调用方需持有锁或在初始化阶段调用
+func (um *UserManager) add(cfg config.UserConfig, source string) error {
+	if cfg.Name == config.DefaultUser {
+		return fmt.Errorf("%w: %s is reserved", ErrUserExists, cfg.Name)
+	}
+	if _, exists := um.users[cfg.Name]; exists {
+		return fmt.Errorf("%w: %s", ErrUserExists, cfg.Name)
+	}
+
+	user, err := newUser(cfg, source)
+	if err != nil {
+		return err
+	}
+
+	var key string
+	if user.PublicKey != "" {
+		public, err := crypto.ParseNoisePublicKey(user.PublicKey)
+		if err != nil {
+			return err
+		}
+		key = base64.StdEncoding.EncodeToString(public)
+		if owner, exists := um.keys[key]; exists {
+			return fmt.Errorf("%w: public key is used by %s", ErrUserExists, owner.user)
+		}
+	}
+
+	um.users[user.Name] = user
+	if key != "" {
+		um.keys[key] = keyOwner{user: user.Name}
+	}
+	return nil
+}
+
+// remove 删除用户及其公钥，调用方需持有锁
+func (um *UserManager) remove(name string) {
+	delete(um.users, name)
+	for key, owner := range um.keys {
+		if owner.user == name {
+			delete(um.keys, key)
+		}
+	}
+}
+
+// Get 返回启用的用户
+func (um *UserManager) Get(name string) (*User, bool) {
+	um.mu.RLock()
+	defer um.mu.RUnlock()
+
+	user, exists := um.users[name]
+	if !exists || !um.enabled(user) {
+		return nil, false
+	}
+	return user, true
+}
+
+// ByKey 返回静态公钥所属的启用用户，以及该公钥绑定的客户端标识
+func (um *UserManager) ByKey(public []byte) (*User, string, bool) {
+	um.mu.RLock()
+	defer um.mu.RUnlock()
+
+	owner, exists := um.keys[base64.StdEncoding.EncodeToString(public)]
+	if !exists {
+		return nil, "", false
+	}
+	user, exists := um.users[owner.user]
+	if !exists || !um.enabled(user) {
+		return nil, "", false
+	}
+	return user, owner.clientID, true
+}
+
+// enabled 判断用户是否启用，调用方需持有锁
+func (um *UserManager) enabled(user *User) bool {
+	return user.IsEnabled() && !um.disabled[user.Name]
+}
+
+// UserInfo 管理 API 返回的用户信息，不包含令牌和令牌哈希
+type UserInfo struct {
+	config.UserConfig
+	Source  string `json:"source"`
+	Enabled bool   `json:"enabled"`
+	Clients int    `json:"clients"` // 在线客户端数
+	Proxies int    `json:"proxies"` // 运行中的代理数
+}
+
+// info 返回用户信息，调用方需持有锁
+func (um *UserManager) info(user *User) UserInfo {
+	info := UserInfo{UserConfig: user.UserConfig, Source: user.Source, Enabled: um.enabled(user)}
+	info.Token, info.TokenHash = "", ""
+	info.UserConfig.Enabled = nil
+	return info
+}
+
+// List 返回按名称排序的用户信息
+func (um *UserManager) List() []UserInfo {
+	um.mu.RLock()
+	defer um.mu.RUnlock()
+
+	infos := make([]UserInfo, 0, len(um.users))
+	for _, user := range um.users {
+		infos = append(infos, um.info(user))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Info 返回单个用户信息
+func (um *UserManager) Info(name string) (UserInfo, bool) {
+	um.mu.RLock()
+	defer um.mu.RUnlock()
+
+	user, exists := um.users[name]
+	if !exists {
+		return UserInfo{}, false
+	}
+	return um.info(user), true
+}
+
+// Config 返回用户配置（包含令牌或令牌哈希），供管理 API 在此基础上修改
+func (um *UserManager) Config(name string) (config.UserConfig, string, bool) {
+	um.mu.RLock()
+	defer um.mu.RUnlock()
+
+	user, exists := um.users[name]
+	if !exists {
+		return config.UserConfig{}, "", false
+	}
+	return user.UserConfig, user.Source, true
+}
+
+// Create 创建用户并持久化，令牌只保存哈希
+func (um *UserManager) Create(cfg config.UserConfig) error {
+	if err := hashToken(&cfg); err != nil {
+		return err
+	}
+
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	if err := um.add(cfg, userSourceAPI); err != nil {
+		return err
+	}
+	return um.persist()
+}
+
+// Update 替换通过管理 API 创建的用户
+func (um *UserManager) Update(name string, cfg config.UserConfig) error {
+	if cfg.Name != name {
+		return fmt.Errorf("user name cannot be changed")
+	}
+	if cfg.Token != "" {
+		// 设置了新令牌，替换原来的哈希
+		cfg.TokenHash = ""
+		if err := hashToken(&cfg); err != nil {
+			return err
+		}
+	}
+
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	existing, exists := um.users[name]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, name)
+	}
+	if existing.Source != userSourceAPI {
+		return fmt.Errorf("%w: %s", ErrUserReadOnly, name)
+	}
+
+	um.remove(name)
+	if err := um.add(cfg, userSourceAPI); err != nil {
+		// 恢复原来的用户
+		um.add(existing.UserConfig, userSourceAPI)
+		return err
+	}
+	return um.persist()
+}
+
+// SetEnabled 启用或停用用户
+func (um *UserManager) SetEnabled(name string, enabled bool) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	user, exists := um.users[name]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, name)
+	}
+
+	if user.Source == userSourceAPI {
+		cfg := user.UserConfig
+		cfg.Enabled = &enabled
+		um.remove(name)
+		if err := um.add(cfg, userSourceAPI); err != nil {
+			um.add(user.UserConfig, userSourceAPI)
+			return err
+		}
+	} else if enabled {
+		delete(um.disabled, name)
+	} else {
+		um.disabled[name] = true
+	}
+	return um.persist()
+}
+
+// Delete 删除通过管理 API 创建的用户
+func (um *UserManager) Delete(name string) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	user, exists := um.users[name]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, name)
+	}
+	if user.Source != userSourceAPI {
+		return fmt.Errorf("%w: %s", ErrUserReadOnly, name)
+	}
+
+	um.remove(name)
+	return um.persist()
+}
+
+// persist 保存 API 用户和停用列表，调用方需持有锁
+func (um *UserManager) persist() error {
+	if um.store == nil {
+		return nil
+	}
+
+	users := make([]config.UserConfig, 0)
+	for _, user := range um.users {
+		if user.Source == userSourceAPI {
+			users = append(users, user.UserConfig)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+
+	disabled := make([]string, 0, len(um.disabled))
+	for name := range um.disabled {
+		disabled = append(disabled, name)
+	}
+	sort.Strings(disabled)
+
+	if err := um.store.Put(storeKeyUsers, users); err != nil {
+		return fmt.Errorf("failed to persist users: %w", err)
+	}
+	if err := um.store.Put(storeKeyDisabledUsers, disabled); err != nil {
+		return fmt.Errorf("failed to persist users: %w", err)
+	}
+	return nil
+}
+
+// checkUser 检查代理是否在用户权限内，调用方需持有锁
+func (pm *ProxyManager) checkUser(user *User, proxy *Proxy) error {
+	if !user.AllowsType(proxy.Type) {
+		return fmt.Errorf("%w: user %s cannot use %s proxies", ErrPermissionDenied, user.Name, proxy.Type)
+	}
+	if user.MaxProxies > 0 {
+		running := 0
+		for name, p := range pm.proxies {
+			if name != proxy.Name && p.User == user.Name && p.Running() {
+				running++
+			}
+		}
+		if running >= user.MaxProxies {
+			return fmt.Errorf("%w: user %s reached max_proxies (%d)", ErrPermissionDenied, user.Name, user.MaxProxies)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/crypto"
+)
+
+func TestUserPSK(t *testing.T) {
+	hash, err := crypto.HashToken("alice-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := newUser(config.UserConfig{Name: "alice", Token: "alice-token"}, userSourceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed, err := newUser(config.UserConfig{Name: "alice", TokenHash: hash}, userSourceAPI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := crypto.DerivePSK("alice-token", crypto.PSKPurposeControl)
+	for name, user := range map[string]*User{"token": plain, "token_hash": hashed} {
+		// 第二次验证命中缓存，结果相同
+		for range 2 {
+			if psk, err := user.PSK("alice-token"); err != nil || !bytes.Equal(psk, want) {
+				t.Errorf("%s: expected the token to be accepted, got %v", name, err)
+			}
+		}
+		// 保存的哈希不能代替令牌
+		for _, token := range []string{"other-token", "", hash} {
+			if _, err := user.PSK(token); !errors.Is(err, ErrTokenRejected) {
+				t.Errorf("%s: expected %q to be rejected, got %v", name, token, err)
+			}
+		}
+	}
+
+	keyOnly := &User{UserConfig: config.UserConfig{Name: "bob"}}
+	if _, err := keyOnly.PSK("alice-token"); !errors.Is(err, ErrTokenRejected) {
+		t.Errorf("Expected a user without a token to be rejected, got %v", err)
+	}
+}
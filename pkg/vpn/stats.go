@@ -41,6 +41,10 @@ type VPNStats struct {
 	handshakeErrors   uint64
 	routingErrors     uint64
 
+	// Replay protection statistics
+	replayedPackets uint64 // duplicate, too old for the replay window or from another session
+	stalePackets    uint64 // timestamp outside the allowed clock skew
+
 	// Rate statistics
 	connectionsPerSecond float64
 	bytesPerSecond       float64
@@ -85,6 +89,9 @@ func (s *VPNStats) Reset() {
 	atomic.StoreUint64(&s.handshakeErrors, 0)
 	atomic.StoreUint64(&s.routingErrors, 0)
 
+	atomic.StoreUint64(&s.replayedPackets, 0)
+	atomic.StoreUint64(&s.stalePackets, 0)
+
 	atomic.StoreInt64(&s.latencyMeasurements, 0)
 
 	s.connectionsPerSecond = 0
@@ -190,6 +197,22 @@ func (s *VPNStats) IncrementObfuscationErrors() {
 	atomic.AddUint64(&s.obfuscationErrors, 1)
 }
 
+// IncrementReplayedPackets increments the rejected replayed packets counter
+func (s *VPNStats) IncrementReplayedPackets() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	atomic.AddUint64(&s.replayedPackets, 1)
+}
+
+// IncrementStalePackets increments the rejected stale packets counter
+func (s *VPNStats) IncrementStalePackets() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	atomic.AddUint64(&s.stalePackets, 1)
+}
+
 // IncrementHandshakeErrors increments handshake errors counter
 func (s *VPNStats) IncrementHandshakeErrors() {
 	s.mu.Lock()
@@ -241,22 +264,16 @@ func (s *VPNStats) UpdatePacketLoss(sent, received uint64) {
 	}
 }
 
-// updateConnectionRate updates connection rate
+// updateConnectionRate updates connection rate, the caller holds s.mu
 func (s *VPNStats) updateConnectionRate() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	elapsed := time.Since(s.lastReset).Seconds()
 	if elapsed > 0 {
 		s.connectionsPerSecond = float64(atomic.LoadUint64(&s.clientConnections)) / elapsed
 	}
 }
 
-// updateBytesPerSecond updates bytes per second
+// updateBytesPerSecond updates bytes per second, the caller holds s.mu
 func (s *VPNStats) updateBytesPerSecond() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	elapsed := time.Since(s.lastReset).Seconds()
 	if elapsed > 0 {
 		s.bytesPerSecond = float64(atomic.LoadUint64(&s.bytesReceived)) / elapsed
@@ -303,6 +320,10 @@ func (s *VPNStats) GetStats() map[string]interface{} {
 		"handshake_errors":   atomic.LoadUint64(&s.handshakeErrors),
 		"routing_errors":     atomic.LoadUint64(&s.routingErrors),
 
+		// Replay protection stats
+		"replayed_packets": atomic.LoadUint64(&s.replayedPackets),
+		"stale_packets":    atomic.LoadUint64(&s.stalePackets),
+
 		// Uptime
 		"uptime_seconds": time.Since(s.lastReset).Seconds(),
 	}
@@ -353,5 +374,7 @@ func (s *VPNStats) GetErrorStats() map[string]uint64 {
 		"obfuscation_errors": atomic.LoadUint64(&s.obfuscationErrors),
 		"handshake_errors":   atomic.LoadUint64(&s.handshakeErrors),
 		"routing_errors":     atomic.LoadUint64(&s.routingErrors),
+		"replayed_packets":   atomic.LoadUint64(&s.replayedPackets),
+		"stale_packets":      atomic.LoadUint64(&s.stalePackets),
 	}
 }
@@ -1,9 +1,12 @@
 package vpn
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 
@@ -55,6 +58,7 @@ type ConnectedClient struct {
 	SendBytes    uint64
 	ReceiveBytes uint64
 	Latency      int64
+	obfuscation  *obfuscation.Session // packet sequence numbers and replay window, created on first use
 	mu           sync.RWMutex
 }
 
@@ -69,12 +73,21 @@ type Route struct {
 	mu        sync.RWMutex
 }
 
-// NewVPN creates a new VPN manager
+// NewVPN creates a new VPN manager. Packets are always sealed and checked for
+// replays; the [obfuscation] section only adds obfuscation on top.
 func NewVPN(cfg *config.Config, encryption *crypto.Encryption) *VPN {
-	// Create obfuscator if enabled
-	var obfuscator *obfuscation.Obfuscation
+	// Already validated when the config was loaded
+	maxSkew, _ := cfg.Obfuscation.MaxSkew()
+	obfuscator := obfuscation.NewObfuscation(encryption, maxSkew)
 	if cfg.Obfuscation.Enabled {
-		obfuscator = obfuscation.NewObfuscation(encryption)
+		for _, name := range cfg.Obfuscation.PipelineNames() {
+			if err := obfuscator.AddPipeline(name, cfg.Obfuscation.Pipelines[name]); err != nil {
+				slog.Error("Obfuscation pipeline is disabled", "pipeline", name, "err", err)
+			}
+		}
+		if !obfuscator.Has(cfg.Obfuscation.Type()) {
+			slog.Error("Unknown obfuscation default_type", "type", cfg.Obfuscation.Type(), "available", obfuscator.Types())
+		}
 	}
 
 	return &VPN{
@@ -103,7 +116,8 @@ func (v *VPN) Start() error {
 		if err != nil {
 			return fmt.Errorf("failed to start TCP VPN listener: %v", err)
 		}
-		log.Printf("TCP VPN server started on %s:%d", v.cfg.VPN.BindAddr, v.cfg.VPN.Port)
+		slog.Info("VPN server started", "transport", "tcp", "bind_addr", v.cfg.VPN.BindAddr, "port", v.cfg.VPN.Port)
+		go v.acceptTCPConnections(v.listener)
 
 	case "udp":
 		// For UDP, we don't have a net.Listener, so we use a different approach
@@ -111,7 +125,7 @@ func (v *VPN) Start() error {
 		if err != nil {
 			return fmt.Errorf("failed to start UDP VPN listener: %v", err)
 		}
-		log.Printf("UDP VPN server started on %s:%d", v.cfg.VPN.BindAddr, v.cfg.VPN.Port)
+		slog.Info("VPN server started", "transport", "udp", "bind_addr", v.cfg.VPN.BindAddr, "port", v.cfg.VPN.Port)
 		// Start a goroutine to handle UDP connections
 		go v.handleUDPConnections(udpConn)
 
@@ -119,20 +133,20 @@ func (v *VPN) Start() error {
 		wsServer := protocol.NewWebSocketServer(protocol.DefaultWebSocketConfig(), v.handleWebSocketConnection)
 		go func() {
 			if err := wsServer.Start(fmt.Sprintf("%s:%d", v.cfg.VPN.BindAddr, v.cfg.VPN.Port)); err != nil {
-				log.Printf("WebSocket VPN server failed: %v", err)
+				slog.Error("VPN server failed", "transport", "websocket", "err", err)
 			}
 		}()
-		log.Printf("WebSocket VPN server started on %s:%d", v.cfg.VPN.BindAddr, v.cfg.VPN.Port)
+		slog.Info("VPN server started", "transport", "websocket", "bind_addr", v.cfg.VPN.BindAddr, "port", v.cfg.VPN.Port)
 		return nil
 
 	case "http":
 		httpServer := protocol.NewHTTPServer(protocol.DefaultHTTPConfig(), v.handleHTTPConnection)
 		go func() {
 			if err := httpServer.Start(fmt.Sprintf("%s:%d", v.cfg.VPN.BindAddr, v.cfg.VPN.Port)); err != nil {
-				log.Printf("HTTP VPN server failed: %v", err)
+				slog.Error("VPN server failed", "transport", "http", "err", err)
 			}
 		}()
-		log.Printf("HTTP VPN server started on %s:%d", v.cfg.VPN.BindAddr, v.cfg.VPN.Port)
+		slog.Info("VPN server started", "transport", "http", "bind_addr", v.cfg.VPN.BindAddr, "port", v.cfg.VPN.Port)
 		return nil
 
 	default:
@@ -142,48 +156,257 @@ func (v *VPN) Start() error {
 	// Enable performance optimization
 	if v.cfg.VPN.EnablePerformance {
 		v.performance.Enable()
-		log.Printf("Performance optimization enabled")
+		slog.Info("Performance optimization enabled")
 	}
 
 	return nil
 }
 
+// newPacketConn frames the packets on conn as configured in [obfuscation]
+func newPacketConn(cfg *config.Config, conn net.Conn, role obfuscation.Role) obfuscation.PacketConn {
+	if cfg.Obfuscation.Enabled && cfg.Obfuscation.PacketFraming() == config.ObfuscationFramingHTTP {
+		return obfuscation.NewHTTPPacketConn(conn, role)
+	}
+	return obfuscation.NewPacketConn(conn)
+}
+
+// acceptTCPConnections accepts VPN clients on the TCP listener
+func (v *VPN) acceptTCPConnections(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Warn("Failed to accept VPN connection", "err", err)
+			continue
+		}
+		go v.handleTCPConnection(conn)
+	}
+}
+
+// handleTCPConnection reads packets from a client until it disconnects. Each
+// packet is checked against the client's replay window and skew limit, and
+// rejected packets are counted in VPNStats. Accepted packets are only
+// accounted for: like the other transports, there is no TUN interface to
+// deliver them to yet, and packets are never relayed between clients.
+func (v *VPN) handleTCPConnection(conn net.Conn) {
+	packetConn := newPacketConn(v.cfg, conn, obfuscation.RoleServer)
+	defer packetConn.Close()
+
+	client := &ConnectedClient{
+		ID:        conn.RemoteAddr().String(),
+		Connected: true,
+		LastSeen:  time.Now().Unix(),
+	}
+
+	if !v.addClient(client) {
+		slog.Warn("VPN client rejected, max_peers reached", "client", client.ID, "max_peers", v.cfg.VPN.MaxPeers)
+		return
+	}
+	defer v.removeClient(client)
+	slog.Info("VPN client connected", "client", client.ID)
+
+	for {
+		data, err := packetConn.ReadPacket()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				slog.Warn("VPN client error", "client", client.ID, "err", err)
+			}
+			slog.Info("VPN client disconnected", "client", client.ID)
+			return
+		}
+		payload, err := v.deobfuscatePacket(client, data)
+		if err != nil {
+			v.stats.IncrementDroppedPackets()
+			continue
+		}
+
+		client.mu.Lock()
+		client.LastSeen = time.Now().Unix()
+		client.ReceiveBytes += uint64(len(payload))
+		client.mu.Unlock()
+		v.stats.AddBytesReceived(uint64(len(payload)))
+	}
+}
+
+// addClient registers a connected client, returns false when max_peers is reached
+func (v *VPN) addClient(client *ConnectedClient) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.cfg.VPN.MaxPeers > 0 && len(v.clients) >= v.cfg.VPN.MaxPeers {
+		return false
+	}
+	v.clients[client.ID] = client
+	v.stats.IncrementActiveConnections()
+	return true
+}
+
+// removeClient unregisters a disconnected client
+func (v *VPN) removeClient(client *ConnectedClient) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.clients, client.ID)
+	client.Connected = false
+	v.stats.DecrementActiveConnections()
+}
+
 // handleUDPConnections handles UDP connections
 func (v *VPN) handleUDPConnections(conn *net.UDPConn) {
 	// Implementation for handling UDP connections
-	log.Printf("UDP connection handler started")
+	slog.Debug("Connection handler started", "transport", "udp")
 }
 
 // handleWebSocketConnection handles WebSocket connections
 func (v *VPN) handleWebSocketConnection(conn *protocol.WebSocketConn) {
 	// Implementation for handling WebSocket connections
-	log.Printf("WebSocket connection handler started")
+	slog.Debug("Connection handler started", "transport", "websocket")
 }
 
 // handleHTTPConnection handles HTTP connections
 func (v *VPN) handleHTTPConnection(conn *protocol.HTTPConn) {
 	// Implementation for handling HTTP connections
-	log.Printf("HTTP connection handler started")
+	slog.Debug("Connection handler started", "transport", "http")
+}
+
+// obfuscationSession returns the obfuscation session of a client
+func (v *VPN) obfuscationSession(client *ConnectedClient) (*obfuscation.Session, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.obfuscation == nil {
+		session, err := v.obfuscator.NewSession()
+		if err != nil {
+			return nil, err
+		}
+		client.obfuscation = session
+	}
+	return client.obfuscation, nil
+}
+
+// obfuscatePacket obfuscates a packet sent to client
+func (v *VPN) obfuscatePacket(client *ConnectedClient, data []byte, obfuscationType string) ([]byte, error) {
+	session, err := v.obfuscationSession(client)
+	if err != nil {
+		return nil, err
+	}
+	packet, err := session.ObfuscatePacket(data, obfuscationType)
+	if err != nil {
+		v.stats.IncrementObfuscationErrors()
+	}
+	return packet, err
+}
+
+// deobfuscatePacket deobfuscates a packet received from client, counting
+// replayed and stale packets separately from other failures
+func (v *VPN) deobfuscatePacket(client *ConnectedClient, data []byte) ([]byte, error) {
+	session, err := v.obfuscationSession(client)
+	if err != nil {
+		return nil, err
+	}
+	packet, err := session.DeobfuscatePacket(data)
+	switch {
+	case errors.Is(err, obfuscation.ErrReplayedPacket):
+		v.stats.IncrementReplayedPackets()
+		return nil, err
+	case errors.Is(err, obfuscation.ErrStalePacket):
+		v.stats.IncrementStalePackets()
+		return nil, err
+	case err != nil:
+		v.stats.IncrementObfuscationErrors()
+		return nil, err
+	}
+	return packet.Payload, nil
 }
 
 // VPNClient represents a VPN client
 type VPNClient struct {
 	cfg        *config.Config
 	encryption *crypto.Encryption
+	obfuscator *obfuscation.Obfuscation
+	session    *obfuscation.Session
+	conn       obfuscation.PacketConn
 	mu         sync.RWMutex
 }
 
 // NewVPNClient creates a new VPN client
 func NewVPNClient(cfg *config.Config, encryption *crypto.Encryption) *VPNClient {
+	// Already validated when the config was loaded
+	maxSkew, _ := cfg.Obfuscation.MaxSkew()
+	obfuscator := obfuscation.NewObfuscation(encryption, maxSkew)
+	if cfg.Obfuscation.Enabled {
+		for _, name := range cfg.Obfuscation.PipelineNames() {
+			if err := obfuscator.AddPipeline(name, cfg.Obfuscation.Pipelines[name]); err != nil {
+				slog.Error("Obfuscation pipeline is disabled", "pipeline", name, "err", err)
+			}
+		}
+	}
+
 	return &VPNClient{
 		cfg:        cfg,
 		encryption: encryption,
+		obfuscator: obfuscator,
 	}
 }
 
-// Connect connects to the VPN server
+// Connect connects to the VPN server on the host of client.server_addr and vpn.port
 func (v *VPNClient) Connect() error {
-	// Simple implementation - just log and return
-	log.Printf("VPN client connecting...")
+	host, _, err := net.SplitHostPort(v.cfg.Client.ServerAddr)
+	if err != nil {
+		host = v.cfg.Client.ServerAddr
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(v.cfg.VPN.Port))
+
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to VPN server: %v", err)
+	}
+	session, err := v.obfuscator.NewSession()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	v.mu.Lock()
+	if v.conn != nil {
+		v.conn.Close()
+	}
+	v.session = session
+	v.conn = newPacketConn(v.cfg, conn, obfuscation.RoleClient)
+	v.mu.Unlock()
+
+	slog.Info("VPN client connected to server", "server", addr)
 	return nil
 }
+
+// Send sends an IP packet to the server
+func (v *VPNClient) Send(payload []byte) error {
+	v.mu.RLock()
+	session, conn := v.session, v.conn
+	v.mu.RUnlock()
+	if conn == nil {
+		return errors.New("VPN client is not connected")
+	}
+
+	obfuscationType := "none"
+	if v.cfg.Obfuscation.Enabled {
+		obfuscationType = v.cfg.Obfuscation.Type()
+	}
+	packet, err := session.ObfuscatePacket(payload, obfuscationType)
+	if err != nil {
+		return err
+	}
+	return conn.WritePacket(packet)
+}
+
+// Close disconnects from the server
+func (v *VPNClient) Close() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.conn == nil {
+		return nil
+	}
+	err := v.conn.Close()
+	v.conn = nil
+	return err
+}
@@ -0,0 +1,98 @@
+package vpn
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aethertunnel/aethertunnel/pkg/config"
+	"github.com/aethertunnel/aethertunnel/pkg/crypto"
+	"github.com/aethertunnel/aethertunnel/pkg/obfuscation"
+)
+
+// ipv4Packet returns a minimal IPv4 header from src to dst followed by payload
+func ipv4Packet(src, dst string, payload string) []byte {
+	packet := make([]byte, 20, 20+len(payload))
+	packet[0] = 0x45
+	copy(packet[12:16], net.ParseIP(src).To4())
+	copy(packet[16:20], net.ParseIP(dst).To4())
+	return append(packet, payload...)
+}
+
+func TestVPNCountsRejectedPackets(t *testing.T) {
+	for _, framing := range []string{config.ObfuscationFramingLength, config.ObfuscationFramingHTTP} {
+		t.Run(framing, func(t *testing.T) {
+			testVPNCountsRejectedPackets(t, framing)
+		})
+	}
+}
+
+func testVPNCountsRejectedPackets(t *testing.T, framing string) {
+	serverCfg := &config.Config{}
+	serverCfg.VPN.BindAddr = "127.0.0.1"
+	serverCfg.VPN.Protocol = "tcp"
+	serverCfg.Obfuscation.Enabled = true
+	serverCfg.Obfuscation.DefaultType = "morph"
+	serverCfg.Obfuscation.Framing = framing
+
+	server := NewVPN(serverCfg, crypto.NewEncryption("vpn-token"))
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start VPN: %v", err)
+	}
+	defer server.listener.Close()
+
+	clientCfg := &config.Config{}
+	clientCfg.Client.ServerAddr = "127.0.0.1:7000"
+	clientCfg.VPN.Port = server.listener.Addr().(*net.TCPAddr).Port
+	clientCfg.Obfuscation.Enabled = true
+	clientCfg.Obfuscation.DefaultType = "aes"
+	clientCfg.Obfuscation.Framing = framing
+
+	client := NewVPNClient(clientCfg, crypto.NewEncryption("vpn-token"))
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	sent := ipv4Packet("10.0.0.2", "10.0.0.3", "ping")
+	if err := client.Send(sent); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return server.stats.GetTrafficStats()["bytes_received"] == uint64(len(sent)) })
+
+	// A replayed packet is dropped and counted, the first copy is accepted
+	conn, err := net.Dial("tcp", server.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	session, _ := obfuscation.NewObfuscation(crypto.NewEncryption("vpn-token"), time.Minute).NewSession()
+	packet, _ := session.ObfuscatePacket(ipv4Packet("10.0.0.4", "10.0.0.2", "once"), "none")
+	packetConn := newPacketConn(clientCfg, conn, obfuscation.RoleClient)
+	packetConn.WritePacket(packet)
+	packetConn.WritePacket(packet)
+	waitFor(t, func() bool { return server.stats.GetErrorStats()["replayed_packets"] == 1 })
+	if received := server.stats.GetTrafficStats()["bytes_received"]; received != uint64(2*len(sent)) {
+		t.Errorf("Expected %d bytes received, got %d", 2*len(sent), received)
+	}
+
+	// Packets sealed with another key are dropped without touching the replay window
+	other, _ := obfuscation.NewObfuscation(crypto.NewEncryption("other-token"), time.Minute).NewSession()
+	packet, _ = other.ObfuscatePacket(sent, "none")
+	packetConn.WritePacket(packet)
+	waitFor(t, func() bool { return server.stats.GetErrorStats()["obfuscation_errors"] == 1 })
+	if replayed := server.stats.GetErrorStats()["replayed_packets"]; replayed != 1 {
+		t.Errorf("Expected 1 replayed packet, got %d", replayed)
+	}
+}
+
+// waitFor polls condition for up to a second
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+	t.Fatal("Condition not met in time")
+}
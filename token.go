@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aethertunnel/aethertunnel/pkg/crypto"
+)
+
+// runTokenCommand 处理 token 子命令
+//
+// generate 生成随机令牌和它的 Argon2id 哈希：令牌交给客户端（client.auth_token），
+// 哈希写入服务端（server.auth_token_hash 或用户的 token_hash）。hash 计算已有令牌的哈希，
+// 未指定 -token 时从标准输入读取一行，避免令牌留在 shell 历史中。
+func runTokenCommand(args []string) {
+	if len(args) == 0 || (args[0] != "generate" && args[0] != "hash") {
+		fmt.Printf("Usage: %s token generate\n", os.Args[0])
+		fmt.Printf("       %s token hash [-token <token>]\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	var token string
+	switch args[0] {
+	case "generate":
+		flags := flag.NewFlagSet("token generate", flag.ExitOnError)
+		flags.Parse(args[1:])
+
+		var err error
+		if token, err = crypto.GenerateToken(); err != nil {
+			fatal("Failed to generate token", "err", err)
+		}
+	case "hash":
+		flags := flag.NewFlagSet("token hash", flag.ExitOnError)
+		value := flags.String("token", "", "token to hash, read from stdin when omitted")
+		flags.Parse(args[1:])
+
+		token = *value
+		if token == "" {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				fatal("Failed to read token", "err", err)
+			}
+			token = strings.TrimRight(line, "\r\n")
+		}
+		if token == "" {
+			fatal("Token must not be empty")
+		}
+	}
+
+	hash, err := crypto.HashToken(token)
+	if err != nil {
+		fatal("Failed to hash token", "err", err)
+	}
+
+	if args[0] == "generate" {
+		fmt.Printf("Token: %s\n", token)
+	}
+	fmt.Printf("Hash:  %s\n", hash)
+}